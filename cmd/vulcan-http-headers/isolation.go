@@ -0,0 +1,288 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	"github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Names of the isolation headers.
+const (
+	permissionsPolicyHeader = "Permissions-Policy"
+	coopHeader              = "Cross-Origin-Opener-Policy"
+	coepHeader              = "Cross-Origin-Embedder-Policy"
+	corpHeader              = "Cross-Origin-Resource-Policy"
+)
+
+// powerfulFeatures are the features of the Permissions Policy that must not
+// be allowed to any origin.
+var powerfulFeatures = []string{
+	"bluetooth",
+	"camera",
+	"display-capture",
+	"geolocation",
+	"hid",
+	"microphone",
+	"payment",
+	"serial",
+	"usb",
+}
+
+// Permissions-Policy vulnerabilities.
+var permissionsPolicyVuln = report.Vulnerability{
+	Summary: "HTTP Permissions Policy Misconfiguration",
+	Description: "`Permissions-Policy` is an HTTP header that allows sites to control which browser features, such as the camera, " +
+		"the microphone or the geolocation, can be used by the page and by the iframes it embeds. " +
+		"Restricting the features reduces the impact of cross-site scripting (XSS) vulnerabilities and of malicious third-party content.",
+	Labels: []string{"issue", "http"},
+	Score:  2.0, // Low.
+	CWEID:  358,
+	Recommendations: []string{
+		"Set the Permissions-Policy header disabling the features not used by the site, e.g. 'camera=(), microphone=(), geolocation=()'.",
+		"Allow the features used by the site only to the origins that need them, instead of to any origin ('*').",
+	},
+	References: []string{
+		"https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Permissions-Policy",
+		"https://www.w3.org/TR/permissions-policy/",
+		"https://owasp.org/www-project-secure-headers/#permissions-policy",
+	},
+}
+
+// processPermissionsPolicy checks the response headers and adds a Vulnerability if the Permissions-Policy header
+// is missing or allows powerful features to any origin.
+func processPermissionsPolicy(vuln report.Vulnerability, target string, r observatoryResult, s state.State) {
+	value, ok := headerValue(r.Scan.ResponseHeaders, permissionsPolicyHeader)
+	if !ok {
+		vuln.Summary = "HTTP Permissions Policy Not Implemented"
+		vuln.Score = 1.0 // Low.
+		addIsolationVuln(vuln, target, permissionsPolicyHeader, value, s)
+		return
+	}
+
+	var details []string
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		feature, allowlist, found := strings.Cut(directive, "=")
+		if !found {
+			details = append(details, fmt.Sprintf("* 'Permissions-Policy' directive '%s' cannot be recognized.", directive))
+			continue
+		}
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		if isPowerfulFeature(feature) && allowsAnyOrigin(allowlist) {
+			details = append(details, fmt.Sprintf("* 'Permissions-Policy' allows the '%s' feature to any origin.", feature))
+		}
+	}
+	if len(details) == 0 {
+		return
+	}
+	vuln.Details = strings.Join(details, "\n")
+	addIsolationVuln(vuln, target, permissionsPolicyHeader, value, s)
+}
+
+func isPowerfulFeature(feature string) bool {
+	for _, f := range powerfulFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAnyOrigin reports whether the allowlist of a Permissions-Policy
+// directive contains the '*' token, e.g.: '*' or '(self *)'.
+func allowsAnyOrigin(allowlist string) bool {
+	allowlist = strings.Trim(strings.TrimSpace(allowlist), "()")
+	for _, origin := range strings.Fields(allowlist) {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Cross-Origin-Opener-Policy vulnerabilities.
+var coopVuln = report.Vulnerability{
+	Summary: "HTTP Cross-Origin-Opener-Policy Misconfiguration",
+	Description: "`Cross-Origin-Opener-Policy` (COOP) is an HTTP header that allows sites to isolate their browsing context from the " +
+		"cross-origin documents that open them or that they open. Without it, a malicious site opening the page in a popup keeps " +
+		"a reference to its window, which can be abused in cross-site leaks (XS-Leaks) and, together with Spectre-like attacks, " +
+		"to read data of the page.",
+	Labels: []string{"issue", "http"},
+	Score:  1.5, // Low.
+	CWEID:  358,
+	Recommendations: []string{
+		"Set the Cross-Origin-Opener-Policy header to 'same-origin', or to 'same-origin-allow-popups' if the site relies on popups " +
+			"to other origins, e.g. for payments or single sign-on.",
+	},
+	References: []string{
+		"https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cross-Origin-Opener-Policy",
+		"https://web.dev/articles/why-coop-coep",
+		"https://owasp.org/www-project-secure-headers/#cross-origin-opener-policy",
+	},
+}
+
+// processCOOP checks the response headers of the HTML pages and adds a Vulnerability if the
+// Cross-Origin-Opener-Policy header is missing or does not isolate the page.
+func processCOOP(vuln report.Vulnerability, target string, r observatoryResult, s state.State) {
+	if !isHTML(r.Scan.ResponseHeaders) {
+		return
+	}
+
+	value, ok := headerValue(r.Scan.ResponseHeaders, coopHeader)
+	if !ok {
+		vuln.Summary = "HTTP Cross-Origin-Opener-Policy Not Implemented"
+		addIsolationVuln(vuln, target, coopHeader, value, s)
+		return
+	}
+
+	switch policyToken(value) {
+	case "same-origin", "same-origin-allow-popups", "noopener-allow-popups":
+		return
+	case "unsafe-none":
+		vuln.Details = "* 'Cross-Origin-Opener-Policy' header set to 'unsafe-none', which does not isolate the page."
+	default:
+		vuln.Details = "* 'Cross-Origin-Opener-Policy' header cannot be recognized."
+		vuln.Recommendations = append(vuln.Recommendations, "Fix the malformed Cross-Origin-Opener-Policy HTTP Header.")
+	}
+	addIsolationVuln(vuln, target, coopHeader, value, s)
+}
+
+// Cross-Origin-Embedder-Policy vulnerabilities.
+var coepVuln = report.Vulnerability{
+	Summary: "HTTP Cross-Origin-Embedder-Policy Misconfiguration",
+	Description: "`Cross-Origin-Embedder-Policy` (COEP) is an HTTP header that prevents a page from loading cross-origin resources " +
+		"that do not explicitly grant it permission. Together with the Cross-Origin-Opener-Policy header, it makes the page " +
+		"cross-origin isolated, which protects its data from Spectre-like attacks.",
+	Labels: []string{"issue", "http"},
+	Score:  report.SeverityThresholdNone,
+	CWEID:  358,
+	Recommendations: []string{
+		"Set the Cross-Origin-Embedder-Policy header to 'require-corp', or to 'credentialless' if the site loads cross-origin " +
+			"resources that do not set the Cross-Origin-Resource-Policy header.",
+	},
+	References: []string{
+		"https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cross-Origin-Embedder-Policy",
+		"https://web.dev/articles/coop-coep",
+		"https://owasp.org/www-project-secure-headers/#cross-origin-embedder-policy",
+	},
+}
+
+// processCOEP checks the response headers of the HTML pages and adds a Vulnerability if the
+// Cross-Origin-Embedder-Policy header is missing or does not restrict the embedded resources.
+func processCOEP(vuln report.Vulnerability, target string, r observatoryResult, s state.State) {
+	if !isHTML(r.Scan.ResponseHeaders) {
+		return
+	}
+
+	value, ok := headerValue(r.Scan.ResponseHeaders, coepHeader)
+	if !ok {
+		vuln.Summary = "HTTP Cross-Origin-Embedder-Policy Not Implemented"
+		addIsolationVuln(vuln, target, coepHeader, value, s)
+		return
+	}
+
+	switch policyToken(value) {
+	case "require-corp", "credentialless":
+		return
+	case "unsafe-none":
+		vuln.Details = "* 'Cross-Origin-Embedder-Policy' header set to 'unsafe-none', which allows loading any cross-origin resource."
+	default:
+		vuln.Details = "* 'Cross-Origin-Embedder-Policy' header cannot be recognized."
+		vuln.Recommendations = append(vuln.Recommendations, "Fix the malformed Cross-Origin-Embedder-Policy HTTP Header.")
+		vuln.Score = 1.0 // Low.
+	}
+	addIsolationVuln(vuln, target, coepHeader, value, s)
+}
+
+// Cross-Origin-Resource-Policy vulnerabilities.
+var corpVuln = report.Vulnerability{
+	Summary: "HTTP Cross-Origin-Resource-Policy Misconfiguration",
+	Description: "`Cross-Origin-Resource-Policy` (CORP) is an HTTP header that allows sites to prevent other origins from loading " +
+		"their resources, e.g. in script or image elements. Without it, a malicious site can load the resources into its own process, " +
+		"where they can be read with Spectre-like attacks, or use them in cross-site leaks (XS-Leaks).",
+	Labels: []string{"issue", "http"},
+	Score:  1.0, // Low.
+	CWEID:  358,
+	Recommendations: []string{
+		"Set the Cross-Origin-Resource-Policy header to 'same-origin', or to 'same-site' if the resources are loaded by other sites of the same domain.",
+		"Use 'cross-origin' only for resources intended to be loaded by any site, e.g. those served by a CDN.",
+	},
+	References: []string{
+		"https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cross-Origin-Resource-Policy",
+		"https://resourcepolicy.fyi/",
+		"https://owasp.org/www-project-secure-headers/#cross-origin-resource-policy",
+	},
+}
+
+// processCORP checks the response headers and adds a Vulnerability if the Cross-Origin-Resource-Policy header
+// is missing or allows any origin to load the resources.
+func processCORP(vuln report.Vulnerability, target string, r observatoryResult, s state.State) {
+	value, ok := headerValue(r.Scan.ResponseHeaders, corpHeader)
+	if !ok {
+		vuln.Summary = "HTTP Cross-Origin-Resource-Policy Not Implemented"
+		addIsolationVuln(vuln, target, corpHeader, value, s)
+		return
+	}
+
+	switch policyToken(value) {
+	case "same-origin", "same-site":
+		return
+	case "cross-origin":
+		vuln.Details = "* 'Cross-Origin-Resource-Policy' header set to 'cross-origin', which allows any origin to load the resources."
+		vuln.Score = report.SeverityThresholdNone
+	default:
+		vuln.Details = "* 'Cross-Origin-Resource-Policy' header cannot be recognized."
+		vuln.Recommendations = append(vuln.Recommendations, "Fix the malformed Cross-Origin-Resource-Policy HTTP Header.")
+	}
+	addIsolationVuln(vuln, target, corpHeader, value, s)
+}
+
+// addIsolationVuln adds the vulnerability with the value of the header observed in the response.
+func addIsolationVuln(vuln report.Vulnerability, target, header, value string, s state.State) {
+	observed := value
+	if observed == "" {
+		observed = "(not set)"
+	}
+	vuln.Resources = []report.ResourcesGroup{{
+		Name:   "Observed Header",
+		Header: []string{"Header", "Value"},
+		Rows:   []map[string]string{{"Header": header, "Value": observed}},
+	}}
+	vuln.AffectedResource = target
+	vuln.Fingerprint = helpers.ComputeFingerprint(vuln.Details)
+	s.AddVulnerabilities(vuln)
+}
+
+// headerValue returns the value of the response header with the given name, which is case-insensitive.
+func headerValue(headers map[string]string, name string) (string, bool) {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return strings.TrimSpace(v), true
+		}
+	}
+	return "", false
+}
+
+// isHTML reports whether the response has an HTML content type.
+func isHTML(headers map[string]string) bool {
+	contentType, _ := headerValue(headers, "Content-Type")
+	contentType = policyToken(contentType)
+	return contentType == "text/html" || contentType == "application/xhtml+xml"
+}
+
+// policyToken returns the value of a header without its parameters, in lower case,
+// e.g.: 'same-origin' for 'same-origin; report-to="coop"'.
+func policyToken(value string) string {
+	token, _, _ := strings.Cut(value, ";")
+	return strings.ToLower(strings.TrimSpace(token))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+// finding is the part of the vulnerabilities checked by the tests.
+type finding struct {
+	Summary  string
+	Score    float32
+	Observed string
+}
+
+func runIsolation(headers map[string]string) []finding {
+	var r observatoryResult
+	r.Scan.ResponseHeaders = headers
+	s := state.State{ResultData: &report.ResultData{}}
+
+	processPermissionsPolicy(permissionsPolicyVuln, "example.com", r, s)
+	processCOOP(coopVuln, "example.com", r, s)
+	processCOEP(coepVuln, "example.com", r, s)
+	processCORP(corpVuln, "example.com", r, s)
+
+	var findings []finding
+	for _, v := range s.Vulnerabilities {
+		findings = append(findings, finding{
+			Summary:  v.Summary,
+			Score:    v.Score,
+			Observed: v.Resources[0].Rows[0]["Value"],
+		})
+	}
+	return findings
+}
+
+func TestIsolationHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []finding
+	}{
+		{
+			name:    "MissingHTML",
+			headers: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			want: []finding{
+				{Summary: "HTTP Permissions Policy Not Implemented", Score: 1.0, Observed: "(not set)"},
+				{Summary: "HTTP Cross-Origin-Opener-Policy Not Implemented", Score: 1.5, Observed: "(not set)"},
+				{Summary: "HTTP Cross-Origin-Embedder-Policy Not Implemented", Score: report.SeverityThresholdNone, Observed: "(not set)"},
+				{Summary: "HTTP Cross-Origin-Resource-Policy Not Implemented", Score: 1.0, Observed: "(not set)"},
+			},
+		},
+		{
+			name:    "MissingAPI",
+			headers: map[string]string{"Content-Type": "application/json"},
+			want: []finding{
+				{Summary: "HTTP Permissions Policy Not Implemented", Score: 1.0, Observed: "(not set)"},
+				{Summary: "HTTP Cross-Origin-Resource-Policy Not Implemented", Score: 1.0, Observed: "(not set)"},
+			},
+		},
+		{
+			name: "Secure",
+			headers: map[string]string{
+				"content-type":                 "text/html",
+				"permissions-policy":           "camera=(), microphone=(self), geolocation=(self \"https://maps.example.com\")",
+				"cross-origin-opener-policy":   `same-origin; report-to="coop"`,
+				"cross-origin-embedder-policy": "require-corp",
+				"cross-origin-resource-policy": "same-site",
+			},
+		},
+		{
+			name: "Weak",
+			headers: map[string]string{
+				"Content-Type":                 "text/html",
+				"Permissions-Policy":           "camera=*, microphone=(self *), fullscreen=*",
+				"Cross-Origin-Opener-Policy":   "unsafe-none",
+				"Cross-Origin-Embedder-Policy": "require-everything",
+				"Cross-Origin-Resource-Policy": "cross-origin",
+			},
+			want: []finding{
+				{Summary: "HTTP Permissions Policy Misconfiguration", Score: 2.0, Observed: "camera=*, microphone=(self *), fullscreen=*"},
+				{Summary: "HTTP Cross-Origin-Opener-Policy Misconfiguration", Score: 1.5, Observed: "unsafe-none"},
+				{Summary: "HTTP Cross-Origin-Embedder-Policy Misconfiguration", Score: 1.0, Observed: "require-everything"},
+				{Summary: "HTTP Cross-Origin-Resource-Policy Misconfiguration", Score: report.SeverityThresholdNone, Observed: "cross-origin"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runIsolation(tt.headers)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected findings (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPermissionsPolicyDetails(t *testing.T) {
+	var r observatoryResult
+	r.Scan.ResponseHeaders = map[string]string{"Permissions-Policy": "camera=*, geolocation=(self *), invalid"}
+	s := state.State{ResultData: &report.ResultData{}}
+	processPermissionsPolicy(permissionsPolicyVuln, "example.com", r, s)
+
+	want := "* 'Permissions-Policy' allows the 'camera' feature to any origin.\n" +
+		"* 'Permissions-Policy' allows the 'geolocation' feature to any origin.\n" +
+		"* 'Permissions-Policy' directive 'invalid' cannot be recognized."
+	if len(s.Vulnerabilities) != 1 {
+		t.Fatalf("got %d vulnerabilities, want 1", len(s.Vulnerabilities))
+	}
+	if got := s.Vulnerabilities[0].Details; got != want {
+		t.Errorf("got details %q, want %q", got, want)
+	}
+}
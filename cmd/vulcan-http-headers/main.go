@@ -168,6 +168,14 @@ func processResults(target string, r observatoryResult, s state.State) error {
 
 	processXFrame(xFrameVuln, target, r, s)
 
+	processPermissionsPolicy(permissionsPolicyVuln, target, r, s)
+
+	processCOOP(coopVuln, target, r, s)
+
+	processCOEP(coepVuln, target, r, s)
+
+	processCORP(corpVuln, target, r, s)
+
 	processGrading(observatoryGrading, target, r, s)
 
 	return nil
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/adevinta/restuss"
+)
+
+// apiError is returned when the Nessus API responds with an unexpected
+// status code.
+type apiError struct {
+	Path       string
+	StatusCode int
+	Body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("unexpected status code %d for %s: %s", e.StatusCode, e.Path, e.Body)
+}
+
+// do performs a request to the given path of the Nessus API and decodes the
+// JSON response into v, if it is not nil. It is used for the endpoints, or
+// the fields of the responses, not provided by the restuss client, and when
+// the reason of a failed request must be known.
+func (r *runner) do(ctx context.Context, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.nessusEndpoint+path, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create request object: %w", err)
+	}
+	r.nessusAuth.AddAuthHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &apiError{Path: path, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// get performs a GET request to the given path of the Nessus API and decodes
+// the JSON response into v.
+func (r *runner) get(ctx context.Context, path string, v any) error {
+	return r.do(ctx, http.MethodGet, path, v)
+}
+
+// scanProgress contains the progress of the hosts of a scan returned by the
+// Nessus API.
+type scanProgress struct {
+	Hosts []struct {
+		Current int64 `json:"scanprogresscurrent"`
+		Total   int64 `json:"scanprogresstotal"`
+	} `json:"hosts"`
+}
+
+// completion returns the completion of the scan between 0 and 1. The
+// returned bool is false when the Nessus API does not report the progress of
+// any host.
+func (p scanProgress) completion() (float32, bool) {
+	var current, total int64
+	for _, h := range p.Hosts {
+		current += h.Current
+		total += h.Total
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	if current > total {
+		current = total
+	}
+	return float32(current) / float32(total), true
+}
+
+// getScan returns the details of the given scan along with its progress.
+func (r *runner) getScan(ctx context.Context, id int64) (*restuss.ScanDetail, scanProgress, error) {
+	var raw json.RawMessage
+	if err := r.get(ctx, fmt.Sprintf("/scans/%d", id), &raw); err != nil {
+		return nil, scanProgress{}, err
+	}
+	detail := &restuss.ScanDetail{}
+	if err := json.Unmarshal(raw, detail); err != nil {
+		return nil, scanProgress{}, err
+	}
+	detail.ID = id
+	var progress scanProgress
+	if err := json.Unmarshal(raw, &progress); err != nil {
+		return nil, scanProgress{}, err
+	}
+	return detail, progress, nil
+}
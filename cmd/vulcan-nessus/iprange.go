@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/adevinta/restuss"
+	report "github.com/adevinta/vulcan-report"
+)
+
+const (
+	ipRangeType = "IPRange"
+
+	// Default maximum number of addresses of the IP ranges, i.e. a /24
+	// IPv4 range.
+	defMaxRangeSize = 256
+)
+
+// validateRange returns an error if the given target is not a valid CIDR or
+// if it contains more than maxSize addresses.
+func validateRange(target string, maxSize int) error {
+	_, ipnet, err := net.ParseCIDR(target)
+	if err != nil {
+		return fmt.Errorf("invalid IP range %q: %w", target, err)
+	}
+	ones, bits := ipnet.Mask.Size()
+	hostBits := bits - ones
+	// Avoid overflows with big IPv6 ranges.
+	if hostBits >= 62 || 1<<hostBits > maxSize {
+		return fmt.Errorf("IP range %s is larger than the maximum of %d addresses", target, maxSize)
+	}
+	return nil
+}
+
+// hostVulnerabilities returns the vulnerabilities found in the given host of
+// the scan.
+func (r *runner) hostVulnerabilities(ctx context.Context, hostID int64) ([]restuss.Vulnerability, error) {
+	var host struct {
+		Vulnerabilities []restuss.Vulnerability `json:"vulnerabilities"`
+	}
+	path := fmt.Sprintf("/scans/%d/hosts/%d", r.nessusPersistedScan.ID, hostID)
+	if err := r.get(ctx, path, &host); err != nil {
+		return nil, err
+	}
+	return host.Vulnerabilities, nil
+}
+
+// addRangeVulnerabilities converts the vulnerabilities found in each host of
+// an IP range scan into Vulcan vulnerabilities. The IP of the host is used as
+// the affected resource, so the vulnerabilities of different hosts are not
+// merged.
+func (r *runner) addRangeVulnerabilities(ctx context.Context, scan restuss.ScanDetail) ([]report.Vulnerability, error) {
+	vulns := []report.Vulnerability{}
+	for _, host := range scan.Hosts {
+		hostVulns, err := r.hostVulnerabilities(ctx, host.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error reading vulnerabilities of host %s: %w", host.Hostname, err)
+		}
+		for _, nessusVulnerability := range hostVulns {
+			vulcanVulnerabilities, err := r.translateFromNessusToVulcan(host.ID, host.Hostname, nessusVulnerability)
+			if err != nil {
+				logger.Errorf("Error reading nessusVulnerability[%v] of host %s: %v", nessusVulnerability.PluginName, host.Hostname, err)
+				continue
+			}
+			vulns = append(vulns, vulcanVulnerabilities...)
+		}
+	}
+	return vulns, nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adevinta/restuss"
+)
+
+func TestValidateRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		maxSize int
+		wantErr bool
+	}{
+		{name: "Slash24", target: "10.0.0.0/24", maxSize: defMaxRangeSize},
+		{name: "SingleAddress", target: "10.0.0.1/32", maxSize: defMaxRangeSize},
+		{name: "TooLarge", target: "10.0.0.0/23", maxSize: defMaxRangeSize, wantErr: true},
+		{name: "CustomSize", target: "10.0.0.0/16", maxSize: 65536},
+		{name: "LargeIPv6", target: "2001:db8::/32", maxSize: defMaxRangeSize, wantErr: true},
+		{name: "IPv6", target: "2001:db8::/120", maxSize: defMaxRangeSize},
+		{name: "NotCIDR", target: "10.0.0.1", maxSize: defMaxRangeSize, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRange(tt.target, tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddRangeVulnerabilities(t *testing.T) {
+	setupFakeNessus(t, &fakeNessus{})
+	r := &runner{}
+	if err := r.auth(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.nessusPersistedScan = &restuss.PersistedScan{ID: 1}
+	scan := restuss.ScanDetail{
+		Hosts: []restuss.Host{
+			{ID: 1, Hostname: "10.0.0.1"},
+			{ID: 2, Hostname: "10.0.0.2"},
+		},
+	}
+	got, err := r.addRangeVulnerabilities(context.Background(), scan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d vulnerabilities, want 2", len(got))
+	}
+	for i, want := range []string{"10.0.0.1", "10.0.0.2"} {
+		if got[i].AffectedResource != want {
+			t.Errorf("got affected resource %q, want %q", got[i].AffectedResource, want)
+		}
+		if host := got[i].Resources[0].Rows[0]["Hostname"]; host != want {
+			t.Errorf("got hostname %q, want %q", host, want)
+		}
+	}
+	// The vulnerabilities of different hosts are not deduplicated.
+	if got[0].Fingerprint == got[1].Fingerprint {
+		t.Error("the vulnerabilities of different hosts have the same fingerprint")
+	}
+}
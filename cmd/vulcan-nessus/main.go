@@ -20,6 +20,31 @@ type options struct {
 	PollingInterval int   `json:"polling_interval"`
 	BasicAuth       bool  `json:"basic_auth"`
 	Delete          *bool `json:"delete"`
+
+	// KeepScan prevents the scan from being deleted from Nessus once the
+	// check finishes. It is meant for debugging. It replaces Delete.
+	KeepScan bool `json:"keep_scan"`
+
+	// PolicyID is the ID of the policy used by the scan. TemplateName
+	// selects the policy by name instead. When neither of them is
+	// specified, the policy defined by NESSUS_POLICY_ID is used.
+	PolicyID     int64  `json:"policy_id"`
+	TemplateName string `json:"template_name"`
+
+	// PollInterval is the interval in seconds between the requests to get
+	// the status of the scan. It replaces PollingInterval.
+	PollInterval int `json:"poll_interval"`
+	// ScanTimeout is the maximum duration of the scan in seconds. When it
+	// is exceeded, the scan is stopped and its partial results are
+	// reported.
+	ScanTimeout int `json:"scan_timeout"`
+	// QueueTimeout is the maximum time in seconds to wait for a free scan
+	// slot when Nessus has reached its concurrent scan limit.
+	QueueTimeout int `json:"queue_timeout"`
+
+	// MaxRangeSize is the maximum number of addresses of the IPRange
+	// targets. The default is 256, i.e. a /24 IPv4 range.
+	MaxRangeSize int `json:"max_range_size"`
 }
 
 func main() {
@@ -11,11 +11,12 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/jpillora/backoff"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/adevinta/restuss"
@@ -29,6 +30,22 @@ const (
 	defPollingInterval = 5 * 60
 	// Default delay range is 1min.
 	defDelayRange = 60
+	// Maximum number of characters of the plugin output added to the
+	// vulnerabilities.
+	maxOutputLength = 2048
+	// Suffix added to the truncated plugin outputs.
+	outputTruncatedSuffix = "\n[truncated]"
+	// Default scan timeout is 7h30min, so there is time to stop the scan
+	// and report its partial results before the timeout of the check.
+	defScanTimeout = 7*60*60 + 30*60
+)
+
+var (
+	// stopPollInterval is the polling interval used while waiting for a
+	// scan to stop.
+	stopPollInterval = 2 * time.Second
+	// stopTimeout is the maximum time to wait for a scan to stop.
+	stopTimeout = 5 * time.Minute
 )
 
 // Runner executes a Nessus check.
@@ -38,8 +55,21 @@ type Runner interface {
 
 type runner struct {
 	nessusCli           *restuss.NessusClient
+	nessusAuth          restuss.AuthProvider
+	nessusEndpoint      string
 	nessusPersistedScan *restuss.PersistedScan
-	Delete              bool
+	// keepScan is true when the scan must not be deleted once the check
+	// finishes.
+	keepScan bool
+	// scanStopped is true when the scan has already been stopped, so it
+	// is not stopped again in the clean up step.
+	scanStopped bool
+	// scanLaunched is true when the scan has been launched, and
+	// scanStarted when Nessus has started running it, i.e. it is not
+	// waiting for a free scan slot anymore.
+	scanLaunched bool
+	scanStarted  bool
+	queue        scanQueue
 }
 
 func (r *runner) Run(ctx context.Context, target, assetType, optJSON string, state checkstate.State) (err error) {
@@ -50,6 +80,19 @@ func (r *runner) Run(ctx context.Context, target, assetType, optJSON string, sta
 		}
 	}
 
+	if assetType == ipRangeType {
+		if opt.MaxRangeSize < 0 {
+			return fmt.Errorf("invalid max_range_size %d: must be greater than or equal to 0", opt.MaxRangeSize)
+		}
+		maxRangeSize := opt.MaxRangeSize
+		if maxRangeSize == 0 {
+			maxRangeSize = defMaxRangeSize
+		}
+		if err = validateRange(target, maxRangeSize); err != nil {
+			return err
+		}
+	}
+
 	isReachable, err := helpers.IsReachable(target, assetType, nil)
 	if err != nil {
 		logger.Warnf("Can not check asset reachability: %v", err)
@@ -58,23 +101,39 @@ func (r *runner) Run(ctx context.Context, target, assetType, optJSON string, sta
 		return checkstate.ErrAssetUnreachable
 	}
 
-	p, err := strconv.Atoi(os.Getenv("NESSUS_POLICY_ID"))
-	if err != nil {
-		return fmt.Errorf("wrong value for NESSUS_POLICY_ID: %v", err)
+	if err = opt.policySelection(); err != nil {
+		return err
 	}
-	policyID := int64(p)
 
 	basicAuth := opt.BasicAuth
 
-	// Default value for delete option is TRUE
-	r.Delete = true
-	if opt.Delete != nil {
-		r.Delete = *opt.Delete
+	// The scan is deleted unless the keep_scan option is set. The delete
+	// option is kept for backwards compatibility.
+	r.keepScan = opt.KeepScan || (opt.Delete != nil && !*opt.Delete)
+
+	// The polling_interval option is kept for backwards compatibility.
+	pollInterval := opt.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = opt.PollingInterval
+	}
+	if pollInterval <= 0 {
+		pollInterval = defPollingInterval
+	}
+
+	if opt.ScanTimeout < 0 {
+		return fmt.Errorf("invalid scan_timeout %d: must be greater than or equal to 0", opt.ScanTimeout)
+	}
+	scanTimeout := time.Duration(opt.ScanTimeout) * time.Second
+	if scanTimeout == 0 {
+		scanTimeout = defScanTimeout * time.Second
 	}
 
-	pollingInterval := opt.PollingInterval
-	if pollingInterval <= 0 {
-		pollingInterval = defPollingInterval
+	if opt.QueueTimeout < 0 {
+		return fmt.Errorf("invalid queue_timeout %d: must be greater than or equal to 0", opt.QueueTimeout)
+	}
+	r.queue.timeout = time.Duration(opt.QueueTimeout) * time.Second
+	if r.queue.timeout == 0 {
+		r.queue.timeout = defQueueTimeout * time.Second
 	}
 
 	// In order to not overload Tenable API
@@ -90,32 +149,84 @@ func (r *runner) Run(ctx context.Context, target, assetType, optJSON string, sta
 	time.Sleep(delay)
 
 	logger = logger.WithFields(log.Fields{
-		"target":    target,
-		"policy ID": policyID,
+		"target": target,
 	})
 	err = r.auth(basicAuth)
 	if err != nil {
 		return err
 	}
-	policy, err := r.loadPolicyDetails(ctx, policyID)
+	policy, err := r.resolvePolicy(ctx, opt)
 	if err != nil {
 		return err
 	}
-	scan, err := r.launchScan(ctx, target, policy)
+	logger = logger.WithFields(log.Fields{
+		"policy ID": policy.ID,
+	})
+	logger.Infof("Using policy %s", policyDetails(policy))
+	scan, err := r.createScan(ctx, target, policy)
 	if err != nil {
 		return err
 	}
 	// We need to store in a field the scan info in order to delete it in the clean
 	// up step.
 	r.nessusPersistedScan = scan
-	scanDetail, err := r.waitUntilScanFinishes(ctx, pollingInterval)
-	if err != nil {
+	// The scan is cleaned up whatever the result of the check is. The
+	// errors cleaning it up are only logged, so they don't override the
+	// error returned by the check.
+	defer r.cleanUpScan(context.Background())
+	if err = r.startScan(ctx, state); err != nil {
+		r.discardScan()
+		return err
+	}
+	r.scanLaunched = true
+	waitCtx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+	scanDetail, err := r.waitUntilScanFinishes(waitCtx, time.Duration(pollInterval)*time.Second, state)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		// The check has been aborted, so the scan is stopped in the clean
+		// up in order to not leave it running in Nessus. If it was still
+		// queued, it is also deleted.
+		if !r.scanStarted {
+			r.discardScan()
+		}
+		return err
+	case errors.Is(err, errQueueTimeout):
+		r.discardScan()
+		return err
+	case errors.Is(err, context.DeadlineExceeded):
+		logger.Warnf("Scan timeout of %v exceeded, stopping the scan", scanTimeout)
+		scanDetail, err = r.stopScan(context.Background())
+		if err != nil {
+			return fmt.Errorf("error stopping the scan after the scan timeout: %w", err)
+		}
+		addNote(state, fmt.Sprintf("The scan was truncated after reaching the scan timeout of %v. Only the vulnerabilities found until then are reported.", scanTimeout))
+	default:
 		return err
 	}
-	vulns, err := r.addVulnerabilities(*scanDetail, target)
+	if r.queue.waited > 0 {
+		addNote(state, fmt.Sprintf("The scan was queued for %v waiting for a free Nessus scan slot.", r.queue.waited.Round(time.Second)))
+	}
+	var vulns []report.Vulnerability
+	if assetType == ipRangeType {
+		// The range is scanned as a single target, but the vulnerabilities
+		// are reported per host.
+		vulns, err = r.addRangeVulnerabilities(ctx, *scanDetail)
+	} else {
+		vulns, err = r.addVulnerabilities(*scanDetail, target)
+	}
 	if err != nil {
 		return err
 	}
+	// The policy is added to the details once the fingerprints are
+	// computed, so changing the policy does not change them.
+	for i := range vulns {
+		if vulns[i].Details != "" {
+			vulns[i].Details += "\n\n"
+		}
+		vulns[i].Details += policyDetails(policy)
+	}
 	state.AddVulnerabilities(vulns...)
 	return nil
 }
@@ -129,11 +240,14 @@ func (r *runner) auth(basicAuth bool) error {
 		auth = restuss.NewKeyAuthProvider(os.Getenv("NESSUS_USERNAME"), os.Getenv("NESSUS_PASSWORD"))
 	}
 
-	cli, err := restuss.NewClient(auth, os.Getenv("NESSUS_ENDPOINT"), false)
+	endpoint := os.Getenv("NESSUS_ENDPOINT")
+	cli, err := restuss.NewClient(auth, endpoint, false)
 	if err != nil {
 		return fmt.Errorf("error creating restuss client: %+v", err)
 	}
 	r.nessusCli = cli
+	r.nessusAuth = auth
+	r.nessusEndpoint = endpoint
 	return nil
 }
 
@@ -148,7 +262,7 @@ func (r *runner) loadPolicyDetails(ctx context.Context, policyID int64) (restuss
 	return *policyDetails, nil
 }
 
-func (r *runner) launchScan(ctx context.Context, target string, policy restuss.Policy) (*restuss.PersistedScan, error) {
+func (r *runner) createScan(ctx context.Context, target string, policy restuss.Policy) (*restuss.PersistedScan, error) {
 	scan, err := r.nessusCli.CreateScanContext(ctx,
 		&restuss.Scan{
 			TemplateUUID: policy.UUID,
@@ -161,34 +275,10 @@ func (r *runner) launchScan(ctx context.Context, target string, policy restuss.P
 	if err != nil {
 		return nil, err
 	}
-	logger := logger.WithFields(log.Fields{
+	logger.WithFields(log.Fields{
 		"scan": fmt.Sprintf("%+v", scan),
-	})
-	logger.Debug("Scan Created")
-
-	b := &backoff.Backoff{
-		Min:    100 * time.Millisecond,
-		Max:    60 * time.Second,
-		Factor: 1.5,
-		Jitter: true,
-	}
-
-	rand.Seed(time.Now().UnixNano())
-
-	// Try 20 times then return an error
-	for i := 0; i < 20; i++ {
-		err = r.nessusCli.LaunchScan(scan.ID)
-		if err == nil {
-			return scan, nil
-		}
-
-		d := b.Duration()
-		logger.Debug(fmt.Sprintf("Err when launching scan: %v, trying again in %v", err, d))
-		time.Sleep(d)
-	}
-
-	return nil, fmt.Errorf("Not possible to launch scan: %v", scan.ID)
-
+	}).Debug("Scan Created")
+	return scan, nil
 }
 
 func (r *runner) deleteScan(ctx context.Context, scanID int64) error {
@@ -207,54 +297,112 @@ func (r *runner) deleteScan(ctx context.Context, scanID int64) error {
 	return err
 }
 
-func (r *runner) waitUntilScanFinishes(ctx context.Context, pollingInterval int) (*restuss.ScanDetail, error) {
-	t := time.NewTicker(time.Duration(pollingInterval) * time.Second)
+// waitUntilScanFinishes polls the status of the scan until it is completed.
+// The completion of the scan is reported to the given progress reporter, if
+// any.
+func (r *runner) waitUntilScanFinishes(ctx context.Context, pollingInterval time.Duration, progress checkstate.ProgressReporter) (*restuss.ScanDetail, error) {
+	t := time.NewTicker(pollingInterval)
+	defer t.Stop()
 LOOP:
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Infof("ctx.Done")
-			t.Stop()
 			return nil, ctx.Err()
 		case <-t.C:
-			scanDetail, err := r.nessusCli.GetScanByID(r.nessusPersistedScan.ID)
+			scanDetail, scanProgress, err := r.getScan(ctx, r.nessusPersistedScan.ID)
 			if err != nil {
 				logger.WithFields(log.Fields{
 					"scan": fmt.Sprintf("%+v", r.nessusPersistedScan),
 				}).Errorf("Error while retrieving scan details: %v", err)
 				continue LOOP
 			}
-			if scanDetail == nil {
-				logger.WithFields(log.Fields{
-					"scan": fmt.Sprintf("%+v", r.nessusPersistedScan),
-				}).Errorf("Missing Status information when retrieving Nessus scan information. will try again in 30 seconds.")
-				continue LOOP
+			if !r.scanStarted {
+				if isQueued(scanDetail.Info.Status) {
+					if err := r.queue.wait(progress); err != nil {
+						return nil, err
+					}
+					continue LOOP
+				}
+				r.scanStarted = true
+				r.queue.done()
 			}
+
+			completion, ok := scanProgress.completion()
 			logger.WithFields(log.Fields{
 				"nessusScanID": fmt.Sprintf("%+v", r.nessusPersistedScan.ID),
-			}).Infof("Status: %s", scanDetail.Info.Status)
+			}).Infof("Status: %s, progress: %.0f%%", scanDetail.Info.Status, completion*100)
+			if ok && progress != nil {
+				progress.SetProgress(completion)
+			}
 
 			if scanDetail.Info.Status == "completed" {
-				t.Stop()
 				return scanDetail, nil
 			}
 
 			if scanDetail.Info.Status == "canceled" {
-				t.Stop()
 				return nil, errors.New("canceled")
 			}
 
 			if scanDetail.Info.Status == "aborted" {
-				t.Stop()
 				return nil, errors.New("aborted")
 			}
 		}
 	}
 }
 
+// stopScan stops the scan and waits until Nessus reports it as finished.
+// It returns the last details of the scan, which contain the vulnerabilities
+// found until it was stopped.
+func (r *runner) stopScan(ctx context.Context) (*restuss.ScanDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, stopTimeout)
+	defer cancel()
+	id := r.nessusPersistedScan.ID
+	scanDetail, _, err := r.getScan(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !scanFinished(scanDetail.Info.Status) {
+		logger.Debug("stopping scan")
+		if err := r.nessusCli.StopScanContext(ctx, id); err != nil {
+			return nil, fmt.Errorf("error trying to stop the scan: %w", err)
+		}
+	}
+	r.scanStopped = true
+	for !scanFinished(scanDetail.Info.Status) {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error while waiting the scan to stop: %w", ctx.Err())
+		case <-time.After(stopPollInterval):
+		}
+		scanDetail, _, err = r.getScan(ctx, id)
+		if err != nil {
+			logger.Errorf("Error while retrieving scan details: %v", err)
+			scanDetail = &restuss.ScanDetail{}
+		}
+	}
+	return scanDetail, nil
+}
+
+// scanFinished returns true if the given scan status is final.
+func scanFinished(status string) bool {
+	switch status {
+	case "completed", "canceled", "aborted":
+		return true
+	}
+	return false
+}
+
 // CleanUp is called by the sdk when the check needs to be aborted in order to give the
 // opportunity to clean up resources.
 func (r *runner) CleanUp(ctx context.Context, target, assetType, opts string) {
+	r.cleanUpScan(ctx)
+}
+
+// cleanUpScan stops the scan, if it is still running, and deletes it from
+// Nessus along with its history, unless the keep_scan option is set. The
+// errors are logged but not returned.
+func (r *runner) cleanUpScan(ctx context.Context) {
 	l := logger.WithFields(log.Fields{"action": "CleanUp"})
 	l.Debug("cleaning up nessus scan")
 	if r.nessusPersistedScan == nil {
@@ -262,34 +410,23 @@ func (r *runner) CleanUp(ctx context.Context, target, assetType, opts string) {
 		return
 	}
 	id := r.nessusPersistedScan.ID
-	// Get the last status of the scan.
-	scanDetail, err := r.nessusCli.GetScanByIDContext(ctx, r.nessusPersistedScan.ID)
-	if err != nil {
-		l.Errorf("error cleaning scan %+v", r.nessusPersistedScan)
-		return
-	}
-	if !(scanDetail.Info.Status == "canceled") && !(scanDetail.Info.Status == "completed") {
-		l.Debug("stopping scan")
-		err = r.nessusCli.StopScanContext(ctx, id)
-		if err != nil {
-			l.WithError(err).Errorf("error trying to stop the scan")
-			return
-		}
-		// We decrease the pool time here because stoping a scan should take far less time
-		// than running a scan.
-		_, err = r.waitUntilScanFinishes(ctx, 2)
-		if err != nil && err.Error() != "canceled" {
-			l.WithError(err).Errorf("error while waiting the scan to stop")
+	if r.scanLaunched && !r.scanStopped {
+		if _, err := r.stopScan(ctx); err != nil {
+			l.WithError(err).Errorf("error cleaning scan %+v", r.nessusPersistedScan)
 			return
 		}
 	}
 
-	if r.Delete {
-		err = r.deleteScan(ctx, id)
-		if err != nil {
-			l.WithError(err).Error("error deleting scan")
-		}
+	if r.keepScan {
+		l.Infof("Keeping scan %d in Nessus", id)
+		r.nessusPersistedScan = nil
+		return
+	}
+	if err := r.deleteScan(ctx, id); err != nil {
+		l.WithError(err).Error("error deleting scan")
+		return
 	}
+	r.nessusPersistedScan = nil
 }
 
 func (r *runner) addVulnerabilities(scan restuss.ScanDetail, target string) ([]report.Vulnerability, error) {
@@ -333,6 +470,9 @@ func (r *runner) addVulnerabilities(scan restuss.ScanDetail, target string) ([]r
 // information found to report the issue. For example for the `SSL Version 2
 // and 3 Protocol Detection` plugin it reports information about the protocols
 // and ciphersuites enabled for the target.
+//
+// The outputs of the plugin are aggregated in a single vulnerability with a
+// row per port in its resources.
 func (r *runner) translateFromNessusToVulcan(hostID int64, target string, nessusVulnerability restuss.Vulnerability) ([]report.Vulnerability, error) {
 	p, err := r.nessusCli.GetPluginByID(nessusVulnerability.PluginID)
 	if err != nil {
@@ -405,12 +545,14 @@ func (r *runner) translateFromNessusToVulcan(hostID int64, target string, nessus
 		return nil, err
 	}
 
+	// As the findings of the plugin are aggregated in a single
+	// vulnerability, we can't be more specific for the affected resource
+	// than the whole target.
+	vulcanVulnerability.AffectedResource = target
+
 	// In the case Nessus doesn't provide runtime/context information there's
 	// no much we can state in addition from what the plugin itself describes.
 	if len(pluginOutput.Output) < 1 {
-		// As there are no ports specified in the Output, we can't be more
-		// specific for the affected resource than the whole target.
-		vulcanVulnerability.AffectedResource = target
 		// As we don't have context information from the Output, at least we
 		// use the score as a fingerprint.
 		vulcanVulnerability.Fingerprint = helpers.ComputeFingerprint(vulcanVulnerability.Score)
@@ -418,68 +560,93 @@ func (r *runner) translateFromNessusToVulcan(hostID int64, target string, nessus
 		return []report.Vulnerability{vulcanVulnerability}, nil
 	}
 
-	var vulnerabilities []report.Vulnerability
-	// Create a new vulnerability per each Output and Port (in case they
-	// exist).  Port format seems to be 'port / protocol / service'. For
-	// example: '25 / tcp / smtp'.  In case the Output is not associated to a
-	// specific port, Nessus seems to be using '0 / tcp'.
+	// Add a row per each Output and Port (in case they exist). Port format
+	// seems to be 'port / protocol / service'. For example: '25 / tcp /
+	// smtp'. In case the Output is not associated to a specific port, Nessus
+	// seems to be using '0 / tcp'.
+	var rows []map[string]string
 	for _, output := range pluginOutput.Output {
-		v := vulcanVulnerability
-		v.Details = output.Output
+		out := truncateOutput(output.Output)
 
 		mapPorts, ok := output.Ports.(map[string]interface{})
 		// Only parse the mapPorts if we get the right type.
 		if !ok || len(mapPorts) < 1 {
 			logger.Warnf("unexpected type for Output.Ports: %#v", output.Ports)
 
-			// Again, if there are no ports specified we can't be more precise
-			// than using the target as the affected resource.
-			v.AffectedResource = target
-			// Apart from the score, we can use the Details as a fingerprint,
-			// that is supposed to give the context of the vulnerability in the
-			// scanned target.
-			//
-			// NOTE: in the examples we analyzed the Details field seemed to be
-			// stable between executions, but there might be plugins where this
-			// information changes more often than expected.
-			v.Fingerprint = helpers.ComputeFingerprint(v.Score, v.Details)
-
-			vulnerabilities = append(vulnerabilities, v)
-
+			// If there are no ports specified we can't be more precise than
+			// using the target.
+			rows = append(rows, map[string]string{
+				"Hostname": target,
+				"Output":   out,
+			})
 			continue
 		}
 
 		for portInformation := range mapPorts {
-			v := v
-
+			row := map[string]string{
+				"Hostname": target,
+				"Port":     portInformation,
+				"Output":   out,
+			}
 			parts := strings.Split(portInformation, " / ")
+			if len(parts) > 1 {
+				row["Port"] = parts[0]
+				row["Protocol"] = parts[1]
+			}
 			if len(parts) > 2 {
-				networkResource := map[string]string{
-					"Hostname": target,
-					"Port":     parts[0],
-					"Protocol": parts[1],
-					"Service":  parts[2],
-				}
-				v.Resources = []report.ResourcesGroup{
-					report.ResourcesGroup{
-						Name: "Network Resources",
-						Header: []string{
-							"Hostname",
-							"Port",
-							"Protocol",
-							"Service",
-						},
-						Rows: []map[string]string{networkResource},
-					},
-				}
+				row["Service"] = parts[2]
 			}
-
-			v.AffectedResource = portInformation
-			v.Fingerprint = helpers.ComputeFingerprint(v.Score, v.Details, v.Resources)
-
-			vulnerabilities = append(vulnerabilities, v)
+			rows = append(rows, row)
 		}
 	}
+	sortRows(rows)
+
+	vulcanVulnerability.Resources = []report.ResourcesGroup{
+		{
+			Name: "Network Resources",
+			Header: []string{
+				"Hostname",
+				"Port",
+				"Protocol",
+				"Service",
+				"Output",
+			},
+			Rows: rows,
+		},
+	}
+	// Apart from the score, we use the rows as a fingerprint, as they give
+	// the context of the vulnerability in the scanned target.
+	//
+	// NOTE: in the examples we analyzed the Output field seemed to be stable
+	// between executions, but there might be plugins where this information
+	// changes more often than expected.
+	vulcanVulnerability.Fingerprint = helpers.ComputeFingerprint(vulcanVulnerability.Score, rows)
+
+	return []report.Vulnerability{vulcanVulnerability}, nil
+}
+
+// truncateOutput truncates the given plugin output to maxOutputLength
+// characters.
+func truncateOutput(output string) string {
+	output = strings.TrimSpace(output)
+	if utf8.RuneCountInString(output) <= maxOutputLength {
+		return output
+	}
+	return string([]rune(output)[:maxOutputLength]) + outputTruncatedSuffix
+}
 
-	return vulnerabilities, nil
+// sortRows sorts the rows of the network resources by port number, protocol
+// and output, so the order does not depend on the order returned by Nessus.
+func sortRows(rows []map[string]string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		pi, _ := strconv.Atoi(rows[i]["Port"])
+		pj, _ := strconv.Atoi(rows[j]["Port"])
+		if pi != pj {
+			return pi < pj
+		}
+		if rows[i]["Protocol"] != rows[j]["Protocol"] {
+			return rows[i]["Protocol"] < rows[j]["Protocol"]
+		}
+		return rows[i]["Output"] < rows[j]["Output"]
+	})
 }
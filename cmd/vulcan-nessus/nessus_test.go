@@ -0,0 +1,502 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adevinta/restuss"
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeScanStatus is a status of the scan returned by the fake Nessus API.
+type fakeScanStatus struct {
+	status  string
+	current int64
+	total   int64
+}
+
+// fakeNessus implements the endpoints of the Nessus API used by the check
+// for a single scan with a single host and vulnerability.
+type fakeNessus struct {
+	policies []policySummary
+	// statuses is the sequence of statuses returned while the scan is
+	// running. The last one is repeated. Once the scan is stopped, its
+	// status is "canceled".
+	statuses []fakeScanStatus
+	// outputs are the outputs of the plugin of the vulnerability.
+	outputs []map[string]any
+	// limitedLaunches is the number of launches rejected because of the
+	// concurrent scan limit.
+	limitedLaunches int
+	// onLaunch is called on each launch of the scan, and onPoll each
+	// time the status of the scan is requested.
+	onLaunch func(launches int)
+	onPoll   func()
+	// deleteFails makes the requests to delete the scan fail.
+	deleteFails bool
+
+	mu       sync.Mutex
+	launches int
+	polls    int
+	stops    int
+	deletes  int
+}
+
+// counts returns the number of times the scan has been stopped and
+// deleted.
+func (f *fakeNessus) counts() (stops, deletes int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stops, f.deletes
+}
+
+func (f *fakeNessus) scan(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := fakeScanStatus{status: "canceled"}
+	if f.stops == 0 {
+		s = f.statuses[min(f.polls, len(f.statuses)-1)]
+		f.polls++
+		if f.onPoll != nil {
+			defer f.onPoll()
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"info": map[string]any{"status": s.status},
+		"hosts": []map[string]any{{
+			"host_id":             1,
+			"hostname":            "127.0.0.1",
+			"scanprogresscurrent": s.current,
+			"scanprogresstotal":   s.total,
+		}},
+		"vulnerabilities": []map[string]any{{
+			"plugin_id":   10,
+			"plugin_name": "SSL Certificate Expiry",
+			"severity":    2,
+		}},
+	})
+}
+
+// setupFakeNessus starts the fake Nessus API and points the check to it.
+func setupFakeNessus(t *testing.T, f *fakeNessus) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /policies", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"policies": f.policies})
+	})
+	for _, p := range f.policies {
+		mux.HandleFunc(fmt.Sprintf("GET /policies/%d", p.ID), func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{
+				"uuid":     fmt.Sprintf("uuid-%d", p.ID),
+				"settings": map[string]any{"name": p.Name},
+			})
+		})
+	}
+	mux.HandleFunc("POST /scans", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"scan": map[string]any{"id": 1}})
+	})
+	mux.HandleFunc("POST /scans/1/launch", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.launches++
+		if f.onLaunch != nil {
+			f.onLaunch(f.launches)
+		}
+		if f.launches <= f.limitedLaunches {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"The maximum number of concurrent scans has been reached."}`))
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("GET /scans/1", f.scan)
+	mux.HandleFunc("POST /scans/1/stop", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.stops++
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("DELETE /scans/1", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.deletes++
+		if f.deleteFails {
+			// Close the connection, so the client does not retry.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	mux.HandleFunc("GET /plugins/plugin/10", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":   10,
+			"name": "SSL Certificate Expiry",
+			"attributes": []map[string]any{
+				{"attribute_name": "cvss3_base_score", "attribute_value": "5.3"},
+			},
+		})
+	})
+	mux.HandleFunc("GET /scans/1/hosts/{host}", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"vulnerabilities": []map[string]any{{
+				"plugin_id":   10,
+				"plugin_name": "SSL Certificate Expiry",
+				"severity":    2,
+			}},
+		})
+	})
+	mux.HandleFunc("GET /scans/1/hosts/{host}/plugins/10", func(w http.ResponseWriter, r *http.Request) {
+		outputs := f.outputs
+		if outputs == nil {
+			outputs = []map[string]any{{
+				"plugin_output": "The certificate has expired.",
+				"ports":         map[string]any{"443 / tcp / www": nil},
+			}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"outputs": outputs})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Setenv("NESSUS_ENDPOINT", srv.URL)
+	t.Setenv("NESSUS_POLICY_ID", "9")
+
+	oldStopPollInterval, oldQueueMinDelay, oldQueueMaxDelay := stopPollInterval, queueMinDelay, queueMaxDelay
+	t.Cleanup(func() {
+		stopPollInterval, queueMinDelay, queueMaxDelay = oldStopPollInterval, oldQueueMinDelay, oldQueueMaxDelay
+	})
+	stopPollInterval, queueMinDelay, queueMaxDelay = time.Millisecond, time.Millisecond, 10*time.Millisecond
+}
+
+func testState(rd *report.ResultData, progress *[]float32) checkstate.State {
+	return checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(p float32) {
+			if progress != nil {
+				*progress = append(*progress, p)
+			}
+		}),
+		ResultData: rd,
+	}
+}
+
+var testPolicies = []policySummary{{ID: 9, Name: "Full Scan"}}
+
+func TestWaitUntilScanFinishes(t *testing.T) {
+	f := &fakeNessus{
+		policies: testPolicies,
+		statuses: []fakeScanStatus{
+			{status: "pending"},
+			{status: "running", current: 0, total: 200},
+			{status: "running", current: 100, total: 200},
+			{status: "completed", current: 200, total: 200},
+		},
+	}
+	setupFakeNessus(t, f)
+	r := &runner{}
+	if err := r.auth(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.nessusPersistedScan = &restuss.PersistedScan{ID: 1}
+	r.queue.timeout = time.Minute
+	var progress []float32
+	var rd report.ResultData
+	got, err := r.waitUntilScanFinishes(context.Background(), time.Millisecond, testState(&rd, &progress))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Info.Status != "completed" {
+		t.Errorf("got status %q, want completed", got.Info.Status)
+	}
+	// The progress is 0 while the scan is pending.
+	if diff := cmp.Diff([]float32{0, 0, 0.5, 1}, progress); diff != "" {
+		t.Errorf("unexpected progress (-want +got):\n%v", diff)
+	}
+	if r.queue.waited <= 0 {
+		t.Error("the time the scan was pending has not been recorded")
+	}
+}
+
+func TestWaitUntilScanFinishesQueueTimeout(t *testing.T) {
+	f := &fakeNessus{
+		policies: testPolicies,
+		statuses: []fakeScanStatus{{status: "pending"}},
+	}
+	setupFakeNessus(t, f)
+	r := &runner{}
+	if err := r.auth(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.nessusPersistedScan = &restuss.PersistedScan{ID: 1}
+	r.queue.timeout = 20 * time.Millisecond
+	var rd report.ResultData
+	_, err := r.waitUntilScanFinishes(context.Background(), time.Millisecond, testState(&rd, nil))
+	if !errors.Is(err, errQueueTimeout) {
+		t.Errorf("got error %v, want %v", err, errQueueTimeout)
+	}
+}
+
+func TestRun(t *testing.T) {
+	wantVuln := report.Vulnerability{
+		Summary:          "SSL Certificate Expiry",
+		Score:            5.3,
+		Labels:           []string{"issue", "nessus"},
+		AffectedResource: "127.0.0.1",
+		Details:          "Policy: Full Scan (9)",
+		Resources: []report.ResourcesGroup{{
+			Name:   "Network Resources",
+			Header: []string{"Hostname", "Port", "Protocol", "Service", "Output"},
+			Rows: []map[string]string{{
+				"Hostname": "127.0.0.1",
+				"Port":     "443",
+				"Protocol": "tcp",
+				"Service":  "www",
+				"Output":   "The certificate has expired.",
+			}},
+		}},
+	}
+	tests := []struct {
+		name        string
+		opts        string
+		statuses    []fakeScanStatus
+		limited     int
+		cancel      func(cancel context.CancelFunc, f *fakeNessus)
+		deleteFails bool
+		wantErr     string
+		wantStops   int
+		wantKept    bool
+		wantNotes   string
+		wantVulns   []report.Vulnerability
+	}{
+		{
+			name:      "Completed",
+			opts:      `{"delay_range":1,"poll_interval":1}`,
+			statuses:  []fakeScanStatus{{status: "completed", current: 100, total: 100}},
+			wantVulns: []report.Vulnerability{wantVuln},
+		},
+		{
+			name:      "ScanTimeout",
+			opts:      `{"delay_range":1,"poll_interval":60,"scan_timeout":1}`,
+			statuses:  []fakeScanStatus{{status: "running", current: 30, total: 100}},
+			wantStops: 1,
+			wantNotes: "The scan was truncated after reaching the scan timeout of 1s. Only the vulnerabilities found until then are reported.",
+			wantVulns: []report.Vulnerability{wantVuln},
+		},
+		{
+			name:     "Canceled",
+			opts:     `{"delay_range":1,"poll_interval":1}`,
+			statuses: []fakeScanStatus{{status: "running", current: 30, total: 100}},
+			cancel: func(cancel context.CancelFunc, f *fakeNessus) {
+				f.onPoll = cancel
+			},
+			wantErr:   "context canceled",
+			wantStops: 1,
+		},
+		{
+			name:      "ConcurrencyLimitLifted",
+			opts:      `{"delay_range":1,"poll_interval":1}`,
+			limited:   3,
+			statuses:  []fakeScanStatus{{status: "pending"}, {status: "completed", current: 100, total: 100}},
+			wantNotes: "The scan was queued for 1s waiting for a free Nessus scan slot.",
+			wantVulns: []report.Vulnerability{wantVuln},
+		},
+		{
+			name:     "QueueTimeout",
+			opts:     `{"delay_range":1,"queue_timeout":1}`,
+			limited:  math.MaxInt,
+			statuses: []fakeScanStatus{{status: "pending"}},
+			wantErr:  "no free Nessus scan slot",
+		},
+		{
+			name:     "CanceledWhileQueued",
+			opts:     `{"delay_range":1}`,
+			limited:  math.MaxInt,
+			statuses: []fakeScanStatus{{status: "pending"}},
+			cancel: func(cancel context.CancelFunc, f *fakeNessus) {
+				f.onLaunch = func(launches int) {
+					if launches == 3 {
+						cancel()
+					}
+				}
+			},
+			wantErr: "context canceled",
+		},
+		{
+			name:     "Aborted",
+			opts:     `{"delay_range":1,"poll_interval":1}`,
+			statuses: []fakeScanStatus{{status: "aborted"}},
+			wantErr:  "aborted",
+		},
+		{
+			name:        "DeleteFails",
+			opts:        `{"delay_range":1,"poll_interval":1}`,
+			statuses:    []fakeScanStatus{{status: "aborted"}},
+			deleteFails: true,
+			wantErr:     "aborted",
+		},
+		{
+			name:      "KeepScan",
+			opts:      `{"delay_range":1,"poll_interval":1,"keep_scan":true}`,
+			statuses:  []fakeScanStatus{{status: "completed", current: 100, total: 100}},
+			wantKept:  true,
+			wantVulns: []report.Vulnerability{wantVuln},
+		},
+		{
+			name:      "DeleteFalse",
+			opts:      `{"delay_range":1,"poll_interval":1,"delete":false}`,
+			statuses:  []fakeScanStatus{{status: "completed", current: 100, total: 100}},
+			wantKept:  true,
+			wantVulns: []report.Vulnerability{wantVuln},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			f := &fakeNessus{
+				policies:        testPolicies,
+				statuses:        tt.statuses,
+				limitedLaunches: tt.limited,
+				deleteFails:     tt.deleteFails,
+			}
+			if tt.cancel != nil {
+				tt.cancel(cancel, f)
+			}
+			setupFakeNessus(t, f)
+			var rd report.ResultData
+			r := &runner{}
+			err := r.Run(ctx, "127.0.0.1", "IP", tt.opts, testState(&rd, nil))
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			ignore := cmpopts.IgnoreFields(report.Vulnerability{}, "Fingerprint")
+			if diff := cmp.Diff(tt.wantVulns, rd.Vulnerabilities, ignore); diff != "" {
+				t.Errorf("unexpected vulnerabilities (-want +got):\n%v", diff)
+			}
+			if rd.Notes != tt.wantNotes {
+				t.Errorf("got notes %q, want %q", rd.Notes, tt.wantNotes)
+			}
+			stops, deletes := f.counts()
+			if stops != tt.wantStops {
+				t.Errorf("got %d stops, want %d", stops, tt.wantStops)
+			}
+			// The scan is deleted when Run returns on every exit path,
+			// even when it has not been launched.
+			if tt.wantKept && deletes > 0 {
+				t.Error("the scan has been deleted")
+			}
+			if !tt.wantKept && deletes == 0 {
+				t.Error("the scan has not been deleted")
+			}
+			// The clean up of the SDK does not delete it again.
+			r.CleanUp(context.Background(), "127.0.0.1", "IP", tt.opts)
+			if _, after := f.counts(); !tt.deleteFails && after != deletes {
+				t.Errorf("the scan has been deleted again in the clean up")
+			}
+		})
+	}
+}
+
+func TestScanProgressCompletion(t *testing.T) {
+	var p scanProgress
+	data := `{"hosts":[{"scanprogresscurrent":50,"scanprogresstotal":100},{"scanprogresscurrent":25,"scanprogresstotal":100}]}`
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, ok := p.completion()
+	if !ok || got != 0.375 {
+		t.Errorf("got completion %v %v, want 0.375 true", got, ok)
+	}
+	if _, ok := (scanProgress{}).completion(); ok {
+		t.Error("got completion for a scan without hosts")
+	}
+}
+
+func TestTranslateFromNessusToVulcan(t *testing.T) {
+	longOutput := strings.Repeat("a", maxOutputLength+10)
+	tests := []struct {
+		name    string
+		outputs []map[string]any
+		want    []map[string]string
+	}{
+		{
+			name: "MultiplePorts",
+			outputs: []map[string]any{
+				{
+					"plugin_output": "TLSv1.0 is enabled.",
+					"ports": map[string]any{
+						"8443 / tcp / www": nil,
+						"443 / tcp / www":  nil,
+					},
+				},
+				{
+					"plugin_output": "TLSv1.1 is enabled.\n",
+					"ports":         map[string]any{"25 / tcp / smtp": nil},
+				},
+			},
+			want: []map[string]string{
+				{"Hostname": "127.0.0.1", "Port": "25", "Protocol": "tcp", "Service": "smtp", "Output": "TLSv1.1 is enabled."},
+				{"Hostname": "127.0.0.1", "Port": "443", "Protocol": "tcp", "Service": "www", "Output": "TLSv1.0 is enabled."},
+				{"Hostname": "127.0.0.1", "Port": "8443", "Protocol": "tcp", "Service": "www", "Output": "TLSv1.0 is enabled."},
+			},
+		},
+		{
+			name: "NoPorts",
+			outputs: []map[string]any{
+				{"plugin_output": "The host is up."},
+			},
+			want: []map[string]string{
+				{"Hostname": "127.0.0.1", "Output": "The host is up."},
+			},
+		},
+		{
+			name: "TruncatedOutput",
+			outputs: []map[string]any{
+				{"plugin_output": longOutput, "ports": map[string]any{"0 / tcp": nil}},
+			},
+			want: []map[string]string{
+				{"Hostname": "127.0.0.1", "Port": "0", "Protocol": "tcp", "Output": longOutput[:maxOutputLength] + outputTruncatedSuffix},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakeNessus(t, &fakeNessus{outputs: tt.outputs})
+			r := &runner{}
+			if err := r.auth(false); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			r.nessusPersistedScan = &restuss.PersistedScan{ID: 1}
+			got, err := r.translateFromNessusToVulcan(1, "127.0.0.1", restuss.Vulnerability{PluginID: 10, Severity: 2})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d vulnerabilities, want 1", len(got))
+			}
+			if got[0].Score != 5.3 {
+				t.Errorf("got score %v, want 5.3", got[0].Score)
+			}
+			if diff := cmp.Diff(tt.want, got[0].Resources[0].Rows); diff != "" {
+				t.Errorf("unexpected rows (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
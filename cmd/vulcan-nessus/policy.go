@@ -0,0 +1,107 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adevinta/restuss"
+)
+
+// policySummary is the summary of a policy returned by the policies list
+// endpoint of the Nessus API.
+type policySummary struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (p policySummary) String() string {
+	return fmt.Sprintf("%s (%d)", p.Name, p.ID)
+}
+
+// policySelection validates the policy options. It returns an error if both
+// policy_id and template_name are specified.
+func (o options) policySelection() error {
+	if o.PolicyID < 0 {
+		return fmt.Errorf("invalid policy_id %d", o.PolicyID)
+	}
+	if o.PolicyID != 0 && o.TemplateName != "" {
+		return errors.New("policy_id and template_name are mutually exclusive")
+	}
+	return nil
+}
+
+// defaultPolicyID returns the ID of the policy configured in the
+// NESSUS_POLICY_ID env var.
+func defaultPolicyID() (int64, error) {
+	p, err := strconv.Atoi(os.Getenv("NESSUS_POLICY_ID"))
+	if err != nil {
+		return 0, fmt.Errorf("wrong value for NESSUS_POLICY_ID: %v", err)
+	}
+	return int64(p), nil
+}
+
+// listPolicies returns the policies available in the Nessus instance.
+func (r *runner) listPolicies(ctx context.Context) ([]policySummary, error) {
+	var list struct {
+		Policies []policySummary `json:"policies"`
+	}
+	if err := r.get(ctx, "/policies", &list); err != nil {
+		return nil, fmt.Errorf("error listing policies: %w", err)
+	}
+	return list.Policies, nil
+}
+
+// resolvePolicy returns the policy selected by the options. When neither
+// policy_id nor template_name are specified, the policy defined by the
+// NESSUS_POLICY_ID env var is used. Otherwise, the requested policy is looked
+// up in the policies available in the Nessus instance and an error listing
+// them is returned if it does not exist.
+func (r *runner) resolvePolicy(ctx context.Context, opt options) (restuss.Policy, error) {
+	if opt.PolicyID == 0 && opt.TemplateName == "" {
+		id, err := defaultPolicyID()
+		if err != nil {
+			return restuss.Policy{}, err
+		}
+		return r.loadPolicyDetails(ctx, id)
+	}
+
+	policies, err := r.listPolicies(ctx)
+	if err != nil {
+		return restuss.Policy{}, err
+	}
+	for _, p := range policies {
+		if (opt.PolicyID != 0 && p.ID == opt.PolicyID) ||
+			(opt.TemplateName != "" && strings.EqualFold(p.Name, opt.TemplateName)) {
+			return r.loadPolicyDetails(ctx, p.ID)
+		}
+	}
+
+	requested := opt.TemplateName
+	if opt.PolicyID != 0 {
+		requested = strconv.FormatInt(opt.PolicyID, 10)
+	}
+	if len(policies) == 0 {
+		return restuss.Policy{}, fmt.Errorf("policy %q not found, no policies available", requested)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	available := make([]string, len(policies))
+	for i, p := range policies {
+		available[i] = p.String()
+	}
+	return restuss.Policy{}, fmt.Errorf("policy %q not found, available policies: %s", requested, strings.Join(available, ", "))
+}
+
+// policyDetails returns the line added to the details of the vulnerabilities
+// to record the policy used by the scan.
+func policyDetails(policy restuss.Policy) string {
+	return fmt.Sprintf("Policy: %s (%d)", policy.Settings.Name, policy.ID)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/restuss"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolvePolicy(t *testing.T) {
+	policies := []policySummary{
+		{ID: 9, Name: "Full Scan"},
+		{ID: 12, Name: "PCI"},
+		{ID: 15, Name: "Light"},
+	}
+	tests := []struct {
+		name    string
+		opt     options
+		envID   string
+		want    restuss.Policy
+		wantErr string
+	}{
+		{
+			name:  "Default",
+			envID: "9",
+			want:  restuss.Policy{ID: 9, UUID: "uuid-9", Settings: restuss.PolicySettings{Name: "Full Scan"}},
+		},
+		{
+			name:    "WrongDefault",
+			envID:   "full",
+			wantErr: "wrong value for NESSUS_POLICY_ID",
+		},
+		{
+			name:  "PolicyID",
+			opt:   options{PolicyID: 12},
+			envID: "9",
+			want:  restuss.Policy{ID: 12, UUID: "uuid-12", Settings: restuss.PolicySettings{Name: "PCI"}},
+		},
+		{
+			name: "TemplateName",
+			opt:  options{TemplateName: "light"},
+			want: restuss.Policy{ID: 15, UUID: "uuid-15", Settings: restuss.PolicySettings{Name: "Light"}},
+		},
+		{
+			name:    "UnknownPolicyID",
+			opt:     options{PolicyID: 7},
+			wantErr: `policy "7" not found, available policies: Full Scan (9), Light (15), PCI (12)`,
+		},
+		{
+			name:    "UnknownTemplateName",
+			opt:     options{TemplateName: "Discovery"},
+			wantErr: `policy "Discovery" not found, available policies: Full Scan (9), Light (15), PCI (12)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakeNessus(t, &fakeNessus{policies: policies})
+			t.Setenv("NESSUS_POLICY_ID", tt.envID)
+			r := &runner{}
+			if err := r.auth(false); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := r.resolvePolicy(context.Background(), tt.opt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected policy (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPolicySelection(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     options
+		wantErr bool
+	}{
+		{name: "None"},
+		{name: "PolicyID", opt: options{PolicyID: 9}},
+		{name: "TemplateName", opt: options{TemplateName: "PCI"}},
+		{name: "Both", opt: options{PolicyID: 9, TemplateName: "PCI"}, wantErr: true},
+		{name: "Negative", opt: options{PolicyID: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opt.policySelection(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+)
+
+// Default queue timeout is 1h.
+const defQueueTimeout = 60 * 60
+
+var (
+	// queueMinDelay and queueMaxDelay are the bounds of the backoff used
+	// to launch the scan again when Nessus has reached its concurrent
+	// scan limit.
+	queueMinDelay = 10 * time.Second
+	queueMaxDelay = 5 * time.Minute
+
+	// concurrencyLimitBody matches the errors returned by Nessus when it
+	// has reached its concurrent scan limit, e.g.: "The maximum number of
+	// concurrent scans has been reached".
+	concurrencyLimitBody = regexp.MustCompile(`(?i)concurrent scan|maximum number of (running |concurrent )?scans|scan limit`)
+
+	// errQueueTimeout is returned when there is no free scan slot in
+	// Nessus after waiting for the queue timeout.
+	errQueueTimeout = errors.New("no free Nessus scan slot")
+)
+
+// isConcurrencyLimit returns true if the given error was caused by Nessus
+// reaching its concurrent scan limit.
+func isConcurrencyLimit(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || concurrencyLimitBody.MatchString(apiErr.Body)
+}
+
+// isQueued returns true if the given scan status means the scan is waiting
+// for a free scan slot.
+func isQueued(status string) bool {
+	return status == "pending" || status == "queued"
+}
+
+// scanQueue tracks the time a scan spends waiting for a free scan slot.
+type scanQueue struct {
+	timeout time.Duration
+	// since is the time the scan started waiting. It is zero when the
+	// scan is not waiting.
+	since time.Time
+	// waited is the total time the scan has been waiting.
+	waited time.Duration
+}
+
+// wait records that the scan is waiting for a free scan slot. It returns
+// errQueueTimeout if the scan has been waiting for longer than the queue
+// timeout. The progress is reported as 0 while the scan is queued.
+func (q *scanQueue) wait(progress checkstate.ProgressReporter) error {
+	if q.since.IsZero() {
+		q.since = time.Now()
+		logger.Info("Scan queued, waiting for a free scan slot")
+	}
+	if progress != nil {
+		progress.SetProgress(0)
+	}
+	if d := time.Since(q.since); d > q.timeout {
+		return fmt.Errorf("%w after waiting %v", errQueueTimeout, d.Round(time.Second))
+	}
+	return nil
+}
+
+// done records that the scan is not waiting anymore.
+func (q *scanQueue) done() {
+	if q.since.IsZero() {
+		return
+	}
+	d := time.Since(q.since)
+	q.waited += d
+	q.since = time.Time{}
+	logger.Infof("Scan dequeued after waiting %v", d.Round(time.Second))
+}
+
+// startScan launches the created scan. When Nessus has reached its
+// concurrent scan limit, it keeps trying with a backoff until the queue
+// timeout is exceeded. Other errors are retried a limited number of times.
+func (r *runner) startScan(ctx context.Context, progress checkstate.ProgressReporter) error {
+	id := r.nessusPersistedScan.ID
+	path := fmt.Sprintf("/scans/%d/launch", id)
+	queueBackoff := &backoff.Backoff{
+		Min:    queueMinDelay,
+		Max:    queueMaxDelay,
+		Factor: 2,
+		Jitter: true,
+	}
+	errBackoff := &backoff.Backoff{
+		Min:    100 * time.Millisecond,
+		Max:    60 * time.Second,
+		Factor: 1.5,
+		Jitter: true,
+	}
+
+	// Try 20 times then return an error, unless the scan is queued.
+	for attempts := 0; attempts < 20; {
+		err := r.do(ctx, http.MethodPost, path, nil)
+		if err == nil {
+			r.queue.done()
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var d time.Duration
+		if isConcurrencyLimit(err) {
+			if err := r.queue.wait(progress); err != nil {
+				return err
+			}
+			d = queueBackoff.Duration()
+			logger.Infof("Scan limit reached, trying to launch the scan again in %v: %v", d, err)
+		} else {
+			attempts++
+			d = errBackoff.Duration()
+			logger.Debugf("Err when launching scan: %v, trying again in %v", err, d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+
+	return fmt.Errorf("Not possible to launch scan: %v", id)
+}
+
+// discardScan stops and deletes a scan that has not started, whatever the
+// value of the delete option is, so no queued scans are left in Nessus.
+func (r *runner) discardScan() {
+	if r.nessusPersistedScan == nil {
+		return
+	}
+	ctx := context.Background()
+	if r.scanLaunched {
+		if _, err := r.stopScan(ctx); err != nil {
+			logger.WithError(err).Error("error stopping the queued scan")
+		}
+	}
+	if err := r.deleteScan(ctx, r.nessusPersistedScan.ID); err == nil {
+		r.nessusPersistedScan = nil
+	}
+}
+
+// addNote adds the given note to the notes of the report.
+func addNote(state checkstate.State, note string) {
+	if state.Notes != "" {
+		state.Notes += "\n"
+	}
+	state.Notes += note
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	"github.com/adevinta/vulcan-checks/internal/awsauth"
+)
+
+// defaultAssumeRoleTimeout is the default maximum number of seconds a
+// request to the assume role endpoint is allowed to take.
+const defaultAssumeRoleTimeout = 30
+
+// awsCredentials are the credentials obtained for the scanned account.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// static returns the credentials provider to be used by the AWS SDK.
+func (c awsCredentials) static() credentials.StaticCredentialsProvider {
+	return credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)
+}
+
+// auth returns the credentials to be used by the awsauth package.
+func (c awsCredentials) auth() awsauth.Credentials {
+	return awsauth.Credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+	}
+}
+
+// env returns the env vars that make the AWS CLI, and hence prowler, use the
+// credentials.
+func (c awsCredentials) env() []string {
+	return []string{
+		envKeyID + "=" + c.AccessKeyID,
+		envKeySecret + "=" + c.SecretAccessKey,
+		envToken + "=" + c.SessionToken,
+	}
+}
+
+// loadCredentials requests credentials for the given account to the assume
+// role endpoint. The external ID is only sent if it is not empty. Each
+// request to the endpoint is allowed to take, at most, the given timeout. It
+// returns the credentials and the version of the payload honored by the
+// endpoint.
+func loadCredentials(ctx context.Context, url string, accountID, role, externalID string, sessionDuration int, timeout time.Duration) (awsCredentials, int, error) {
+	opts := awsauth.AssumeRoleOptions{
+		ExternalID:      externalID,
+		SessionDuration: sessionDuration,
+		Timeout:         timeout,
+		Logger:          ctxLogger(ctx),
+	}
+	r, err := awsauth.AssumeRole(ctx, url, accountID, role, opts)
+	if err != nil {
+		return awsCredentials{}, 0, err
+	}
+	creds := awsCredentials{
+		AccessKeyID:     r.AccessKeyID,
+		SecretAccessKey: r.SecretAccessKey,
+		SessionToken:    r.SessionToken,
+	}
+	return creds, r.PayloadVersion, nil
+}
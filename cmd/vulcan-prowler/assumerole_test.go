@@ -0,0 +1,43 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadCredentialsKeepsEnvironment(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_key":"AKID","secret_access_key":"secret","session_token":"token","version":2}`))
+	}))
+	defer srv.Close()
+
+	before := os.Environ()
+	creds, version, err := loadCredentials(context.Background(), srv.URL, "123456789012", "audit", "", 3600, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(before, os.Environ()); diff != "" {
+		t.Errorf("process environment modified (-before +after):\n%s", diff)
+	}
+	if version != 2 {
+		t.Errorf("unexpected payload version: %d", version)
+	}
+	want := awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	if diff := cmp.Diff(want, creds); diff != "" {
+		t.Errorf("credentials mismatch (-want +got):\n%s", diff)
+	}
+	wantEnv := []string{"AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token"}
+	if diff := cmp.Diff(wantEnv, creds.env()); diff != "" {
+		t.Errorf("env mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// loadAWSConfig returns the config of the AWS SDK v2 clients used by the
+// check. The clients use the given credentials and region, and retry the
+// requests that fail because of throttling or a server error.
+func loadAWSConfig(ctx context.Context, creds awsCredentials, region string, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithCredentialsProvider(creds.static()),
+		config.WithRetryMaxAttempts(awsMaxRetries + 1),
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, append(opts, optFns...)...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("can not load AWS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// endpointName returns the endpoint used for the given service, to be
+// included in errors and logs.
+func endpointName(overrides map[string]string, service, region string) string {
+	if u, ok := overrides[service]; ok {
+		return u
+	}
+	return fmt.Sprintf("default %s endpoint in %s", service, region)
+}
+
+// newIAMClient returns an IAM client that honors the endpoint overrides.
+func newIAMClient(cfg aws.Config, overrides map[string]string) *iam.Client {
+	return iam.NewFromConfig(cfg, func(o *iam.Options) {
+		if u, ok := overrides["iam"]; ok {
+			o.BaseEndpoint = aws.String(u)
+		}
+	})
+}
+
+// newSTSClient returns an STS client that honors the endpoint overrides.
+func newSTSClient(cfg aws.Config, overrides map[string]string) *sts.Client {
+	return sts.NewFromConfig(cfg, func(o *sts.Options) {
+		if u, ok := overrides["sts"]; ok {
+			o.BaseEndpoint = aws.String(u)
+		}
+	})
+}
+
+// newOrganizationsClient returns an Organizations client that honors the
+// endpoint overrides.
+func newOrganizationsClient(cfg aws.Config, overrides map[string]string) *organizations.Client {
+	return organizations.NewFromConfig(cfg, func(o *organizations.Options) {
+		if u, ok := overrides["organizations"]; ok {
+			o.BaseEndpoint = aws.String(u)
+		}
+	})
+}
+
+// newS3Client returns an S3 client for the given region that honors the
+// endpoint overrides.
+func newS3Client(cfg aws.Config, overrides map[string]string, region string) *s3.Client {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = region
+		if u, ok := overrides["s3"]; ok {
+			o.BaseEndpoint = aws.String(u)
+		}
+	})
+}
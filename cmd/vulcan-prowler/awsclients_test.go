@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const listAccountAliasesResponse = `<ListAccountAliasesResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <ListAccountAliasesResult>
+    <IsTruncated>false</IsTruncated>
+    <AccountAliases>%s</AccountAliases>
+  </ListAccountAliasesResult>
+  <ResponseMetadata>
+    <RequestId>c5a076e9-f1b0-11df-8fbe-45274EXAMPLE</RequestId>
+  </ResponseMetadata>
+</ListAccountAliasesResponse>`
+
+var testCredentials = awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+func TestAccountAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases string
+		want    string
+	}{
+		{
+			name:    "Alias",
+			aliases: "<member>stub-alias</member>",
+			want:    "stub-alias",
+		},
+		{
+			name: "NoAlias",
+			want: "123456789012 (no alias)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/xml")
+				w.Write([]byte(fmt.Sprintf(listAccountAliasesResponse, tt.aliases)))
+			}))
+			defer srv.Close()
+
+			alias, err := accountAlias(context.Background(), testCredentials, defaultAPIRegion, map[string]string{"iam": srv.URL}, "123456789012")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if alias != tt.want {
+				t.Errorf("unexpected alias: %q", alias)
+			}
+		})
+	}
+}
+
+func TestVerifyCredentialsEndpointOverride(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:sts::123456789012:assumed-role/audit/vulcan</Arn>
+    <UserId>AROAEXAMPLE:vulcan</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+  <ResponseMetadata>
+    <RequestId>01234567-89ab-cdef-0123-456789abcdef</RequestId>
+  </ResponseMetadata>
+</GetCallerIdentityResponse>`))
+	}))
+	defer srv.Close()
+
+	got, err := verifyCredentials(context.Background(), testCredentials, map[string]string{"sts": srv.URL}, defaultAPIRegion, "123456789012")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "123456789012" {
+		t.Errorf("unexpected account ID: %q", got)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// batchConfig contains the configuration of the batch mode.
+type batchConfig struct {
+	// TargetsFile is the file containing the targets to scan, one per line.
+	// If it is "-" the targets are read from the standard input.
+	TargetsFile string
+	// OutputDir is the directory where the reports are written.
+	OutputDir string
+	// Options are the options passed to the check for every target.
+	Options   string
+	AssetType string
+}
+
+// parseBatchFlags parses the flags of the batch mode. It returns false if
+// the batch mode has not been requested, that is, the -targets-file flag is
+// not present.
+func parseBatchFlags(args []string) (batchConfig, bool, error) {
+	var cfg batchConfig
+	found := false
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if strings.HasPrefix(arg, "-") && (name == "targets-file" || strings.HasPrefix(name, "targets-file=")) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return cfg, false, nil
+	}
+	set := flag.NewFlagSet(checkName, flag.ContinueOnError)
+	set.StringVar(&cfg.TargetsFile, "targets-file", "", `file with the targets to scan, one per line, or "-" to read them from the standard input`)
+	set.StringVar(&cfg.OutputDir, "output-dir", ".", "directory where the reports are written")
+	set.StringVar(&cfg.Options, "o", "", "options passed to the check for every target")
+	set.StringVar(&cfg.AssetType, "asset-type", assetTypeAWSAccount, "asset type of the targets")
+	if err := set.Parse(args); err != nil {
+		return cfg, false, err
+	}
+	if cfg.TargetsFile == "" {
+		return cfg, false, fmt.Errorf("-targets-file must have a non-empty value")
+	}
+	return cfg, true, nil
+}
+
+// batchResult is the result of scanning one target in batch mode.
+type batchResult struct {
+	Target   string
+	Account  string
+	Results  *resultsSummary
+	Duration time.Duration
+	Err      error
+}
+
+// runBatch scans sequentially the targets read from the targets file using
+// the given check handler and writes one report per account in the output
+// directory. The targets are not scanned in parallel because the handler
+// stores the credentials of the account in the environment of the process
+// and prowler always writes its report to the same location. It prints a
+// summary of the scans and returns the exit code of the process: 1 if any
+// of the scans failed and 0 otherwise.
+func runBatch(ctx context.Context, cfg batchConfig, handler func(context.Context, string, string, string, checkstate.State) error, stdin io.Reader, stdout io.Writer) int {
+	targets, err := readTargets(cfg.TargetsFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stdout, "can not read targets: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		fmt.Fprintf(stdout, "can not create output directory: %v\n", err)
+		return 1
+	}
+
+	var results []batchResult
+	for _, target := range targets {
+		logger.Infof("batch: scanning target %s", target)
+		results = append(results, scanTarget(ctx, cfg, handler, target))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	writeBatchSummary(stdout, results)
+	for _, r := range results {
+		if r.Err != nil {
+			return 1
+		}
+	}
+	if len(results) < len(targets) {
+		return 1
+	}
+	return 0
+}
+
+// readTargets reads the targets from the given file or, if it is "-", from
+// stdin. Empty lines and lines starting with "#" are ignored.
+func readTargets(path string, stdin io.Reader) ([]string, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
+
+var unsafeFilenameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// scanTarget runs the check handler for a target and writes its report to
+// the output directory. The errors of the handler are recorded in the report
+// and in the returned result.
+func scanTarget(ctx context.Context, cfg batchConfig, handler func(context.Context, string, string, string, checkstate.State) error, target string) batchResult {
+	res := batchResult{Target: target, Account: target}
+	if tgt, err := parseTarget(target); err == nil {
+		res.Account = tgt.AccountID
+	}
+
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	start := time.Now()
+	res.Err = handler(ctx, target, cfg.AssetType, cfg.Options, state)
+	res.Duration = time.Since(start)
+	if res.Err != nil {
+		rd.Error = res.Err.Error()
+	}
+
+	var data reportData
+	if len(rd.Data) > 0 && json.Unmarshal(rd.Data, &data) == nil {
+		res.Results = &data.Results
+	}
+
+	content, err := json.MarshalIndent(rd, "", "  ")
+	if err == nil {
+		name := unsafeFilenameRegexp.ReplaceAllString(res.Account, "_") + ".json"
+		err = os.WriteFile(filepath.Join(cfg.OutputDir, name), content, 0o644)
+	}
+	if err != nil && res.Err == nil {
+		res.Err = fmt.Errorf("can not write report: %w", err)
+	}
+	return res
+}
+
+// writeBatchSummary prints a table with the results of the batch.
+func writeBatchSummary(w io.Writer, results []batchResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCOUNT\tCOVERAGE\tFAILED/TOTAL\tDURATION\tERROR")
+	for _, r := range results {
+		coverage, counts := "-", "-"
+		if r.Results != nil {
+			coverage = "full"
+			if r.Results.Truncated {
+				coverage = "partial"
+			}
+			counts = fmt.Sprintf("%d/%d", r.Results.Failed, r.Results.Total)
+		}
+		errMsg := "-"
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Account, coverage, counts, r.Duration.Round(time.Second), errMsg)
+	}
+	tw.Flush()
+}
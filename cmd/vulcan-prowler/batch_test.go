@@ -0,0 +1,123 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseBatchFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    batchConfig
+		wantOK  bool
+		wantErr bool
+	}{
+		{
+			name: "NotBatch",
+			args: []string{"-r", "arn:aws:iam::123456789012:root"},
+		},
+		{
+			name:   "Batch",
+			args:   []string{"-targets-file", "-", "-output-dir", "/tmp/reports", "-o", `{"groups":["cislevel1"]}`},
+			want:   batchConfig{TargetsFile: "-", OutputDir: "/tmp/reports", Options: `{"groups":["cislevel1"]}`, AssetType: "AWSAccount"},
+			wantOK: true,
+		},
+		{
+			name:    "EmptyTargetsFile",
+			args:    []string{"-targets-file="},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseBatchFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("unexpected batch mode: %v", ok)
+			}
+			if tt.wantOK {
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("config mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	targets := `arn:aws:iam::111111111111:root
+
+# Comment.
+arn:aws:iam::222222222222:root
+`
+	var scanned []string
+	handler := func(ctx context.Context, target, assetType, opts string, state checkstate.State) error {
+		scanned = append(scanned, target)
+		if strings.Contains(target, "222222222222") {
+			return errors.New("can not assume role")
+		}
+		state.AddVulnerabilities(report.Vulnerability{Summary: "test"})
+		data, err := json.Marshal(reportData{Results: resultsSummary{Total: 10, Passed: 7, Failed: 3}})
+		if err != nil {
+			return err
+		}
+		state.Data = data
+		return nil
+	}
+	cfg := batchConfig{TargetsFile: "-", OutputDir: dir, AssetType: "AWSAccount"}
+	var out bytes.Buffer
+	code := runBatch(context.Background(), cfg, handler, strings.NewReader(targets), &out)
+	if code != 1 {
+		t.Errorf("unexpected exit code: %d", code)
+	}
+	if diff := cmp.Diff([]string{"arn:aws:iam::111111111111:root", "arn:aws:iam::222222222222:root"}, scanned); diff != "" {
+		t.Errorf("scanned targets mismatch (-want +got):\n%s", diff)
+	}
+
+	var rd report.ResultData
+	content, err := os.ReadFile(filepath.Join(dir, "111111111111.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(content, &rd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rd.Vulnerabilities) != 1 || rd.Error != "" {
+		t.Errorf("unexpected report: %+v", rd)
+	}
+	content, err = os.ReadFile(filepath.Join(dir, "222222222222.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(content, &rd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rd.Error != "can not assume role" {
+		t.Errorf("unexpected report error: %q", rd.Error)
+	}
+
+	summary := out.String()
+	for _, want := range []string{"111111111111  full", "3/10", "222222222222", "can not assume role"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary does not contain %q:\n%s", want, summary)
+		}
+	}
+}
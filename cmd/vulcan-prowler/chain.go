@@ -0,0 +1,105 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	// defaultChainSessionName is the default name of the session of the
+	// role assumed in the second hop of a role chain.
+	defaultChainSessionName = `vulcan-prowler`
+	// maxChainSessionDuration is the maximum duration, in seconds, allowed
+	// by STS for the sessions of the roles assumed with role chaining.
+	maxChainSessionDuration = 3600
+)
+
+// errChainRole is returned when the second hop of a role chain fails.
+var errChainRole = errors.New("second hop of the role chain failed")
+
+var sessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// stsAssumeRoleAPI contains the STS operations used to assume a role.
+type stsAssumeRoleAPI interface {
+	AssumeRole(context.Context, *sts.AssumeRoleInput, ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+// validateChainOptions checks the options of the role chain and sets their
+// default values.
+func validateChainOptions(opts *options) error {
+	if opts.ChainRoleARN == "" {
+		return nil
+	}
+	parsed, err := arn.Parse(opts.ChainRoleARN)
+	if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return fmt.Errorf("invalid chain_role_arn %q: must be the ARN of an IAM role", opts.ChainRoleARN)
+	}
+	if opts.ChainSessionName == "" {
+		opts.ChainSessionName = defaultChainSessionName
+	}
+	if !sessionNameRegexp.MatchString(opts.ChainSessionName) {
+		return fmt.Errorf("invalid chain_session_name %q", opts.ChainSessionName)
+	}
+	if opts.ChainSessionDuration == 0 {
+		opts.ChainSessionDuration = maxChainSessionDuration
+	}
+	if opts.ChainSessionDuration < minSessionDuration || opts.ChainSessionDuration > maxChainSessionDuration {
+		return fmt.Errorf("invalid chain_session_duration %d: must be between %d and %d seconds", opts.ChainSessionDuration, minSessionDuration, maxChainSessionDuration)
+	}
+	return nil
+}
+
+// assumeChainRole assumes the given role using the STS client and returns
+// the credentials of the new session.
+func assumeChainRole(ctx context.Context, api stsAssumeRoleAPI, roleARN, sessionName string, duration int) (awsCredentials, error) {
+	out, err := api.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(sessionName),
+		DurationSeconds: aws.Int32(int32(duration)),
+	})
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("%w: can not assume the role %s: %w", errChainRole, roleARN, err)
+	}
+	if out.Credentials == nil {
+		return awsCredentials{}, fmt.Errorf("%w: no credentials returned assuming the role %s", errChainRole, roleARN)
+	}
+	return awsCredentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+	}, nil
+}
+
+// chainCredentials returns the credentials of the role configured in the
+// chain_role_arn option, assumed using the given credentials of the first
+// hop. If the option is not set, it returns the given credentials.
+func chainCredentials(ctx context.Context, creds awsCredentials, opts options, region string) (awsCredentials, error) {
+	if opts.ChainRoleARN == "" {
+		return creds, nil
+	}
+	cfg, err := loadAWSConfig(ctx, creds, region)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("%w: %w", errChainRole, err)
+	}
+	return assumeChainRole(ctx, newSTSClient(cfg, opts.EndpointOverrides), opts.ChainRoleARN, opts.ChainSessionName, opts.ChainSessionDuration)
+}
+
+// sessionLifetime returns the duration, in seconds, of the sessions used to
+// scan the account.
+func sessionLifetime(opts options) int {
+	if opts.ChainRoleARN != "" && opts.ChainSessionDuration < opts.SessionDuration {
+		return opts.ChainSessionDuration
+	}
+	return opts.SessionDuration
+}
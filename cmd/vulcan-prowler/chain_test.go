@@ -0,0 +1,154 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeAssumeRoleSTS struct {
+	out   *sts.AssumeRoleOutput
+	err   error
+	input *sts.AssumeRoleInput
+}
+
+func (f *fakeAssumeRoleSTS) AssumeRole(_ context.Context, in *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.input = in
+	return f.out, f.err
+}
+
+func TestAssumeChainRole(t *testing.T) {
+	const roleARN = "arn:aws:iam::123456789012:role/SecurityAudit"
+	tests := []struct {
+		name    string
+		api     *fakeAssumeRoleSTS
+		want    awsCredentials
+		wantErr bool
+	}{
+		{
+			name: "Assumed",
+			api: &fakeAssumeRoleSTS{
+				out: &sts.AssumeRoleOutput{
+					Credentials: &types.Credentials{
+						AccessKeyId:     aws.String("AKIDCHAIN"),
+						SecretAccessKey: aws.String("chain-secret"),
+						SessionToken:    aws.String("chain-token"),
+					},
+				},
+			},
+			want: awsCredentials{
+				AccessKeyID:     "AKIDCHAIN",
+				SecretAccessKey: "chain-secret",
+				SessionToken:    "chain-token",
+			},
+		},
+		{
+			name:    "AccessDenied",
+			api:     &fakeAssumeRoleSTS{err: errors.New("AccessDenied: not authorized to perform sts:AssumeRole")},
+			wantErr: true,
+		},
+		{
+			name:    "NoCredentials",
+			api:     &fakeAssumeRoleSTS{out: &sts.AssumeRoleOutput{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := assumeChainRole(context.Background(), tt.api, roleARN, "vulcan", 900)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && !errors.Is(err, errChainRole) {
+				t.Errorf("error is not a second hop error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("credentials mismatch (-want +got):\n%s", diff)
+			}
+			in := tt.api.input
+			if aws.ToString(in.RoleArn) != roleARN || aws.ToString(in.RoleSessionName) != "vulcan" || aws.ToInt32(in.DurationSeconds) != 900 {
+				t.Errorf("unexpected assume role input: %+v", in)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsChainRole(t *testing.T) {
+	tests := []struct {
+		name         string
+		optJSON      string
+		wantName     string
+		wantDuration int
+		wantLifetime int
+		wantErr      bool
+	}{
+		{
+			name:         "NoChain",
+			optJSON:      `{}`,
+			wantLifetime: defaultSessionDuration,
+		},
+		{
+			name:         "Defaults",
+			optJSON:      `{"chain_role_arn": "arn:aws:iam::123456789012:role/SecurityAudit", "session_duration": 7200}`,
+			wantName:     defaultChainSessionName,
+			wantDuration: maxChainSessionDuration,
+			wantLifetime: maxChainSessionDuration,
+		},
+		{
+			name:         "Custom",
+			optJSON:      `{"chain_role_arn": "arn:aws:iam::123456789012:role/audit/SecurityAudit", "chain_session_name": "audit@vulcan", "chain_session_duration": 1800}`,
+			wantName:     "audit@vulcan",
+			wantDuration: 1800,
+			wantLifetime: 1800,
+		},
+		{
+			name:    "NotARole",
+			optJSON: `{"chain_role_arn": "arn:aws:iam::123456789012:user/audit"}`,
+			wantErr: true,
+		},
+		{
+			name:    "NotAnARN",
+			optJSON: `{"chain_role_arn": "SecurityAudit"}`,
+			wantErr: true,
+		},
+		{
+			name:    "InvalidSessionName",
+			optJSON: `{"chain_role_arn": "arn:aws:iam::123456789012:role/SecurityAudit", "chain_session_name": "vulcan prowler"}`,
+			wantErr: true,
+		},
+		{
+			name:    "DurationTooLong",
+			optJSON: `{"chain_role_arn": "arn:aws:iam::123456789012:role/SecurityAudit", "chain_session_duration": 7200}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.ChainSessionName != tt.wantName {
+				t.Errorf("unexpected session name: %q", opts.ChainSessionName)
+			}
+			if opts.ChainSessionDuration != tt.wantDuration {
+				t.Errorf("unexpected session duration: %d", opts.ChainSessionDuration)
+			}
+			if got := sessionLifetime(opts); got != tt.wantLifetime {
+				t.Errorf("unexpected session lifetime: %d", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxCollapsedRegions is the maximum number of regions listed in the Region
+// column of a row that collapses the same failed control in several
+// regions. The full list of regions is appended to the message of the row.
+const maxCollapsedRegions = 2
+
+// regionPlaceholder replaces the region of an entry in its message and
+// resource to decide whether two rows of the same control are equivalent.
+const regionPlaceholder = "<region>"
+
+// collapseControlRows merges the rows of the same control whose messages and
+// resources only differ in the region they refer to. The Region column of
+// the merged row lists the affected regions. The order of the rows is kept,
+// the merged rows take the position of the first row collapsed in them.
+func collapseControlRows(rows []controlRow) []controlRow {
+	var (
+		keys    []string
+		merged  = map[string]controlRow{}
+		regions = map[string][]string{}
+	)
+	for _, r := range rows {
+		region := r.row["Region"]
+		key := strings.Join([]string{
+			r.control,
+			normalizeRegion(r.row["Message"], region),
+			normalizeRegion(r.row["Resource"], region),
+		}, "\x00")
+		if _, ok := merged[key]; !ok {
+			keys = append(keys, key)
+			merged[key] = r
+		}
+		regions[key] = append(regions[key], region)
+	}
+	collapsed := make([]controlRow, 0, len(keys))
+	for _, key := range keys {
+		r := merged[key]
+		if rs := regions[key]; len(rs) > 1 {
+			r = collapsedRow(r, rs)
+		}
+		collapsed = append(collapsed, r)
+	}
+	return collapsed
+}
+
+// collapsedRow returns a copy of the given row that refers to all the given
+// regions.
+func collapsedRow(r controlRow, regions []string) controlRow {
+	regions = append([]string(nil), regions...)
+	sort.Strings(regions)
+	row := make(map[string]string, len(r.row))
+	for k, v := range r.row {
+		row[k] = v
+	}
+	row["Region"] = collapsedRegionsLabel(regions)
+	if len(regions) > maxCollapsedRegions {
+		row["Message"] = fmt.Sprintf("%s (regions: %s)", row["Message"], strings.Join(regions, ", "))
+	}
+	return controlRow{row: row, control: r.control, score: r.score}
+}
+
+// collapsedRegionsLabel returns the value of the Region column of a
+// collapsed row, e.g.: "eu-west-1, us-east-1 (+4 more)".
+func collapsedRegionsLabel(regions []string) string {
+	if len(regions) <= maxCollapsedRegions {
+		return strings.Join(regions, ", ")
+	}
+	listed := strings.Join(regions[:maxCollapsedRegions], ", ")
+	return fmt.Sprintf("%s (+%d more)", listed, len(regions)-maxCollapsedRegions)
+}
+
+// normalizeRegion replaces the given region in s with a placeholder.
+func normalizeRegion(s, region string) string {
+	if region == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, region, regionPlaceholder)
+}
+
+// uniqueControls returns the number of different controls of the given
+// entries.
+func uniqueControls(entries []entry) int {
+	seen := map[string]bool{}
+	for _, e := range entries {
+		seen[e.Control] = true
+	}
+	return len(seen)
+}
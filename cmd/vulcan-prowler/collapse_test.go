@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+func TestCollapseControlRows(t *testing.T) {
+	row := func(control, region, message, resource string) controlRow {
+		r := map[string]string{
+			"Control": control,
+			"Region":  region,
+			"Message": message,
+		}
+		if resource != "" {
+			r["Resource"] = resource
+		}
+		return controlRow{row: r, control: control, score: 6.9}
+	}
+	tests := []struct {
+		name string
+		rows []controlRow
+		want []controlRow
+	}{
+		{
+			name: "SameMessage",
+			rows: []controlRow{
+				row("2.1", "us-east-1", "No CloudTrail trail with multi-region enabled", ""),
+				row("2.1", "eu-west-1", "No CloudTrail trail with multi-region enabled", ""),
+			},
+			want: []controlRow{
+				row("2.1", "eu-west-1, us-east-1", "No CloudTrail trail with multi-region enabled", ""),
+			},
+		},
+		{
+			name: "MessageWithRegion",
+			rows: []controlRow{
+				row("2.9", "eu-west-1", "No VPCFlowLog found in eu-west-1", ""),
+				row("2.9", "us-east-1", "No VPCFlowLog found in us-east-1", ""),
+			},
+			want: []controlRow{
+				row("2.9", "eu-west-1, us-east-1", "No VPCFlowLog found in eu-west-1", ""),
+			},
+		},
+		{
+			name: "ManyRegions",
+			rows: []controlRow{
+				row("2.1", "us-east-1", "No trail", ""),
+				row("2.1", "eu-west-1", "No trail", ""),
+				row("2.1", "eu-west-2", "No trail", ""),
+				row("2.1", "us-west-2", "No trail", ""),
+			},
+			want: []controlRow{
+				row("2.1", "eu-west-1, eu-west-2 (+2 more)", "No trail (regions: eu-west-1, eu-west-2, us-east-1, us-west-2)", ""),
+			},
+		},
+		{
+			name: "DifferentResources",
+			rows: []controlRow{
+				row("4.1", "eu-west-1", "Found Security Group: sg-0a1b2c3d open", "sg-0a1b2c3d"),
+				row("4.1", "us-east-1", "Found Security Group: sg-0d3c2b1a open", "sg-0d3c2b1a"),
+				row("4.1", "us-east-2", "Found Security Group: sg-0a1b2c3d open", "arn:aws:ec2:us-east-2:123456789012:security-group/sg-0a1b2c3d"),
+				row("4.1", "us-west-2", "Found Security Group: sg-0a1b2c3d open", "arn:aws:ec2:us-west-2:123456789012:security-group/sg-0a1b2c3d"),
+			},
+			want: []controlRow{
+				row("4.1", "eu-west-1", "Found Security Group: sg-0a1b2c3d open", "sg-0a1b2c3d"),
+				row("4.1", "us-east-1", "Found Security Group: sg-0d3c2b1a open", "sg-0d3c2b1a"),
+				row("4.1", "us-east-2, us-west-2", "Found Security Group: sg-0a1b2c3d open", "arn:aws:ec2:us-east-2:123456789012:security-group/sg-0a1b2c3d"),
+			},
+		},
+		{
+			name: "DifferentControls",
+			rows: []controlRow{
+				row("2.1", "eu-west-1", "No trail", ""),
+				row("2.2", "eu-west-1", "No trail", ""),
+			},
+			want: []controlRow{
+				row("2.1", "eu-west-1", "No trail", ""),
+				row("2.2", "eu-west-1", "No trail", ""),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collapseControlRows(tt.rows)
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(controlRow{})); diff != "" {
+				t.Errorf("unexpected rows (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestFillCISLevelVulnCollapseRegions(t *testing.T) {
+	controls := map[string]CISControl{
+		"2.1": {ID: "2.1", Severity: 6.9, SeverityLiteral: "Medium"},
+	}
+	r := &prowlerReport{}
+	for _, region := range []string{"eu-west-1", "us-east-1", "eu-west-2"} {
+		r.entries = append(r.entries, entry{
+			Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)",
+			Status:  "FAIL",
+			Region:  region,
+			Message: "No CloudTrail trails with multi-region enabled",
+		})
+	}
+	tests := []struct {
+		name        string
+		opts        options
+		wantRegions []string
+		wantDetails string
+	}{
+		{
+			name:        "Disabled",
+			wantRegions: []string{"eu-west-1", "us-east-1", "eu-west-2"},
+			wantDetails: "Failed Controls: 3\n",
+		},
+		{
+			name:        "Enabled",
+			opts:        options{CollapseRegions: true},
+			wantRegions: []string{"eu-west-1, eu-west-2 (+1 more)"},
+			wantDetails: "Failed Controls: 1 (3 region occurrences)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := report.Vulnerability{}
+			fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, tt.opts, controls)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var regions []string
+			for _, row := range fv.Resources[0].Rows {
+				regions = append(regions, row["Region"])
+			}
+			if diff := cmp.Diff(tt.wantRegions, regions); diff != "" {
+				t.Errorf("unexpected regions (-want +got):\n%v", diff)
+			}
+			if !strings.Contains(fv.Details, tt.wantDetails) {
+				t.Errorf("unexpected details: %s", fv.Details)
+			}
+		})
+	}
+}
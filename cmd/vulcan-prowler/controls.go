@@ -0,0 +1,183 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	report "github.com/adevinta/vulcan-report"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/internal/ciscontrols"
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+const (
+	// envControlsURL is the env var that defines the URL the controls
+	// metadata is fetched from when the controls_url option is not set.
+	envControlsURL = `VULCAN_PROWLER_CONTROLS_URL`
+
+	// bundledControlsFile is the controls metadata file shipped with the
+	// check.
+	bundledControlsFile = "cis_controls.json"
+
+	// maxControlsSize is the maximum size of the controls metadata
+	// fetched from a URL.
+	maxControlsSize = 1024 * 1024
+
+	// controlsFetchTimeout is the maximum time allowed to fetch the
+	// controls metadata from a URL.
+	controlsFetchTimeout = 10 * time.Second
+
+	// unknownSeverityLiteral is the severity literal of the controls
+	// without metadata.
+	unknownSeverityLiteral = "Unknown"
+)
+
+var sha256Regexp = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// validateControlsSource checks the options that define where the controls
+// metadata is loaded from.
+func validateControlsSource(controlsURL, checksum string) error {
+	if controlsURL != "" {
+		u, err := url.Parse(controlsURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid controls_url: %q is not an http or https URL", controlsURL)
+		}
+	}
+	if checksum != "" && !sha256Regexp.MatchString(checksum) {
+		return fmt.Errorf("invalid controls_sha256 %q: must be an hex encoded SHA-256 digest", checksum)
+	}
+	return nil
+}
+
+// loadControls loads the controls metadata from controlsURL. If controlsURL
+// is empty or the metadata can not be fetched or is invalid, the bundled
+// metadata file is loaded instead. In the latter case it also returns a note
+// explaining why the fallback was used.
+func loadControls(ctx context.Context, client *http.Client, controlsURL, checksum, bundled string) (prowlerparse.MetadataFile, string, error) {
+	var note string
+	if controlsURL != "" {
+		mf, err := fetchControls(ctx, client, controlsURL, checksum)
+		if err == nil {
+			ctxLogger(ctx).Infof("controls metadata loaded from %s", redactURL(controlsURL))
+			logMetadataWarnings(ctx, mf)
+			return mf, "", nil
+		}
+		ctxLogger(ctx).Warnf("can not load the controls metadata from %s, using the bundled metadata: %v", redactURL(controlsURL), err)
+		note = fmt.Sprintf("The controls metadata could not be loaded from %s, the bundled metadata was used instead: %v", redactURL(controlsURL), err)
+	}
+	content, err := os.ReadFile(bundled)
+	if err != nil {
+		return prowlerparse.MetadataFile{}, "", err
+	}
+	mf, err := ciscontrols.Load(content)
+	if err != nil {
+		return prowlerparse.MetadataFile{}, "", err
+	}
+	logMetadataWarnings(ctx, mf)
+	return mf, note, nil
+}
+
+// logMetadataWarnings logs the problems of the controls metadata that do not
+// make it invalid.
+func logMetadataWarnings(ctx context.Context, mf prowlerparse.MetadataFile) {
+	log := ctxLogger(ctx)
+	for _, w := range mf.Warnings() {
+		log.Warnf("controls metadata: %s", w)
+	}
+}
+
+// lookupControl returns the metadata of the control of the given entry. If
+// there is no metadata for the control, it returns a default one with a
+// medium severity and without remediation, and false.
+func lookupControl(e entry, controls map[string]CISControl) (CISControl, bool) {
+	if c, ok := prowlerparse.Metadata(controls).LookupFinding(e); ok {
+		return c, true
+	}
+	id, _, _ := prowlerparse.ParseControl(e.Control)
+	return CISControl{
+		ID:              id,
+		Severity:        report.SeverityThresholdMedium,
+		SeverityLiteral: unknownSeverityLiteral,
+	}, false
+}
+
+// unknownControls returns the identifiers of the failed controls of the
+// given entries without metadata, sorted.
+func unknownControls(entries []entry, controls map[string]CISControl) []string {
+	var (
+		unknown []string
+		seen    = map[string]bool{}
+	)
+	for _, e := range entries {
+		if e.Status != "FAIL" {
+			continue
+		}
+		c, ok := lookupControl(e, controls)
+		if ok || c.ID == "" || seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+		unknown = append(unknown, c.ID)
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return prowlerparse.CompareControls(unknown[i], unknown[j]) < 0
+	})
+	return unknown
+}
+
+// fetchControls fetches and validates the controls metadata from the given
+// URL. If checksum is not empty, the SHA-256 digest of the document must
+// match it.
+func fetchControls(ctx context.Context, client *http.Client, controlsURL, checksum string) (prowlerparse.MetadataFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, controlsFetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, controlsURL, nil)
+	if err != nil {
+		return prowlerparse.MetadataFile{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return prowlerparse.MetadataFile{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return prowlerparse.MetadataFile{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxControlsSize+1))
+	if err != nil {
+		return prowlerparse.MetadataFile{}, err
+	}
+	if len(content) > maxControlsSize {
+		return prowlerparse.MetadataFile{}, fmt.Errorf("document exceeds the maximum size of %d bytes", maxControlsSize)
+	}
+	if checksum != "" {
+		sum := sha256.Sum256(content)
+		if got := hex.EncodeToString(sum[:]); !bytes.EqualFold([]byte(got), []byte(checksum)) {
+			return prowlerparse.MetadataFile{}, fmt.Errorf("checksum mismatch: got %s, want %s", got, checksum)
+		}
+	}
+	return ciscontrols.Load(content)
+}
+
+// redactURL returns the URL without the credentials it could contain.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const remoteControls = `{
+	"benchmark_version": "1.2",
+	"controls": {
+		"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "remediation": "https://example.com/1.3"}
+	}
+}`
+
+func TestLoadControls(t *testing.T) {
+	sum := sha256.Sum256([]byte(remoteControls))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		checksum     string
+		noURL        bool
+		wantRemote   bool
+		wantFallback bool
+	}{
+		{
+			name:       "Remote",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(remoteControls)) },
+			checksum:   checksum,
+			wantRemote: true,
+		},
+		{
+			name:  "NoURL",
+			noURL: true,
+		},
+		{
+			name:         "ChecksumMismatch",
+			handler:      func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(remoteControls)) },
+			checksum:     strings.Repeat("0", 64),
+			wantFallback: true,
+		},
+		{
+			name:         "NotFound",
+			handler:      http.NotFound,
+			wantFallback: true,
+		},
+		{
+			name: "TooLarge",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(strings.Repeat(" ", maxControlsSize+1)))
+			},
+			wantFallback: true,
+		},
+		{
+			name: "InvalidSchema",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 20}}}`))
+			},
+			wantFallback: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var controlsURL string
+			client := http.DefaultClient
+			if !tt.noURL {
+				srv := httptest.NewServer(tt.handler)
+				defer srv.Close()
+				controlsURL = srv.URL
+				client = srv.Client()
+			}
+			mf, note, err := loadControls(context.Background(), client, controlsURL, tt.checksum, bundledControlsFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The remote metadata only contains one control.
+			if remote := len(mf.Controls) == 1; remote != tt.wantRemote {
+				t.Errorf("unexpected metadata source, remote: %v", remote)
+			}
+			if (note != "") != tt.wantFallback {
+				t.Errorf("unexpected note: %q", note)
+			}
+		})
+	}
+}
+
+func TestValidateControlsSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		controlsURL string
+		checksum    string
+		wantErr     bool
+	}{
+		{name: "Unset"},
+		{name: "Valid", controlsURL: "https://example.com/cis_controls.json", checksum: strings.Repeat("a", 64)},
+		{name: "InvalidScheme", controlsURL: "file:///etc/passwd", wantErr: true},
+		{name: "InvalidChecksum", controlsURL: "https://example.com/cis_controls.json", checksum: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateControlsSource(tt.controlsURL, tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
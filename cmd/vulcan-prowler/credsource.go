@@ -0,0 +1,117 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// refreshMargin is the time before the expiration of the credentials in
+// which they are already considered stale, so a prowler execution does not
+// start with credentials that expire right after.
+const refreshMargin = 5 * time.Minute
+
+// errCredentialsExpired is returned when prowler fails because the
+// credentials it was using expired.
+var errCredentialsExpired = errors.New("credentials expired")
+
+// credentialsFetcher returns new credentials for the scanned account.
+type credentialsFetcher func(ctx context.Context) (awsCredentials, error)
+
+// credentialSource provides the credentials used to scan an account and
+// refreshes them when they are about to expire. It is safe for concurrent
+// use.
+type credentialSource struct {
+	fetch    credentialsFetcher
+	lifetime time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	creds     awsCredentials
+	acquired  time.Time
+	refreshes int
+}
+
+// newCredentialSource returns a credentialSource that starts with the given
+// credentials, acquired now, and that are valid for the given lifetime. If
+// fetch is nil the credentials are never refreshed.
+func newCredentialSource(creds awsCredentials, lifetime time.Duration, fetch credentialsFetcher) *credentialSource {
+	return &credentialSource{
+		fetch:    fetch,
+		lifetime: lifetime,
+		now:      time.Now,
+		creds:    creds,
+		acquired: time.Now(),
+	}
+}
+
+// get returns the current credentials, refreshing them first if they are
+// about to expire.
+func (s *credentialSource) get(ctx context.Context) (awsCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetch == nil || s.now().Before(s.acquired.Add(s.lifetime-refreshMargin)) {
+		return s.creds, nil
+	}
+	return s.refreshLocked(ctx)
+}
+
+// refresh returns fresh credentials given that the credentials used
+// expired. If the credentials have already been refreshed since used were
+// returned, the current ones are returned without requesting new ones.
+func (s *credentialSource) refresh(ctx context.Context, used awsCredentials) (awsCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fetch == nil {
+		return awsCredentials{}, errCredentialsExpired
+	}
+	if s.creds != used {
+		return s.creds, nil
+	}
+	return s.refreshLocked(ctx)
+}
+
+func (s *credentialSource) refreshLocked(ctx context.Context) (awsCredentials, error) {
+	ctxLogger(ctx).Info("refreshing credentials")
+	creds, err := s.fetch(ctx)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	s.creds = creds
+	s.acquired = s.now()
+	s.refreshes++
+	return creds, nil
+}
+
+// refreshCount returns the number of times the credentials have been
+// refreshed.
+func (s *credentialSource) refreshCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshes
+}
+
+// withCredentials runs prowler using the credentials provided by src. If
+// prowler fails because the credentials expired, it is executed again, only
+// once, with fresh credentials.
+func withCredentials(ctx context.Context, src *credentialSource, cfg prowlerConfig, run func(ctx context.Context, cfg prowlerConfig) (*prowlerReport, error)) (*prowlerReport, error) {
+	creds, err := src.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := run(ctx, cfg.withCredentials(creds))
+	if !errors.Is(err, errCredentialsExpired) {
+		return r, err
+	}
+	ctxLogger(ctx).Warn("prowler credentials expired")
+	creds, err = src.refresh(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	return run(ctx, cfg.withCredentials(creds))
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeShortLivedEndpoint implements an assume role endpoint that returns
+// a different session token in each response. It returns the number of
+// requests received.
+func fakeShortLivedEndpoint(t *testing.T) (*httptest.Server, func() int) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		fmt.Fprintf(w, `{"access_key":"AKID","secret_access_key":"secret","session_token":"token-%d","version":2}`, requests)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// newTestCredentialSource returns a credentialSource that gets its
+// credentials from the given assume role endpoint and uses the given clock.
+func newTestCredentialSource(t *testing.T, url string, lifetime time.Duration, now func() time.Time) *credentialSource {
+	fetch := func(ctx context.Context) (awsCredentials, error) {
+		creds, _, err := loadCredentials(ctx, url, "123456789012", "audit", "", int(lifetime/time.Second), time.Minute)
+		return creds, err
+	}
+	creds, err := fetch(context.Background())
+	if err != nil {
+		t.Fatalf("can not get initial credentials: %v", err)
+	}
+	src := newCredentialSource(creds, lifetime, fetch)
+	src.now = now
+	src.acquired = now()
+	return src
+}
+
+func TestCredentialSourceGet(t *testing.T) {
+	srv, requests := fakeShortLivedEndpoint(t)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	src := newTestCredentialSource(t, srv.URL, 15*time.Minute, func() time.Time { return now })
+
+	tests := []struct {
+		name          string
+		elapsed       time.Duration
+		wantToken     string
+		wantRefreshes int
+	}{
+		{
+			name:      "Fresh",
+			elapsed:   time.Minute,
+			wantToken: "token-1",
+		},
+		{
+			name:      "BeforeMargin",
+			elapsed:   9 * time.Minute,
+			wantToken: "token-1",
+		},
+		{
+			name:          "WithinMargin",
+			elapsed:       11 * time.Minute,
+			wantToken:     "token-2",
+			wantRefreshes: 1,
+		},
+		{
+			name:          "Refreshed",
+			elapsed:       12 * time.Minute,
+			wantToken:     "token-2",
+			wantRefreshes: 1,
+		},
+		{
+			name:          "RefreshedExpired",
+			elapsed:       30 * time.Minute,
+			wantToken:     "token-3",
+			wantRefreshes: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start.Add(tt.elapsed)
+			creds, err := src.get(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if creds.SessionToken != tt.wantToken {
+				t.Errorf("unexpected token: got %q, want %q", creds.SessionToken, tt.wantToken)
+			}
+			if got := src.refreshCount(); got != tt.wantRefreshes {
+				t.Errorf("unexpected number of refreshes: got %d, want %d", got, tt.wantRefreshes)
+			}
+		})
+	}
+	if got := requests(); got != 3 {
+		t.Errorf("unexpected number of requests: %d", got)
+	}
+}
+
+func TestCredentialSourceRefreshOnce(t *testing.T) {
+	srv, requests := fakeShortLivedEndpoint(t)
+	src := newTestCredentialSource(t, srv.URL, time.Hour, time.Now)
+	used, err := src.get(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// All the regions using the expired credentials ask for a refresh, but
+	// only one request must be sent to the endpoint.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			creds, err := src.refresh(context.Background(), used)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if creds.SessionToken != "token-2" {
+				t.Errorf("unexpected token: %q", creds.SessionToken)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := src.refreshCount(); got != 1 {
+		t.Errorf("unexpected number of refreshes: %d", got)
+	}
+	if got := requests(); got != 2 {
+		t.Errorf("unexpected number of requests: %d", got)
+	}
+}
+
+func TestWithCredentials(t *testing.T) {
+	tests := []struct {
+		name          string
+		fetch         bool
+		expirations   int
+		wantEnv       [][]string
+		wantRefreshes int
+		wantErr       error
+	}{
+		{
+			name:  "NotExpired",
+			fetch: true,
+			wantEnv: [][]string{
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-1"},
+			},
+		},
+		{
+			name:        "ExpiredOnce",
+			fetch:       true,
+			expirations: 1,
+			wantEnv: [][]string{
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-1"},
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-2"},
+			},
+			wantRefreshes: 1,
+		},
+		{
+			name:        "ExpiredTwice",
+			fetch:       true,
+			expirations: 2,
+			wantEnv: [][]string{
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-1"},
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-2"},
+			},
+			wantRefreshes: 1,
+			wantErr:       errCredentialsExpired,
+		},
+		{
+			name:        "StaticCredentials",
+			expirations: 1,
+			wantEnv: [][]string{
+				{"BASE=1", "AWS_ACCESS_KEY_ID=AKID", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token-1"},
+			},
+			wantErr: errCredentialsExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := fakeShortLivedEndpoint(t)
+			src := newTestCredentialSource(t, srv.URL, time.Hour, time.Now)
+			if !tt.fetch {
+				src.fetch = nil
+			}
+			var env [][]string
+			run := func(ctx context.Context, cfg prowlerConfig) (*prowlerReport, error) {
+				env = append(env, cfg.Env)
+				if len(env) <= tt.expirations {
+					return nil, errCredentialsExpired
+				}
+				return &prowlerReport{}, nil
+			}
+			cfg := prowlerConfig{Env: []string{"BASE=1"}}
+			_, err := withCredentials(context.Background(), src, cfg, run)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantEnv, env); diff != "" {
+				t.Errorf("env mismatch (-want +got):\n%s", diff)
+			}
+			if got := src.refreshCount(); got != tt.wantRefreshes {
+				t.Errorf("unexpected number of refreshes: %d", got)
+			}
+			if diff := cmp.Diff([]string{"BASE=1"}, cfg.Env); diff != "" {
+				t.Errorf("base config modified (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIsExpiredTokenOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{
+			name:   "ExpiredToken",
+			output: "An error occurred (ExpiredToken) when calling the DescribeInstances operation: The security token included in the request is expired",
+			want:   true,
+		},
+		{
+			name:   "RequestExpired",
+			output: "An error occurred (RequestExpired) when calling the ListUsers operation: Request has expired.",
+			want:   true,
+		},
+		{
+			name:   "NoError",
+			output: "1.1 [cis-1.1] Avoid the use of the root account: PASS",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isExpiredTokenOutput([]byte(tt.output)); got != tt.want {
+				t.Errorf("unexpected result: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reportData is the structured information attached to the Data field of the
+// report generated by the check.
+type reportData struct {
+	Provenance provenance `json:"provenance"`
+	// MetadataMismatch is true when the version of the controls metadata
+	// does not match the selected benchmark version.
+	MetadataMismatch bool           `json:"metadata_mismatch,omitempty"`
+	Results          resultsSummary `json:"results"`
+	// FailedBySeverity contains the number of reported failed controls of
+	// each CIS severity.
+	FailedBySeverity severityCounts `json:"failed_by_severity"`
+	// Levels contains the results of the CIS level 1 and level 2 controls
+	// when all of them were executed.
+	Levels []levelSummary `json:"levels,omitempty"`
+	// Skipped contains the controls of the benchmark that were not
+	// executed and the reason why.
+	Skipped []skippedControl `json:"skipped,omitempty"`
+	Config  configSnapshot   `json:"config"`
+	Metrics runMetrics       `json:"metrics"`
+}
+
+// resultsSummary contains the number of entries of the prowler report.
+type resultsSummary struct {
+	Total  int `json:"total"`
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	// Info is the number of informational entries, including the manual
+	// and not scored controls.
+	Info int `json:"info"`
+	// NotEvaluated is the number of entries of the controls that prowler
+	// could not evaluate due to missing permissions.
+	NotEvaluated int `json:"not_evaluated,omitempty"`
+	// Warning is the number of entries suppressed by the allowlist of
+	// prowler.
+	Warning int `json:"warning,omitempty"`
+	// Unknown is the number of entries with an unexpected status.
+	Unknown int `json:"unknown,omitempty"`
+	// CompliancePct is the percentage of passed entries over the passed,
+	// failed and not evaluated ones. Informational entries are excluded. It
+	// is null when there are no entries to compute it from.
+	CompliancePct *float64 `json:"compliance_pct"`
+	// Truncated is true when prowler did not finish in time and the
+	// results are partial.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// provenance identifies the inputs used to generate the report.
+type provenance struct {
+	// BenchmarkVersion is the CIS benchmark version selected.
+	BenchmarkVersion string `json:"benchmark_version"`
+	// MetadataBenchmarkVersion is the CIS benchmark version of the controls
+	// metadata used to classify the findings.
+	MetadataBenchmarkVersion string `json:"metadata_benchmark_version"`
+	// AssumeRoleVersion is the version of the assume role payload honored
+	// by the assume role endpoint.
+	AssumeRoleVersion int `json:"assume_role_version"`
+	// ProwlerVersion is the version of prowler that executed the scan, or
+	// "unknown" if it could not be determined.
+	ProwlerVersion string `json:"prowler_version"`
+	// Groups are the groups of controls executed by prowler.
+	Groups []string `json:"groups"`
+	// Regions are the regions scanned. It is empty when all the regions
+	// were scanned.
+	Regions []string `json:"regions,omitempty"`
+	// SessionDuration is the duration, in seconds, of the session of the
+	// credentials used by prowler.
+	SessionDuration int `json:"session_duration"`
+}
+
+// scanDetails returns the parameters of the scan to be included in the
+// details of the vulnerabilities.
+func (p provenance) scanDetails() string {
+	regions := regionLabel("")
+	if len(p.Regions) > 0 {
+		regions = strings.Join(p.Regions, ", ")
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Prowler Version: %s\n", p.ProwlerVersion)
+	fmt.Fprintf(&b, "Executed Groups: %s\n", strings.Join(p.Groups, ", "))
+	fmt.Fprintf(&b, "Scanned Regions: %s\n", regions)
+	fmt.Fprintf(&b, "Session Duration: %ds\n", p.SessionDuration)
+	return b.String()
+}
+
+// configSnapshot contains the configuration used by the check that is not
+// already visible in the options of the report.
+type configSnapshot struct {
+	// EndpointOverrides contains the endpoint overrides with the
+	// credentials, if any, redacted.
+	EndpointOverrides map[string]string `json:"endpoint_overrides,omitempty"`
+}
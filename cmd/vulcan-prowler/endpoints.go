@@ -0,0 +1,206 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	// The AWS SDK v2 does not expose the partitions and regions metadata,
+	// so the identifiers of the partitions are taken from the AWS SDK v1.
+	// The clients of the check use the AWS SDK v2.
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// envAWSConfigFile is the env var used by the AWS CLI, and hence by prowler,
+// to locate its config file.
+const envAWSConfigFile = `AWS_CONFIG_FILE`
+
+const (
+	// envEndpointURL is the env var that defines a custom endpoint URL for
+	// all the services queried by the check.
+	envEndpointURL = `AWS_ENDPOINT_URL`
+	// envEndpointURLPrefix is the prefix of the env vars that define a
+	// custom endpoint URL for a service, e.g.: AWS_ENDPOINT_URL_IAM.
+	envEndpointURLPrefix = `AWS_ENDPOINT_URL_`
+)
+
+// checkServices are the services queried directly by the check.
+var checkServices = []string{"iam", "organizations", "s3", "sts"}
+
+// awsMaxRetries is the maximum number of times a request to the AWS API is
+// retried when it fails because of throttling or a server error.
+const awsMaxRetries = 5
+
+// partitionRegions contains the region used to query the AWS API of each
+// partition when no region is configured.
+var partitionRegions = map[string]string{
+	endpoints.AwsPartitionID:      defaultAPIRegion,
+	endpoints.AwsCnPartitionID:    "cn-north-1",
+	endpoints.AwsUsGovPartitionID: "us-gov-west-1",
+}
+
+// servicesSection is the name of the services section of the AWS CLI config
+// file generated for prowler.
+const servicesSection = `vulcan-prowler`
+
+var serviceIDRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateEndpointOverrides checks that the keys of the overrides are service
+// identifiers and the values are https URLs.
+func validateEndpointOverrides(overrides map[string]string) error {
+	return validateEndpointURLs(overrides, "https")
+}
+
+// validateEndpointURLs checks that the keys of the overrides are service
+// identifiers and the values are URLs with one of the given schemes.
+func validateEndpointURLs(overrides map[string]string, schemes ...string) error {
+	for _, service := range sortedKeys(overrides) {
+		if !serviceIDRegexp.MatchString(service) {
+			return fmt.Errorf("invalid endpoint override: invalid service %q", service)
+		}
+		u, err := url.Parse(overrides[service])
+		if err != nil {
+			return fmt.Errorf("invalid endpoint override for service %s: %w", service, err)
+		}
+		if !slices.Contains(schemes, u.Scheme) || u.Host == "" {
+			return fmt.Errorf("invalid endpoint override for service %s: %q is not an %s URL", service, u.Redacted(), strings.Join(schemes, " or "))
+		}
+	}
+	return nil
+}
+
+// envEndpointOverrides returns the endpoint overrides defined in the given
+// environment with the env vars used by the AWS SDKs and CLI:
+// AWS_ENDPOINT_URL_<SERVICE> defines the endpoint of a service and
+// AWS_ENDPOINT_URL the endpoint of the services queried by the check that
+// do not have a specific one. As they are meant for testing against local
+// emulators, like LocalStack, the endpoints can also be http URLs.
+func envEndpointOverrides(environ []string) (map[string]string, error) {
+	overrides := map[string]string{}
+	var global string
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || v == "" {
+			continue
+		}
+		switch {
+		case k == envEndpointURL:
+			global = v
+		case strings.HasPrefix(k, envEndpointURLPrefix):
+			service := strings.ToLower(strings.TrimPrefix(k, envEndpointURLPrefix))
+			overrides[service] = v
+		}
+	}
+	if global != "" {
+		for _, service := range checkServices {
+			if _, ok := overrides[service]; !ok {
+				overrides[service] = global
+			}
+		}
+	}
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	if err := validateEndpointURLs(overrides, "https", "http"); err != nil {
+		return nil, fmt.Errorf("invalid %s env vars: %w", envEndpointURL, err)
+	}
+	return overrides, nil
+}
+
+// mergeEndpointOverrides returns the union of the given overrides. The
+// overrides in opts take precedence over the ones in env.
+func mergeEndpointOverrides(env, opts map[string]string) map[string]string {
+	if len(env) == 0 {
+		return opts
+	}
+	merged := map[string]string{}
+	for service, endpoint := range env {
+		merged[service] = endpoint
+	}
+	for service, endpoint := range opts {
+		merged[service] = endpoint
+	}
+	return merged
+}
+
+// redactedEndpointOverrides returns a copy of the overrides without the
+// credentials the URLs could contain.
+func redactedEndpointOverrides(overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return nil
+	}
+	redacted := map[string]string{}
+	for service, endpoint := range overrides {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			continue
+		}
+		redacted[service] = u.Redacted()
+	}
+	return redacted
+}
+
+// apiRegion returns the region used to query the AWS API of the given
+// partition. The configured region, if any, takes precedence.
+func apiRegion(partition, region string) string {
+	if region != "" {
+		return region
+	}
+	if r, ok := partitionRegions[partition]; ok {
+		return r
+	}
+	return defaultAPIRegion
+}
+
+// awsCLIConfig returns the content of an AWS CLI config file that makes the
+// default profile use the given endpoint overrides.
+func awsCLIConfig(overrides map[string]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[default]\nservices = %s\n\n", servicesSection)
+	fmt.Fprintf(&b, "[services %s]\n", servicesSection)
+	for _, service := range sortedKeys(overrides) {
+		fmt.Fprintf(&b, "%s =\n  endpoint_url = %s\n", service, overrides[service])
+	}
+	return b.String()
+}
+
+// writeAWSCLIConfig writes an AWS CLI config file with the given endpoint
+// overrides and returns the env vars that make prowler use it together with
+// a function that removes the file.
+func writeAWSCLIConfig(overrides map[string]string) ([]string, func(), error) {
+	if len(overrides) == 0 {
+		return nil, func() {}, nil
+	}
+	f, err := os.CreateTemp("", "vulcan-prowler-aws-config-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if _, err := f.WriteString(awsCLIConfig(overrides)); err != nil {
+		f.Close()
+		cleanup()
+		return nil, nil, err
+	}
+	if err := f.Close(); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return []string{envAWSConfigFile + "=" + f.Name()}, cleanup, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// checksListRegexp matches a comma separated list of prowler checks,
+	// e.g.: check11,extra718.
+	checksListRegexp = regexp.MustCompile(`^[a-z0-9_]+(,[a-z0-9_]+)*$`)
+	numberRegexp     = regexp.MustCompile(`^[0-9]{1,6}$`)
+)
+
+// allowedExtraArgs contains the prowler flags that can be passed using the
+// extra_args option. The value of each flag is the regular expression its
+// argument must match, or nil if the flag does not take an argument.
+var allowedExtraArgs = map[string]*regexp.Regexp{
+	// Only report failed checks.
+	"-q": nil,
+	// Show the numbers of the checks.
+	"-n": nil,
+	// Do not print the banner.
+	"-b": nil,
+	// Keep the credential report.
+	"-k": nil,
+	// Maximum number of items returned by the AWS CLI calls.
+	"-m": numberRegexp,
+	// Checks to exclude.
+	"-E": checksListRegexp,
+}
+
+// outputArgs contains the prowler flags that change the format, the name or
+// the location of the report. They are explicitly rejected because the check
+// depends on them to find and parse the report.
+var outputArgs = map[string]bool{
+	"-M": true,
+	"-F": true,
+	"-o": true,
+	"-B": true,
+	"-D": true,
+	"-S": true,
+}
+
+// validateExtraArgs returns an error if the args contain flags that are not
+// allowed or flags with invalid arguments.
+func validateExtraArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if outputArgs[arg] {
+			return fmt.Errorf("invalid extra_args: flag %q changes the prowler output", arg)
+		}
+		valueRegexp, ok := allowedExtraArgs[arg]
+		if !ok {
+			return fmt.Errorf("invalid extra_args: flag %q is not allowed", arg)
+		}
+		if valueRegexp == nil {
+			continue
+		}
+		i++
+		if i == len(args) {
+			return fmt.Errorf("invalid extra_args: flag %q requires an argument", arg)
+		}
+		if !valueRegexp.MatchString(args[i]) {
+			return fmt.Errorf("invalid extra_args: invalid argument %q for flag %q", args[i], arg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateExtraArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "NoArgs", args: nil},
+		{name: "AllowedFlags", args: []string{"-q", "-n", "-m", "100", "-E", "check11,extra718"}},
+		{name: "OutputFormat", args: []string{"-M", "csv"}, wantErr: true},
+		{name: "OutputFilename", args: []string{"-F", "other"}, wantErr: true},
+		{name: "OutputDirectory", args: []string{"-o", "/tmp"}, wantErr: true},
+		{name: "UnknownFlag", args: []string{"-x", "/tmp/checks"}, wantErr: true},
+		{name: "ShellInjection", args: []string{"; rm -rf /"}, wantErr: true},
+		{name: "ShellInjectionInValue", args: []string{"-E", "check11; rm -rf /"}, wantErr: true},
+		{name: "CommandSubstitution", args: []string{"-m", "$(id)"}, wantErr: true},
+		{name: "Redirection", args: []string{"-q", ">", "/etc/passwd"}, wantErr: true},
+		{name: "MissingValue", args: []string{"-m"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateExtraArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsExtraArgsInjection(t *testing.T) {
+	if _, err := buildOptions(`{"extra_args":["-q", "\"; rm -rf /\""]}`, "aws"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestBuildParamsExtraArgs(t *testing.T) {
+	got := buildParams("eu-west-1", []string{"cislevel1"}, []string{"-q", "-m", "100"}, reportName, "/tmp/prowler-1")
+	want := []string{
+		"-g", "cislevel1",
+		"-M", "json",
+		"-F", "report",
+		"-o", "/tmp/prowler-1",
+		"-r", "eu-west-1", "-f", "eu-west-1",
+		"-q", "-m", "100",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected params (-want +got):\n%v", diff)
+	}
+}
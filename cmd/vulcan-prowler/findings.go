@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+
+	report "github.com/adevinta/vulcan-report"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+const (
+	// findingsSchemaVersion is the version of the schema of the findings
+	// document. It must be increased with every change that is not backward
+	// compatible.
+	findingsSchemaVersion = 1
+
+	// findingsAttachmentName is the name of the attachment of the info
+	// vulnerability that contains the findings document.
+	findingsAttachmentName = "findings.json"
+)
+
+// findingsDocument is the machine-readable version of the results shown in
+// the details and resources of the vulnerabilities, so the consumers of the
+// report do not have to parse them.
+type findingsDocument struct {
+	Schema         int             `json:"schema"`
+	Account        findingsAccount `json:"account"`
+	Groups         []string        `json:"groups"`
+	ProwlerVersion string          `json:"prowler_version,omitempty"`
+	Results        resultsSummary  `json:"results"`
+	FailedControls []failedControl `json:"failed_controls"`
+}
+
+// findingsAccount identifies the scanned account.
+type findingsAccount struct {
+	ID    string `json:"id"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// failedControl contains the regions and resources in which a control
+// failed.
+type failedControl struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	// Severity is the CIS severity of the control. It is 0 when there is no
+	// metadata for the control.
+	Severity        float32  `json:"severity"`
+	SeverityLiteral string   `json:"severity_literal,omitempty"`
+	Regions         []string `json:"regions"`
+	Resources       []string `json:"resources,omitempty"`
+}
+
+// buildFindingsDocument returns the findings document of the given report.
+// The failed controls are sorted by severity and identifier.
+func buildFindingsDocument(r *prowlerReport, acc awsAccount, groups []string, controls map[string]CISControl) findingsDocument {
+	doc := findingsDocument{
+		Schema:         findingsSchemaVersion,
+		Account:        findingsAccount{ID: acc.ID, Alias: acc.Alias},
+		Groups:         append([]string{}, groups...),
+		ProwlerVersion: r.version,
+		Results:        r.summary(),
+		FailedControls: []failedControl{},
+	}
+	byID := map[string]*failedControl{}
+	for _, e := range r.entries {
+		if e.Status != "FAIL" {
+			continue
+		}
+		id, description, err := prowlerparse.ParseControl(e.Control)
+		if err != nil {
+			continue
+		}
+		fc, ok := byID[id]
+		if !ok {
+			fc = &failedControl{ID: id, Description: description, Regions: []string{}}
+			if cinfo, ok := prowlerparse.Metadata(controls).LookupFinding(e); ok {
+				fc.Severity = cinfo.Severity
+				fc.SeverityLiteral = cinfo.SeverityLiteral
+			}
+			byID[id] = fc
+		}
+		fc.Regions = appendUnique(fc.Regions, e.Region)
+		fc.Resources = appendUnique(fc.Resources, prowlerparse.Resource(e))
+	}
+	for _, fc := range byID {
+		sort.Strings(fc.Regions)
+		sort.Strings(fc.Resources)
+		doc.FailedControls = append(doc.FailedControls, *fc)
+	}
+	sort.Slice(doc.FailedControls, func(i, j int) bool {
+		ci, cj := doc.FailedControls[i], doc.FailedControls[j]
+		if ci.Severity == cj.Severity {
+			return ci.ID < cj.ID
+		}
+		return ci.Severity > cj.Severity
+	})
+	return doc
+}
+
+// appendUnique appends s to the given slice if it is not empty and it is not
+// already in the slice.
+func appendUnique(ss []string, s string) []string {
+	if s == "" || slices.Contains(ss, s) {
+		return ss
+	}
+	return append(ss, s)
+}
+
+// findingsAttachment returns the attachment with the given findings
+// document encoded in JSON.
+func findingsAttachment(doc findingsDocument) (report.Attachment, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return report.Attachment{}, err
+	}
+	return report.Attachment{
+		Name:        findingsAttachmentName,
+		ContentType: "application/json",
+		Data:        data,
+	}, nil
+}
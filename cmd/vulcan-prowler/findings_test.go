@@ -0,0 +1,129 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildFindingsDocument(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 8.9, SeverityLiteral: "High"},
+		"2.1": {ID: "2.1", Severity: 3.9, SeverityLiteral: "Low"},
+	}
+	r := &prowlerReport{
+		version: "2.12.0",
+		entries: []entry{
+			{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL", Region: "us-east-1", Message: "No CloudTrail trails were found"},
+			{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "No CloudTrail trails were found"},
+			{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "User deploy-bot has not used access key 1 since creation"},
+			{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "User ci has not used access key 1 since creation"},
+			{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "FAIL", Region: "eu-west-1", ResourceID: "arn:aws:s3:::logs"},
+			{Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)", Status: "PASS", Region: "eu-west-1"},
+			{Control: "[check11] Avoid the use of the root account (Scored)", Status: "Info", Region: "eu-west-1"},
+		},
+	}
+	acc := awsAccount{ID: "123456789012", Alias: "alias"}
+	got := buildFindingsDocument(r, acc, []string{"cislevel2"}, controls)
+	want := findingsDocument{
+		Schema:         1,
+		Account:        findingsAccount{ID: "123456789012", Alias: "alias"},
+		Groups:         []string{"cislevel2"},
+		ProwlerVersion: "2.12.0",
+		Results:        resultsSummary{Total: 7, Passed: 1, Failed: 5, Info: 1, CompliancePct: float64Ptr(16.67)},
+		FailedControls: []failedControl{
+			{
+				ID:              "1.3",
+				Description:     "Ensure credentials unused for 90 days or greater are disabled",
+				Severity:        8.9,
+				SeverityLiteral: "High",
+				Regions:         []string{"eu-west-1"},
+				Resources:       []string{"ci", "deploy-bot"},
+			},
+			{
+				ID:              "2.1",
+				Description:     "Ensure CloudTrail is enabled in all regions",
+				Severity:        3.9,
+				SeverityLiteral: "Low",
+				Regions:         []string{"eu-west-1", "us-east-1"},
+			},
+			{
+				ID:              "extra718",
+				Description:     "Check if S3 buckets have server access logging enabled",
+				Severity:        3.9,
+				SeverityLiteral: "Low",
+				Regions:         []string{"eu-west-1"},
+				Resources:       []string{"arn:aws:s3:::logs"},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected document (-want +got):\n%v", diff)
+	}
+}
+
+func TestFindingsDocumentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  findingsDocument
+	}{
+		{
+			name: "Empty",
+			doc: findingsDocument{
+				Schema:         findingsSchemaVersion,
+				Account:        findingsAccount{ID: "123456789012"},
+				Groups:         []string{},
+				FailedControls: []failedControl{},
+			},
+		},
+		{
+			name: "FailedControls",
+			doc: findingsDocument{
+				Schema:         findingsSchemaVersion,
+				Account:        findingsAccount{ID: "123456789012", Alias: "alias"},
+				Groups:         []string{"cislevel1", "extras"},
+				ProwlerVersion: "3.11.3",
+				Results:        resultsSummary{Total: 3, Passed: 1, Failed: 1, NotEvaluated: 1, CompliancePct: float64Ptr(33.33)},
+				FailedControls: []failedControl{
+					{
+						ID:              "s3_bucket_public_access",
+						Description:     "Ensure there are no S3 buckets open to <Everyone> & Any AWS user",
+						Severity:        10,
+						SeverityLiteral: "Critical",
+						Regions:         []string{"eu-west-1"},
+						Resources:       []string{"arn:aws:s3:::public"},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := findingsAttachment(tt.doc)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if a.Name != "findings.json" || a.ContentType != "application/json" {
+				t.Errorf("unexpected attachment: %s %s", a.Name, a.ContentType)
+			}
+			var schema struct {
+				Schema int `json:"schema"`
+			}
+			if err := json.Unmarshal(a.Data, &schema); err != nil || schema.Schema != 1 {
+				t.Errorf("unexpected schema version: %v %v", schema.Schema, err)
+			}
+			var got findingsDocument
+			if err := json.Unmarshal(a.Data, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.doc, got); diff != "" {
+				t.Errorf("document does not round-trip (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+
+	"github.com/adevinta/vulcan-checks/internal/awsauth"
+)
+
+// handler runs the check. The dependencies that talk to AWS, to the assume
+// role endpoint and to prowler are interfaces, so the handler can be tested
+// replacing them with fakes.
+type handler struct {
+	creds   credentialsProvider
+	aliases aliasResolver
+	prowler prowlerRunner
+}
+
+// newHandler returns a handler that uses the real dependencies of the check.
+func newHandler() *handler {
+	return &handler{
+		creds:   defaultCredentialsProvider{},
+		aliases: aliasResolverFunc(accountAlias),
+		prowler: execProwlerRunner{},
+	}
+}
+
+// scanCredentials are the credentials used to scan an account.
+type scanCredentials struct {
+	// Creds are the credentials obtained when the check starts.
+	Creds awsCredentials
+	// Source provides the credentials to the executions of prowler,
+	// refreshing them when they expire.
+	Source *credentialSource
+	// VerifiedID is the account ID returned by STS for Creds.
+	VerifiedID string
+	// AssumeRoleVersion is the version of the payload used to request the
+	// credentials to the assume role endpoint. It is 0 if the endpoint was
+	// not used.
+	AssumeRoleVersion int
+}
+
+// credentialsProvider obtains the credentials used to scan the target
+// account.
+type credentialsProvider interface {
+	Credentials(ctx context.Context, tgt awsTarget, assetType string, opts options, region string) (scanCredentials, error)
+}
+
+// aliasResolver returns the alias of an account.
+type aliasResolver interface {
+	Alias(ctx context.Context, creds awsCredentials, region string, overrides map[string]string, accountID string) (string, error)
+}
+
+// aliasResolverFunc allows to use a function as an aliasResolver.
+type aliasResolverFunc func(ctx context.Context, creds awsCredentials, region string, overrides map[string]string, accountID string) (string, error)
+
+// Alias calls f.
+func (f aliasResolverFunc) Alias(ctx context.Context, creds awsCredentials, region string, overrides map[string]string, accountID string) (string, error) {
+	return f(ctx, creds, region, overrides, accountID)
+}
+
+// prowlerRunner executes prowler in a region with the given config, that
+// defines the groups, or the control, to execute. Version returns the
+// version of prowler, e.g.: 2.12.1, and its major version.
+type prowlerRunner interface {
+	Run(ctx context.Context, region string, cfg prowlerConfig) (*prowlerReport, error)
+	Version(ctx context.Context) (string, int, error)
+}
+
+// execProwlerRunner executes the prowler binary.
+type execProwlerRunner struct{}
+
+// Run executes prowler and parses its report.
+func (execProwlerRunner) Run(ctx context.Context, region string, cfg prowlerConfig) (*prowlerReport, error) {
+	u := scanUnit{region: region, group: strings.Join(cfg.Groups, "-"), check: cfg.Check}
+	return runProwler(ctx, region, cfg, reportName+"-"+u.name())
+}
+
+// Version returns the version and the major version of the prowler binary.
+func (execProwlerRunner) Version(ctx context.Context) (string, int, error) {
+	return prowlerVersion(ctx)
+}
+
+// defaultCredentialsProvider obtains the credentials from the local
+// development mode, the environment or the assume role endpoint, in that
+// order of preference, assumes the chained role, if configured, and verifies
+// that the credentials belong to the target account.
+type defaultCredentialsProvider struct{}
+
+// Credentials returns the credentials used to scan the target account.
+func (defaultCredentialsProvider) Credentials(ctx context.Context, tgt awsTarget, assetType string, opts options, region string) (scanCredentials, error) {
+	log := ctxLogger(ctx)
+	var (
+		creds             awsCredentials
+		assumeRoleVersion int
+		// fetchBase requests new credentials for the first hop.
+		fetchBase credentialsFetcher
+	)
+	local, err := localMode()
+	if err != nil {
+		return scanCredentials{}, err
+	}
+	envCreds, ok := envCredentials()
+	if opts.UseEnvCredentials && !ok {
+		return scanCredentials{}, fmt.Errorf("use_env_credentials is set but %s and %s env vars are not defined", envKeyID, envKeySecret)
+	}
+	switch {
+	case local:
+		// See the local development mode in local.go.
+		log.Warn("local development mode: using the default AWS credential chain and skipping the reachability check")
+		creds, err = defaultChainCredentials(ctx, region)
+		if err != nil {
+			return scanCredentials{}, err
+		}
+		fetchBase = func(ctx context.Context) (awsCredentials, error) {
+			return defaultChainCredentials(ctx, region)
+		}
+	case ok:
+		log.Info("using the AWS credentials defined in the environment")
+		creds = envCreds
+	default:
+		endpoint := os.Getenv(envEndpoint)
+		if endpoint == "" {
+			return scanCredentials{}, fmt.Errorf("%s env var must have a non-empty value", envEndpoint)
+		}
+		role := os.Getenv(envRole)
+
+		log.Infof("using endpoint '%s' and role '%s'", endpoint, role)
+
+		isReachable, err := helpers.IsReachable(tgt.ARN, assetType,
+			helpers.NewAWSCreds(endpoint, role))
+		if err != nil {
+			log.Warnf("Can not check asset reachability: %v", err)
+		}
+		if !isReachable {
+			return scanCredentials{}, checkstate.ErrAssetUnreachable
+		}
+
+		creds, assumeRoleVersion, err = loadCredentials(ctx, endpoint, tgt.AccountID, role, opts.ExternalID, opts.SessionDuration, time.Duration(opts.AssumeRoleTimeout)*time.Second)
+		if errors.Is(err, awsauth.ErrRoleNotAuthorized) {
+			return scanCredentials{}, fmt.Errorf("role '%s' not authorized for account %s: %w", role, tgt.AccountID, err)
+		}
+		if err != nil {
+			return scanCredentials{}, fmt.Errorf("can not get credentials for the role '%s' from the endpoint '%s': %w", role, endpoint, err)
+		}
+		log.Infof("assume role payload version: %d", assumeRoleVersion)
+		fetchBase = func(ctx context.Context) (awsCredentials, error) {
+			creds, _, err := loadCredentials(ctx, endpoint, tgt.AccountID, role, opts.ExternalID, opts.SessionDuration, time.Duration(opts.AssumeRoleTimeout)*time.Second)
+			return creds, err
+		}
+	}
+	creds, err = chainCredentials(ctx, creds, opts, region)
+	if err != nil {
+		return scanCredentials{}, err
+	}
+	verifiedID, err := verifyCredentials(ctx, creds, opts.EndpointOverrides, region, tgt.AccountID)
+	if err != nil {
+		return scanCredentials{}, err
+	}
+	log.Infof("verified account ID: %s", verifiedID)
+	var fetch credentialsFetcher
+	if fetchBase != nil || opts.ChainRoleARN != "" {
+		fetch = func(ctx context.Context) (awsCredentials, error) {
+			base := envCreds
+			if fetchBase != nil {
+				var err error
+				if base, err = fetchBase(ctx); err != nil {
+					return awsCredentials{}, err
+				}
+			}
+			creds, err := chainCredentials(ctx, base, opts, region)
+			if err != nil {
+				return awsCredentials{}, err
+			}
+			if _, err := verifyCredentials(ctx, creds, opts.EndpointOverrides, region, tgt.AccountID); err != nil {
+				return awsCredentials{}, err
+			}
+			return creds, nil
+		}
+	}
+	return scanCredentials{
+		Creds:             creds,
+		Source:            newCredentialSource(creds, time.Duration(sessionLifetime(opts))*time.Second, fetch),
+		VerifiedID:        verifiedID,
+		AssumeRoleVersion: assumeRoleVersion,
+	}, nil
+}
@@ -0,0 +1,290 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeCredentialsProvider struct {
+	err error
+}
+
+func (f fakeCredentialsProvider) Credentials(ctx context.Context, tgt awsTarget, assetType string, opts options, region string) (scanCredentials, error) {
+	if f.err != nil {
+		return scanCredentials{}, f.err
+	}
+	creds := awsCredentials{AccessKeyID: "AKIDFAKE", SecretAccessKey: "secret"}
+	return scanCredentials{
+		Creds:      creds,
+		Source:     newCredentialSource(creds, 0, nil),
+		VerifiedID: tgt.AccountID,
+	}, nil
+}
+
+// fakeProwlerRunner returns a copy of the canned entries of each group and
+// records the groups it was asked to execute.
+type fakeProwlerRunner struct {
+	entries map[string][]entry
+
+	mu     sync.Mutex
+	groups []string
+}
+
+func (f *fakeProwlerRunner) Run(ctx context.Context, region string, cfg prowlerConfig) (*prowlerReport, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := &prowlerReport{groups: cfg.Groups, version: "2.12.1"}
+	for _, g := range cfg.Groups {
+		f.groups = append(f.groups, g)
+		r.entries = append(r.entries, f.entries[g]...)
+	}
+	return r, nil
+}
+
+func (f *fakeProwlerRunner) Version(ctx context.Context) (string, int, error) {
+	return "2.12.1", 2, nil
+}
+
+func newTestHandler(runner prowlerRunner) *handler {
+	return &handler{
+		creds: fakeCredentialsProvider{},
+		aliases: aliasResolverFunc(func(context.Context, awsCredentials, string, map[string]string, string) (string, error) {
+			return "alias", nil
+		}),
+		prowler: runner,
+	}
+}
+
+var handlerEntries = map[string][]entry{
+	"cislevel2": {
+		{
+			Control: "[check11] Avoid the use of the root account (Scored)",
+			Status:  "PASS",
+			Region:  "eu-west-1",
+			Message: "Root user in the account wasn't accessed in the last 1 days",
+			Level:   "Level 1",
+		},
+		{
+			Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+			Status:  "FAIL",
+			Region:  "eu-west-1",
+			Message: "User user1 has not used access key 1 since creation",
+			Level:   "Level 1",
+		},
+		{
+			Control: "[check41] Ensure no security groups allow ingress from 0.0.0.0/0 or ::/0 to port 22 (Scored)",
+			Status:  "FAIL",
+			Region:  "eu-west-1",
+			Message: "Found Security Group: sg-1234 open to 0.0.0.0/0",
+			Level:   "Level 1",
+		},
+	},
+}
+
+var passedEntries = map[string][]entry{
+	"cislevel2": {
+		{
+			Control: "[check11] Avoid the use of the root account (Scored)",
+			Status:  "PASS",
+			Region:  "eu-west-1",
+			Message: "Root user in the account wasn't accessed in the last 1 days",
+			Level:   "Level 1",
+		},
+	},
+}
+
+func TestHandlerRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    string
+		entries    map[string][]entry
+		wantGroups []string
+	}{
+		{
+			name:       "NoFailedControls",
+			options:    `{"disable_management_account_check":true}`,
+			entries:    passedEntries,
+			wantGroups: []string{"cislevel2"},
+		},
+		{
+			name:       "SecurityLevel1",
+			options:    `{"security_level":1,"disable_management_account_check":true}`,
+			entries:    handlerEntries,
+			wantGroups: []string{"cislevel2"},
+		},
+		{
+			name:       "SecurityLevel2",
+			options:    `{"security_level":2,"disable_management_account_check":true}`,
+			entries:    handlerEntries,
+			wantGroups: []string{"cislevel2"},
+		},
+		{
+			name:       "GroupBySection",
+			options:    `{"group_by_section":true,"disable_management_account_check":true}`,
+			entries:    handlerEntries,
+			wantGroups: []string{"cislevel2"},
+		},
+		{
+			name:       "DefaultSecurityLevel",
+			options:    `{"disable_management_account_check":true}`,
+			entries:    handlerEntries,
+			wantGroups: []string{"cislevel2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeProwlerRunner{entries: tt.entries}
+			h := newTestHandler(runner)
+			var rd report.ResultData
+			state := checkstate.State{
+				ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+				ResultData:       &rd,
+			}
+			if err := h.run(context.Background(), "arn:aws:iam::123456789012:root", "AWSAccount", tt.options, state); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			slices.Sort(runner.groups)
+			if diff := cmp.Diff(tt.wantGroups, runner.groups); diff != "" {
+				t.Errorf("unexpected groups (-want +got):\n%v", diff)
+			}
+			got, err := json.MarshalIndent(rd.Vulnerabilities, "", "  ")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			golden := filepath.Join("testdata", "handler", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Errorf("unexpected vulnerabilities (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestHandlerRunCredentialsError(t *testing.T) {
+	errCreds := errors.New("no credentials")
+	h := newTestHandler(&fakeProwlerRunner{})
+	h.creds = fakeCredentialsProvider{err: errCreds}
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	err := h.run(context.Background(), "123456789012", "AWSAccount", "", state)
+	if !errors.Is(err, errCreds) {
+		t.Errorf("got error %v, want %v", err, errCreds)
+	}
+	if len(rd.Vulnerabilities) > 0 {
+		t.Errorf("unexpected vulnerabilities: %+v", rd.Vulnerabilities)
+	}
+}
+
+func TestHandlerRunProwlerVersion(t *testing.T) {
+	const finding = `{"Control":"[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)","Status":"FAIL","Region":"eu-west-1","Message":"User user1 has not used access key 1 since creation"}`
+	tests := []struct {
+		name             string
+		version          string
+		wantVersion      string
+		wantVersionFlags []string
+	}{
+		{
+			name:             "V2",
+			wantVersion:      "2.12.0",
+			wantVersionFlags: []string{"-V"},
+		},
+		{
+			name:             "V3",
+			version:          "3",
+			wantVersion:      "3.11.3",
+			wantVersionFlags: []string{"-V", "--version"},
+		},
+		{
+			name:             "Unknown",
+			version:          "unknown",
+			wantVersion:      unknownProwlerVersion,
+			wantVersionFlags: []string{"-V", "--version"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupFakeProwler(t)
+			t.Setenv("FAKE_VERSION", tt.version)
+			t.Setenv("REPORT_DIR", dir)
+			t.Setenv("FAKE_REPORT", finding)
+			h := newTestHandler(execProwlerRunner{})
+			var rd report.ResultData
+			state := checkstate.State{
+				ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+				ResultData:       &rd,
+			}
+			opts := `{"region":"eu-west-1","disable_management_account_check":true}`
+			if err := h.run(context.Background(), "123456789012", "AWSAccount", opts, state); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := "Prowler Version: " + tt.wantVersion + "\n"
+			if len(rd.Vulnerabilities) == 0 {
+				t.Fatal("no vulnerabilities")
+			}
+			for _, v := range rd.Vulnerabilities {
+				if !strings.Contains(v.Details, want) {
+					t.Errorf("details of %q do not contain %q: %q", v.Summary, want, v.Details)
+				}
+			}
+			var data reportData
+			if err := json.Unmarshal(rd.Data, &data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data.Provenance.ProwlerVersion != tt.wantVersion {
+				t.Errorf("got prowler version %q in the data, want %q", data.Provenance.ProwlerVersion, tt.wantVersion)
+			}
+			// The version is detected once per check, not per execution
+			// of prowler.
+			versions, err := os.ReadFile(filepath.Join(dir, "versions"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantVersionFlags, strings.Fields(string(versions))); diff != "" {
+				t.Errorf("version flags mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHandlerRunUnsupportedAssetType(t *testing.T) {
+	runner := &fakeProwlerRunner{entries: handlerEntries}
+	h := newTestHandler(runner)
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	err := h.run(context.Background(), "example.com", "Hostname", "", state)
+	if err == nil || !strings.Contains(err.Error(), `unsupported asset type "Hostname"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(runner.groups) > 0 {
+		t.Errorf("prowler executed for an unsupported asset type: %v", runner.groups)
+	}
+}
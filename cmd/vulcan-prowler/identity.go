@@ -0,0 +1,65 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// errAccountMismatch is returned when the credentials used by the check do
+// not belong to the target account.
+var errAccountMismatch = errors.New("credentials do not belong to the target account")
+
+// stsAPI contains the STS operations used to verify the identity of the
+// credentials.
+type stsAPI interface {
+	GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// envCredentials returns the AWS credentials defined in the environment of
+// the process. It returns false if the access key ID or the secret access key
+// are not defined.
+func envCredentials() (awsCredentials, bool) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv(envKeyID),
+		SecretAccessKey: os.Getenv(envKeySecret),
+		SessionToken:    os.Getenv(envToken),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return awsCredentials{}, false
+	}
+	return creds, true
+}
+
+// verifyCredentials checks, using STS in the given region, that the
+// credentials belong to the given account and returns the account ID
+// reported by STS.
+func verifyCredentials(ctx context.Context, creds awsCredentials, overrides map[string]string, region, accountID string) (string, error) {
+	cfg, err := loadAWSConfig(ctx, creds, region)
+	if err != nil {
+		return "", err
+	}
+	return verifyAccount(ctx, newSTSClient(cfg, overrides), accountID)
+}
+
+// verifyAccount checks that the credentials used by the STS client belong to
+// the given account and returns the account ID reported by STS.
+func verifyAccount(ctx context.Context, api stsAPI, accountID string) (string, error) {
+	out, err := api.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("can not get caller identity: %w", err)
+	}
+	got := aws.ToString(out.Account)
+	if got != accountID {
+		return got, fmt.Errorf("%w: credentials belong to account %q, target account is %q", errAccountMismatch, got, accountID)
+	}
+	return got, nil
+}
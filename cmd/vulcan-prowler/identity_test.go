@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeSTS struct {
+	account string
+	err     error
+}
+
+func (f fakeSTS) GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String(f.account)}, nil
+}
+
+func TestVerifyAccount(t *testing.T) {
+	tests := []struct {
+		name         string
+		api          fakeSTS
+		accountID    string
+		want         string
+		wantErr      bool
+		wantMismatch bool
+	}{
+		{
+			name:      "SameAccount",
+			api:       fakeSTS{account: "123456789012"},
+			accountID: "123456789012",
+			want:      "123456789012",
+		},
+		{
+			name:         "OtherAccount",
+			api:          fakeSTS{account: "210987654321"},
+			accountID:    "123456789012",
+			want:         "210987654321",
+			wantErr:      true,
+			wantMismatch: true,
+		},
+		{
+			name:      "STSError",
+			api:       fakeSTS{err: errors.New("expired token")},
+			accountID: "123456789012",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyAccount(context.Background(), tt.api, tt.accountID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if errors.Is(err, errAccountMismatch) != tt.wantMismatch {
+				t.Errorf("unexpected mismatch error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("account mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		want   awsCredentials
+		wantOK bool
+	}{
+		{
+			name: "AllDefined",
+			env: map[string]string{
+				envKeyID:     "AKIDEXAMPLE",
+				envKeySecret: "secret",
+				envToken:     "token",
+			},
+			want: awsCredentials{
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+			},
+			wantOK: true,
+		},
+		{
+			name: "NoToken",
+			env: map[string]string{
+				envKeyID:     "AKIDEXAMPLE",
+				envKeySecret: "secret",
+			},
+			want: awsCredentials{
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "secret",
+			},
+			wantOK: true,
+		},
+		{
+			name: "NoSecret",
+			env:  map[string]string{envKeyID: "AKIDEXAMPLE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{envKeyID, envKeySecret, envToken} {
+				t.Setenv(k, tt.env[k])
+			}
+			got, ok := envCredentials()
+			if ok != tt.wantOK {
+				t.Errorf("unexpected ok: %v", ok)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("credentials mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
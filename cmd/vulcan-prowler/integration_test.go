@@ -0,0 +1,63 @@
+//go:build integration
+
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/google/go-cmp/cmp"
+)
+
+// localStackAccountID is the account ID used by LocalStack by default.
+const localStackAccountID = "000000000000"
+
+// TestLocalStackAccountFlow runs the verification of the credentials and the
+// retrieval of the account alias against LocalStack. It requires the env var
+// AWS_ENDPOINT_URL to point to LocalStack, e.g.:
+//
+//	AWS_ENDPOINT_URL=http://localhost:4566 go test -tags integration -run LocalStack .
+func TestLocalStackAccountFlow(t *testing.T) {
+	if os.Getenv(envEndpointURL) == "" {
+		t.Skipf("%s env var is not defined", envEndpointURL)
+	}
+	ctx := context.Background()
+	opts, err := buildOptions(`{}`, "aws")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	creds := awsCredentials{AccessKeyID: "test", SecretAccessKey: "test"}
+	region := apiRegion("aws", opts.Region)
+
+	verifiedID, err := verifyCredentials(ctx, creds, opts.EndpointOverrides, region, localStackAccountID)
+	if err != nil {
+		t.Fatalf("can not verify credentials: %v", err)
+	}
+
+	cfg, err := loadAWSConfig(ctx, creds, region)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	svc := newIAMClient(cfg, opts.EndpointOverrides)
+	if _, err := svc.CreateAccountAlias(ctx, &iam.CreateAccountAliasInput{AccountAlias: aws.String("vulcan-localstack")}); err != nil {
+		t.Fatalf("can not create account alias: %v", err)
+	}
+	defer svc.DeleteAccountAlias(ctx, &iam.DeleteAccountAliasInput{AccountAlias: aws.String("vulcan-localstack")})
+
+	alias, err := accountAlias(ctx, creds, region, opts.EndpointOverrides, localStackAccountID)
+	if err != nil {
+		t.Fatalf("can not retrieve account alias: %v", err)
+	}
+	acc := awsAccount{ID: localStackAccountID, Alias: alias, VerifiedID: verifiedID}
+	want := "Account: vulcan-localstack\nVerified Account ID: 000000000000\n"
+	if diff := cmp.Diff(want, acc.details()); diff != "" {
+		t.Errorf("details mismatch (-want +got):\n%s", diff)
+	}
+}
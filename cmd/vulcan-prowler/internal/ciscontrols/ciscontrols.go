@@ -0,0 +1,136 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+// Package ciscontrols loads and validates the metadata of the controls of
+// the CIS benchmark, like the cis_controls.json file shipped with the
+// vulcan-prowler check.
+package ciscontrols
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+
+	report "github.com/adevinta/vulcan-report"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// Controls is the metadata of the controls of a version of the CIS
+// benchmark.
+type Controls = prowlerparse.MetadataFile
+
+var (
+	// cisIDRegexp matches the identifiers of the CIS controls, e.g.: 1.12.
+	cisIDRegexp = regexp.MustCompile(`^[1-9]\.[1-9][0-9]?$`)
+
+	// extraIDRegexp matches the identifiers of the extra checks of
+	// prowler, e.g.: extra718.
+	extraIDRegexp = regexp.MustCompile(`^extra[0-9]+$`)
+)
+
+// severityLiterals contains the valid values of the SeverityLiteral field
+// of the controls.
+var severityLiterals = map[string]bool{
+	"Info":     true,
+	"Low":      true,
+	"Medium":   true,
+	"High":     true,
+	"Critical": true,
+}
+
+// EntryError is a validation error of one entry of the metadata.
+type EntryError struct {
+	// Control is the key of the entry.
+	Control string
+	Reason  string
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("control %s: %s", e.Control, e.Reason)
+}
+
+// Load decodes and validates the given metadata. If the metadata is
+// invalid, the returned error joins one [*EntryError] per problem found, so
+// all the bad entries are reported at once.
+func Load(data []byte) (Controls, error) {
+	c, err := prowlerparse.LoadMetadata(bytes.NewReader(data))
+	if err != nil {
+		return Controls{}, err
+	}
+	if err := Validate(c); err != nil {
+		return Controls{}, fmt.Errorf("invalid controls metadata: %w", err)
+	}
+	return c, nil
+}
+
+// Validate checks that the metadata is well formed: the controls must be
+// keyed by their identifier, that must be the one of a CIS control or an
+// extra check, and have a severity between 0 and 10 consistent with a known
+// severity literal. The remediation URL and the timestamp pattern, if
+// defined, must be valid.
+func Validate(c Controls) error {
+	if c.BenchmarkVersion == "" {
+		return errors.New("missing benchmark version")
+	}
+	if len(c.Controls) == 0 {
+		return errors.New("no controls defined")
+	}
+	ids := make([]string, 0, len(c.Controls))
+	for id := range c.Controls {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return prowlerparse.CompareControls(ids[i], ids[j]) < 0
+	})
+	var errs []error
+	for _, id := range ids {
+		for _, reason := range validateControl(id, c.Controls[id]) {
+			errs = append(errs, &EntryError{Control: id, Reason: reason})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateControl returns the problems of the metadata of the given
+// control.
+func validateControl(id string, c prowlerparse.Control) []string {
+	var reasons []string
+	if !cisIDRegexp.MatchString(id) && !extraIDRegexp.MatchString(id) {
+		reasons = append(reasons, fmt.Sprintf("invalid id %q", id))
+	}
+	if c.ID != id {
+		reasons = append(reasons, fmt.Sprintf("unexpected id %q", c.ID))
+	}
+	if c.Severity < 0 || c.Severity > report.SeverityThresholdCritical {
+		reasons = append(reasons, fmt.Sprintf("invalid severity %v", c.Severity))
+	}
+	switch {
+	case c.SeverityLiteral == "":
+		reasons = append(reasons, "missing severity literal")
+	case !severityLiterals[c.SeverityLiteral]:
+		reasons = append(reasons, fmt.Sprintf("invalid severity literal %q", c.SeverityLiteral))
+	case c.Severity == 0 && c.SeverityLiteral != "Info":
+		// A severity of 0 is usually a missing severity.
+		reasons = append(reasons, fmt.Sprintf("severity 0 with severity literal %q", c.SeverityLiteral))
+	}
+	if c.Level < 0 || c.Level > 2 {
+		reasons = append(reasons, fmt.Sprintf("invalid level %d", c.Level))
+	}
+	if c.Remediation != "" {
+		u, err := url.Parse(c.Remediation)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			reasons = append(reasons, fmt.Sprintf("invalid remediation URL %q", c.Remediation))
+		}
+	}
+	if c.TimestampPattern != "" {
+		if _, err := regexp.Compile(c.TimestampPattern); err != nil {
+			reasons = append(reasons, fmt.Sprintf("invalid timestamp pattern: %v", err))
+		}
+	}
+	return reasons
+}
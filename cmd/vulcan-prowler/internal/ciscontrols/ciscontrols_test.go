@@ -0,0 +1,145 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package ciscontrols
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadShipped(t *testing.T) {
+	data, err := os.ReadFile("../../cis_controls.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := Load(data)
+	if err != nil {
+		t.Fatalf("invalid shipped metadata: %v", err)
+	}
+	if len(c.Controls) == 0 {
+		t.Error("no shipped controls")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantErr   bool
+		wantEntry []EntryError
+	}{
+		{
+			name:  "Valid",
+			input: `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "remediation": "https://example.com/1.3"}}}`,
+		},
+		{
+			name:  "Extra",
+			input: `{"benchmark_version": "1.2", "controls": {"extra718": {"id": "extra718", "severity": 3.9, "severity_literal": "Low"}}}`,
+		},
+		{
+			name:  "Info",
+			input: `{"benchmark_version": "1.2", "controls": {"1.1": {"id": "1.1", "severity": 0, "severity_literal": "Info"}}}`,
+		},
+		{
+			name:    "InvalidJSON",
+			input:   `{"benchmark_version": "1.2", "controls": [`,
+			wantErr: true,
+		},
+		{
+			name:    "NoControls",
+			input:   `{"benchmark_version": "1.2", "controls": {}}`,
+			wantErr: true,
+		},
+		{
+			name:      "InvalidID",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3.1": {"id": "1.3.1", "severity": 6.9, "severity_literal": "Medium"}}}`,
+			wantEntry: []EntryError{{Control: "1.3.1", Reason: `invalid id "1.3.1"`}},
+		},
+		{
+			name:      "IDMismatch",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.4", "severity": 6.9, "severity_literal": "Medium"}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: `unexpected id "1.4"`}},
+		},
+		{
+			name:      "InvalidSeverity",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 11, "severity_literal": "Medium"}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: "invalid severity 11"}},
+		},
+		{
+			name:      "MissingSeverity",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity_literal": "Medium"}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: `severity 0 with severity literal "Medium"`}},
+		},
+		{
+			name:      "MissingSeverityLiteral",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 6.9}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: "missing severity literal"}},
+		},
+		{
+			name:      "InvalidSeverityLiteral",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Severe"}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: `invalid severity literal "Severe"`}},
+		},
+		{
+			name:      "InvalidRemediation",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "remediation": "javascript:alert(1)"}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: `invalid remediation URL "javascript:alert(1)"`}},
+		},
+		{
+			name:      "InvalidTimestampPattern",
+			input:     `{"benchmark_version": "1.2", "controls": {"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "timestamp_pattern": "("}}}`,
+			wantEntry: []EntryError{{Control: "1.3", Reason: "invalid timestamp pattern: error parsing regexp: missing closing ): `(`"}},
+		},
+		{
+			name: "SeveralEntries",
+			input: `{"benchmark_version": "1.2", "controls": {
+				"1.10": {"id": "1.10", "severity": 6.9},
+				"1.2": {"id": "1.2", "severity": -1, "severity_literal": "Low"},
+				"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium"}
+			}}`,
+			wantEntry: []EntryError{
+				{Control: "1.2", Reason: "invalid severity -1"},
+				{Control: "1.10", Reason: "missing severity literal"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load([]byte(tt.input))
+			wantErr := tt.wantErr || len(tt.wantEntry) > 0
+			if (err != nil) != wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tt.wantEntry) == 0 {
+				return
+			}
+			if !strings.HasPrefix(err.Error(), "invalid controls metadata: ") {
+				t.Errorf("unexpected error message: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantEntry, entryErrors(err)); diff != "" {
+				t.Errorf("unexpected entry errors (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+// entryErrors returns the entry errors joined in the given error.
+func entryErrors(err error) []EntryError {
+	var entries []EntryError
+	switch e := errors.Unwrap(err).(type) {
+	case interface{ Unwrap() []error }:
+		for _, e := range e.Unwrap() {
+			var ee *EntryError
+			if errors.As(e, &ee) {
+				entries = append(entries, *ee)
+			}
+		}
+	}
+	return entries
+}
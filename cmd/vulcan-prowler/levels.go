@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// supersetGroup is the prowler group that executes the controls of all the
+// CIS levels.
+const supersetGroup = "cislevel2"
+
+// levelSummary contains the results of the controls of a CIS level.
+type levelSummary struct {
+	Level        int `json:"level"`
+	Passed       int `json:"passed"`
+	Failed       int `json:"failed"`
+	NotEvaluated int `json:"not_evaluated,omitempty"`
+	// CompliancePct is the percentage of passed entries over the passed,
+	// failed and not evaluated ones. It is null when there are no entries
+	// to compute it from.
+	CompliancePct *float64 `json:"compliance_pct"`
+}
+
+// executedGroups returns the prowler groups executed to report the given
+// groups. The cislevel1 group is replaced by cislevel2, that contains all
+// its controls, so the compliance of both levels is computed from the same
+// execution. The entries of the controls that were not requested are
+// removed afterwards with [scopeEntries].
+func executedGroups(groups []string) []string {
+	var executed []string
+	for _, g := range groups {
+		if g == "cislevel1" {
+			g = supersetGroup
+		}
+		if !slices.Contains(executed, g) {
+			executed = append(executed, g)
+		}
+	}
+	return executed
+}
+
+// entryLevel returns the CIS level of the control of the given entry. It
+// returns 0 if the control is not a CIS control or its level is unknown.
+func entryLevel(e entry, controls map[string]CISControl) int {
+	c, ok := prowlerparse.Metadata(controls).LookupFinding(e)
+	if !ok || prowlerparse.IsExtra(c.ID) {
+		return 0
+	}
+	return c.Level
+}
+
+// levelSummaries returns the results of the CIS level 1 and level 2
+// controls of the given entries. The level 2 results include the level 1
+// controls, as the level 2 profile extends the level 1 one. It returns nil
+// if there is no entry of a CIS control.
+func levelSummaries(entries []entry, controls map[string]CISControl) []levelSummary {
+	var l1, l2 []entry
+	for _, e := range entries {
+		switch entryLevel(e, controls) {
+		case 1:
+			l1 = append(l1, e)
+			l2 = append(l2, e)
+		case 2:
+			l2 = append(l2, e)
+		}
+	}
+	if len(l2) == 0 {
+		return nil
+	}
+	var levels []levelSummary
+	for i, entries := range [][]entry{l1, l2} {
+		s := (&prowlerReport{entries: entries}).summary()
+		levels = append(levels, levelSummary{
+			Level:         i + 1,
+			Passed:        s.Passed,
+			Failed:        s.Failed,
+			NotEvaluated:  s.NotEvaluated,
+			CompliancePct: s.CompliancePct,
+		})
+	}
+	return levels
+}
+
+// scopeEntries returns the given entries without the ones of the CIS
+// controls that are not included in the requested groups, e.g.: the level 2
+// controls when only cislevel1 was requested.
+func scopeEntries(entries []entry, groups []string, controls map[string]CISControl) []entry {
+	var scoped []entry
+	for _, e := range entries {
+		if entryLevel(e, controls) == 2 {
+			c, _ := prowlerparse.Metadata(controls).LookupFinding(e)
+			included := slices.ContainsFunc(groups, func(g string) bool { return groupIncludes(g, c) })
+			if !included {
+				continue
+			}
+		}
+		scoped = append(scoped, e)
+	}
+	return scoped
+}
+
+// levelsDetails returns the lines of the details of a vulnerability with the
+// compliance of each CIS level.
+func levelsDetails(levels []levelSummary) string {
+	var details string
+	for _, l := range levels {
+		pct := "n/a"
+		if l.CompliancePct != nil {
+			pct = fmt.Sprintf("%v%%", *l.CompliancePct)
+		}
+		details += fmt.Sprintf("CIS Level %d Compliance: %s (passed %d, failed %d, not evaluated %d)\n",
+			l.Level, pct, l.Passed, l.Failed, l.NotEvaluated)
+	}
+	return details
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExecutedGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []string
+		want   []string
+	}{
+		{
+			name:   "Level1",
+			groups: []string{"cislevel1"},
+			want:   []string{"cislevel2"},
+		},
+		{
+			name:   "BothLevels",
+			groups: []string{"cislevel1", "cislevel2", "extras"},
+			want:   []string{"cislevel2", "extras"},
+		},
+		{
+			name:   "OtherGroups",
+			groups: []string{"group1", "extras"},
+			want:   []string{"group1", "extras"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, executedGroups(tt.groups)); diff != "" {
+				t.Errorf("unexpected groups (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+// levelEntries contains the entries of a cislevel2 execution with level 1
+// and level 2 controls.
+var levelEntries = []entry{
+	{Control: "[check11] Avoid the use of the root account (Scored)", Status: "PASS", Region: "eu-west-1"},
+	{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+	{Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)", Status: "PASS", Region: "eu-west-1"},
+	{Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)", Status: "FAIL", Region: "eu-west-1"},
+	{Control: "[check27] Ensure CloudTrail logs are encrypted at rest using KMS CMKs (Scored)", Status: "PASS", Region: "eu-west-1"},
+	{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "FAIL", Region: "eu-west-1"},
+}
+
+// levelControls contains the metadata of the controls in levelEntries.
+var levelControls = map[string]CISControl{
+	"1.1": {ID: "1.1", Severity: 6.9, SeverityLiteral: "Medium", Level: 1},
+	"1.3": {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium", Level: 1},
+	"1.4": {ID: "1.4", Severity: 6.9, SeverityLiteral: "Medium", Level: 1},
+	"2.6": {ID: "2.6", Severity: 3.9, SeverityLiteral: "Low", Level: 1},
+	"2.7": {ID: "2.7", Severity: 3.9, SeverityLiteral: "Low", Level: 2},
+}
+
+func TestLevelSummaries(t *testing.T) {
+	got := levelSummaries(levelEntries, levelControls)
+	want := []levelSummary{
+		{Level: 1, Passed: 2, Failed: 2, CompliancePct: float64Ptr(50)},
+		{Level: 2, Passed: 3, Failed: 2, CompliancePct: float64Ptr(60)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected levels (-want +got):\n%v", diff)
+	}
+	if got := levelSummaries(levelEntries[5:], levelControls); got != nil {
+		t.Errorf("unexpected levels without CIS controls: %v", got)
+	}
+	wantDetails := "CIS Level 1 Compliance: 50% (passed 2, failed 2, not evaluated 0)\n" +
+		"CIS Level 2 Compliance: 60% (passed 3, failed 2, not evaluated 0)\n"
+	if diff := cmp.Diff(wantDetails, levelsDetails(got)); diff != "" {
+		t.Errorf("unexpected details (-want +got):\n%v", diff)
+	}
+}
+
+func TestScopeEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []string
+		want   []entry
+	}{
+		{
+			name:   "Level1",
+			groups: []string{"cislevel1"},
+			want:   append(append([]entry{}, levelEntries[:4]...), levelEntries[5]),
+		},
+		{
+			name:   "Level2",
+			groups: []string{"cislevel2"},
+			want:   levelEntries,
+		},
+		{
+			name:   "Level1AndSection",
+			groups: []string{"cislevel1", "group2"},
+			want:   levelEntries,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scopeEntries(levelEntries, tt.groups, levelControls)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// The local development mode allows to run the check against an AWS account
+// without a vulcan-assume-role service. It is enabled by setting the
+// VULCAN_PROWLER_LOCAL env var to "1", typically in the RequiredVars section
+// of the local.toml file used by the SDK local mode. In this mode:
+//
+//   - VULCAN_ASSUME_ROLE_ENDPOINT and ROLE_NAME are not required.
+//   - The credentials are obtained from the default credential chain of the
+//     AWS SDK (env vars, shared config profile, SSO, ...), so AWS_PROFILE can
+//     be used to select the account, and they are refreshed from the same
+//     chain when they are about to expire.
+//   - The reachability check of the asset is skipped.
+//
+// The rest of the check (credentials verification, role chaining, controls
+// loading, prowler run and report) works as in production.
+//
+// The images of the check define VULCAN_PROWLER_LOCAL_DISABLED, so the mode
+// can not be enabled in production by accident: the check fails if both env
+// vars are set.
+const (
+	envLocalMode         = `VULCAN_PROWLER_LOCAL`
+	envLocalModeDisabled = `VULCAN_PROWLER_LOCAL_DISABLED`
+)
+
+// localMode returns true if the local development mode is enabled. It returns
+// an error if the mode is enabled in an environment that forbids it.
+func localMode() (bool, error) {
+	if os.Getenv(envLocalMode) != "1" {
+		return false, nil
+	}
+	if os.Getenv(envLocalModeDisabled) != "" {
+		return false, fmt.Errorf("%s can not be enabled when %s is set", envLocalMode, envLocalModeDisabled)
+	}
+	return true, nil
+}
+
+// defaultChainCredentials returns the credentials provided by the default
+// credential chain of the AWS SDK.
+func defaultChainCredentials(ctx context.Context, region string) (awsCredentials, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("can not load AWS config: %w", err)
+	}
+	if cfg.Credentials == nil {
+		return awsCredentials{}, fmt.Errorf("no credentials found in the default AWS credential chain")
+	}
+	c, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("can not retrieve credentials from the default AWS credential chain: %w", err)
+	}
+	return awsCredentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+	}, nil
+}
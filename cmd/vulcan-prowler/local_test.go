@@ -0,0 +1,59 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLocalMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    string
+		disabled string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "Unset"},
+		{name: "Enabled", local: "1", want: true},
+		{name: "OtherValue", local: "true"},
+		{name: "DisabledInImage", local: "1", disabled: "1", wantErr: true},
+		{name: "DisabledNotRequested", disabled: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envLocalMode, tt.local)
+			t.Setenv(envLocalModeDisabled, tt.disabled)
+			got, err := localMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultChainCredentials(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv(envKeyID, "AKIDLOCAL")
+	t.Setenv(envKeySecret, "secret")
+	t.Setenv(envToken, "token")
+
+	got, err := defaultChainCredentials(context.Background(), "eu-west-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := awsCredentials{AccessKeyID: "AKIDLOCAL", SecretAccessKey: "secret", SessionToken: "token"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("credentials mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// envCheckID is the env var that contains the ID of the check, set by the
+// agent that runs it.
+const envCheckID = `VULCAN_CHECK_ID`
+
+// loggerKey is the key of the logger stored in a context.
+type loggerKey struct{}
+
+// withLogger returns a copy of the context that carries the given logger.
+func withLogger(ctx context.Context, l *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// ctxLogger returns the logger carried by the context or, if it does not
+// carry any, the logger of the check. The helpers executed during a scan use
+// it so their logs include the fields that identify the scan.
+func ctxLogger(ctx context.Context) *logrus.Entry {
+	if l, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return l
+	}
+	return logger
+}
+
+// scanFields returns the fields that identify the scan of the given groups
+// in an account. The fields must never contain sensitive values like
+// credentials or external IDs.
+func scanFields(accountID string, opts options, groups []string) logrus.Fields {
+	region := regionLabel(opts.Region)
+	if len(opts.Regions) > 0 {
+		region = strings.Join(opts.Regions, ",")
+	}
+	return logrus.Fields{
+		"check_id":   os.Getenv(envCheckID),
+		"account_id": accountID,
+		"region":     region,
+		"groups":     strings.Join(groups, ","),
+	}
+}
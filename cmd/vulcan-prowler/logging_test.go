@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestScanFields(t *testing.T) {
+	t.Setenv(envCheckID, "check-1")
+	tests := []struct {
+		name   string
+		opts   options
+		groups []string
+		want   logrus.Fields
+	}{
+		{
+			name:   "AllRegions",
+			groups: []string{"cislevel1", "extras"},
+			want: logrus.Fields{
+				"check_id":   "check-1",
+				"account_id": "123456789012",
+				"region":     "all regions",
+				"groups":     "cislevel1,extras",
+			},
+		},
+		{
+			name:   "Region",
+			opts:   options{Region: "eu-west-1"},
+			groups: []string{"cislevel2"},
+			want: logrus.Fields{
+				"check_id":   "check-1",
+				"account_id": "123456789012",
+				"region":     "eu-west-1",
+				"groups":     "cislevel2",
+			},
+		},
+		{
+			name:   "Regions",
+			opts:   options{Regions: []string{"eu-west-1", "us-east-1"}},
+			groups: []string{"cislevel2"},
+			want: logrus.Fields{
+				"check_id":   "check-1",
+				"account_id": "123456789012",
+				"region":     "eu-west-1,us-east-1",
+				"groups":     "cislevel2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scanFields("123456789012", tt.opts, tt.groups)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected fields (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestCtxLogger(t *testing.T) {
+	if got := ctxLogger(context.Background()); got != logger {
+		t.Errorf("got %v, want the logger of the check", got)
+	}
+	l := logger.WithField("account_id", "123456789012")
+	if got := ctxLogger(withLogger(context.Background(), l)); got != l {
+		t.Errorf("got %v, want %v", got, l)
+	}
+}
+
+func TestHandlerRunLogFields(t *testing.T) {
+	t.Setenv(envCheckID, "check-1")
+	hook := logtest.NewLocal(logger.Logger)
+	t.Cleanup(func() { logger.Logger.ReplaceHooks(make(logrus.LevelHooks)) })
+
+	h := newTestHandler(&fakeProwlerRunner{entries: handlerEntries})
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	opts := `{"region":"eu-west-1","disable_management_account_check":true,"external_id":"secret-external-id"}`
+	if err := h.run(context.Background(), "123456789012", "AWSAccount", opts, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := hook.AllEntries()
+	if len(entries) == 0 {
+		t.Fatal("no log entries")
+	}
+	want := map[string]string{
+		"check_id":   "check-1",
+		"account_id": "123456789012",
+		"region":     "eu-west-1",
+		"groups":     "cislevel2",
+	}
+	var withAlias int
+	for _, e := range entries {
+		for k, v := range want {
+			if e.Data[k] != v {
+				t.Errorf("entry %q: field %s is %v, want %v", e.Message, k, e.Data[k], v)
+			}
+		}
+		if e.Data["account_alias"] == "alias" {
+			withAlias++
+		}
+		for k, v := range e.Data {
+			if s := fmt.Sprint(v); s == "secret-external-id" || s == "secret" {
+				t.Errorf("entry %q: field %s contains a sensitive value", e.Message, k)
+			}
+		}
+	}
+	if withAlias == 0 {
+		t.Error("no log entry contains the account alias")
+	}
+}
@@ -5,27 +5,32 @@ Copyright 2020 Adevinta
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"html"
 	"net/http"
 	"os"
+	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	// The AWS SDK v2 does not expose the partitions and regions metadata
+	// used by validateRegion.
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 
 	check "github.com/adevinta/vulcan-check-sdk"
 	"github.com/adevinta/vulcan-check-sdk/helpers"
 	checkstate "github.com/adevinta/vulcan-check-sdk/state"
 	report "github.com/adevinta/vulcan-report"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+	"github.com/adevinta/vulcan-checks/internal/awsauth"
 )
 
 const (
@@ -33,13 +38,24 @@ const (
 	// services API endpoints.
 	defaultAPIRegion       = `eu-west-1`
 	defaultSessionDuration = 3600 // 1 hour.
+	// minSessionDuration and maxSessionDuration are the limits of the
+	// duration of the sessions allowed by STS.
+	minSessionDuration = 900   // 15 minutes.
+	maxSessionDuration = 43200 // 12 hours.
 
 	envEndpoint = `VULCAN_ASSUME_ROLE_ENDPOINT`
 	envRole     = `ROLE_NAME`
+	// envExternalID is the env var that defines the external ID sent to the
+	// assume role endpoint when the external_id option is not set.
+	envExternalID = `VULCAN_ASSUME_ROLE_EXTERNAL_ID`
 
 	envKeyID     = `AWS_ACCESS_KEY_ID`
 	envKeySecret = `AWS_SECRET_ACCESS_KEY`
 	envToken     = `AWS_SESSION_TOKEN`
+
+	// maxPassedRows is the maximum number of rows of the passed controls
+	// table.
+	maxPassedRows = 500
 )
 
 var (
@@ -50,250 +66,682 @@ var (
 		"cislevel2",
 	}
 
-	// CISCompliance is the vulnerability generated by the check when it does
-	// not receive any security level and the account has failed controls.
-	CISCompliance = report.Vulnerability{
-		Summary: "Compliance With CIS AWS Foundations Benchmark (BETA)",
-		Description: `<p>
-			The check did not receive the security classification
-			of the AWS account so the benchmark has been executed against the
-			CIS Level 2.
-			The CIS AWS Foundations Benchmark provides prescriptive
-			guidance for configuring security options for a subset of Amazon Web
-			Services with an emphasis on foundational, testable, and architecture
-			agnostic settings. The services included in the scope are: IAM, Confing,
-			CloudTrail, CloudWatch, SNS, S3 and VPC (Default).
-		</p>
-		<p>
-			Recommendations are provided in order to comply with all the controls required
-			by the CIS Level 2.
-		</p>
-		<p>
-			Check the Details and Resources sections to know the compliance status
-			and more details.
-		</p>`,
-		Labels: []string{"compliance", "cis", "aws"},
-		References: []string{
-			"https://d0.awsstatic.com/whitepapers/compliance/AWS_CIS_Foundations_Benchmark.pdf",
-			"https://github.com/toniblyx/prowler",
-			"https://www.cisecurity.org/benchmark/amazon_web_services/",
-		},
-		Fingerprint: helpers.ComputeFingerprint(),
-		Score:       report.SeverityThresholdMedium,
-	}
-
-	// CISLevel1Compliance is the vulnerability generated by the check when it
-	// receives a security level of 1 or less and the account has failed
-	// controls.
-	CISLevel1Compliance = report.Vulnerability{
-		Summary: "Compliance With CIS Level 1 AWS Foundations Benchmark (BETA)",
-		Description: `<p>
-			This account has been checked for compliance with the CIS Level 1 according
-			to its security classification. You can check the security classification of the account in
-			the details section.
-			</p>
-			<p>
-		    The CIS AWS Foundations Benchmark provides prescriptive
-			guidance for configuring security options for a subset of Amazon Web
-			Services with an emphasis on foundational, testable, and architecture
-			agnostic settings. The services included in the scope are: IAM, Confing,
-			CloudTrail, CloudWatch, SNS, S3 and VPC (Default).
-		</p>
-		<p>
-			Recommendations are provided in order to comply with all the controls required
-			by the CIS Level 1.
-		</p>
-		<p>
-			Check the Details and Resources sections to know the compliance status
-			and more details.
-		</p>`,
-		Labels: []string{"compliance", "cis", "aws"},
-		References: []string{
-			"https://d0.awsstatic.com/whitepapers/compliance/AWS_CIS_Foundations_Benchmark.pdf",
-			"https://github.com/toniblyx/prowler",
-			"https://www.cisecurity.org/benchmark/amazon_web_services/",
-		},
-		Fingerprint: helpers.ComputeFingerprint(),
-		Score:       report.SeverityThresholdMedium,
-	}
-
-	// CISLevel2Compliance is the vulnerability generated by the check when it
-	// receives a security level of 2 or more and the account has failed
-	// controls.
-	CISLevel2Compliance = report.Vulnerability{
-		Summary: "Compliance With CIS Level 2 AWS Foundations Benchmark (BETA)",
-		Description: `<p>
-			This account has been checked for compliance with the CIS Level 2 according
-			to its security classification. You can check the security classification
-			of the account in the details section.
-			</p>
-			<p>
-		    The CIS AWS Foundations Benchmark provides prescriptive
-			guidance for configuring security options for a subset of Amazon Web
-			Services with an emphasis on foundational, testable, and architecture
-			agnostic settings. The services included in the scope are: IAM, Confing,
-			CloudTrail, CloudWatch, SNS, S3 and VPC (Default).
-		</p>
-		<p>
-			Recommendations are provided in order to comply with all the controls required
-			by the CIS Level 2.
-		</p>
-		<p>
-			Check the Details and Resources sections to know the compliance status
-			and more details.
-		</p>`,
-		Labels: []string{"compliance", "cis", "aws"},
-		References: []string{
-			"https://d0.awsstatic.com/whitepapers/compliance/AWS_CIS_Foundations_Benchmark.pdf",
-			"https://github.com/toniblyx/prowler",
-			"https://www.cisecurity.org/benchmark/amazon_web_services/",
-		},
-		Fingerprint: helpers.ComputeFingerprint(),
-		Score:       report.SeverityThresholdMedium,
-	}
-
-	// CISComplianceInfo is a vulnerability that is always generated by the
-	// check. It contains the not scored and informational controls related to
-	// the account.
-	CISComplianceInfo = report.Vulnerability{
-		Summary: "Information About CIS AWS Foundations Benchmark (BETA)",
-		Description: `<p>
-			     Information gathered by executing the CIS benchmark on the account.
-		</p>
-			`,
-		Labels: []string{"compliance", "cis", "aws"},
-		References: []string{
-			"https://d0.awsstatic.com/whitepapers/compliance/AWS_CIS_Foundations_Benchmark.pdf",
-			"https://github.com/toniblyx/prowler",
-			"https://www.cisecurity.org/benchmark/amazon_web_services/",
-		},
-		Fingerprint: helpers.ComputeFingerprint(),
-		Score:       report.SeverityThresholdNone,
-	}
+	// managementAccountLabel is added to the vulnerabilities generated when
+	// the account is the management account of an organization.
+	managementAccountLabel = "aws-management-account"
 )
 
 // CISControl holds the info related to AWS CIS control.
-type CISControl struct {
-	ID              string  `json:"id"`
-	Severity        float32 `json:"severity"`
-	SeverityLiteral string  `json:"severity_literal"`
-	Remediation     string  `json:"remediation"`
-}
+type CISControl = prowlerparse.Control
 
 type options struct {
 	Region          string   `json:"region"`
 	Groups          []string `json:"groups"`
 	SessionDuration int      `json:"session_duration"` // In secs.
 	SecurityLevel   *byte    `json:"security_level"`
+	// MinSeverity filters out of the failed controls the ones with a CIS
+	// severity lower than its value.
+	MinSeverity severity `json:"min_severity"`
+	// ThresholdDays overrides, per control ID, the number of days used to
+	// decide whether the timestamps embedded in the prowler messages
+	// correspond to a failed control.
+	ThresholdDays map[string]int `json:"threshold_days"`
+	// PerControlFindings makes the check generate one vulnerability per
+	// failed control instead of the aggregated compliance vulnerability.
+	PerControlFindings bool `json:"per_control_findings"`
+	// DisableManagementAccountCheck disables the call to the Organizations
+	// API used to detect whether the account is the management account of
+	// an organization.
+	DisableManagementAccountCheck bool `json:"disable_management_account_check"`
+	// Timeout is the maximum number of seconds prowler is allowed to run. When
+	// it is exceeded prowler is stopped and the results obtained until then
+	// are reported. As prowler uses the credentials obtained for the scan,
+	// the timeout should not be greater than the session duration, otherwise
+	// the credentials could expire before prowler finishes.
+	Timeout int `json:"timeout"`
+	// EndpointOverrides contains custom endpoint URLs keyed by AWS service
+	// identifier, e.g.: {"sts": "https://vpce-1234.sts.eu-west-1.vpce.amazonaws.com"}.
+	// They are used by the AWS clients of the check and by prowler.
+	EndpointOverrides map[string]string `json:"endpoint_overrides"`
+	// IncludePassed makes the check include the passed controls in the
+	// resources of the info vulnerability.
+	IncludePassed bool `json:"include_passed"`
+	// BenchmarkVersion is the version of the CIS benchmark to check. It must
+	// match the version of the controls metadata.
+	BenchmarkVersion string `json:"benchmark_version"`
+	// MetadataMismatch defines the behavior of the check when the version of
+	// the controls metadata does not match the benchmark version: "strict",
+	// the default, makes the check fail and "lenient" reports the mismatch
+	// and goes on with the scan.
+	MetadataMismatch string `json:"metadata_mismatch"`
+	// ExtraArgs contains additional flags passed to prowler. Only a set of
+	// flags that do not change the output of prowler are allowed.
+	ExtraArgs []string `json:"extra_args"`
+	// EnrichS3 makes the check retrieve the current public access and
+	// encryption state of the buckets referenced by the failed S3 controls.
+	EnrichS3 bool `json:"enrich_s3"`
+	// EnrichS3MaxBuckets is the maximum number of buckets whose state is
+	// retrieved when EnrichS3 is enabled.
+	EnrichS3MaxBuckets int `json:"enrich_s3_max_buckets"`
+	// APIRateLimit is the maximum number of AWS API requests per second
+	// made by the check to enrich the results of prowler.
+	APIRateLimit int `json:"api_rate_limit"`
+	// Score overrides the score of the compliance vulnerability set by the
+	// templates. It must be between 0 and 10.
+	Score *float32 `json:"score"`
+	// FixedScore makes the compliance vulnerability keep the score set by
+	// the templates instead of taking the CIS severity of the most severe
+	// failed control.
+	FixedScore bool `json:"fixed_score"`
+	// ScoreFloor and ScoreCeiling limit the score of the compliance
+	// vulnerability when it is derived from the severity of the failed
+	// controls.
+	ScoreFloor   *float32 `json:"score_floor"`
+	ScoreCeiling *float32 `json:"score_ceiling"`
+	// ControlsURL is the URL of the controls metadata. When it is not set,
+	// the value of the VULCAN_PROWLER_CONTROLS_URL env var is used and, if
+	// the env var is not set either, or the metadata can not be fetched,
+	// the metadata bundled with the check is used.
+	ControlsURL string `json:"controls_url"`
+	// ControlsSHA256 is the expected SHA-256 digest, hex encoded, of the
+	// controls metadata fetched from ControlsURL.
+	ControlsSHA256 string `json:"controls_sha256"`
+	// Regions makes the check run prowler once per region instead of once
+	// for all the regions. It can not be used together with Region.
+	Regions []string `json:"regions"`
+	// MaxParallelRegions is the maximum number of executions of prowler, one
+	// per region and group, run concurrently.
+	MaxParallelRegions int `json:"max_parallel_regions"`
+	// Labels are added to the labels of all the vulnerabilities generated
+	// by the check, e.g.: ["team:payments", "env:prod"].
+	Labels []string `json:"labels"`
+	// AssumeRoleTimeout is the maximum number of seconds a request to the
+	// assume role endpoint is allowed to take.
+	AssumeRoleTimeout int `json:"assume_role_timeout"`
+	// UseEnvCredentials makes the check use the AWS credentials defined in
+	// the environment instead of requesting them to the assume role
+	// endpoint. The credentials in the environment are also used when this
+	// option is not set but they are defined.
+	UseEnvCredentials bool `json:"use_env_credentials"`
+	// ExternalID is the external ID required by the trust policy of the
+	// role assumed to scan the account. The check never logs it, but the
+	// SDK logs the options of the check, so the env var
+	// VULCAN_ASSUME_ROLE_EXTERNAL_ID is the preferred way of setting it.
+	ExternalID string `json:"external_id"`
+	// ChainRoleARN is the ARN of a role assumed, using the credentials
+	// returned by the assume role endpoint, to scan the account.
+	ChainRoleARN string `json:"chain_role_arn"`
+	// ChainSessionName is the name of the session of the role in
+	// ChainRoleARN. It defaults to "vulcan-prowler".
+	ChainSessionName string `json:"chain_session_name"`
+	// ChainSessionDuration is the duration, in seconds, of the session of
+	// the role in ChainRoleARN. STS allows, at most, 1 hour for the sessions
+	// of chained roles, that is also the default.
+	ChainSessionDuration int `json:"chain_session_duration"`
+	// ThrottlingRetries is the number of times the execution of prowler in
+	// a region and group is retried when it fails because AWS throttled its
+	// requests. It defaults to 3.
+	ThrottlingRetries *int `json:"throttling_retries"`
+	// FailOnNotEvaluated makes the check fail when prowler could not
+	// evaluate some control because the role used lacks some permission.
+	FailOnNotEvaluated bool `json:"fail_on_not_evaluated"`
+	// PerCheckExecution makes the check execute prowler once per control
+	// of the selected groups, so a control that can not be executed does
+	// not make the check lose the results of the others.
+	PerCheckExecution bool `json:"per_check_execution"`
+	// MaxNotExecutedRatio is the maximum ratio, between 0 and 1, of the
+	// controls that can fail to execute when PerCheckExecution is set
+	// without failing the check. It defaults to 0.2.
+	MaxNotExecutedRatio *float64 `json:"max_not_executed_ratio"`
+	// CollapseRegions makes the failed controls table show in one row the
+	// same failed control in several regions when the messages only differ
+	// in the region.
+	CollapseRegions bool `json:"collapse_regions"`
+	// AllowEmptyReport makes the check only log a warning, instead of
+	// failing, when prowler does not report any entry for a group that is
+	// known to contain scored controls. It is intended for custom groups
+	// that reuse the names of the CIS groups.
+	AllowEmptyReport bool `json:"allow_empty_report"`
+	// StrictMetadata makes the check fail when some failed control has no
+	// metadata. Otherwise the control is reported with an unknown severity,
+	// scored as medium, and listed in the notes of the check.
+	StrictMetadata bool `json:"strict_metadata"`
+	// GroupBySection makes the compliance vulnerability report the failed
+	// controls in one table per section of the CIS benchmark instead of in
+	// a single table.
+	GroupBySection bool `json:"group_by_section"`
+}
+
+// UnmarshalJSON decodes the options accepting the security level either as a
+// number, a numeric string or one of the literals "level1" and "level2".
+func (o *options) UnmarshalJSON(data []byte) error {
+	type optionsAlias options
+	aux := struct {
+		*optionsAlias
+		SecurityLevel json.RawMessage `json:"security_level"`
+	}{
+		optionsAlias: (*optionsAlias)(o),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	level, err := parseSecurityLevel(aux.SecurityLevel)
+	if err != nil {
+		return err
+	}
+	o.SecurityLevel = level
+	return nil
 }
 
-func buildOptions(optJSON string) (options, error) {
+// parseSecurityLevel parses the raw JSON value of the security_level option.
+func parseSecurityLevel(raw json.RawMessage) (*byte, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	invalid := fmt.Errorf("invalid security_level %s: must be 0, 1, 2, \"level1\" or \"level2\"", raw)
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		s = strings.ToLower(strings.TrimSpace(s))
+		s = strings.TrimPrefix(s, "level")
+		raw = json.RawMessage(s)
+	}
+	var n float64
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return nil, invalid
+	}
+	if n != float64(int(n)) || n < 0 || n > 2 {
+		return nil, invalid
+	}
+	level := byte(n)
+	return &level, nil
+}
+
+// severityLiterals maps the CIS severity literals used in cis_controls.json
+// to their scores.
+var severityLiterals = map[string]float32{
+	"low":      report.SeverityThresholdLow,
+	"medium":   report.SeverityThresholdMedium,
+	"high":     report.SeverityThresholdHigh,
+	"critical": report.SeverityThresholdCritical,
+}
+
+// severity is a score that can be specified in the options either as a
+// number or as one of the CIS severity literals, e.g.: "High".
+type severity float32
+
+func (s *severity) UnmarshalJSON(data []byte) error {
+	var score float32
+	if err := json.Unmarshal(data, &score); err == nil {
+		if score < 0 || score > 10 {
+			return fmt.Errorf("invalid severity %v: must be between 0 and 10", score)
+		}
+		*s = severity(score)
+		return nil
+	}
+	var literal string
+	if err := json.Unmarshal(data, &literal); err != nil {
+		return fmt.Errorf("invalid severity %s: must be a number or a severity literal", data)
+	}
+	score, ok := severityLiterals[strings.ToLower(literal)]
+	if !ok {
+		return fmt.Errorf("invalid severity %q: must be one of Low, Medium, High or Critical", literal)
+	}
+	*s = severity(score)
+	return nil
+}
+
+// buildOptions parses and validates the options of the check. The partition
+// is the AWS partition of the target account, e.g.: aws, aws-cn or
+// aws-us-gov.
+func buildOptions(optJSON string, partition string) (options, error) {
 	var opts options
 	if optJSON != "" {
 		if err := json.Unmarshal([]byte(optJSON), &opts); err != nil {
 			return opts, err
 		}
 	}
+	if opts.Region != "" {
+		if err := validateRegion(opts.Region, partition); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Region != "" && len(opts.Regions) > 0 {
+		return opts, errors.New("region and regions options can not be used together")
+	}
+	for _, region := range opts.Regions {
+		if err := validateRegion(region, partition); err != nil {
+			return opts, err
+		}
+	}
+	if opts.MaxParallelRegions < 0 {
+		return opts, fmt.Errorf("invalid max_parallel_regions %d: must be greater than or equal to 0", opts.MaxParallelRegions)
+	}
+	if opts.MaxParallelRegions == 0 {
+		opts.MaxParallelRegions = 1
+	}
+	if opts.ThrottlingRetries == nil {
+		retries := defaultThrottlingRetries
+		opts.ThrottlingRetries = &retries
+	}
+	if n := *opts.ThrottlingRetries; n < 0 || n > maxThrottlingRetries {
+		return opts, fmt.Errorf("invalid throttling_retries %d: must be between 0 and %d", n, maxThrottlingRetries)
+	}
+	if opts.MaxNotExecutedRatio == nil {
+		ratio := defaultMaxNotExecutedRatio
+		opts.MaxNotExecutedRatio = &ratio
+	}
+	if r := *opts.MaxNotExecutedRatio; r < 0 || r > 1 {
+		return opts, fmt.Errorf("invalid max_not_executed_ratio %v: must be between 0 and 1", r)
+	}
 	if opts.Groups == nil {
 		opts.Groups = defaultGroups
 	}
 	if opts.SessionDuration == 0 {
 		opts.SessionDuration = defaultSessionDuration
 	}
+	if opts.SessionDuration < minSessionDuration || opts.SessionDuration > maxSessionDuration {
+		return opts, fmt.Errorf("invalid session_duration %d: must be between %d and %d seconds", opts.SessionDuration, minSessionDuration, maxSessionDuration)
+	}
+	if err := validateChainOptions(&opts); err != nil {
+		return opts, err
+	}
+	if opts.AssumeRoleTimeout < 0 {
+		return opts, fmt.Errorf("invalid assume_role_timeout %d: must be greater than or equal to 0", opts.AssumeRoleTimeout)
+	}
+	if opts.AssumeRoleTimeout == 0 {
+		opts.AssumeRoleTimeout = defaultAssumeRoleTimeout
+	}
+	if opts.Timeout < 0 {
+		return opts, fmt.Errorf("invalid timeout %d: must be greater than or equal to 0", opts.Timeout)
+	}
+	if err := validateEndpointOverrides(opts.EndpointOverrides); err != nil {
+		return opts, err
+	}
+	envOverrides, err := envEndpointOverrides(os.Environ())
+	if err != nil {
+		return opts, err
+	}
+	opts.EndpointOverrides = mergeEndpointOverrides(envOverrides, opts.EndpointOverrides)
+	if err := validateExtraArgs(opts.ExtraArgs); err != nil {
+		return opts, err
+	}
+	if opts.EnrichS3MaxBuckets < 0 {
+		return opts, fmt.Errorf("invalid enrich_s3_max_buckets %d: must be greater than or equal to 0", opts.EnrichS3MaxBuckets)
+	}
+	if opts.EnrichS3MaxBuckets == 0 {
+		opts.EnrichS3MaxBuckets = defaultEnrichS3MaxBuckets
+	}
+	if opts.APIRateLimit < 0 {
+		return opts, fmt.Errorf("invalid api_rate_limit %d: must be greater than or equal to 0", opts.APIRateLimit)
+	}
+	if opts.APIRateLimit == 0 {
+		opts.APIRateLimit = defaultAPIRateLimit
+	}
+	for _, l := range opts.Labels {
+		if strings.TrimSpace(l) == "" {
+			return opts, errors.New("invalid labels: labels must be non-empty strings")
+		}
+	}
+	if opts.Score != nil && (*opts.Score < 0 || *opts.Score > report.SeverityThresholdCritical) {
+		return opts, fmt.Errorf("invalid score %v: must be between 0 and %v", *opts.Score, report.SeverityThresholdCritical)
+	}
+	for name, score := range map[string]*float32{"score_floor": opts.ScoreFloor, "score_ceiling": opts.ScoreCeiling} {
+		if score != nil && (*score < 0 || *score > report.SeverityThresholdCritical) {
+			return opts, fmt.Errorf("invalid %s %v: must be between 0 and %v", name, *score, report.SeverityThresholdCritical)
+		}
+	}
+	if opts.ScoreFloor != nil && opts.ScoreCeiling != nil && *opts.ScoreFloor > *opts.ScoreCeiling {
+		return opts, fmt.Errorf("invalid score_floor %v: must be lower than or equal to score_ceiling %v", *opts.ScoreFloor, *opts.ScoreCeiling)
+	}
+	if opts.ControlsURL == "" {
+		opts.ControlsURL = os.Getenv(envControlsURL)
+	}
+	if opts.ExternalID == "" {
+		opts.ExternalID = os.Getenv(envExternalID)
+	}
+	if err := validateControlsSource(opts.ControlsURL, opts.ControlsSHA256); err != nil {
+		return opts, err
+	}
+	if opts.BenchmarkVersion == "" {
+		opts.BenchmarkVersion = defaultBenchmarkVersion
+	}
+	if err := validateBenchmarkVersion(opts.BenchmarkVersion); err != nil {
+		return opts, err
+	}
+	switch opts.MetadataMismatch {
+	case "":
+		opts.MetadataMismatch = metadataMismatchStrict
+	case metadataMismatchStrict, metadataMismatchLenient:
+	default:
+		return opts, fmt.Errorf("invalid metadata_mismatch %q: must be %q or %q", opts.MetadataMismatch, metadataMismatchStrict, metadataMismatchLenient)
+	}
 
 	return opts, nil
 }
 
-func main() {
-	run := func(ctx context.Context, target, assetType, optJSON string, state checkstate.State) error {
-		if target == "" {
-			return errors.New("check target missing")
+// validateRegion returns an error if the region does not belong to the
+// given partition according to the endpoints metadata of the AWS SDK.
+func validateRegion(region, partition string) error {
+	if partition == "" {
+		partition = endpoints.AwsPartitionID
+	}
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() != partition {
+			continue
 		}
-		parsedARN, err := arn.Parse(target)
-		if err != nil {
-			return err
+		if _, ok := p.Regions()[region]; !ok {
+			return fmt.Errorf("invalid region %q for partition %q", region, partition)
 		}
+		return nil
+	}
+	return fmt.Errorf("unknown partition %q", partition)
+}
 
-		opts, err := buildOptions(optJSON)
-		if err != nil {
-			return err
-		}
+func main() {
+	h := newHandler()
 
-		endpoint := os.Getenv(envEndpoint)
-		if endpoint == "" {
-			return fmt.Errorf("%s env var must have a non-empty value", envEndpoint)
-		}
-		role := os.Getenv(envRole)
+	// The batch mode is handled before creating the check because the SDK
+	// exits when it finds flags it does not know.
+	bcfg, ok, err := parseBatchFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if ok {
+		os.Exit(runBatch(context.Background(), bcfg, h.run, os.Stdin, os.Stdout))
+	}
 
-		logger.Infof("using endpoint '%s' and role '%s'", endpoint, role)
+	c := check.NewCheckFromHandler(checkName, h.run)
+	c.RunAndServe()
+}
 
-		isReachable, err := helpers.IsReachable(target, assetType,
-			helpers.NewAWSCreds(endpoint, role))
-		if err != nil {
-			logger.Warnf("Can not check asset reachability: %v", err)
-		}
-		if !isReachable {
-			return checkstate.ErrAssetUnreachable
-		}
+// run is the handler of the check.
+func (h *handler) run(ctx context.Context, target, assetType, optJSON string, state checkstate.State) error {
+	if target == "" {
+		return errors.New("check target missing")
+	}
+	if err := validateAssetType(assetType); err != nil {
+		return err
+	}
+	tgt, err := parseTarget(target)
+	if err != nil {
+		return err
+	}
 
-		if err := loadCredentials(endpoint, parsedARN.AccountID, role, opts.SessionDuration); err != nil {
-			return fmt.Errorf("can not get credentials for the role '%s' from the endpoint '%s': %w", endpoint, role, err)
-		}
+	opts, err := buildOptions(optJSON, tgt.Partition)
+	if err != nil {
+		return err
+	}
 
-		alias, err := accountAlias(credentials.NewEnvCredentials())
-		if err != nil {
-			return fmt.Errorf("can not retrieve account alias: %w", err)
-		}
+	groups, err := groupsFromOpts(opts)
+	if err != nil {
+		return err
+	}
+	// The logs emitted during the scan, including the ones of the helpers
+	// that receive the context, contain the fields that identify it.
+	ctx = withLogger(ctx, logger.WithFields(scanFields(tgt.AccountID, opts, groups)))
+	if opts.Timeout > opts.SessionDuration {
+		ctxLogger(ctx).Warnf("session_duration %d is shorter than the timeout %d: the credentials could expire before prowler finishes", opts.SessionDuration, opts.Timeout)
+	}
+
+	// region is used to query the AWS API from the check.
+	region := apiRegion(tgt.Partition, opts.Region)
+
+	sc, err := h.creds.Credentials(ctx, tgt, assetType, opts, region)
+	if err != nil {
+		return err
+	}
+	creds, credSrc := sc.Creds, sc.Source
+
+	alias, err := h.aliases.Alias(ctx, creds, region, opts.EndpointOverrides, tgt.AccountID)
+	if err != nil {
+		return fmt.Errorf("can not retrieve the alias of the account %s using the IAM endpoint %s: %w", tgt.AccountID, endpointName(opts.EndpointOverrides, "iam", region), err)
+	}
 
-		logger.Infof("account alias: '%s'", alias)
-		groups, err := groupsFromOpts(opts)
+	ctx = withLogger(ctx, ctxLogger(ctx).WithField("account_alias", alias))
+	log := ctxLogger(ctx)
+	log.Infof("account alias: '%s'", alias)
+	acc := awsAccount{ID: tgt.AccountID, Alias: alias, ARN: tgt.ARN, VerifiedID: sc.VerifiedID}
+	if !opts.DisableManagementAccountCheck {
+		cfg, err := loadAWSConfig(ctx, creds, region)
 		if err != nil {
 			return err
 		}
-		// Load AWS CIS controls information.
-		content, err := os.ReadFile("cis_controls.json")
+		acc.Management, err = isManagementAccount(ctx, newOrganizationsClient(cfg, opts.EndpointOverrides), tgt.AccountID)
 		if err != nil {
+			log.Warnf("can not check if the account is an organization management account: %v", err)
+		}
+		if acc.Management {
+			log.Infof("account %s is an organization management account", tgt.AccountID)
+		}
+	}
+	// Load AWS CIS controls information.
+	mf, note, err := loadControls(ctx, &http.Client{}, opts.ControlsURL, opts.ControlsSHA256, bundledControlsFile)
+	if err != nil {
+		return err
+	}
+	state.Notes = note
+	mismatch, err := checkMetadataVersion(opts.BenchmarkVersion, mf.BenchmarkVersion, opts.MetadataMismatch)
+	if err != nil {
+		return err
+	}
+	if mismatch {
+		log.Warnf("metadata version mismatch: benchmark version %s, controls metadata version %s", opts.BenchmarkVersion, mf.BenchmarkVersion)
+	}
+	controls := mf.Controls
+	if err := validateThresholds(opts.ThresholdDays, controls); err != nil {
+		return err
+	}
+	prowlerCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		prowlerCtx, cancel = context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+		defer cancel()
+	}
+	env, cleanup, err := writeAWSCLIConfig(opts.EndpointOverrides)
+	if err != nil {
+		return fmt.Errorf("can not write the AWS CLI config file: %w", err)
+	}
+	defer cleanup()
+	skipped := resolveScope(groups, opts.ExtraArgs, controls)
+	runGroups := executedGroups(groups)
+	progress := newProgressTracker(len(scopeControls(runGroups, opts.ExtraArgs, controls)), len(opts.Regions), state.SetProgress)
+	pcfg := prowlerConfig{
+		Groups:           runGroups,
+		ExtraArgs:        opts.ExtraArgs,
+		Env:              env,
+		Progress:         progress,
+		AllowEmptyReport: opts.AllowEmptyReport,
+	}
+	regions := opts.Regions
+	if len(regions) == 0 {
+		regions = []string{opts.Region}
+	}
+	units := scanUnits(regions, runGroups)
+	if opts.PerCheckExecution {
+		checks := scopeControls(runGroups, opts.ExtraArgs, controls)
+		if len(checks) == 0 {
+			return errors.New("the selected groups do not contain any control to execute individually")
+		}
+		units = checkUnits(regions, checks)
+	}
+	// The version of prowler is recorded to tell apart the changes in the
+	// findings caused by the account from the ones caused by prowler.
+	// The version is detected once for all the executions of prowler. It
+	// is needed to build the parameters of prowler v3, so the scan goes on
+	// assuming the version in the image when it can not be determined.
+	version, major, err := h.prowler.Version(ctx)
+	if err != nil {
+		log.Warnf("can not determine the prowler version, assuming v%d: %v", defaultProwlerMajorVersion, err)
+		version, major = unknownProwlerVersion, defaultProwlerMajorVersion
+	}
+	log.Infof("prowler version: %s", version)
+	pcfg.Version, pcfg.MajorVersion = version, major
+	prov := provenance{
+		BenchmarkVersion:         opts.BenchmarkVersion,
+		MetadataBenchmarkVersion: mf.BenchmarkVersion,
+		AssumeRoleVersion:        sc.AssumeRoleVersion,
+		ProwlerVersion:           version,
+		Groups:                   runGroups,
+		SessionDuration:          sessionLifetime(opts),
+	}
+	if !slices.Equal(regions, []string{""}) {
+		prov.Regions = regions
+	}
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		cfg := pcfg
+		cfg.Groups = []string{u.group}
+		if u.check != "" {
+			cfg.Groups = nil
+			cfg.Check = u.check
+		}
+		return withCredentials(ctx, credSrc, cfg, func(ctx context.Context, cfg prowlerConfig) (*prowlerReport, error) {
+			return h.prowler.Run(ctx, u.region, cfg)
+		})
+	}
+	retries := &retryRecorder{}
+	run = retryThrottled(*opts.ThrottlingRetries, retries, run)
+	r, err := runUnits(prowlerCtx, units, opts.MaxParallelRegions, run, progress.finish)
+	if err != nil {
+		return err
+	}
+	if err := emptyUnitsError(r.failedUnits); err != nil {
+		return err
+	}
+	if opts.PerCheckExecution {
+		if err := addNotExecuted(ctx, r, len(units), *opts.MaxNotExecutedRatio); err != nil {
 			return err
 		}
-		controls := map[string]CISControl{}
-		err = json.Unmarshal(content, &controls)
+	}
+	if slices.Contains(runGroups, supersetGroup) {
+		r.levels = levelSummaries(r.entries, controls)
+	}
+	r.entries = scopeEntries(r.entries, groups, controls)
+	r.skipped = skipped
+	r.retries = retries.all()
+	for _, w := range r.warnings {
+		if state.Notes != "" {
+			state.Notes += "\n"
+		}
+		state.Notes += "Prowler failed after reporting partial results: " + w
+	}
+	sstats := sanitizeEntries(r.entries)
+	acc.Alias = sanitizeString(acc.Alias, &sstats)
+	if sstats.total() > 0 {
+		log.Warnf("sanitized report strings: %d control characters, %d formatting characters, %d invalid UTF-8 sequences",
+			sstats.ControlChars, sstats.FormatChars, sstats.InvalidUTF8)
+	}
+	if unknown := unknownControls(r.entries, controls); len(unknown) > 0 {
+		log.Warnf("no information for controls: %s", strings.Join(unknown, ", "))
+		if opts.StrictMetadata {
+			return fmt.Errorf("no information for controls: %s", strings.Join(unknown, ", "))
+		}
+		if state.Notes != "" {
+			state.Notes += "\n"
+		}
+		state.Notes += "Controls without metadata, reported with unknown severity: " + strings.Join(unknown, ", ")
+	}
+	r.thresholds, err = applyThresholds(r, controls, opts.ThresholdDays, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(opts.ThresholdDays) > 0 {
+		log.Infof("custom thresholds applied: %d entries reclassified, %d entries with unparseable timestamps",
+			r.thresholds.Reclassified, r.thresholds.Unparseable)
+	}
+
+	if notEvaluated := notEvaluatedControls(r.entries); len(notEvaluated) > 0 {
+		log.Warnf("controls not evaluated due to missing permissions: %s", strings.Join(notEvaluated, ", "))
+		if opts.FailOnNotEvaluated {
+			return fmt.Errorf("%d controls could not be evaluated due to missing permissions: %s", len(notEvaluated), strings.Join(notEvaluated, ", "))
+		}
+	}
+	if opts.EnrichS3 {
+		creds, err := credSrc.get(ctx)
 		if err != nil {
-			return err
+			return fmt.Errorf("can not refresh credentials: %w", err)
 		}
-		r, err := runProwler(ctx, opts.Region, groups)
+		cfg, err := loadAWSConfig(ctx, creds, region)
 		if err != nil {
 			return err
 		}
+		limiter := newRateLimiter(opts.APIRateLimit)
+		enricher := newS3Enricher(cfg, opts.EndpointOverrides, limiter, opts.EnrichS3MaxBuckets)
+		r.exposures = enricher.enrich(ctx, r, controls)
+		limiter.Stop()
+		log.Infof("retrieved the current exposure of %d buckets", len(r.exposures))
+	}
+	r.credentialRefreshes = credSrc.refreshCount()
 
-		var v report.Vulnerability
-		if opts.SecurityLevel == nil {
-			v = CISCompliance
-
-		} else if *opts.SecurityLevel == 0 || *opts.SecurityLevel == 1 {
-			v = CISLevel1Compliance
-		} else {
-			v = CISLevel2Compliance
-		}
-		fv, err := fillCISLevelVuln(&v, r, alias, opts.SecurityLevel, controls)
+	v, err := renderVuln(frameworkCIS, templateLevel(opts.SecurityLevel), acc.Alias)
+	if err != nil {
+		return err
+	}
+	if opts.Score != nil {
+		v.Score = *opts.Score
+	}
+	infov, err := buildCISInfoVuln(ctx, r, acc, opts, controls)
+	if err != nil {
+		return err
+	}
+	findings, err := findingsAttachment(buildFindingsDocument(r, acc, groups, controls))
+	if err != nil {
+		return err
+	}
+	infov.Attachments = append(infov.Attachments, findings)
+	var vulns []report.Vulnerability
+	if opts.PerControlFindings {
+		vulns, err = buildPerControlVulns(v, r, acc, opts, controls)
 		if err != nil {
 			return err
 		}
-		infov, err := buildCISInfoVuln(r, alias, opts.SecurityLevel)
+	} else {
+		fv, err := fillCISLevelVuln(&v, r, acc, opts, controls)
 		if err != nil {
 			return err
 		}
-		// if fv == nil it means there were no failed checks so there is no
-		// vuln.
+		// if fv == nil it means there were no failed checks so there is
+		// no vuln.
 		if fv != nil {
-			state.AddVulnerabilities(*fv)
+			vulns = append(vulns, *fv)
+		}
+	}
+	vulns = append(vulns, infov)
+	for i := range vulns {
+		if acc.Management {
+			addLabels(&vulns[i], managementAccountLabel)
+		}
+		addLabels(&vulns[i], scanLabels(groups, opts.SecurityLevel)...)
+		addLabels(&vulns[i], opts.Labels...)
+		if mismatch {
+			vulns[i].Details = metadataMismatchWarning(opts.BenchmarkVersion, mf.BenchmarkVersion) + vulns[i].Details
 		}
-		state.AddVulnerabilities(infov)
+		vulns[i].Details += "\n" + prov.scanDetails()
+	}
+	state.AddVulnerabilities(vulns...)
 
-		return nil
+	data := reportData{
+		Provenance:       prov,
+		MetadataMismatch: mismatch,
+		Results:          r.summary(),
+		FailedBySeverity: failedSeverities(r.entries, controls, opts.MinSeverity),
+		Levels:           r.levels,
+		Skipped:          r.skipped,
+		Config: configSnapshot{
+			EndpointOverrides: redactedEndpointOverrides(opts.EndpointOverrides),
+		},
+		Metrics: runMetrics{
+			Check:   selfUsage(ctx),
+			Prowler: r.usage,
+		},
+	}
+	log.Infof("resource usage: check %+v, prowler %+v", data.Metrics.Check, data.Metrics.Prowler)
+	state.Data, err = json.Marshal(data)
+	if err != nil {
+		return err
 	}
 
-	c := check.NewCheckFromHandler(checkName, run)
-	c.RunAndServe()
+	return nil
 }
 
 func groupsFromOpts(opts options) ([]string, error) {
@@ -313,9 +761,27 @@ func groupsFromOpts(opts options) ([]string, error) {
 
 }
 
-func buildCISInfoVuln(r *prowlerReport, alias string, slevel *byte) (report.Vulnerability, error) {
-	v := CISComplianceInfo
-	var info []entry
+func buildCISInfoVuln(ctx context.Context, r *prowlerReport, acc awsAccount, opts options, controls map[string]CISControl) (report.Vulnerability, error) {
+	v, err := renderVuln(frameworkCISInfo, templateLevel(opts.SecurityLevel), acc.Alias)
+	if err != nil {
+		return report.Vulnerability{}, err
+	}
+	var (
+		info     []entry
+		filtered []controlRow
+		passed   []entry
+		unknown  = map[string]int{}
+	)
+	warningTable := report.ResourcesGroup{
+		Name: "Suppressed / Warning Controls",
+		Header: []string{
+			"Control",
+			"Description",
+			"Region",
+			"Status",
+			"Message",
+		},
+	}
 	infoTable := report.ResourcesGroup{
 		Name: "Info + Not Scored Controls",
 		Header: []string{
@@ -325,104 +791,432 @@ func buildCISInfoVuln(r *prowlerReport, alias string, slevel *byte) (report.Vuln
 			"Message",
 		},
 	}
+	notEvaluatedTable := report.ResourcesGroup{
+		Name: "Controls Not Evaluated",
+		Header: []string{
+			"Control",
+			"Description",
+			"Region",
+			"Missing Permission",
+			"Reason",
+		},
+	}
 	for _, e := range r.entries {
 		switch e.Status {
+		case prowlerparse.StatusNotEvaluated:
+			control, description, err := prowlerparse.ParseControl(e.Control)
+			if err != nil {
+				return report.Vulnerability{}, err
+			}
+			row := map[string]string{
+				"Control":            html.EscapeString(control),
+				"Description":        html.EscapeString(description),
+				"Region":             html.EscapeString(e.Region),
+				"Missing Permission": html.EscapeString(e.MissingPermission),
+				"Reason":             html.EscapeString(notEvaluatedReason(e)),
+			}
+			notEvaluatedTable.Rows = append(notEvaluatedTable.Rows, row)
 		case "Info":
 			info = append(info, e)
-			control, description, err := parseControl(e.Control)
+			control, description, err := prowlerparse.ParseControl(e.Control)
 			if err != nil {
 				return report.Vulnerability{}, err
 			}
 			row := map[string]string{
-				"Control":     control,
-				"Description": description,
-				"Region":      e.Region,
-				"Message":     e.Message,
+				"Control":     html.EscapeString(control),
+				"Description": html.EscapeString(description),
+				"Region":      html.EscapeString(e.Region),
+				"Message":     html.EscapeString(e.Message),
 			}
 			infoTable.Rows = append(infoTable.Rows, row)
+		case "FAIL":
+			c, err := failedControlRow(e, controls, acc, r.exposures)
+			if err != nil {
+				return report.Vulnerability{}, err
+			}
+			if c.score < float32(opts.MinSeverity) {
+				filtered = append(filtered, c)
+			}
+		case "PASS":
+			passed = append(passed, e)
+		default:
+			// The entries suppressed by an allowlist have the WARNING
+			// status. The rest of statuses are unexpected, but they are
+			// reported in the same table instead of being ignored.
+			if e.Status != statusWarning {
+				unknown[e.Status]++
+			}
+			control, description, err := prowlerparse.ParseControl(e.Control)
+			if err != nil {
+				return report.Vulnerability{}, err
+			}
+			row := map[string]string{
+				"Control":     html.EscapeString(control),
+				"Description": html.EscapeString(description),
+				"Region":      html.EscapeString(e.Region),
+				"Status":      html.EscapeString(e.Status),
+				"Message":     html.EscapeString(e.Message),
+			}
+			warningTable.Rows = append(warningTable.Rows, row)
 		}
 	}
+	statuses := make([]string, 0, len(unknown))
+	for status := range unknown {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		ctxLogger(ctx).Warnf("unknown prowler status %q in %d entries", status, unknown[status])
+	}
+	sortRowsByControl(infoTable.Rows, "Message")
 	v.Resources = append(v.Resources, infoTable)
+	if len(notEvaluatedTable.Rows) > 0 {
+		v.Resources = append(v.Resources, notEvaluatedTable)
+	}
+	if len(warningTable.Rows) > 0 {
+		sortRowsByControl(warningTable.Rows, "Status", "Message")
+		v.Resources = append(v.Resources, warningTable)
+	}
+	var passedTruncated bool
+	if opts.IncludePassed {
+		var passedTable report.ResourcesGroup
+		passedTable, passedTruncated = passedControlsTable(passed)
+		v.Resources = append(v.Resources, passedTable)
+	}
+	if opts.MinSeverity > 0 {
+		sortControlRows(filtered)
+		filteredTable := report.ResourcesGroup{
+			Name:   "Filtered Controls",
+			Header: failedControlsHeader(acc, r.exposures),
+		}
+		for _, c := range filtered {
+			filteredTable.Rows = append(filteredTable.Rows, c.row)
+		}
+		v.Resources = append(v.Resources, filteredTable)
+	}
 
-	v.Details = fmt.Sprintf("Account: %s\n", alias)
-	if slevel != nil {
-		v.Details += fmt.Sprintf("Security Level: %d\n", *slevel)
+	acc.setAffectedResource(&v)
+	v.Details = acc.details()
+	if opts.SecurityLevel != nil {
+		v.Details += fmt.Sprintf("Security Level: %d\n", *opts.SecurityLevel)
 	}
 	v.Details += "\n"
+	v.Details += r.summary().complianceDetails()
+	v.Details += levelsDetails(r.levels)
 	v.Details += fmt.Sprintf("Info + Not Scored Controls: %d\n", len(info))
+	v.Details += fmt.Sprintf("Passed Controls: %d\n", len(passed))
+	v.Details += r.summary().otherStatusesDetails()
+	if passedTruncated {
+		v.Details += fmt.Sprintf("Passed Controls Table Truncated: only the first %d controls are listed\n", maxPassedRows)
+	}
+	if opts.MinSeverity > 0 {
+		v.Details += fmt.Sprintf("Filtered Controls (CIS Severity below %v): %d\n", float32(opts.MinSeverity), len(filtered))
+	}
+	if r.truncated {
+		v.Details += "\n"
+		v.Details += fmt.Sprintf("Scan Truncated: prowler did not finish in %d seconds\n", opts.Timeout)
+		v.Details += fmt.Sprintf("Groups Not Completed: %s\n", strings.Join(r.groups, ", "))
+		v.Details += fmt.Sprintf("Regions With Results: %s\n", strings.Join(r.regions(), ", "))
+	}
+	if n := len(notEvaluatedControls(r.entries)); n > 0 {
+		v.Details += fmt.Sprintf("Controls Not Evaluated (missing permissions): %d\n", n)
+	}
+	if len(r.failedRegions) > 0 {
+		v.Details += fmt.Sprintf("Regions Failed: %s\n", strings.Join(r.failedRegions, ", "))
+	}
+	if len(r.regionDurations) > 0 {
+		v.Details += fmt.Sprintf("Region Durations: %s\n", r.regionDurationsDetails())
+	}
+	if r.credentialRefreshes > 0 {
+		v.Details += fmt.Sprintf("Credential Refreshes: %d\n", r.credentialRefreshes)
+	}
+	if len(r.retries) > 0 {
+		v.Details += "\n"
+		for _, u := range r.retries {
+			v.Details += fmt.Sprintf("Throttling Retries: %s\n", u)
+		}
+	}
+	if len(r.skipped) > 0 {
+		counts, reasons := skipReasons(r.skipped)
+		v.Details += "\n"
+		v.Details += fmt.Sprintf("Skipped Controls: %d\n", len(r.skipped))
+		for _, reason := range reasons {
+			v.Details += fmt.Sprintf("Skipped Controls (%s): %d\n", reason, counts[reason])
+		}
+	}
+	if opts.MinSeverity > 0 {
+		v.Details += "Skipped Controls do not include the ones below min_severity, which are executed and whose failures are counted as Filtered Controls\n"
+	}
+	if len(opts.ThresholdDays) > 0 {
+		v.Details += fmt.Sprintf("Controls Reclassified by Custom Thresholds: %d\n", r.thresholds.Reclassified)
+		v.Details += fmt.Sprintf("Controls With Unparseable Timestamps: %d\n", r.thresholds.Unparseable)
+	}
 
 	return v, nil
 }
 
-func fillCISLevelVuln(v *report.Vulnerability, r *prowlerReport, alias string, slevel *byte, controls map[string]CISControl) (*report.Vulnerability, error) {
-	type controlRow struct {
-		row     map[string]string
-		control string
-		score   float32
+// notEvaluatedReason returns the reason why prowler could not evaluate the
+// control of the given entry.
+func notEvaluatedReason(e entry) string {
+	if _, ok := prowlerparse.AccessDenied(e.Message); ok {
+		return "Access denied"
+	}
+	return e.Message
+}
+
+// notEvaluatedControls returns the sorted identifiers of the controls that
+// prowler could not evaluate, in at least one region, because the role used
+// lacks some permission.
+func notEvaluatedControls(entries []entry) []string {
+	seen := map[string]bool{}
+	var controls []string
+	for _, e := range entries {
+		if e.Status != prowlerparse.StatusNotEvaluated {
+			continue
+		}
+		control, _, err := prowlerparse.ParseControl(e.Control)
+		if err != nil {
+			control = e.Control
+		}
+		if seen[control] {
+			continue
+		}
+		seen[control] = true
+		controls = append(controls, control)
+	}
+	sort.Strings(controls)
+	return controls
+}
+
+// passedControlsTable returns a table with the given passed entries sorted
+// by control and region. The table contains at most maxPassedRows rows, the
+// returned bool is true if some entries have been left out.
+func passedControlsTable(passed []entry) (report.ResourcesGroup, bool) {
+	table := report.ResourcesGroup{
+		Name: "Passed Controls",
+		Header: []string{
+			"Control",
+			"Description",
+			"Region",
+		},
+	}
+	for _, e := range passed {
+		control, description, err := prowlerparse.ParseControl(e.Control)
+		if err != nil {
+			control, description = e.Control, ""
+		}
+		row := map[string]string{
+			"Control":     html.EscapeString(control),
+			"Description": html.EscapeString(description),
+			"Region":      html.EscapeString(e.Region),
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	sortRowsByControl(table.Rows)
+	if len(table.Rows) <= maxPassedRows {
+		return table, false
+	}
+	table.Rows = table.Rows[:maxPassedRows]
+	return table, true
+}
+
+// sortRowsByControl sorts the given rows of a resources table by control,
+// comparing the control identifiers numerically, then by region and then by
+// the given columns, so the tables do not depend on the order in which
+// prowler writes the results.
+func sortRowsByControl(rows []map[string]string, columns ...string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		ri, rj := rows[i], rows[j]
+		if c := prowlerparse.CompareControls(ri["Control"], rj["Control"]); c != 0 {
+			return c < 0
+		}
+		for _, col := range append([]string{"Region"}, columns...) {
+			if ri[col] != rj[col] {
+				return ri[col] < rj[col]
+			}
+		}
+		return false
+	})
+}
+
+// failedControlsHeader returns the header of the failed controls tables.
+// When the account is an organization management account the tables include
+// a column with the notes that apply to that kind of accounts. When the
+// exposure of the S3 buckets has been retrieved the tables include a column
+// with it.
+func failedControlsHeader(acc awsAccount, exposures s3Exposures) []string {
+	header := []string{
+		"Control",
+		"Description",
+		"CIS Severity",
+		"Region",
+		"Resource",
+		"Message",
+		"References",
+	}
+	if acc.Management {
+		header = append(header, "Note")
+	}
+	if exposures != nil {
+		header = append(header, "Current Exposure")
+	}
+	return header
+}
+
+type controlRow struct {
+	row     map[string]string
+	control string
+	score   float32
+}
+
+// failedControlRow builds the row of the failed controls tables for the
+// given entry. The controls without metadata are reported with an unknown
+// severity.
+func failedControlRow(e entry, controls map[string]CISControl, acc awsAccount, exposures s3Exposures) (controlRow, error) {
+	control, description, err := prowlerparse.ParseControl(e.Control)
+	if err != nil {
+		return controlRow{}, err
 	}
+	cinfo, _ := lookupControl(e, controls)
+	// The values of the resources tables are rendered as HTML, so the
+	// values that come from prowler are escaped. The only column that
+	// contains HTML is the one with the link to the remediation.
+	row := map[string]string{
+		"Control":      html.EscapeString(control),
+		"Description":  html.EscapeString(description),
+		"CIS Severity": cinfo.SeverityLiteral,
+		"Region":       html.EscapeString(e.Region),
+		"Message":      html.EscapeString(e.Message),
+		"References":   remediationReference(cinfo),
+	}
+	if resource := prowlerparse.Resource(e); resource != "" {
+		row["Resource"] = html.EscapeString(resource)
+	}
+	if acc.Management {
+		row["Note"] = cinfo.ManagementAccountNote
+	}
+	if exposures != nil && cinfo.Service == serviceS3 {
+		row["Current Exposure"] = exposures.entryExposure(e)
+	}
+	return controlRow{row, control, cinfo.Severity}, nil
+}
+
+// remediationReference returns the value of the References column of the
+// given control: the remediation text followed by the link to the
+// remediation guidance, if the control has them.
+func remediationReference(c CISControl) string {
+	var parts []string
+	if c.RemediationText != "" {
+		parts = append(parts, html.EscapeString(c.RemediationText))
+	}
+	if c.Remediation != "" {
+		parts = append(parts, fmt.Sprintf("<a href=\"%s\">Reference</a>", html.EscapeString(c.Remediation)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// sortControlRows sorts the rows by severity and control in descending
+// order. The control identifiers are compared numerically.
+func sortControlRows(rows []controlRow) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].score == rows[j].score {
+			return prowlerparse.CompareControls(rows[i].control, rows[j].control) > 0
+		}
+		return rows[i].score > rows[j].score
+	})
+}
+
+func fillCISLevelVuln(v *report.Vulnerability, r *prowlerReport, acc awsAccount, opts options, controls map[string]CISControl) (*report.Vulnerability, error) {
 	var (
 		total  int
+		passed int
 		rows   []controlRow
 		failed []entry
 	)
 	fcTable := report.ResourcesGroup{
-		Name: "Failed Controls",
-		Header: []string{
-			"Control",
-			"Description",
-			"CIS Severity",
-			"Region",
-			"Message",
-			"References",
-		},
+		Name:   "Failed Controls",
+		Header: failedControlsHeader(acc, r.exposures),
 	}
 
 	for _, e := range r.entries {
 		switch e.Status {
 		case "FAIL":
-			failed = append(failed, e)
-			control, description, err := parseControl(e.Control)
+			c, err := failedControlRow(e, controls, acc, r.exposures)
 			if err != nil {
 				return nil, err
 			}
-			cinfo, ok := controls[control]
-			if !ok {
-				fmt.Printf("warning: no information for control %s", control)
+			if c.score < float32(opts.MinSeverity) {
+				total++
 				continue
 			}
-			row := map[string]string{
-				"Control":      control,
-				"Description":  description,
-				"CIS Severity": cinfo.SeverityLiteral,
-				"Region":       e.Region,
-				"Message":      e.Message,
-				"References":   fmt.Sprintf("<a href=\"%s\">Reference</a>", cinfo.Remediation),
-			}
-			c := controlRow{row, control, cinfo.Severity}
+			failed = append(failed, e)
 			rows = append(rows, c)
-			fallthrough
+			total++
+		case "PASS":
+			passed++
+			total++
 		default:
 			total++
 		}
 	}
-	sort.Slice(rows, func(i, j int) bool {
-		if rows[i].score == rows[j].score {
-			return rows[i].control > rows[j].control
+	// The failed controls of each section are counted before collapsing the
+	// rows so the counts match the number of failed controls.
+	var sections string
+	if opts.GroupBySection {
+		sections = sectionDetails(rows)
+	}
+	if opts.CollapseRegions {
+		rows = collapseControlRows(rows)
+	}
+	sortControlRows(rows)
+	if opts.GroupBySection {
+		v.Resources = append(v.Resources, sectionGroups(rows, fcTable.Header)...)
+	} else {
+		for _, r := range rows {
+			fcTable.Rows = append(fcTable.Rows, r.row)
 		}
-		return rows[i].score > rows[j].score
-	})
-	for _, r := range rows {
-		fcTable.Rows = append(fcTable.Rows, r.row)
+		v.Resources = append(v.Resources, fcTable)
 	}
-	v.Resources = append(v.Resources, fcTable)
 
-	v.Details = fmt.Sprintf("Account: %s\n", alias)
-	if slevel != nil {
-		v.Details += fmt.Sprintf("Security Level: %d\n", *slevel)
+	acc.setAffectedResource(v)
+	v.Details = acc.details()
+	if opts.SecurityLevel != nil {
+		v.Details += fmt.Sprintf("Security Level: %d\n", *opts.SecurityLevel)
+	}
+	if opts.MinSeverity > 0 {
+		v.Details += fmt.Sprintf("Minimum CIS Severity: %v\n", float32(opts.MinSeverity))
+	}
+	switch {
+	case opts.Score != nil:
+		v.Details += fmt.Sprintf("Score: %v (set by policy with the score option)\n", *opts.Score)
+	case !opts.FixedScore && len(rows) > 0:
+		// The rows are sorted by severity, so the first one is the most
+		// severe failed control.
+		worst := rows[0]
+		v.Score = clampScore(worst.score, opts.ScoreFloor, opts.ScoreCeiling)
+		v.Details += fmt.Sprintf("Score: %v (CIS severity of the most severe failed control: %s)\n", v.Score, worst.control)
+		if v.Score != worst.score {
+			v.Details += fmt.Sprintf("Score Limited From: %v\n", worst.score)
+		}
 	}
 	v.Details += "\n"
-	v.Details += fmt.Sprintf("Failed Controls: %d\n", len(failed))
+	if opts.CollapseRegions {
+		v.Details += fmt.Sprintf("Failed Controls: %d (%d region occurrences)\n", uniqueControls(failed), len(failed))
+	} else {
+		v.Details += fmt.Sprintf("Failed Controls: %d\n", len(failed))
+	}
+	if len(failed) > 0 {
+		v.Details += fmt.Sprintf("Failed Controls by Severity: %s\n", failedSeverities(r.entries, controls, opts.MinSeverity).details())
+	}
+	v.Details += sections
+	v.Details += fmt.Sprintf("Passed Controls: %d\n", passed)
 	v.Details += fmt.Sprintf("Total Controls: %d\n", total)
+	v.Details += r.summary().otherStatusesDetails()
+	v.Details += r.summary().complianceDetails()
+	v.Details += levelsDetails(r.levels)
+	if r.credentialRefreshes > 0 {
+		v.Details += fmt.Sprintf("Credential Refreshes: %d\n", r.credentialRefreshes)
+	}
 	// This vulnerability only makes sense when there is, at least, one failed check.
 	if len(failed) < 1 {
 		return nil, nil
@@ -430,93 +1224,227 @@ func fillCISLevelVuln(v *report.Vulnerability, r *prowlerReport, alias string, s
 	return v, nil
 }
 
-func parseControl(raw string) (control string, description string, err error) {
-	if raw == "" {
-		return "", "", fmt.Errorf("error parsing raw control, unexpected format %s", raw)
+// clampScore limits the given score to the range defined by the floor and the
+// ceiling, if they are not nil.
+func clampScore(score float32, floor, ceiling *float32) float32 {
+	if floor != nil && score < *floor {
+		score = *floor
 	}
-	// Raw format example: "[check13] Ensure credentials unused for 90 days or
-	// greater are disabled (Scored)""
-	parts := strings.Split(raw, "] ")
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("error parsing raw control, unexpected format %s", raw)
+	if ceiling != nil && score > *ceiling {
+		score = *ceiling
 	}
-	// parts[0] = [check13 .
-	control = strings.Replace(parts[0], "[check", "", -1)
-	// control = 13 .
-	control = control[:1] + "." + control[1:]
-	// control = 1.13
-	// description = Ensure credentials unused for 90 days or greater are
-	// disabled (Scored)
-	description = strings.Replace(parts[1], "(Scored)", "", -1)
-	return
+	return score
 }
 
-type assumeRoleResponse struct {
-	AccessKey       string `json:"access_key"`
-	SecretAccessKey string `json:"secret_access_key"`
-	SessionToken    string `json:"session_token"`
+// buildPerControlVulns returns one vulnerability per failed control. The
+// labels and references of the vulnerabilities are taken from the given
+// template.
+func buildPerControlVulns(tmpl report.Vulnerability, r *prowlerReport, acc awsAccount, opts options, controls map[string]CISControl) ([]report.Vulnerability, error) {
+	var (
+		ids    []string
+		failed = map[string][]entry{}
+	)
+	for _, e := range r.entries {
+		if e.Status != "FAIL" {
+			continue
+		}
+		control, _, err := prowlerparse.ParseControl(e.Control)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := failed[control]; !ok {
+			ids = append(ids, control)
+		}
+		failed[control] = append(failed[control], e)
+	}
+
+	var vulns []report.Vulnerability
+	for _, id := range ids {
+		entries := failed[id]
+		_, description, err := prowlerparse.ParseControl(entries[0].Control)
+		if err != nil {
+			return nil, err
+		}
+		cinfo, _ := lookupControl(entries[0], controls)
+		if cinfo.Severity < float32(opts.MinSeverity) {
+			continue
+		}
+		v := report.Vulnerability{
+			Summary:     fmt.Sprintf("CIS Control %s: %s", id, strings.TrimSpace(description)),
+			Description: description,
+			Score:       cinfo.Severity,
+			Labels:      append([]string{}, tmpl.Labels...),
+			References:  append([]string{}, tmpl.References...),
+			Fingerprint: helpers.ComputeFingerprint(id),
+		}
+		if cinfo.RemediationText != "" {
+			v.Recommendations = append(v.Recommendations, cinfo.RemediationText)
+		}
+		if cinfo.Remediation != "" {
+			v.Recommendations = append(v.Recommendations,
+				fmt.Sprintf("Follow the remediation guidance of the control: %s", cinfo.Remediation))
+		}
+		// The vulnerability refers to the resource of the control when all
+		// its entries refer to the same one.
+		acc.setAffectedResource(&v)
+		if resource := entriesResource(entries); resource != "" {
+			v.AffectedResource = resource
+			v.AffectedResourceString = ""
+		}
+		v.Details = acc.details()
+		if opts.SecurityLevel != nil {
+			v.Details += fmt.Sprintf("Security Level: %d\n", *opts.SecurityLevel)
+		}
+		v.Details += fmt.Sprintf("CIS Severity: %s\n", cinfo.SeverityLiteral)
+		if acc.Management && cinfo.ManagementAccountNote != "" {
+			v.Details += fmt.Sprintf("Management Account Note: %s\n", cinfo.ManagementAccountNote)
+		}
+		v.Details += "\n"
+		for _, e := range entries {
+			v.Details += fmt.Sprintf("%s: %s\n", e.Region, e.Message)
+			if r.exposures != nil && cinfo.Service == serviceS3 {
+				v.Details += fmt.Sprintf("Current Exposure: %s\n", r.exposures.entryExposure(e))
+			}
+		}
+		vulns = append(vulns, v)
+	}
+	sort.SliceStable(vulns, func(i, j int) bool {
+		return vulns[i].Score > vulns[j].Score
+	})
+	return vulns, nil
 }
 
-func loadCredentials(url string, accountID, role string, sessionDuration int) error {
-	m := map[string]interface{}{"account_id": accountID}
-	if role != "" {
-		m["role"] = role
+// entriesResource returns the resource of the given entries, as returned by
+// [prowlerparse.Resource], if all of them refer to the same resource.
+// Otherwise it returns an empty string.
+func entriesResource(entries []entry) string {
+	var resource string
+	for i, e := range entries {
+		r := prowlerparse.Resource(e)
+		if r == "" || (i > 0 && r != resource) {
+			return ""
+		}
+		resource = r
 	}
-	if sessionDuration != 0 {
-		m["duration"] = sessionDuration
+	return resource
+}
+
+// awsAccount contains the information about the scanned account shown in the
+// vulnerabilities.
+type awsAccount struct {
+	ID    string
+	Alias string
+	// ARN is the ARN of the account, e.g.: arn:aws:iam::123456789012:root.
+	ARN string
+	// VerifiedID is the account ID returned by STS for the credentials used
+	// in the scan.
+	VerifiedID string
+	// Management is true if the account is the management account of an
+	// organization.
+	Management bool
+}
+
+// setAffectedResource sets the affected resource of the given vulnerability
+// to the ARN of the account and the affected resource string to its alias.
+func (a awsAccount) setAffectedResource(v *report.Vulnerability) {
+	v.AffectedResource = a.ARN
+	v.AffectedResourceString = a.Alias
+}
+
+// details returns the lines identifying the account in the Details of the
+// vulnerabilities.
+func (a awsAccount) details() string {
+	d := fmt.Sprintf("Account: %s\n", a.Alias)
+	if a.VerifiedID != "" {
+		d += fmt.Sprintf("Verified Account ID: %s\n", a.VerifiedID)
 	}
-	jsonBody, err := json.Marshal(m)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if a.Management {
+		d += "Organization Management Account: yes\n"
 	}
-	defer resp.Body.Close()
+	return d
+}
 
-	buf, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
+// groupLabelRegexp matches the characters that are not allowed in the
+// labels generated from the prowler groups.
+var groupLabelRegexp = regexp.MustCompile(`[^a-z0-9]+`)
 
-	var r assumeRoleResponse
-	err = json.Unmarshal(buf, &r)
-	if err != nil {
-		logger.Errorf("can not decode response body '%s'", string(buf))
-		return err
+// scanLabels returns the labels that identify the prowler groups executed
+// and, when it was provided, the CIS security level of the scan, e.g.:
+// ["cislevel2", "extras", "cis-level-2"]. The labels of the groups are
+// normalized, deduplicated and sorted.
+func scanLabels(groups []string, securityLevel *byte) []string {
+	var labels []string
+	for _, g := range groups {
+		l := strings.Trim(groupLabelRegexp.ReplaceAllString(strings.ToLower(g), "-"), "-")
+		if l != "" && !slices.Contains(labels, l) {
+			labels = append(labels, l)
+		}
 	}
-
-	if err := os.Setenv(envKeyID, r.AccessKey); err != nil {
-		return err
+	sort.Strings(labels)
+	if level := templateLevel(securityLevel); level != "" {
+		labels = append(labels, "cis-level-"+level)
 	}
+	return labels
+}
 
-	if err := os.Setenv(envKeySecret, r.SecretAccessKey); err != nil {
-		return err
+// addLabels appends to the labels of the vulnerability the given ones that it
+// does not already have.
+func addLabels(v *report.Vulnerability, labels ...string) {
+	for _, l := range labels {
+		found := false
+		for _, vl := range v.Labels {
+			if vl == l {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.Labels = append(v.Labels, l)
+		}
 	}
+}
 
-	if err := os.Setenv(envToken, r.SessionToken); err != nil {
-		return err
-	}
+// organizationsAPI contains the Organizations operations used by the
+// check.
+type organizationsAPI interface {
+	DescribeOrganization(context.Context, *organizations.DescribeOrganizationInput, ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error)
+}
 
-	return nil
+// isManagementAccount returns true if the account that the credentials of
+// the client belong to is the management account of an organization. The
+// function returns false without error when the credentials are not allowed
+// to describe the organization or the account is not part of an
+// organization.
+func isManagementAccount(ctx context.Context, svc organizationsAPI, accountID string) (bool, error) {
+	resp, err := svc.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		if isErrCode(err, "AccessDeniedException") || isErrCode(err, "AWSOrganizationsNotInUseException") {
+			return false, nil
+		}
+		return false, err
+	}
+	if resp.Organization == nil {
+		return false, nil
+	}
+	return aws.ToString(resp.Organization.MasterAccountId) == accountID, nil
 }
 
 // accountAlias gets one of the current aliases for the account that the
-// credentials passed belong to.
-func accountAlias(creds *credentials.Credentials) (string, error) {
-	svc := iam.New(session.New(&aws.Config{Credentials: creds}))
-	resp, err := svc.ListAccountAliases(&iam.ListAccountAliasesInput{})
+// credentials belong to, using the IAM endpoint of the given region or its
+// override. If the account has no aliases, it returns the given account ID
+// followed by "(no alias)".
+func accountAlias(ctx context.Context, creds awsCredentials, region string, overrides map[string]string, accountID string) (string, error) {
+	opts := awsauth.AliasOptions{
+		Endpoint:    overrides["iam"],
+		MaxAttempts: awsMaxRetries + 1,
+	}
+	alias, err := awsauth.AccountAlias(ctx, creds.auth(), region, opts)
 	if err != nil {
 		return "", err
 	}
-	if len(resp.AccountAliases) == 0 {
-		logger.Warn("No aliases found for the account")
-		return "", nil
-	}
-	a := resp.AccountAliases[0]
-	if a == nil {
-		return "", errors.New("unexpected nil getting aliases for aws account")
+	if alias == "" {
+		ctxLogger(ctx).Warn("No aliases found for the account")
+		return fmt.Sprintf("%s (no alias)", accountID), nil
 	}
-	return *a, nil
+	return alias, nil
 }
@@ -0,0 +1,1213 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+func TestFillCISLevelVulnExtras(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {
+			ID:              "1.3",
+			Severity:        10,
+			SeverityLiteral: "Critical",
+			Remediation:     "https://example.com/1.3",
+		},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{
+				Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "User user1 has not used access key 1 since creation",
+			},
+			{
+				Control: "[extra718] Check if S3 buckets have server access logging enabled",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "Bucket bucket1 has server access logging disabled",
+			},
+		},
+	}
+	v, err := renderVuln(frameworkCIS, "2", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv == nil {
+		t.Fatal("expected vulnerability, got nil")
+	}
+	want := []map[string]string{
+		{
+			"Control":      "1.3",
+			"Description":  "Ensure credentials unused for 90 days or greater are disabled",
+			"CIS Severity": "Critical",
+			"Region":       "eu-west-1",
+			"Resource":     "user1",
+			"Message":      "User user1 has not used access key 1 since creation",
+			"References":   "<a href=\"https://example.com/1.3\">Reference</a>",
+		},
+		{
+			"Control":      "extra718",
+			"Description":  "Check if S3 buckets have server access logging enabled",
+			"CIS Severity": "Low",
+			"Region":       "eu-west-1",
+			"Resource":     "bucket1",
+			"Message":      "Bucket bucket1 has server access logging disabled",
+			"References":   "",
+		},
+	}
+	if diff := cmp.Diff(want, fv.Resources[0].Rows); diff != "" {
+		t.Errorf("unexpected rows (-want +got):\n%v", diff)
+	}
+}
+
+func TestFillCISLevelVulnUnknownControl(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 3.9, SeverityLiteral: "Low"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{
+				Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "User user1 has not used access key 1 since creation",
+			},
+			{
+				Control: "[check299] Ensure a control added in a newer benchmark is enabled (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "Control is not enabled",
+			},
+			{
+				Control: "[check11] Avoid the use of the root account (Scored)",
+				Status:  "PASS",
+				Region:  "eu-west-1",
+			},
+		},
+	}
+	if diff := cmp.Diff([]string{"2.99"}, unknownControls(r.entries, controls)); diff != "" {
+		t.Errorf("unexpected unknown controls (-want +got):\n%v", diff)
+	}
+	v := report.Vulnerability{}
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []map[string]string{
+		{
+			"Control":      "2.99",
+			"Description":  "Ensure a control added in a newer benchmark is enabled",
+			"CIS Severity": "Unknown",
+			"Region":       "eu-west-1",
+			"Message":      "Control is not enabled",
+			"References":   "",
+		},
+		{
+			"Control":      "1.3",
+			"Description":  "Ensure credentials unused for 90 days or greater are disabled",
+			"CIS Severity": "Low",
+			"Region":       "eu-west-1",
+			"Resource":     "user1",
+			"Message":      "User user1 has not used access key 1 since creation",
+			"References":   "",
+		},
+	}
+	if diff := cmp.Diff(want, fv.Resources[0].Rows); diff != "" {
+		t.Errorf("unexpected rows (-want +got):\n%v", diff)
+	}
+	if fv.Score != report.SeverityThresholdMedium {
+		t.Errorf("unexpected score: %v", fv.Score)
+	}
+	for _, line := range []string{"Failed Controls: 2\n", "Passed Controls: 1\n", "Total Controls: 3\n"} {
+		if !strings.Contains(fv.Details, line) {
+			t.Errorf("details do not contain %q: %s", line, fv.Details)
+		}
+	}
+}
+
+func TestAffectedResource(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3":      {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium"},
+		"extra718": {ID: "extra718", Severity: 3.9, SeverityLiteral: "Low"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "User user1 unused"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "us-east-1", Message: "User user1 unused"},
+			{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "FAIL", Region: "eu-west-1", Message: "Bucket logs has server access logging disabled"},
+			{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "FAIL", Region: "eu-west-1", Message: "Bucket backups has server access logging disabled"},
+		},
+	}
+	acc := awsAccount{ID: "123456789012", Alias: "alias", ARN: "arn:aws:iam::123456789012:root"}
+	type affected struct {
+		Resource, ResourceString string
+	}
+	account := affected{"arn:aws:iam::123456789012:root", "alias"}
+
+	t.Run("Aggregated", func(t *testing.T) {
+		v, err := renderVuln(frameworkCIS, "", acc.Alias)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fv, err := fillCISLevelVuln(&v, r, acc, options{}, controls)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		infov, err := buildCISInfoVuln(context.Background(), r, acc, options{}, controls)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, v := range []report.Vulnerability{*fv, infov} {
+			got := affected{v.AffectedResource, v.AffectedResourceString}
+			if got != account {
+				t.Errorf("unexpected affected resource of %q: %+v", v.Summary, got)
+			}
+		}
+	})
+
+	t.Run("PerControl", func(t *testing.T) {
+		tmpl, err := renderVuln(frameworkCIS, "", acc.Alias)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		vulns, err := buildPerControlVulns(tmpl, r, acc, options{}, controls)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := map[string]affected{
+			// All the entries of the control refer to the same user.
+			"1.3": {"user1", ""},
+			// The entries of the control refer to different buckets.
+			"extra718": account,
+		}
+		got := map[string]affected{}
+		for _, v := range vulns {
+			id := strings.TrimPrefix(strings.SplitN(v.Summary, ":", 2)[0], "CIS Control ")
+			got[id] = affected{v.AffectedResource, v.AffectedResourceString}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected affected resources (-want +got):\n%v", diff)
+		}
+	})
+}
+
+func TestRemediationReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		control CISControl
+		want    string
+	}{
+		{
+			name:    "TextAndLink",
+			control: CISControl{RemediationText: "Rotate the keys & remove the unused ones.", Remediation: "https://example.com/1.4"},
+			want:    `Rotate the keys &amp; remove the unused ones. <a href="https://example.com/1.4">Reference</a>`,
+		},
+		{
+			name:    "OnlyLink",
+			control: CISControl{Remediation: "https://example.com/1.4"},
+			want:    `<a href="https://example.com/1.4">Reference</a>`,
+		},
+		{
+			name:    "OnlyText",
+			control: CISControl{RemediationText: "Rotate the keys."},
+			want:    "Rotate the keys.",
+		},
+		{
+			name: "None",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remediationReference(tt.control); got != tt.want {
+				t.Errorf("unexpected reference, want: %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildPerControlVulnsRemediationText(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.4": {ID: "1.4", Severity: 6.9, SeverityLiteral: "Medium", Remediation: "https://example.com/1.4", RemediationText: "Rotate the keys."},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check14] Ensure access keys are rotated (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "User user1 has old keys"},
+		},
+	}
+	tmpl, err := renderVuln(frameworkCIS, "", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vulns, err := buildPerControlVulns(tmpl, r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vulns) != 1 {
+		t.Fatalf("unexpected number of vulnerabilities: %d", len(vulns))
+	}
+	want := []string{
+		"Rotate the keys.",
+		"Follow the remediation guidance of the control: https://example.com/1.4",
+	}
+	if diff := cmp.Diff(want, vulns[0].Recommendations); diff != "" {
+		t.Errorf("unexpected recommendations (-want +got):\n%v", diff)
+	}
+}
+
+func TestBuildOptionsMinSeverity(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    severity
+		wantErr bool
+	}{
+		{name: "unset", optJSON: `{}`, want: 0},
+		{name: "number", optJSON: `{"min_severity": 8.9}`, want: 8.9},
+		{name: "literal", optJSON: `{"min_severity": "High"}`, want: 8.9},
+		{name: "lowercase literal", optJSON: `{"min_severity": "critical"}`, want: 10},
+		{name: "out of range", optJSON: `{"min_severity": 11}`, wantErr: true},
+		{name: "negative", optJSON: `{"min_severity": -1}`, wantErr: true},
+		{name: "unknown literal", optJSON: `{"min_severity": "Severe"}`, wantErr: true},
+		{name: "invalid type", optJSON: `{"min_severity": true}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.MinSeverity != tt.want {
+				t.Errorf("unexpected min severity, want: %v, got: %v", tt.want, opts.MinSeverity)
+			}
+		})
+	}
+}
+
+func TestMinSeverityFilter(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 10, SeverityLiteral: "Critical"},
+		"2.1": {ID: "2.1", Severity: 3.9, SeverityLiteral: "Low"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check22] Ensure log file validation (Scored)", Status: "PASS", Region: "eu-west-1"},
+		},
+	}
+	opts := options{MinSeverity: severity(report.SeverityThresholdHigh)}
+
+	v, err := renderVuln(frameworkCIS, "", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, opts, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv == nil {
+		t.Fatal("expected vulnerability, got nil")
+	}
+	if got := len(fv.Resources[0].Rows); got != 1 || fv.Resources[0].Rows[0]["Control"] != "1.3" {
+		t.Errorf("unexpected failed controls rows: %v", fv.Resources[0].Rows)
+	}
+	if !strings.Contains(fv.Details, "Failed Controls: 1\n") || !strings.Contains(fv.Details, "Total Controls: 3\n") {
+		t.Errorf("unexpected details: %s", fv.Details)
+	}
+
+	infov, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, opts, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(infov.Resources) != 2 || infov.Resources[1].Name != "Filtered Controls" {
+		t.Fatalf("unexpected resources: %v", infov.Resources)
+	}
+	if !strings.Contains(infov.Details, "Skipped Controls do not include the ones below min_severity") {
+		t.Errorf("details do not tell the min_severity apart from the skipped controls: %s", infov.Details)
+	}
+	if got := infov.Resources[1].Rows; len(got) != 1 || got[0]["Control"] != "2.1" {
+		t.Errorf("unexpected filtered controls rows: %v", got)
+	}
+}
+
+func TestBuildPerControlVulns(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3":  {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium", Remediation: "https://example.com/1.3"},
+		"1.12": {ID: "1.12", Severity: 10, SeverityLiteral: "Critical"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "User user1 unused"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "us-east-1", Message: "User user2 unused"},
+			{Control: "[check112] Ensure no root account access key exists (Scored)", Status: "FAIL", Region: "eu-west-1", Message: "Root has access keys"},
+			{Control: "[check14] Ensure access keys are rotated (Scored)", Status: "PASS", Region: "eu-west-1"},
+		},
+	}
+	tmpl, err := renderVuln(frameworkCIS, "", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	acc := awsAccount{Alias: "alias", ARN: "arn:aws:iam::123456789012:root"}
+	vulns, err := buildPerControlVulns(tmpl, r, acc, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(vulns) != 2 {
+		t.Fatalf("unexpected number of vulnerabilities: %d", len(vulns))
+	}
+	got := vulns[1]
+	want := report.Vulnerability{
+		Summary:                "CIS Control 1.3: Ensure credentials unused",
+		Description:            "Ensure credentials unused",
+		Score:                  6.9,
+		AffectedResource:       "arn:aws:iam::123456789012:root",
+		AffectedResourceString: "alias",
+		Labels:                 tmpl.Labels,
+		References:             tmpl.References,
+		Fingerprint:            helpers.ComputeFingerprint("1.3"),
+		Recommendations: []string{
+			"Follow the remediation guidance of the control: https://example.com/1.3",
+		},
+		Details: "Account: alias\nCIS Severity: Medium\n\neu-west-1: User user1 unused\nus-east-1: User user2 unused\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected vulnerability (-want +got):\n%v", diff)
+	}
+	if vulns[0].Summary != "CIS Control 1.12: Ensure no root account access key exists" || vulns[0].Score != 10 {
+		t.Errorf("unexpected vulnerability: %+v", vulns[0])
+	}
+	if vulns[0].Fingerprint == vulns[1].Fingerprint {
+		t.Error("expected different fingerprints per control")
+	}
+}
+
+func TestBuildOptionsSecurityLevel(t *testing.T) {
+	level := func(l byte) *byte { return &l }
+	tests := []struct {
+		name    string
+		optJSON string
+		want    *byte
+		wantErr bool
+	}{
+		{name: "unset", optJSON: `{}`, want: nil},
+		{name: "null", optJSON: `{"security_level": null}`, want: nil},
+		{name: "number", optJSON: `{"security_level": 2}`, want: level(2)},
+		{name: "zero", optJSON: `{"security_level": 0}`, want: level(0)},
+		{name: "numeric string", optJSON: `{"security_level": "1"}`, want: level(1)},
+		{name: "literal level1", optJSON: `{"security_level": "level1"}`, want: level(1)},
+		{name: "literal level2", optJSON: `{"security_level": "Level2"}`, want: level(2)},
+		{name: "out of range", optJSON: `{"security_level": 3}`, wantErr: true},
+		{name: "negative", optJSON: `{"security_level": -1}`, wantErr: true},
+		{name: "decimal", optJSON: `{"security_level": 1.5}`, wantErr: true},
+		{name: "out of range string", optJSON: `{"security_level": "level3"}`, wantErr: true},
+		{name: "unparsable string", optJSON: `{"security_level": "high"}`, wantErr: true},
+		{name: "invalid type", optJSON: `{"security_level": true}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, opts.SecurityLevel); diff != "" {
+				t.Errorf("unexpected security level (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsKeepsOtherFields(t *testing.T) {
+	opts, err := buildOptions(`{"region":"eu-west-1","groups":["cislevel1"],"session_duration":1800,"security_level":"2"}`, "aws")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	level := byte(2)
+	retries := defaultThrottlingRetries
+	ratio := defaultMaxNotExecutedRatio
+	want := options{
+		Region:              "eu-west-1",
+		Groups:              []string{"cislevel1"},
+		SessionDuration:     1800,
+		SecurityLevel:       &level,
+		BenchmarkVersion:    defaultBenchmarkVersion,
+		MetadataMismatch:    metadataMismatchStrict,
+		EnrichS3MaxBuckets:  defaultEnrichS3MaxBuckets,
+		APIRateLimit:        defaultAPIRateLimit,
+		MaxParallelRegions:  1,
+		AssumeRoleTimeout:   defaultAssumeRoleTimeout,
+		ThrottlingRetries:   &retries,
+		MaxNotExecutedRatio: &ratio,
+	}
+	if diff := cmp.Diff(want, opts); diff != "" {
+		t.Errorf("unexpected options (-want +got):\n%v", diff)
+	}
+}
+
+func TestManagementAccount(t *testing.T) {
+	controls := map[string]CISControl{
+		"2.1": {ID: "2.1", Severity: 6.9, SeverityLiteral: "Medium", ManagementAccountNote: "Use an organization trail."},
+		"1.3": {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1"},
+		},
+	}
+	acc := awsAccount{ID: "123456789012", Alias: "alias", Management: true}
+	v, err := renderVuln(frameworkCIS, "", acc.Alias)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv, err := fillCISLevelVuln(&v, r, acc, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(fv.Details, "Account: alias\nOrganization Management Account: yes\n") {
+		t.Errorf("unexpected details: %s", fv.Details)
+	}
+	if got := fv.Resources[0].Header; got[len(got)-1] != "Note" {
+		t.Errorf("unexpected header: %v", got)
+	}
+	notes := map[string]string{}
+	for _, row := range fv.Resources[0].Rows {
+		notes[row["Control"]] = row["Note"]
+	}
+	want := map[string]string{"2.1": "Use an organization trail.", "1.3": ""}
+	if diff := cmp.Diff(want, notes); diff != "" {
+		t.Errorf("unexpected notes (-want +got):\n%v", diff)
+	}
+
+	addLabels(fv, managementAccountLabel)
+	addLabels(fv, managementAccountLabel, "cis")
+	wantLabels := []string{"compliance", "cis", "aws", managementAccountLabel}
+	if diff := cmp.Diff(wantLabels, fv.Labels); diff != "" {
+		t.Errorf("unexpected labels (-want +got):\n%v", diff)
+	}
+}
+
+type fakeOrganizations struct {
+	org *orgtypes.Organization
+	err error
+}
+
+func (f fakeOrganizations) DescribeOrganization(context.Context, *organizations.DescribeOrganizationInput, ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &organizations.DescribeOrganizationOutput{Organization: f.org}, nil
+}
+
+func TestIsManagementAccount(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     fakeOrganizations
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "management account",
+			api:  fakeOrganizations{org: &orgtypes.Organization{MasterAccountId: aws.String("123456789012")}},
+			want: true,
+		},
+		{
+			name: "member account",
+			api:  fakeOrganizations{org: &orgtypes.Organization{MasterAccountId: aws.String("210987654321")}},
+		},
+		{
+			name: "access denied",
+			api:  fakeOrganizations{err: &smithy.GenericAPIError{Code: "AccessDeniedException"}},
+		},
+		{
+			name: "not in organization",
+			api:  fakeOrganizations{err: &smithy.GenericAPIError{Code: "AWSOrganizationsNotInUseException"}},
+		},
+		{
+			name:    "other error",
+			api:     fakeOrganizations{err: &smithy.GenericAPIError{Code: "ServiceException"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isManagementAccount(context.Background(), tt.api, "123456789012")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected result: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsRegion(t *testing.T) {
+	tests := []struct {
+		name      string
+		optJSON   string
+		partition string
+		want      string
+		wantErr   bool
+	}{
+		{name: "empty", optJSON: `{}`, partition: "aws", want: ""},
+		{name: "valid", optJSON: `{"region": "eu-west-1"}`, partition: "aws", want: "eu-west-1"},
+		{name: "default partition", optJSON: `{"region": "us-east-1"}`, partition: "", want: "us-east-1"},
+		{name: "missing dash", optJSON: `{"region": "eu-west1"}`, partition: "aws", wantErr: true},
+		{name: "govcloud", optJSON: `{"region": "us-gov-west-1"}`, partition: "aws-us-gov", want: "us-gov-west-1"},
+		{name: "china", optJSON: `{"region": "cn-north-1"}`, partition: "aws-cn", want: "cn-north-1"},
+		{name: "china region in aws partition", optJSON: `{"region": "cn-north-1"}`, partition: "aws", wantErr: true},
+		{name: "aws region in govcloud partition", optJSON: `{"region": "eu-west-1"}`, partition: "aws-us-gov", wantErr: true},
+		{name: "unknown partition", optJSON: `{"region": "eu-west-1"}`, partition: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, tt.partition)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && opts.Region != tt.want {
+				t.Errorf("unexpected region, want: %q, got: %q", tt.want, opts.Region)
+			}
+		})
+	}
+}
+
+func TestBuildCISInfoVulnTruncated(t *testing.T) {
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "PASS", Region: "us-east-1"},
+			{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check22] Ensure log file validation (Scored)", Status: "PASS", Region: "eu-west-1"},
+		},
+		groups:    []string{"cislevel2"},
+		truncated: true,
+	}
+	v, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{Timeout: 600}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Scan Truncated: prowler did not finish in 600 seconds\n" +
+		"Groups Not Completed: cislevel2\n" +
+		"Regions With Results: eu-west-1, us-east-1\n"
+	if !strings.Contains(v.Details, want) {
+		t.Errorf("unexpected details: %s", v.Details)
+	}
+}
+
+func TestBuildCISInfoVulnInfoSorted(t *testing.T) {
+	entries := []entry{
+		{Control: "[check110] Ensure IAM password policy prevents password reuse (Scored)", Status: "Info", Region: "us-east-1", Message: "Password policy not found"},
+		{Control: "[check12] Ensure MFA is enabled for all IAM users (Scored)", Status: "Info", Region: "eu-west-1", Message: "No users found"},
+		{Control: "[check110] Ensure IAM password policy prevents password reuse (Scored)", Status: "Info", Region: "eu-west-1", Message: "Password policy not found"},
+		{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "Info", Region: "eu-west-1", Message: "b"},
+		{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "Info", Region: "eu-west-1", Message: "a"},
+		{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "Info", Region: "eu-west-1", Message: "No buckets found"},
+		{Control: "[check19] Ensure IAM password policy requires minimum length (Scored)", Status: "Info", Region: "eu-west-1", Message: "Password policy not found"},
+	}
+	want := []map[string]string{
+		{"Control": "1.2", "Description": "Ensure MFA is enabled for all IAM users", "Region": "eu-west-1", "Message": "No users found"},
+		{"Control": "1.9", "Description": "Ensure IAM password policy requires minimum length", "Region": "eu-west-1", "Message": "Password policy not found"},
+		{"Control": "1.10", "Description": "Ensure IAM password policy prevents password reuse", "Region": "eu-west-1", "Message": "Password policy not found"},
+		{"Control": "1.10", "Description": "Ensure IAM password policy prevents password reuse", "Region": "us-east-1", "Message": "Password policy not found"},
+		{"Control": "2.1", "Description": "Ensure CloudTrail is enabled in all regions", "Region": "eu-west-1", "Message": "a"},
+		{"Control": "2.1", "Description": "Ensure CloudTrail is enabled in all regions", "Region": "eu-west-1", "Message": "b"},
+		{"Control": "extra718", "Description": "Check if S3 buckets have server access logging enabled", "Region": "eu-west-1", "Message": "No buckets found"},
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := append([]entry(nil), entries...)
+		rnd.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		v, err := buildCISInfoVuln(context.Background(), &prowlerReport{entries: shuffled}, awsAccount{Alias: "alias"}, options{}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diff := cmp.Diff(want, v.Resources[0].Rows); diff != "" {
+			t.Fatalf("unexpected info rows for order %v (-want +got):\n%s", shuffled, diff)
+		}
+	}
+}
+
+func TestBuildCISInfoVulnWarnings(t *testing.T) {
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[extra718] Check if S3 buckets have server access logging enabled", Status: "WARNING", Region: "eu-west-1", Message: "Bucket logs has server access logging disabled"},
+			{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "WARNING", Region: "eu-west-1", Message: "User ci has not used access key 1 since creation"},
+			{Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)", Status: "ERROR", Region: "us-east-1", Message: "Unexpected error"},
+		},
+	}
+	v, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := report.ResourcesGroup{
+		Name:   "Suppressed / Warning Controls",
+		Header: []string{"Control", "Description", "Region", "Status", "Message"},
+		Rows: []map[string]string{
+			{"Control": "1.3", "Description": "Ensure credentials unused for 90 days or greater are disabled", "Region": "eu-west-1", "Status": "WARNING", "Message": "User ci has not used access key 1 since creation"},
+			{"Control": "1.4", "Description": "Ensure access keys are rotated every 90 days or less", "Region": "us-east-1", "Status": "ERROR", "Message": "Unexpected error"},
+			{"Control": "extra718", "Description": "Check if S3 buckets have server access logging enabled", "Region": "eu-west-1", "Status": "WARNING", "Message": "Bucket logs has server access logging disabled"},
+		},
+	}
+	if len(v.Resources) != 2 {
+		t.Fatalf("unexpected number of resources: %d", len(v.Resources))
+	}
+	if diff := cmp.Diff(want, v.Resources[1]); diff != "" {
+		t.Errorf("warning controls mismatch (-want +got):\n%s", diff)
+	}
+	for _, line := range []string{"Suppressed / Warning Controls: 2\n", "Controls With Unknown Status: 1\n"} {
+		if !strings.Contains(v.Details, line) {
+			t.Errorf("details do not contain %q: %s", line, v.Details)
+		}
+	}
+	s := r.summary()
+	if s.Warning != 2 || s.Unknown != 1 {
+		t.Errorf("unexpected summary: %+v", s)
+	}
+}
+
+func TestBuildCISInfoVulnPassed(t *testing.T) {
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check22] Ensure log file validation (Scored)", Status: "PASS", Region: "eu-west-1"},
+			{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "PASS", Region: "us-east-1"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "PASS", Region: "eu-west-1"},
+		},
+	}
+	v, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{IncludePassed: true}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := report.ResourcesGroup{
+		Name:   "Passed Controls",
+		Header: []string{"Control", "Description", "Region"},
+		Rows: []map[string]string{
+			{"Control": "1.3", "Description": "Ensure credentials unused", "Region": "eu-west-1"},
+			{"Control": "1.3", "Description": "Ensure credentials unused", "Region": "us-east-1"},
+			{"Control": "2.2", "Description": "Ensure log file validation", "Region": "eu-west-1"},
+		},
+	}
+	if len(v.Resources) != 2 {
+		t.Fatalf("unexpected number of resources: %d", len(v.Resources))
+	}
+	if diff := cmp.Diff(want, v.Resources[1]); diff != "" {
+		t.Errorf("passed controls mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(v.Details, "Passed Controls: 3\n") {
+		t.Errorf("unexpected details: %s", v.Details)
+	}
+
+	v, err = buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v.Resources) != 1 {
+		t.Errorf("unexpected number of resources: %d", len(v.Resources))
+	}
+	if !strings.Contains(v.Details, "Passed Controls: 3\n") {
+		t.Errorf("unexpected details: %s", v.Details)
+	}
+}
+
+func TestResourcesTablesEscapeHTML(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.16": {
+			ID:              "1.16",
+			Severity:        3.9,
+			SeverityLiteral: "Low",
+			Remediation:     "https://example.com/1.16?a=1&b=2",
+		},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{
+				Control: "[check116] Ensure IAM policies are attached only to groups or roles (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: `User <img src=x onerror=alert(1)> has policy {"Condition":{"StringLike":"a&b"}} attached`,
+			},
+			{
+				Control: "[check11] Avoid the use of the root account <script>alert(1)</script> (Scored)",
+				Status:  "Info",
+				Region:  "eu-west-1",
+				Message: "Root user last used <b>today</b> & yesterday",
+			},
+		},
+	}
+	v, err := renderVuln(frameworkCIS, "2", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantFailed := []map[string]string{
+		{
+			"Control":      "1.16",
+			"Description":  "Ensure IAM policies are attached only to groups or roles",
+			"CIS Severity": "Low",
+			"Region":       "eu-west-1",
+			"Message":      "User &lt;img src=x onerror=alert(1)&gt; has policy {&#34;Condition&#34;:{&#34;StringLike&#34;:&#34;a&amp;b&#34;}} attached",
+			"References":   `<a href="https://example.com/1.16?a=1&amp;b=2">Reference</a>`,
+		},
+	}
+	if diff := cmp.Diff(wantFailed, fv.Resources[0].Rows); diff != "" {
+		t.Errorf("unexpected failed controls rows (-want +got):\n%v", diff)
+	}
+	infov, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantInfo := []map[string]string{
+		{
+			"Control":     "1.1",
+			"Description": "Avoid the use of the root account &lt;script&gt;alert(1)&lt;/script&gt;",
+			"Region":      "eu-west-1",
+			"Message":     "Root user last used &lt;b&gt;today&lt;/b&gt; &amp; yesterday",
+		},
+	}
+	if diff := cmp.Diff(wantInfo, infov.Resources[0].Rows); diff != "" {
+		t.Errorf("unexpected info controls rows (-want +got):\n%v", diff)
+	}
+}
+
+func TestBuildCISInfoVulnNotEvaluated(t *testing.T) {
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check25] Ensure AWS Config is enabled in all regions (Scored)", Status: prowlerparse.StatusNotEvaluated, Region: "eu-west-1", Message: "An error occurred (AccessDeniedException)", MissingPermission: "config:DescribeConfigurationRecorders"},
+			{Control: "[check25] Ensure AWS Config is enabled in all regions (Scored)", Status: prowlerparse.StatusNotEvaluated, Region: "us-east-1", Message: "An error occurred (AccessDeniedException)", MissingPermission: "config:DescribeConfigurationRecorders"},
+			{Control: "[extra73] Ensure there are no S3 buckets open to Everyone or Any AWS user", Status: prowlerparse.StatusNotEvaluated, Region: "eu-west-1", Message: "prowler failed to execute the control: exit status 1"},
+		},
+	}
+	v, err := buildCISInfoVuln(context.Background(), r, awsAccount{Alias: "alias"}, options{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := report.ResourcesGroup{
+		Name:   "Controls Not Evaluated",
+		Header: []string{"Control", "Description", "Region", "Missing Permission", "Reason"},
+		Rows: []map[string]string{
+			{"Control": "2.5", "Description": "Ensure AWS Config is enabled in all regions", "Region": "eu-west-1", "Missing Permission": "config:DescribeConfigurationRecorders", "Reason": "Access denied"},
+			{"Control": "2.5", "Description": "Ensure AWS Config is enabled in all regions", "Region": "us-east-1", "Missing Permission": "config:DescribeConfigurationRecorders", "Reason": "Access denied"},
+			{"Control": "extra73", "Description": "Ensure there are no S3 buckets open to Everyone or Any AWS user", "Region": "eu-west-1", "Missing Permission": "", "Reason": "prowler failed to execute the control: exit status 1"},
+		},
+	}
+	if len(v.Resources) != 2 {
+		t.Fatalf("unexpected number of resources: %d", len(v.Resources))
+	}
+	if diff := cmp.Diff(want, v.Resources[1]); diff != "" {
+		t.Errorf("not evaluated controls mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(v.Details, "Controls Not Evaluated (missing permissions): 2\n") {
+		t.Errorf("unexpected details: %s", v.Details)
+	}
+	if diff := cmp.Diff([]string{"2.5", "extra73"}, notEvaluatedControls(r.entries)); diff != "" {
+		t.Errorf("not evaluated controls mismatch (-want +got):\n%s", diff)
+	}
+	if got := r.summary().NotEvaluated; got != 3 {
+		t.Errorf("unexpected not evaluated entries in the summary: %d", got)
+	}
+}
+
+func TestPassedControlsTableTruncated(t *testing.T) {
+	var passed []entry
+	for i := 0; i < maxPassedRows+10; i++ {
+		passed = append(passed, entry{
+			Control: fmt.Sprintf("[extra%d] Extra control", 700+i),
+			Status:  "PASS",
+			Region:  "eu-west-1",
+		})
+	}
+	table, truncated := passedControlsTable(passed)
+	if !truncated {
+		t.Error("table not truncated")
+	}
+	if len(table.Rows) != maxPassedRows {
+		t.Errorf("unexpected number of rows: %d", len(table.Rows))
+	}
+}
+
+func TestBuildOptionsScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    *float32
+		wantErr bool
+	}{
+		{name: "unset", optJSON: `{}`},
+		{name: "high", optJSON: `{"score": 8.9}`, want: float32Ptr(8.9)},
+		{name: "zero", optJSON: `{"score": 0}`, want: float32Ptr(0)},
+		{name: "out of range", optJSON: `{"score": 10.1}`, wantErr: true},
+		{name: "negative", optJSON: `{"score": -1}`, wantErr: true},
+		{name: "invalid type", optJSON: `{"score": "High"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, opts.Score); diff != "" {
+				t.Errorf("unexpected score (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestFillCISLevelVulnScoreOverride(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 10, SeverityLiteral: "Critical"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1"},
+		},
+	}
+	opts := options{Score: float32Ptr(report.SeverityThresholdHigh)}
+	v, err := renderVuln(frameworkCIS, "", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v.Score = *opts.Score
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, opts, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fv.Score != report.SeverityThresholdHigh {
+		t.Errorf("unexpected score: %v", fv.Score)
+	}
+	if !strings.Contains(fv.Details, "Score: 8.9 (set by policy with the score option)\n") {
+		t.Errorf("unexpected details: %s", fv.Details)
+	}
+}
+
+func float32Ptr(f float32) *float32 {
+	return &f
+}
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func TestBuildOptionsSessionDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", optJSON: `{}`, want: 3600},
+		{name: "minimum", optJSON: `{"session_duration": 900}`, want: 900},
+		{name: "maximum", optJSON: `{"session_duration": 43200}`, want: 43200},
+		{name: "below minimum", optJSON: `{"session_duration": 899}`, wantErr: true},
+		{name: "above maximum", optJSON: `{"session_duration": 43201}`, wantErr: true},
+		{name: "negative", optJSON: `{"session_duration": -1}`, wantErr: true},
+		{name: "shorter than timeout", optJSON: `{"session_duration": 900, "timeout": 1800}`, want: 900},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if opts.SessionDuration != tt.want {
+				t.Errorf("unexpected session duration, want: %d, got: %d", tt.want, opts.SessionDuration)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    []string
+		wantErr bool
+	}{
+		{name: "unset", optJSON: `{}`},
+		{name: "labels", optJSON: `{"labels": ["team:payments", "env:prod"]}`, want: []string{"team:payments", "env:prod"}},
+		{name: "empty label", optJSON: `{"labels": ["team:payments", ""]}`, wantErr: true},
+		{name: "blank label", optJSON: `{"labels": [" "]}`, wantErr: true},
+		{name: "invalid type", optJSON: `{"labels": [1]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.want, opts.Labels); diff != "" {
+				t.Errorf("unexpected labels (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsExternalID(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		env     string
+		want    string
+	}{
+		{name: "unset", optJSON: `{}`},
+		{name: "option", optJSON: `{"external_id": "from-option"}`, want: "from-option"},
+		{name: "env", optJSON: `{}`, env: "from-env", want: "from-env"},
+		{name: "option takes precedence", optJSON: `{"external_id": "from-option"}`, env: "from-env", want: "from-option"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envExternalID, tt.env)
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.ExternalID != tt.want {
+				t.Errorf("unexpected external ID: got %q, want %q", opts.ExternalID, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddCustomLabels(t *testing.T) {
+	for _, framework := range []string{frameworkCIS, frameworkCISInfo} {
+		v, err := renderVuln(framework, "", "alias")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		builtin := append([]string{}, v.Labels...)
+		addLabels(&v, append([]string{"team:payments", "team:payments"}, builtin...)...)
+		want := append(builtin, "team:payments")
+		if diff := cmp.Diff(want, v.Labels); diff != "" {
+			t.Errorf("unexpected labels for %s (-want +got):\n%v", framework, diff)
+		}
+	}
+}
+
+func TestScanLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    []string
+	}{
+		{
+			name:    "Defaults",
+			optJSON: `{}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel2"},
+		},
+		{
+			name:    "Groups",
+			optJSON: `{"groups": ["extras", "GDPR", "cislevel1", "extras"]}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel1", "extras", "gdpr"},
+		},
+		{
+			name:    "GroupsNormalized",
+			optJSON: `{"groups": ["Group 1_IAM", " forensics-ready "]}`,
+			want:    []string{"compliance", "cis", "aws", "forensics-ready", "group-1-iam"},
+		},
+		{
+			name:    "SecurityLevel1",
+			optJSON: `{"security_level": 1}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel1", "cis-level-1"},
+		},
+		{
+			name:    "SecurityLevel0",
+			optJSON: `{"security_level": 0}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel1", "cis-level-1"},
+		},
+		{
+			name:    "SecurityLevel2",
+			optJSON: `{"security_level": "level2"}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel2", "cis-level-2"},
+		},
+		{
+			name:    "SecurityLevelOverridesGroups",
+			optJSON: `{"security_level": 2, "groups": ["extras"]}`,
+			want:    []string{"compliance", "cis", "aws", "cislevel2", "cis-level-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			groups, err := groupsFromOpts(opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v, err := renderVuln(frameworkCIS, templateLevel(opts.SecurityLevel), "alias")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The labels are added twice to check that they are not
+			// duplicated.
+			addLabels(&v, scanLabels(groups, opts.SecurityLevel)...)
+			addLabels(&v, scanLabels(groups, opts.SecurityLevel)...)
+			if diff := cmp.Diff(tt.want, v.Labels); diff != "" {
+				t.Errorf("unexpected labels (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestAccountDetails(t *testing.T) {
+	tests := []struct {
+		name string
+		acc  awsAccount
+		want string
+	}{
+		{
+			name: "Alias",
+			acc:  awsAccount{ID: "123456789012", Alias: "alias"},
+			want: "Account: alias\n",
+		},
+		{
+			name: "VerifiedID",
+			acc:  awsAccount{ID: "123456789012", Alias: "alias", VerifiedID: "123456789012"},
+			want: "Account: alias\nVerified Account ID: 123456789012\n",
+		},
+		{
+			name: "Management",
+			acc:  awsAccount{ID: "123456789012", Alias: "alias", VerifiedID: "123456789012", Management: true},
+			want: "Account: alias\nVerified Account ID: 123456789012\nOrganization Management Account: yes\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.acc.details()); diff != "" {
+				t.Errorf("details mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFillCISLevelVulnScore(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 8.9, SeverityLiteral: "High"},
+		"2.1": {ID: "2.1", Severity: 3.9, SeverityLiteral: "Low"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check13] Ensure credentials unused (Scored)", Status: "FAIL", Region: "eu-west-1"},
+		},
+	}
+	score := func(s float32) *float32 { return &s }
+	tests := []struct {
+		name        string
+		opts        options
+		want        float32
+		wantDetails string
+	}{
+		{
+			name:        "WorstControl",
+			want:        8.9,
+			wantDetails: "Score: 8.9 (CIS severity of the most severe failed control: 1.3)\n",
+		},
+		{
+			name:        "Fixed",
+			opts:        options{FixedScore: true},
+			want:        6.9,
+			wantDetails: "Account: alias\n\nFailed Controls: 2\n",
+		},
+		{
+			name:        "Ceiling",
+			opts:        options{ScoreCeiling: score(6.9)},
+			want:        6.9,
+			wantDetails: "Score: 6.9 (CIS severity of the most severe failed control: 1.3)\nScore Limited From: 8.9\n",
+		},
+		{
+			name:        "Floor",
+			opts:        options{ScoreFloor: score(9)},
+			want:        9,
+			wantDetails: "Score Limited From: 8.9\n",
+		},
+		{
+			name:        "ScoreOption",
+			opts:        options{Score: score(2)},
+			want:        2,
+			wantDetails: "Score: 2 (set by policy with the score option)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := renderVuln(frameworkCIS, "2", "alias")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.opts.Score != nil {
+				v.Score = *tt.opts.Score
+			}
+			fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, tt.opts, controls)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fv.Score != tt.want {
+				t.Errorf("unexpected score, want: %v, got: %v", tt.want, fv.Score)
+			}
+			if !strings.Contains(fv.Details, tt.wantDetails) {
+				t.Errorf("unexpected details: %s", fv.Details)
+			}
+			if !strings.Contains(fv.Details, "Compliance: 0% (passed 0, failed 2, info 0, not evaluated 0)\n") {
+				t.Errorf("unexpected compliance details: %s", fv.Details)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsScoreLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		wantErr bool
+	}{
+		{name: "Unset", optJSON: `{}`},
+		{name: "Valid", optJSON: `{"score_floor": 3.9, "score_ceiling": 8.9}`},
+		{name: "FloorOutOfRange", optJSON: `{"score_floor": -1}`, wantErr: true},
+		{name: "CeilingOutOfRange", optJSON: `{"score_ceiling": 11}`, wantErr: true},
+		{name: "FloorAboveCeiling", optJSON: `{"score_floor": 8.9, "score_ceiling": 3.9}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildOptions(tt.optJSON, "aws"); (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultBenchmarkVersion is the version of the CIS benchmark
+	// implemented by the prowler version the check is shipped with.
+	defaultBenchmarkVersion = "1.2"
+
+	// metadataMismatchStrict makes the check fail when the version of the
+	// controls metadata does not match the selected benchmark version.
+	metadataMismatchStrict = "strict"
+	// metadataMismatchLenient makes the check report the mismatch and go on
+	// with the scan.
+	metadataMismatchLenient = "lenient"
+)
+
+var benchmarkVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+){1,2}$`)
+
+// validateBenchmarkVersion returns an error if the version is not a valid
+// CIS benchmark version, e.g.: 1.2 or 1.4.0.
+func validateBenchmarkVersion(version string) error {
+	if !benchmarkVersionRegexp.MatchString(version) {
+		return fmt.Errorf("invalid benchmark_version %q", version)
+	}
+	return nil
+}
+
+// normalizeVersion removes the trailing zero patch number of a version, so
+// 1.2 and 1.2.0 are considered the same version.
+func normalizeVersion(version string) string {
+	if strings.Count(version, ".") == 2 {
+		return strings.TrimSuffix(version, ".0")
+	}
+	return version
+}
+
+// checkMetadataVersion compares the benchmark version of the controls
+// metadata with the selected one. It returns true if they do not match. In
+// strict mode a mismatch is returned as an error.
+func checkMetadataVersion(benchmark, metadata, mode string) (bool, error) {
+	if normalizeVersion(benchmark) == normalizeVersion(metadata) {
+		return false, nil
+	}
+	if mode == metadataMismatchLenient {
+		return true, nil
+	}
+	return true, fmt.Errorf("metadata version mismatch: the controls metadata is for CIS benchmark %s but the selected version is %s", metadata, benchmark)
+}
+
+// metadataMismatchWarning returns the warning added to the Details of the
+// vulnerabilities when the check runs with metadata for a benchmark version
+// different from the selected one.
+func metadataMismatchWarning(benchmark, metadata string) string {
+	return fmt.Sprintf("WARNING: metadata version mismatch: the CIS benchmark version selected is %s but the controls metadata is for version %s, severities and references could be wrong.\n\n", benchmark, metadata)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import "testing"
+
+func TestCheckMetadataVersion(t *testing.T) {
+	tests := []struct {
+		name         string
+		benchmark    string
+		metadata     string
+		mode         string
+		wantMismatch bool
+		wantErr      bool
+	}{
+		{
+			name:      "Match",
+			benchmark: "1.2",
+			metadata:  "1.2",
+			mode:      metadataMismatchStrict,
+		},
+		{
+			name:      "MatchPatchZero",
+			benchmark: "1.2.0",
+			metadata:  "1.2",
+			mode:      metadataMismatchStrict,
+		},
+		{
+			name:         "MismatchStrict",
+			benchmark:    "1.5",
+			metadata:     "1.2",
+			mode:         metadataMismatchStrict,
+			wantMismatch: true,
+			wantErr:      true,
+		},
+		{
+			name:         "MismatchLenient",
+			benchmark:    "1.5",
+			metadata:     "1.2",
+			mode:         metadataMismatchLenient,
+			wantMismatch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatch, err := checkMetadataVersion(tt.benchmark, tt.metadata, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if mismatch != tt.wantMismatch {
+				t.Errorf("unexpected mismatch: %v", mismatch)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsBenchmarkVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		wantErr bool
+	}{
+		{name: "Default", optJSON: `{}`},
+		{name: "Lenient", optJSON: `{"benchmark_version":"1.5","metadata_mismatch":"lenient"}`},
+		{name: "InvalidVersion", optJSON: `{"benchmark_version":"v1.5"}`, wantErr: true},
+		{name: "InvalidMode", optJSON: `{"metadata_mismatch":"ignore"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
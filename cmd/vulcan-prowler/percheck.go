@@ -0,0 +1,61 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// defaultMaxNotExecutedRatio is the default maximum ratio of the executions
+// of the controls that can fail when the controls are executed individually.
+const defaultMaxNotExecutedRatio = 0.2
+
+// checkControl returns the raw control, as written by prowler in its
+// reports but without description, of the control with the given
+// identifier, e.g.: "[check113] " for the control 1.13.
+func checkControl(id string) string {
+	version := 2
+	if strings.Contains(id, "_") {
+		version = 3
+	}
+	name, err := prowlerCheckName(id, version)
+	if err != nil {
+		name = id
+	}
+	return "[" + name + "] "
+}
+
+// addNotExecuted adds to the report one entry with the status
+// [prowlerparse.StatusNotEvaluated] for each control that prowler failed to
+// execute. total is the number of executions of the controls. It returns an
+// error if the ratio of failed executions is greater than maxRatio.
+func addNotExecuted(ctx context.Context, r *prowlerReport, total int, maxRatio float64) error {
+	var errs []error
+	for _, f := range r.failedUnits {
+		if f.unit.check == "" {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", f.unit, f.err))
+		r.entries = append(r.entries, entry{
+			Control: checkControl(f.unit.check),
+			Status:  prowlerparse.StatusNotEvaluated,
+			Region:  f.unit.region,
+			Message: fmt.Sprintf("prowler failed to execute the control: %v", f.err),
+		})
+	}
+	if total == 0 || len(errs) == 0 {
+		return nil
+	}
+	if ratio := float64(len(errs)) / float64(total); ratio > maxRatio {
+		return fmt.Errorf("prowler failed to execute %d of %d controls, more than the allowed ratio %v: %w", len(errs), total, maxRatio, errors.Join(errs...))
+	}
+	ctxLogger(ctx).Warnf("prowler failed to execute %d of %d controls", len(errs), total)
+	return nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+func TestProwlerCheckName(t *testing.T) {
+	tests := []struct {
+		name    string
+		control string
+		version int
+		want    string
+		wantErr bool
+	}{
+		{name: "CIS", control: "1.13", version: 2, want: "check113"},
+		{name: "CIS one digit", control: "2.1", version: 2, want: "check21"},
+		{name: "Extra", control: "extra718", version: 2, want: "extra718"},
+		{name: "V3", control: "iam_root_mfa_enabled", version: 3, want: "iam_root_mfa_enabled"},
+		{name: "V2 control in V3", control: "1.13", version: 3, wantErr: true},
+		{name: "Invalid", control: "1.x", version: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prowlerCheckName(tt.control, tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected check, want: %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScopeControls(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.1":      {ID: "1.1", Level: 1},
+		"1.2":      {ID: "1.2", Level: 1},
+		"1.13":     {ID: "1.13", Level: 1},
+		"2.5":      {ID: "2.5", Level: 2},
+		"extra718": {ID: "extra718", Level: 2},
+	}
+	got := scopeControls([]string{"cislevel1"}, []string{"-E", "check11"}, controls)
+	if diff := cmp.Diff([]string{"1.2", "1.13"}, got); diff != "" {
+		t.Errorf("unexpected controls (-want +got):\n%v", diff)
+	}
+}
+
+func TestAddNotExecuted(t *testing.T) {
+	errFailed := errors.New("exit status 1")
+	newReport := func() *prowlerReport {
+		return &prowlerReport{
+			entries: []entry{{Control: "[check11] Avoid the use of the root account", Status: "PASS", Region: "eu-west-1"}},
+			failedUnits: []unitFailure{
+				{unit: scanUnit{region: "eu-west-1", check: "1.13"}, err: errFailed},
+				{unit: scanUnit{region: "eu-west-1", check: "extra718"}, err: errFailed},
+			},
+		}
+	}
+
+	r := newReport()
+	if err := addNotExecuted(context.Background(), r, 10, 0.2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []entry{
+		{Control: "[check11] Avoid the use of the root account", Status: "PASS", Region: "eu-west-1"},
+		{Control: "[check113] ", Status: prowlerparse.StatusNotEvaluated, Region: "eu-west-1", Message: "prowler failed to execute the control: exit status 1"},
+		{Control: "[extra718] ", Status: prowlerparse.StatusNotEvaluated, Region: "eu-west-1", Message: "prowler failed to execute the control: exit status 1"},
+	}
+	if diff := cmp.Diff(want, r.entries); diff != "" {
+		t.Errorf("unexpected entries (-want +got):\n%v", diff)
+	}
+	if diff := cmp.Diff([]string{"1.13", "extra718"}, notEvaluatedControls(r.entries)); diff != "" {
+		t.Errorf("unexpected not evaluated controls (-want +got):\n%v", diff)
+	}
+
+	if err := addNotExecuted(context.Background(), newReport(), 5, 0.2); !errors.Is(err, errFailed) {
+		t.Errorf("error %v is not %v", err, errFailed)
+	}
+}
+
+func TestRunProwlerCheck(t *testing.T) {
+	dir := setupFakeProwler(t)
+	cfg := prowlerConfig{
+		Check: "1.13",
+		Env: []string{
+			"REPORT_DIR=" + dir,
+			`FAKE_REPORT={"Control":"[check113] Ensure MFA is enabled for the root account","Status":"PASS","Region":"eu-west-1"}`,
+		},
+	}
+	r, err := runProwler(context.Background(), "eu-west-1", cfg, reportName+"-eu-west-1-1.13")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.entries) != 1 {
+		t.Errorf("unexpected entries: %v", r.entries)
+	}
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := strings.Fields(string(args))
+	if diff := cmp.Diff([]string{"-M", "json", "-F", "report-eu-west-1-1.13", "-o"}, got[:5]); diff != "" {
+		t.Errorf("unexpected args (-want +got):\n%v", diff)
+	}
+	if diff := cmp.Diff([]string{"-r", "eu-west-1", "-f", "eu-west-1", "-c", "check113"}, got[6:]); diff != "" {
+		t.Errorf("unexpected args (-want +got):\n%v", diff)
+	}
+}
+
+func TestBuildOptionsMaxNotExecutedRatio(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    float64
+		wantErr bool
+	}{
+		{name: "Default", optJSON: `{"per_check_execution": true}`, want: defaultMaxNotExecutedRatio},
+		{name: "Strict", optJSON: `{"per_check_execution": true, "max_not_executed_ratio": 0}`, want: 0},
+		{name: "Custom", optJSON: `{"max_not_executed_ratio": 0.5}`, want: 0.5},
+		{name: "Negative", optJSON: `{"max_not_executed_ratio": -0.1}`, wantErr: true},
+		{name: "GreaterThanOne", optJSON: `{"max_not_executed_ratio": 1.5}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if *opts.MaxNotExecutedRatio != tt.want {
+				t.Errorf("unexpected ratio, want: %v, got: %v", tt.want, *opts.MaxNotExecutedRatio)
+			}
+		})
+	}
+}
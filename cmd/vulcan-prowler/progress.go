@@ -0,0 +1,140 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+const (
+	// progressEveryControls is the number of new controls processed by
+	// prowler after which the progress is reported.
+	progressEveryControls = 10
+	// progressInterval is the time after which the progress is reported if
+	// prowler processed any new control.
+	progressInterval = 30 * time.Second
+)
+
+// progressTracker computes the progress of the scan from the output of the
+// prowler executions while they run. Each execution, one per region when
+// the scan is split by region, has the same weight in the progress. It is
+// safe for concurrent use.
+type progressTracker struct {
+	// expected is the number of controls executed by each execution of
+	// prowler.
+	expected int
+	runs     int
+	report   func(progress float32)
+	now      func() time.Time
+
+	mu         sync.Mutex
+	seen       map[string]map[string]bool
+	finished   map[string]bool
+	pending    int
+	lastReport time.Time
+}
+
+// newProgressTracker returns a tracker for the given number of executions
+// of prowler, each executing the given number of controls, that reports the
+// progress using the report function.
+func newProgressTracker(expected, runs int, report func(progress float32)) *progressTracker {
+	if runs < 1 {
+		runs = 1
+	}
+	return &progressTracker{
+		expected:   expected,
+		runs:       runs,
+		report:     report,
+		now:        time.Now,
+		seen:       map[string]map[string]bool{},
+		finished:   map[string]bool{},
+		lastReport: time.Now(),
+	}
+}
+
+// observe processes a line written by prowler to its standard output in the
+// given execution. The lines that are not findings are ignored.
+func (p *progressTracker) observe(run string, line []byte) {
+	fd, ok, err := prowlerparse.ParseLine(line)
+	if err != nil || !ok || fd.ID == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seen := p.seen[run]
+	if seen == nil {
+		seen = map[string]bool{}
+		p.seen[run] = seen
+	}
+	if seen[fd.ID] {
+		return
+	}
+	seen[fd.ID] = true
+	p.pending++
+	if p.pending >= progressEveryControls || p.now().Sub(p.lastReport) >= progressInterval {
+		p.reportLocked()
+	}
+}
+
+// finish marks the given execution as finished, whether it succeeded or
+// not, and reports the progress.
+func (p *progressTracker) finish(run string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished[run] = true
+	p.reportLocked()
+}
+
+func (p *progressTracker) reportLocked() {
+	p.pending = 0
+	p.lastReport = p.now()
+	p.report(p.progressLocked())
+}
+
+func (p *progressTracker) progressLocked() float32 {
+	if p.expected <= 0 {
+		return float32(len(p.finished)) / float32(p.runs)
+	}
+	done := 0
+	for run, seen := range p.seen {
+		if !p.finished[run] {
+			done += min(len(seen), p.expected)
+		}
+	}
+	done += len(p.finished) * p.expected
+	return min(float32(done)/float32(p.runs*p.expected), 1)
+}
+
+// lineWriter is a writer that calls a function for each complete line
+// written to it.
+type lineWriter struct {
+	fn  func(line []byte)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush calls the function with the incomplete line written last, if any.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.fn(w.buf)
+		w.buf = nil
+	}
+}
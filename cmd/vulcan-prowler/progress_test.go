@@ -0,0 +1,102 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// findingLine returns a line of a prowler JSON report for the given control.
+func findingLine(id string) []byte {
+	return []byte(fmt.Sprintf(`{"Control":"[check%s] Control %s","Status":"PASS","Region":"eu-west-1"}`, id, id))
+}
+
+func TestProgressTracker(t *testing.T) {
+	var reported []float32
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := newProgressTracker(40, 2, func(progress float32) {
+		reported = append(reported, progress)
+	})
+	p.now = func() time.Time { return now }
+	p.lastReport = now
+
+	// 10 new controls in the first region trigger a report.
+	for i := 1; i <= 10; i++ {
+		p.observe("eu-west-1", findingLine(fmt.Sprintf("1%d", i)))
+	}
+	// Repeated controls, non finding lines and findings of unknown
+	// controls are ignored.
+	p.observe("eu-west-1", findingLine("11"))
+	p.observe("eu-west-1", []byte("Colors code for results:"))
+	p.observe("eu-west-1", []byte(`{"Control":"unknown","Status":"PASS"}`))
+	// A single new control is reported after the progress interval.
+	now = now.Add(progressInterval)
+	p.observe("us-east-1", findingLine("21"))
+	// Finishing a region counts all its controls as processed.
+	p.finish("eu-west-1")
+	p.finish("us-east-1")
+
+	want := []float32{10.0 / 80, 11.0 / 80, 41.0 / 80, 1}
+	if diff := cmp.Diff(want, reported); diff != "" {
+		t.Errorf("reported progress mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressTrackerNoExpectedControls(t *testing.T) {
+	var reported []float32
+	p := newProgressTracker(0, 0, func(progress float32) {
+		reported = append(reported, progress)
+	})
+	p.observe("", findingLine("11"))
+	p.finish("")
+	if diff := cmp.Diff([]float32{1}, reported); diff != "" {
+		t.Errorf("reported progress mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line []byte) { lines = append(lines, string(line)) }}
+	for _, s := range []string{"first", " line\nsecond line\nthi", "rd", " line"} {
+		w.Write([]byte(s))
+	}
+	w.flush()
+	want := []string{"first line", "second line", "third line"}
+	if diff := cmp.Diff(want, lines); diff != "" {
+		t.Errorf("lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteStream(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	onLine := func(line []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, string(line))
+	}
+	script := `echo one; sleep 0.1; echo two; printf three`
+	output, _, usage, err := executeStream(context.Background(), nil, onLine, nil, "/bin/sh", "-c", script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"one", "two", "three"}, lines); diff != "" {
+		t.Errorf("lines mismatch (-want +got):\n%s", diff)
+	}
+	if string(output) != "one\ntwo\nthree" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if usage.OutputBytes != int64(len(output)) {
+		t.Errorf("unexpected output bytes: %d", usage.OutputBytes)
+	}
+}
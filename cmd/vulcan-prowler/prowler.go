@@ -5,96 +5,472 @@ Copyright 2020 Adevinta
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adevinta/vulcan-check-sdk/helpers/command"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
 )
 
 const (
-	prowlerCmd     = `/prowler/prowler`
-	reportFormat   = `json`
-	reportName     = `report`
-	reportLocation = `/prowler/output/report.json`
+	reportFormat = `json`
+	reportName   = `report`
+
+	// defaultProwlerMajorVersion is the major version of the prowler
+	// installed in the image of the check.
+	defaultProwlerMajorVersion = 2
+	// unknownProwlerVersion is reported when the version of prowler can
+	// not be determined.
+	unknownProwlerVersion = "unknown"
+)
+
+var (
+	prowlerCmd = `/prowler/prowler`
+	// reportDir is the directory in which the output directory of each
+	// execution of prowler is created. If it is empty, the default
+	// directory for temporary files is used.
+	reportDir = ``
 )
 
+// prowlerConfig contains the parameters shared by all the executions of
+// prowler.
+type prowlerConfig struct {
+	Groups []string
+	// ExtraArgs are appended to the parameters of prowler.
+	ExtraArgs []string
+	// Env contains env vars added to the environment of prowler.
+	Env []string
+	// Progress, if not nil, tracks the progress of the executions of
+	// prowler.
+	Progress *progressTracker
+	// Check, if not empty, makes prowler execute only the given control
+	// instead of the groups.
+	Check string
+	// AllowEmptyReport makes an execution of prowler that does not report
+	// any entry for groups known to contain scored controls be logged as a
+	// warning instead of failing.
+	AllowEmptyReport bool
+	// Version is the version of prowler, e.g.: 2.12.1, detected once per
+	// check. MajorVersion selects the parameters of prowler, the major
+	// version in the image is assumed when it is 0.
+	Version      string
+	MajorVersion int
+}
+
+// withCredentials returns a copy of the config that makes prowler use the
+// given credentials.
+func (c prowlerConfig) withCredentials(creds awsCredentials) prowlerConfig {
+	env := make([]string, 0, len(c.Env)+3)
+	env = append(env, c.Env...)
+	c.Env = append(env, creds.env()...)
+	return c
+}
+
+// expiredTokenErrors are the AWS error codes written by prowler when the
+// credentials it uses expire.
+var expiredTokenErrors = [][]byte{
+	[]byte("ExpiredToken"),
+	[]byte("RequestExpired"),
+}
+
+// isExpiredTokenOutput returns true if the output of prowler shows that its
+// credentials expired.
+func isExpiredTokenOutput(output []byte) bool {
+	for _, e := range expiredTokenErrors {
+		if bytes.Contains(output, e) {
+			return true
+		}
+	}
+	return false
+}
+
 type prowlerReport struct {
-	entries []entry
+	entries    []entry
+	thresholds thresholdStats
+	// groups contains the groups executed by prowler.
+	groups []string
+	// truncated is true when prowler was stopped because the scan exceeded
+	// the configured timeout. In that case entries only contains the
+	// results written by prowler before being stopped.
+	truncated bool
+	// usage contains the resources consumed by prowler.
+	usage resourceUsage
+	// failedRegions contains the regions in which, at least, one of the
+	// executions of prowler failed.
+	failedRegions []string
+	// regionDurations contains the time spent running prowler in each
+	// region, adding the executions of all the groups.
+	regionDurations map[string]time.Duration
+	// retries contains the units that were retried because AWS throttled
+	// prowler.
+	retries []unitRetries
+	// failedUnits contains the units in which prowler failed.
+	failedUnits []unitFailure
+	// skipped contains the controls of the benchmark that were not
+	// executed.
+	skipped []skippedControl
+	// exposures contains the current exposure of the buckets referenced by
+	// the failed S3 controls. It is nil if the exposure was not retrieved.
+	exposures s3Exposures
+	// credentialRefreshes is the number of times the credentials had to be
+	// refreshed during the scan.
+	credentialRefreshes int
+	// warnings contains the errors of the executions of prowler that failed
+	// after writing some results.
+	warnings []string
+	// levels contains the results of the controls of each CIS level. It is
+	// nil if not all the CIS controls were executed.
+	levels []levelSummary
+	// version is the version of prowler that generated the report, e.g.:
+	// 2.12.1.
+	version string
+}
+
+// regions returns the sorted list of regions with, at least, one entry in the
+// report.
+func (r *prowlerReport) regions() []string {
+	seen := map[string]bool{}
+	var regions []string
+	for _, e := range r.entries {
+		if e.Region == "" || seen[e.Region] {
+			continue
+		}
+		seen[e.Region] = true
+		regions = append(regions, e.Region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// summary returns the number of entries of the report by status.
+func (r *prowlerReport) summary() resultsSummary {
+	s := resultsSummary{Total: len(r.entries), Truncated: r.truncated}
+	for _, e := range r.entries {
+		switch e.Status {
+		case "PASS":
+			s.Passed++
+		case "FAIL":
+			s.Failed++
+		case "Info":
+			s.Info++
+		case prowlerparse.StatusNotEvaluated:
+			s.NotEvaluated++
+		case statusWarning:
+			s.Warning++
+		default:
+			s.Unknown++
+		}
+	}
+	if n := s.Passed + s.Failed + s.NotEvaluated; n > 0 {
+		pct := math.Round(float64(s.Passed)/float64(n)*10000) / 100
+		s.CompliancePct = &pct
+	}
+	return s
 }
 
-type entry struct {
-	Profile    string
-	Account    string `json:"Account Number"`
-	Control    string
-	Message    string
-	Status     string
-	Scored     string
-	Level      string
-	ControlID  string `json:"Control ID"`
-	Region     string
-	Timestamp  string
-	Compliance string
-	Service    string
+// otherStatusesDetails returns the lines of the details of a vulnerability
+// with the number of entries suppressed by an allowlist and with an unknown
+// status, if any.
+func (s resultsSummary) otherStatusesDetails() string {
+	var details string
+	if s.Warning > 0 {
+		details += fmt.Sprintf("Suppressed / Warning Controls: %d\n", s.Warning)
+	}
+	if s.Unknown > 0 {
+		details += fmt.Sprintf("Controls With Unknown Status: %d\n", s.Unknown)
+	}
+	return details
+}
+
+// complianceDetails returns the line of the details of a vulnerability with
+// the compliance percentage and the number of entries by status.
+func (s resultsSummary) complianceDetails() string {
+	pct := "n/a"
+	if s.CompliancePct != nil {
+		pct = fmt.Sprintf("%v%%", *s.CompliancePct)
+	}
+	return fmt.Sprintf("Compliance: %s (passed %d, failed %d, info %d, not evaluated %d)\n",
+		pct, s.Passed, s.Failed, s.Info, s.NotEvaluated)
+}
+
+type entry = prowlerparse.Finding
+
+// statusWarning is the status of the entries of the findings suppressed by
+// the allowlist of prowler.
+const statusWarning = "WARNING"
+
+// prowlerVersionRegexp extracts the version and the major version from the
+// output of "prowler -V", e.g.: "Prowler 2.12.1" or "Prowler 3.11.3
+// (latest)".
+var prowlerVersionRegexp = regexp.MustCompile(`(?i)prowler\s+v?((\d+)\.\d+(?:\.\d+)?)`)
+
+// v3Compliance maps the prowler v2 groups to the compliance frameworks of
+// prowler v3 that execute the same controls.
+var v3Compliance = map[string]string{
+	"cislevel1": "cis_1.5_aws",
+	"cislevel2": "cis_1.5_aws",
+	"group1":    "cis_1.5_aws",
+	"group2":    "cis_1.5_aws",
+	"group3":    "cis_1.5_aws",
+	"group4":    "cis_1.5_aws",
+}
+
+// prowlerVersion returns the version, e.g.: 2.12.1, and the major version of
+// the installed prowler. Prowler v2 prints its version with the flag -V,
+// while prowler v3 only supports --version.
+func prowlerVersion(ctx context.Context) (string, int, error) {
+	var out []byte
+	for _, flag := range []string{"-V", "--version"} {
+		output, status, err := command.Execute(ctx, logger, prowlerCmd, flag)
+		if err != nil {
+			return "", 0, err
+		}
+		out = output
+		if status != 0 {
+			continue
+		}
+		if m := prowlerVersionRegexp.FindSubmatch(output); m != nil {
+			major, err := strconv.Atoi(string(m[2]))
+			return string(m[1]), major, err
+		}
+	}
+	return "", 0, fmt.Errorf("can not read the prowler version from: %s", bytes.TrimSpace(out))
 }
 
 /*
 	Command example:
-		prowler -r eu-west-1 -g cislevel1 -T 3600 -M json -F report
+		prowler -r eu-west-1 -g cislevel1 -T 3600 -M json -F report -o /tmp/prowler-123
+
+	Output available at /tmp/prowler-123/report.json
 
-	Output available at /prowler/output/report.json
+	Prowler v3 command example:
+		prowler aws --compliance cis_1.5_aws -M json -F report -o /tmp/prowler-123 -f eu-west-1
 */
 
-func buildParams(region string, groups []string) []string {
-	params := []string{
-		"-g", strings.Join(groups, ","),
-		"-M", reportFormat,
-		"-F", reportName,
+func buildParams(region string, groups []string, extraArgs []string, name, outDir string) []string {
+	var params []string
+	if len(groups) > 0 {
+		params = append(params, "-g", strings.Join(groups, ","))
 	}
+	params = append(params,
+		"-M", reportFormat,
+		"-F", name,
+		"-o", outDir,
+	)
 	if region != "" {
 		params = append(params, "-r", region, "-f", region)
 	} else {
 		params = append(params, "-r", defaultAPIRegion)
 	}
+	params = append(params, extraArgs...)
 	return params
 }
 
-func runProwler(ctx context.Context, region string, groups []string) (*prowlerReport, error) {
-	logger.Infof("using region: %+v, and groups: %+v", region, groups)
-	params := buildParams(region, groups)
+// cisControlRegexp matches the identifiers of the CIS controls in the
+// metadata, e.g.: 1.13.
+var cisControlRegexp = regexp.MustCompile(`^(\d)\.(\d+)$`)
 
-	version, _, err := command.Execute(ctx, logger, prowlerCmd, "-V")
+// prowlerCheckName returns the name of the check that executes the control
+// with the given identifier in the given major version of prowler, e.g.:
+// check113 for the control 1.13 in prowler v2.
+func prowlerCheckName(control string, version int) (string, error) {
+	if version >= 3 {
+		if _, _, err := prowlerparse.ParseControl("[" + control + "] "); err != nil || !strings.Contains(control, "_") {
+			return "", fmt.Errorf("control %q is not a prowler v3 check", control)
+		}
+		return control, nil
+	}
+	if prowlerparse.IsExtra(control) {
+		return control, nil
+	}
+	m := cisControlRegexp.FindStringSubmatch(control)
+	if m == nil {
+		return "", fmt.Errorf("control %q is not a prowler v2 check", control)
+	}
+	return "check" + m[1] + m[2], nil
+}
+
+// buildParamsV3 returns the parameters of prowler v3 equivalent to the ones
+// returned by buildParams. Prowler v3 has no groups, so they are translated to
+// the compliance frameworks that contain their controls.
+func buildParamsV3(region string, groups []string, extraArgs []string, name, outDir string) ([]string, error) {
+	var frameworks []string
+	seen := map[string]bool{}
+	for _, g := range groups {
+		f, ok := v3Compliance[g]
+		if !ok {
+			return nil, fmt.Errorf("group %q is not supported by prowler v3", g)
+		}
+		if !seen[f] {
+			seen[f] = true
+			frameworks = append(frameworks, f)
+		}
+	}
+	params := []string{"aws"}
+	if len(frameworks) > 0 {
+		params = append(params, "--compliance")
+		params = append(params, frameworks...)
+	}
+	params = append(params,
+		"-M", reportFormat,
+		"-F", name,
+		"-o", outDir,
+	)
+	if region != "" {
+		params = append(params, "-f", region)
+	}
+	params = append(params, extraArgs...)
+	return params, nil
+}
+
+// runProwler executes prowler and parses the report it generates, that is
+// written in the file with the given name in a temporary directory created
+// for the execution and removed when it finishes. If the context has a
+// deadline and it is exceeded, prowler is killed and the returned report
+// contains the results written before that happened. If the context is
+// canceled, prowler is killed, the partial report is discarded and the error
+// of the context is returned.
+func runProwler(ctx context.Context, region string, cfg prowlerConfig, name string) (*prowlerReport, error) {
+	log := ctxLogger(ctx).WithField("region", regionLabel(region))
+	groups := cfg.Groups
+	log.Infof("using region: %+v, and groups: %+v", region, groups)
+	version := cfg.MajorVersion
+	if version == 0 {
+		version = defaultProwlerMajorVersion
+	}
+	extraArgs := cfg.ExtraArgs
+	if cfg.Check != "" {
+		check, err := prowlerCheckName(cfg.Check, version)
+		if err != nil {
+			return nil, err
+		}
+		groups = nil
+		extraArgs = append([]string{"-c", check}, extraArgs...)
+	}
+	outDir, err := os.MkdirTemp(reportDir, "prowler-")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("can not create the output directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(outDir); err != nil {
+			log.Warnf("can not remove the output directory %s: %v", outDir, err)
+		}
+	}()
+	params := buildParams(region, groups, extraArgs, name, outDir)
+	if version >= 3 {
+		params, err = buildParamsV3(region, groups, extraArgs, name, outDir)
+		if err != nil {
+			return nil, err
+		}
 	}
-	logger.Infof("prowler version: %s", version)
 
-	output, status, err := command.Execute(ctx, logger, prowlerCmd, params...)
+	log.WithField("params", params).Info("executing prowler")
+	var onLine func(line []byte)
+	if cfg.Progress != nil {
+		onLine = func(line []byte) { cfg.Progress.observe(region, line) }
+	}
+	stderr := &tailBuffer{max: maxStderrSize}
+	output, status, usage, err := executeStream(ctx, cfg.Env, onLine, stderr, prowlerCmd, params...)
 	if err != nil {
 		return nil, err
 	}
-	logger.Infof("exit status: %v", status)
-	logger.Debugf("prowler output: %s", output)
+	failed := ctx.Err() == nil && prowlerFailed(status)
+	if failed {
+		log.Errorf("prowler exited with status %d, stderr: %s", status, stderr)
+	}
+	log.Infof("prowler resource usage: %+v", usage)
+	log.Infof("exit status: %v", status)
+	log.Debugf("prowler output: %s", output)
+	if ctx.Err() == nil && isExpiredTokenOutput(output) {
+		return nil, errCredentialsExpired
+	}
+	if failed && isThrottledOutput(stderr.String(), string(output)) {
+		return nil, fmt.Errorf("%w: %v", errProwlerThrottled, stderrError(status, stderr.String()))
+	}
+
+	truncated := false
+	switch ctx.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		log.Warn("prowler timed out, reporting partial results")
+		truncated = true
+	default:
+		// The scan has been aborted, the partial results are discarded.
+		return nil, ctx.Err()
+	}
 
-	fileReport, err := os.ReadFile(reportLocation)
+	fileReport, err := os.ReadFile(filepath.Join(outDir, name+"."+reportFormat))
 	if err != nil {
+		if truncated {
+			return nil, fmt.Errorf("prowler timed out before writing any results: %w", err)
+		}
+		if failed {
+			return nil, stderrError(status, stderr.String())
+		}
+		if os.IsNotExist(err) {
+			// Prowler writes, at least, one entry per executed control,
+			// so a successful execution always generates the report.
+			return nil, fmt.Errorf("%w: prowler exited with status %d, stderr: %s", errProwlerNoReport, status, stderr)
+		}
 		return nil, err
 	}
-	logger.Debugf("file report: %s", fileReport)
+	log.Debugf("file report: %s", fileReport)
 
-	scanner := bufio.NewScanner(bytes.NewReader(fileReport))
-	var report prowlerReport
-	for scanner.Scan() {
-		var e entry
-		if err := json.Unmarshal([]byte(scanner.Text()), &e); err != nil {
-			logger.Errorf("output line: %v", scanner.Text())
+	// Prowler is executed in JSON mode, but the reports in the CSV format
+	// are still accepted so the check can read the reports written by the
+	// images that predate it. The reports of prowler v2 and v3 are
+	// distinguished by their shape.
+	parsed, err := prowlerparse.ParseReport(bytes.NewReader(fileReport))
+	findings, stats := parsed.Findings, parsed.Stats
+	if err != nil {
+		// The last line of the report written by a prowler process that has
+		// been killed can be incomplete.
+		if !truncated && !failed {
 			return nil, err
 		}
-		report.entries = append(report.entries, e)
+		log.Warnf("ignoring the end of the partial report: %v", err)
+	}
+	log.Infof("prowler report: %d findings, by status: %v", stats.Findings, stats.ByStatus)
+	if failed && len(findings) == 0 {
+		return nil, stderrError(status, stderr.String())
+	}
+	if len(findings) == 0 && !truncated {
+		// An empty report usually means that prowler did not execute the
+		// expected checks, e.g.: the group name is wrong, so it must not be
+		// reported as a clean scan.
+		err := emptyReportError(region, groups, output, stderr.String())
+		switch {
+		case cfg.AllowEmptyReport || !slices.ContainsFunc(groups, hasScoredControls):
+			log.Warn(err)
+		default:
+			return nil, err
+		}
+	}
+	report := prowlerReport{
+		entries:   findings,
+		groups:    groups,
+		truncated: truncated,
+		usage:     usage,
+		version:   cfg.Version,
+	}
+	if failed {
+		// Prowler failed after writing some results, they are reported
+		// together with the reason of the failure.
+		report.warnings = []string{stderrError(status, stderr.String()).Error()}
+		if region != "" {
+			report.warnings[0] = fmt.Sprintf("region %s: %s", region, report.warnings[0])
+		}
 	}
-
 	return &report, nil
 }
@@ -0,0 +1,540 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// fakeProwlerScript is a fake prowler that writes the report in the env var
+// FAKE_REPORT to the output directory, writes FAKE_STDERR to its standard error and exits with the
+// status in FAKE_STATUS. It behaves as prowler v3 when the env var
+// FAKE_VERSION is 3 and it fails to print its version when FAKE_VERSION is
+// unknown. The arguments it receives are written to the file args
+// in the directory REPORT_DIR, and the version flags are appended to the
+// file versions of the same directory.
+const fakeProwlerScript = `#!/bin/sh
+if [ -n "$REPORT_DIR" ] && { [ "$1" = "-V" ] || [ "$1" = "--version" ]; }; then
+	echo "$1" >> "$REPORT_DIR/versions"
+fi
+if [ "$FAKE_VERSION" = "unknown" ] && { [ "$1" = "-V" ] || [ "$1" = "--version" ]; }; then
+	echo "prowler: unexpected error" >&2
+	exit 1
+fi
+if [ "$1" = "-V" ]; then
+	if [ "$FAKE_VERSION" = "3" ]; then
+		echo "prowler: error: unrecognized arguments: -V" >&2
+		exit 2
+	fi
+	echo "Prowler 2.12.0"
+	exit 0
+fi
+if [ "$1" = "--version" ]; then
+	echo "Prowler 3.11.3 (You are running the latest version, yay!)"
+	exit 0
+fi
+echo "$@" > "$REPORT_DIR/args"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-F" ]; then
+		name="$2"
+	fi
+	if [ "$1" = "-o" ]; then
+		out="$2"
+	fi
+	shift
+done
+if [ -n "$FAKE_REPORT" ]; then
+	printf '%s\n' "$FAKE_REPORT" > "$out/$name.json"
+fi
+printf '%s\n' "$FAKE_STDERR" >&2
+exit "${FAKE_STATUS:-0}"
+`
+
+// setupFakeProwler makes runProwler execute the fake prowler script and
+// returns the directory where the reports are written.
+func setupFakeProwler(t *testing.T) string {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "prowler")
+	if err := os.WriteFile(script, []byte(fakeProwlerScript), 0o755); err != nil {
+		t.Fatalf("can not write fake prowler: %v", err)
+	}
+	oldCmd, oldDir := prowlerCmd, reportDir
+	t.Cleanup(func() { prowlerCmd, reportDir = oldCmd, oldDir })
+	prowlerCmd = script
+	reportDir = t.TempDir()
+	return reportDir
+}
+
+func TestRunProwlerStderr(t *testing.T) {
+	const finding = `{"Control":"[check11] Avoid the use of the root account","Status":"PASS","Region":"eu-west-1"}`
+	tests := []struct {
+		name         string
+		report       string
+		stderr       string
+		status       string
+		wantErr      error
+		wantErrMsg   string
+		wantEntries  int
+		wantWarnings int
+	}{
+		{
+			name:        "FailedChecks",
+			report:      finding,
+			status:      "3",
+			wantEntries: 1,
+		},
+		{
+			name:       "MissingAWSCLI",
+			stderr:     "/prowler/prowler: line 321: aws: command not found",
+			status:     "127",
+			wantErr:    errAWSCLIMissing,
+			wantErrMsg: "aws: command not found",
+		},
+		{
+			name:       "InvalidCredentials",
+			stderr:     "Unable to locate credentials. You can configure credentials by running \"aws configure\".",
+			status:     "1",
+			wantErr:    errProwlerCredentials,
+			wantErrMsg: "status 1",
+		},
+		{
+			name:       "AccessDenied",
+			stderr:     "An error occurred (AccessDenied) when calling the GenerateCredentialReport operation: User is not authorized",
+			status:     "1",
+			wantErr:    errProwlerAccessDenied,
+			wantErrMsg: "GenerateCredentialReport",
+		},
+		{
+			name:       "Unknown",
+			stderr:     "something went wrong",
+			status:     "2",
+			wantErrMsg: "prowler exited with status 2, stderr: something went wrong",
+		},
+		{
+			name:       "Throttled",
+			report:     finding,
+			stderr:     "An error occurred (Throttling) when calling the GenerateCredentialReport operation (reached max retries: 4): Rate exceeded",
+			status:     "1",
+			wantErr:    errProwlerThrottled,
+			wantErrMsg: "Rate exceeded",
+		},
+		{
+			name:         "PartialResults",
+			report:       finding,
+			stderr:       "An error occurred (AccessDenied) when calling the ListUsers operation",
+			status:       "1",
+			wantEntries:  1,
+			wantWarnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupFakeProwler(t)
+			cfg := prowlerConfig{
+				Groups: []string{"cislevel1"},
+				Env: []string{
+					"REPORT_DIR=" + dir,
+					"FAKE_REPORT=" + tt.report,
+					"FAKE_STDERR=" + tt.stderr,
+					"FAKE_STATUS=" + tt.status,
+				},
+			}
+			r, err := runProwler(context.Background(), "", cfg, reportName)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v is not %v", err, tt.wantErr)
+			}
+			if tt.wantErrMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrMsg) {
+					t.Errorf("error %v does not contain %q", err, tt.wantErrMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(r.entries) != tt.wantEntries {
+				t.Errorf("unexpected number of entries: %d", len(r.entries))
+			}
+			if len(r.warnings) != tt.wantWarnings {
+				t.Errorf("unexpected warnings: %v", r.warnings)
+			}
+		})
+	}
+}
+
+func TestRunProwlerExitCodes(t *testing.T) {
+	const (
+		passed = `{"Control":"[check11] Avoid the use of the root account","Status":"PASS","Region":"eu-west-1"}`
+		failed = `{"Control":"[check13] Ensure credentials unused for 90 days or greater are disabled","Status":"FAIL","Region":"eu-west-1"}`
+	)
+	tests := []struct {
+		name        string
+		report      string
+		stderr      string
+		status      string
+		wantErr     error
+		wantErrMsg  string
+		wantSummary resultsSummary
+	}{
+		{
+			name:        "SuccessWithFindings",
+			report:      passed + "\n" + failed,
+			status:      "3",
+			wantSummary: resultsSummary{Total: 2, Passed: 1, Failed: 1, CompliancePct: float64Ptr(50)},
+		},
+		{
+			name:        "SuccessClean",
+			report:      passed,
+			status:      "0",
+			wantSummary: resultsSummary{Total: 1, Passed: 1, CompliancePct: float64Ptr(100)},
+		},
+		{
+			name:       "Crash",
+			stderr:     "Traceback (most recent call last): KeyError: 'Regions'",
+			status:     "1",
+			wantErrMsg: "prowler exited with status 1, stderr: Traceback",
+		},
+		{
+			name:       "CrashSignal",
+			stderr:     "Segmentation fault",
+			status:     "139",
+			wantErrMsg: "prowler exited with status 139, stderr: Segmentation fault",
+		},
+		{
+			name:       "NoOutput",
+			stderr:     "no checks to execute",
+			status:     "0",
+			wantErr:    errProwlerNoReport,
+			wantErrMsg: "status 0, stderr: no checks to execute",
+		},
+		{
+			name:    "NoOutputWithFindingsStatus",
+			status:  "3",
+			wantErr: errProwlerNoReport,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupFakeProwler(t)
+			cfg := prowlerConfig{
+				Groups: []string{"cislevel1"},
+				Env: []string{
+					"REPORT_DIR=" + dir,
+					"FAKE_REPORT=" + tt.report,
+					"FAKE_STDERR=" + tt.stderr,
+					"FAKE_STATUS=" + tt.status,
+				},
+			}
+			r, err := runProwler(context.Background(), "", cfg, reportName)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v is not %v", err, tt.wantErr)
+			}
+			if tt.wantErrMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErrMsg)) {
+				t.Errorf("error %v does not contain %q", err, tt.wantErrMsg)
+			}
+			if tt.wantErr != nil || tt.wantErrMsg != "" {
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantSummary, r.summary()); diff != "" {
+				t.Errorf("unexpected summary (-want +got):\n%v", diff)
+			}
+			if len(r.warnings) != 0 {
+				t.Errorf("unexpected warnings: %v", r.warnings)
+			}
+		})
+	}
+}
+
+func TestRunProwlerEmptyReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []string
+		allow   bool
+		wantErr bool
+	}{
+		{
+			name:    "ScoredGroup",
+			groups:  []string{"cislevel1"},
+			wantErr: true,
+		},
+		{
+			name:   "ScoredGroupAllowed",
+			groups: []string{"cislevel1"},
+			allow:  true,
+		},
+		{
+			name:   "CustomGroup",
+			groups: []string{"extras"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupFakeProwler(t)
+			cfg := prowlerConfig{
+				Groups:           tt.groups,
+				AllowEmptyReport: tt.allow,
+				Env: []string{
+					"REPORT_DIR=" + dir,
+					"FAKE_REPORT= ",
+					"FAKE_STDERR=group not found",
+					"FAKE_STATUS=0",
+				},
+			}
+			r, err := runProwler(context.Background(), "eu-west-1", cfg, reportName)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(r.entries) != 0 {
+					t.Errorf("unexpected entries: %v", r.entries)
+				}
+				return
+			}
+			if !errors.Is(err, errProwlerNoEntries) {
+				t.Fatalf("error %v is not %v", err, errProwlerNoEntries)
+			}
+			for _, want := range []string{"groups cislevel1", "region eu-west-1", "stderr: group not found"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{max: 8}
+	b.Write([]byte("0123"))
+	if got := b.String(); got != "0123" {
+		t.Errorf("unexpected tail: %q", got)
+	}
+	b.Write([]byte("456789"))
+	if got := b.String(); got != "...23456789" {
+		t.Errorf("unexpected tail: %q", got)
+	}
+}
+
+func TestRunProwlerVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     string
+		report      string
+		want        []entry
+		wantVersion string
+		wantControl CISControl
+	}{
+		{
+			name:    "V2",
+			version: "2",
+			report:  `{"Control":"[check11] Avoid the use of the root account (Scored)","Status":"FAIL","Region":"eu-west-1","Message":"Root account used recently","Resource ID":"root"}`,
+			want: []entry{
+				{
+					Control:     "[check11] Avoid the use of the root account (Scored)",
+					Status:      "FAIL",
+					Region:      "eu-west-1",
+					Message:     "Root account used recently",
+					ResourceID:  "root",
+					ID:          "1.1",
+					Description: "Avoid the use of the root account",
+				},
+			},
+			wantVersion: "2.12.0",
+			wantControl: CISControl{ID: "1.1", Severity: 10, SeverityLiteral: "Critical", Remediation: "https://example.com/1.1"},
+		},
+		{
+			name:    "V3",
+			version: "3",
+			report:  `[{"CheckID":"iam_avoid_root_usage","CheckTitle":"Avoid the use of the root accounts","Status":"FAIL","StatusExtended":"Root account used recently","Severity":"high","Region":"eu-west-1","ResourceId":"<root_account>","Remediation":{"Recommendation":{"Text":"Use IAM users.","Url":"https://example.com/iam_avoid_root_usage"}}}]`,
+			want: []entry{
+				{
+					Control:         "[iam_avoid_root_usage] Avoid the use of the root accounts",
+					Status:          "FAIL",
+					Region:          "eu-west-1",
+					Message:         "Root account used recently",
+					ControlID:       "iam_avoid_root_usage",
+					ResourceID:      "<root_account>",
+					Severity:        "high",
+					Remediation:     "https://example.com/iam_avoid_root_usage",
+					RemediationText: "Use IAM users.",
+					ID:              "iam_avoid_root_usage",
+					Description:     "Avoid the use of the root accounts",
+				},
+			},
+			wantVersion: "3.11.3",
+			wantControl: CISControl{ID: "iam_avoid_root_usage", Severity: 8.9, SeverityLiteral: "High", Remediation: "https://example.com/iam_avoid_root_usage", RemediationText: "Use IAM users."},
+		},
+	}
+	controls := map[string]CISControl{
+		"1.1": {ID: "1.1", Severity: 10, SeverityLiteral: "Critical", Remediation: "https://example.com/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupFakeProwler(t)
+			t.Setenv("FAKE_VERSION", tt.version)
+			version, major, err := prowlerVersion(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			cfg := prowlerConfig{
+				Groups:       []string{"cislevel1"},
+				Env:          []string{"REPORT_DIR=" + dir, "FAKE_REPORT=" + tt.report},
+				Version:      version,
+				MajorVersion: major,
+			}
+			r, err := runProwler(context.Background(), "eu-west-1", cfg, reportName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, r.entries); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%v", diff)
+			}
+			if r.version != tt.wantVersion {
+				t.Errorf("unexpected version, want: %q, got: %q", tt.wantVersion, r.version)
+			}
+			got, ok := prowlerparse.Metadata(controls).LookupFinding(r.entries[0])
+			if !ok {
+				t.Fatal("no metadata for the finding")
+			}
+			if diff := cmp.Diff(tt.wantControl, got); diff != "" {
+				t.Errorf("unexpected metadata (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestBuildParamsV3(t *testing.T) {
+	got, err := buildParamsV3("eu-west-1", []string{"cislevel1", "cislevel2"}, []string{"-q"}, reportName, "/tmp/prowler-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"aws",
+		"--compliance", "cis_1.5_aws",
+		"-M", "json",
+		"-F", "report",
+		"-o", "/tmp/prowler-1",
+		"-f", "eu-west-1",
+		"-q",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected params (-want +got):\n%v", diff)
+	}
+	if _, err := buildParamsV3("", []string{"extras"}, nil, reportName, "/tmp/prowler-1"); err == nil {
+		t.Error("expected error for an unsupported group, got nil")
+	}
+}
+
+func TestRunProwlerIsolatesOutput(t *testing.T) {
+	dir := setupFakeProwler(t)
+	const finding = `{"Control":"[check11] Avoid the use of the root account","Status":"FAIL","Region":"eu-west-1"}`
+	cfg := prowlerConfig{
+		Groups: []string{"cislevel1"},
+		Env:    []string{"REPORT_DIR=" + dir, "FAKE_REPORT=" + finding, "FAKE_STATUS=3"},
+	}
+	r, err := runProwler(context.Background(), "", cfg, reportName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(r.entries) != 1 {
+		t.Fatalf("unexpected entries: %v", r.entries)
+	}
+
+	// A second run with the same report name that does not write any
+	// output must not return the findings of the first one.
+	cfg.Env = []string{"REPORT_DIR=" + dir, "FAKE_STATUS=0"}
+	r, err = runProwler(context.Background(), "", cfg, reportName)
+	if !errors.Is(err, errProwlerNoReport) {
+		t.Errorf("error %v is not %v, report: %+v", err, errProwlerNoReport, r)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Errorf("output directory %s not removed", e.Name())
+		}
+	}
+}
+
+func TestRunProwlerCanceledRemovesOutput(t *testing.T) {
+	dir := setupFakeProwler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := prowlerConfig{Env: []string{"REPORT_DIR=" + dir}}
+	if _, err := runProwler(ctx, "", cfg, reportName); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Errorf("output directory %s not removed", e.Name())
+		}
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []string
+		want        resultsSummary
+		wantDetails string
+	}{
+		{
+			name:        "Empty",
+			want:        resultsSummary{},
+			wantDetails: "Compliance: n/a (passed 0, failed 0, info 0, not evaluated 0)\n",
+		},
+		{
+			name:        "OnlyInfo",
+			statuses:    []string{"Info", "Info"},
+			want:        resultsSummary{Total: 2, Info: 2},
+			wantDetails: "Compliance: n/a (passed 0, failed 0, info 2, not evaluated 0)\n",
+		},
+		{
+			name:        "InfoExcluded",
+			statuses:    []string{"PASS", "PASS", "FAIL", "Info", "WARNING"},
+			want:        resultsSummary{Total: 5, Passed: 2, Failed: 1, Info: 1, Warning: 1, CompliancePct: float64Ptr(66.67)},
+			wantDetails: "Compliance: 66.67% (passed 2, failed 1, info 1, not evaluated 0)\n",
+		},
+		{
+			name:        "NotEvaluated",
+			statuses:    []string{"PASS", "FAIL", "FAIL", prowlerparse.StatusNotEvaluated},
+			want:        resultsSummary{Total: 4, Passed: 1, Failed: 2, NotEvaluated: 1, CompliancePct: float64Ptr(25)},
+			wantDetails: "Compliance: 25% (passed 1, failed 2, info 0, not evaluated 1)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &prowlerReport{}
+			for _, s := range tt.statuses {
+				r.entries = append(r.entries, entry{Status: s})
+			}
+			got := r.summary()
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected summary (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(tt.wantDetails, got.complianceDetails()); diff != "" {
+				t.Errorf("unexpected details (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package prowlerparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// StatusNotEvaluated is the status of the findings of the controls that
+// prowler could not evaluate because the role used lacks some permission.
+// Prowler reports them with the status of the control, usually FAIL or
+// INFO, and the access denied error in the message.
+const StatusNotEvaluated = "NOT_EVALUATED"
+
+// accessDeniedErrors are the messages written by prowler, or by the AWS CLI
+// it executes, when the role used is not allowed to perform an action.
+var accessDeniedErrors = []string{
+	"AccessDenied",
+	"Access Denied",
+	"UnauthorizedOperation",
+	"AuthorizationError",
+	"not authorized to perform",
+}
+
+var (
+	// permissionRegexp extracts the action denied from the AWS error
+	// messages, e.g.: "User: arn:aws:sts::123456789012:assumed-role/audit
+	// is not authorized to perform: config:DescribeConfigurationRecorders".
+	permissionRegexp = regexp.MustCompile(`not authorized to perform:? ([A-Za-z0-9-]+:[A-Za-z0-9*]+)`)
+	// operationRegexp extracts the operation denied from the AWS CLI error
+	// messages, e.g.: "An error occurred (AccessDeniedException) when
+	// calling the DescribeConfigurationRecorders operation".
+	operationRegexp = regexp.MustCompile(`when calling the ([A-Za-z0-9]+) operation`)
+)
+
+// AccessDenied returns true if the given message of a finding shows that
+// prowler could not evaluate the control because the role used is not
+// allowed to perform some action. It also returns the action, e.g.:
+// config:DescribeConfigurationRecorders, or the name of the operation denied
+// if the message does not contain the action. The returned permission is
+// empty if the message contains neither of them.
+func AccessDenied(message string) (permission string, ok bool) {
+	for _, e := range accessDeniedErrors {
+		if strings.Contains(message, e) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return "", false
+	}
+	if m := permissionRegexp.FindStringSubmatch(message); m != nil {
+		return m[1], true
+	}
+	if m := operationRegexp.FindStringSubmatch(message); m != nil {
+		return m[1], true
+	}
+	return "", true
+}
+
+// setNotEvaluated changes the status of the given finding to
+// [StatusNotEvaluated] if its message shows that prowler could not evaluate
+// the control.
+func setNotEvaluated(fd *Finding) {
+	permission, ok := AccessDenied(fd.Message)
+	if !ok {
+		return
+	}
+	fd.Status = StatusNotEvaluated
+	fd.MissingPermission = permission
+}
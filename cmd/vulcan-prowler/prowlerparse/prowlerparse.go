@@ -0,0 +1,534 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+// Package prowlerparse parses the reports generated by prowler and enriches
+// the findings they contain with the metadata of the controls.
+package prowlerparse
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+// Format is the format of a prowler report.
+type Format string
+
+// FormatJSON is the format generated by prowler v2 when it is executed with
+// the flag "-M json": one JSON document per line.
+const FormatJSON Format = "json"
+
+// FormatCSV is the format generated by prowler v2 when it is executed with
+// the flag "-M csv": a header line followed by one comma separated line per
+// finding. It is only supported as a fallback for reports generated by older
+// versions of the check, because the messages of some controls contain the
+// separator.
+const FormatCSV Format = "csv"
+
+// csvHeader is the first column of the header of the reports in the CSV
+// format.
+const csvHeader = "PROFILE"
+
+// csvColumns maps the columns of the reports in the CSV format to the
+// fields of a [Finding].
+var csvColumns = map[string]func(*Finding) *string{
+	"PROFILE":               func(fd *Finding) *string { return &fd.Profile },
+	"ACCOUNT_NUM":           func(fd *Finding) *string { return &fd.Account },
+	"REGION":                func(fd *Finding) *string { return &fd.Region },
+	"TITLE_ID":              func(fd *Finding) *string { return &fd.ControlID },
+	"CHECK_RESULT":          func(fd *Finding) *string { return &fd.Status },
+	"ITEM_SCORED":           func(fd *Finding) *string { return &fd.Scored },
+	"ITEM_LEVEL":            func(fd *Finding) *string { return &fd.Level },
+	"TITLE_TEXT":            func(fd *Finding) *string { return &fd.Control },
+	"CHECK_RESULT_EXTENDED": func(fd *Finding) *string { return &fd.Message },
+	"CHECK_SERVICENAME":     func(fd *Finding) *string { return &fd.Service },
+	"CHECK_RESOURCE_ID":     func(fd *Finding) *string { return &fd.ResourceID },
+	"PROWLER_START_TIME":    func(fd *Finding) *string { return &fd.Timestamp },
+}
+
+// maxLineSize is the maximum size of a line of a report.
+const maxLineSize = 1024 * 1024
+
+// Finding is an entry of a prowler report.
+type Finding struct {
+	Profile    string
+	Account    string `json:"Account Number"`
+	Control    string
+	Message    string
+	Status     string
+	Scored     string
+	Level      string
+	ControlID  string `json:"Control ID"`
+	Region     string
+	Timestamp  string
+	Compliance string
+	Service    string
+	// ResourceID is the identifier of the resource affected by the
+	// finding, e.g.: the ARN of a bucket. It is empty for the findings that
+	// do not refer to a concrete resource.
+	ResourceID string `json:"Resource ID"`
+	// Severity is the severity of the check reported by prowler v3, e.g.:
+	// high. It takes precedence over the severity of the metadata of the
+	// control.
+	Severity string `json:"-"`
+	// Remediation is the URL of the remediation guidance of the check
+	// reported by prowler v3. It takes precedence over the remediation of
+	// the metadata of the control.
+	Remediation string `json:"-"`
+	// RemediationText is the remediation guidance of the check reported by
+	// prowler v3. It takes precedence over the remediation text of the
+	// metadata of the control.
+	RemediationText string `json:"-"`
+	// MissingPermission is the action that prowler was not allowed to
+	// perform when the status of the finding is [StatusNotEvaluated]. It can
+	// be empty if the message of the finding does not contain it.
+	MissingPermission string `json:"-"`
+
+	// ID is the identifier of the control parsed from the Control field,
+	// e.g.: 1.13 or extra718. It is empty if the control could not be
+	// parsed.
+	ID string `json:"-"`
+	// Description is the description of the control parsed from the
+	// Control field.
+	Description string `json:"-"`
+	// Metadata is the metadata of the control. It is set by [Enrich].
+	Metadata *Control `json:"-"`
+}
+
+// Stats contains counters about a parsed report.
+type Stats struct {
+	// Findings is the number of findings in the report.
+	Findings int
+	// ByStatus contains the number of findings per status.
+	ByStatus map[string]int
+	// MalformedControls is the number of findings whose control could not
+	// be parsed.
+	MalformedControls int
+}
+
+// DetectFormat returns the format of the given report. It returns an empty
+// format if the report is empty or its format is not supported.
+func DetectFormat(data []byte) Format {
+	data = bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(data, []byte("{")):
+		return FormatJSON
+	case bytes.HasPrefix(data, []byte("[")):
+		return FormatJSONV3
+	case bytes.HasPrefix(data, []byte(csvHeader+",")):
+		return FormatCSV
+	default:
+		return ""
+	}
+}
+
+// Parse reads a prowler report in the given format. If the report contains an
+// invalid finding, Parse returns an error together with the findings read
+// before it.
+func Parse(r io.Reader, f Format) ([]Finding, Stats, error) {
+	stats := Stats{ByStatus: map[string]int{}}
+	switch f {
+	case FormatJSON:
+	case FormatCSV:
+		return parseCSV(r, stats)
+	case FormatJSONV3:
+		return parseJSONV3(r, stats)
+	default:
+		return nil, stats, fmt.Errorf("unsupported report format %q", f)
+	}
+	var findings []Finding
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	n := 0
+	for scanner.Scan() {
+		n++
+		fd, ok, err := ParseLine(scanner.Bytes())
+		if err != nil {
+			return findings, stats, fmt.Errorf("invalid finding at line %d: %w", n, err)
+		}
+		if !ok {
+			continue
+		}
+		stats.add(fd)
+		findings = append(findings, fd)
+	}
+	if err := scanner.Err(); err != nil {
+		return findings, stats, err
+	}
+	return findings, stats, nil
+}
+
+// Report is a parsed prowler report.
+type Report struct {
+	// Format is the format of the report.
+	Format   Format
+	Findings []Finding
+	Stats    Stats
+}
+
+// ParseReport reads a prowler report detecting its format. The reports
+// whose format can not be detected, like the empty ones, are read as
+// reports in the JSON format of prowler v2. If the report contains an
+// invalid finding, ParseReport returns an error together with the report
+// containing the findings read before it.
+func ParseReport(r io.Reader) (*Report, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	format := DetectFormat(data)
+	if format == "" {
+		format = FormatJSON
+	}
+	findings, stats, err := Parse(bytes.NewReader(data), format)
+	return &Report{Format: format, Findings: findings, Stats: stats}, err
+}
+
+// parseCSV reads a prowler report in the CSV format. The columns are
+// identified by the header of the report, so the unknown ones are ignored.
+func parseCSV(r io.Reader, stats Stats) ([]Finding, Stats, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, stats, nil
+	}
+	if err != nil {
+		return nil, stats, fmt.Errorf("invalid header: %w", err)
+	}
+	if len(header) == 0 || header[0] != csvHeader {
+		return nil, stats, fmt.Errorf("invalid header: %q", strings.Join(header, ","))
+	}
+	var findings []Finding
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return findings, stats, fmt.Errorf("invalid finding: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) != len(header) {
+			return findings, stats, fmt.Errorf("invalid finding at line %d: %d fields, expected %d", line, len(record), len(header))
+		}
+		var fd Finding
+		for i, column := range header {
+			if field, ok := csvColumns[column]; ok {
+				*field(&fd) = record[i]
+			}
+		}
+		if fd.Control == "" || fd.Status == "" {
+			return findings, stats, fmt.Errorf("invalid finding at line %d: missing control or status", line)
+		}
+		setControl(&fd)
+		setNotEvaluated(&fd)
+		stats.add(fd)
+		findings = append(findings, fd)
+	}
+	return findings, stats, nil
+}
+
+// add updates the counters with the given finding.
+func (s *Stats) add(fd Finding) {
+	if fd.ID == "" {
+		s.MalformedControls++
+	}
+	s.Findings++
+	s.ByStatus[fd.Status]++
+}
+
+// setControl sets the ID and the description of the given finding from its
+// Control field, if it can be parsed.
+func setControl(fd *Finding) {
+	if id, description, err := ParseControl(fd.Control); err == nil {
+		fd.ID = id
+		fd.Description = description
+	}
+}
+
+// ParseLine parses a line of a report in the JSON format. It returns false if
+// the line is empty. If the control of the finding can not be parsed, the ID
+// of the returned finding is empty.
+func ParseLine(line []byte) (Finding, bool, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return Finding{}, false, nil
+	}
+	var fd Finding
+	if err := json.Unmarshal(line, &fd); err != nil {
+		return Finding{}, false, err
+	}
+	setControl(&fd)
+	setNotEvaluated(&fd)
+	return fd, true, nil
+}
+
+var (
+	// rawControlRegexp splits the Control field of a finding in the
+	// identifier of the control and its description, e.g.: "[check13]
+	// Ensure credentials unused for 90 days or greater are disabled
+	// (Scored)".
+	rawControlRegexp = regexp.MustCompile(`^\s*\[\s*([^\]\s]+)\s*\]\s*(.*?)\s*$`)
+	// cisCheckRegexp matches the prowler v2 checks of the CIS controls. The
+	// first digit is the section of the benchmark, from 1 to 4, and the rest
+	// the number of the control in the section, e.g.: check113 is the
+	// control 1.13 and check41 the control 4.1.
+	cisCheckRegexp = regexp.MustCompile(`^check([1-4])([1-9][0-9]?)$`)
+	// extraCheckRegexp matches the prowler v2 extra checks, with or without
+	// the "check_" prefix, e.g.: extra718 or check_extra718.
+	extraCheckRegexp = regexp.MustCompile(`^(?:check_)?(extra[0-9]+)$`)
+	// cisControlIDRegexp matches the identifiers of the CIS controls
+	// returned by ParseControl, e.g.: 1.13.
+	cisControlIDRegexp = regexp.MustCompile(`^([0-9]+)\.([0-9]+)$`)
+	// scoredRegexp matches the suffix that tells whether a CIS control is
+	// scored.
+	scoredRegexp = regexp.MustCompile(`\s*\((?:Not )?Scored\)\s*$`)
+)
+
+// ControlError is returned by [ParseControl] when the Control field of a
+// finding has an unexpected format.
+type ControlError struct {
+	// Raw is the Control field that could not be parsed.
+	Raw string
+	// Reason describes why the field could not be parsed.
+	Reason string
+}
+
+func (e *ControlError) Error() string {
+	return fmt.Sprintf("error parsing raw control %q: %s", e.Raw, e.Reason)
+}
+
+// ParseControl extracts the identifier and the description of a control
+// from the Control field of a finding. The description does not include
+// the "(Scored)" or "(Not Scored)" suffix of the CIS controls. The returned
+// error is a [*ControlError] when the field has an unexpected format.
+func ParseControl(raw string) (control string, description string, err error) {
+	// Raw format examples:
+	//   "[check13] Ensure credentials unused for 90 days or greater are
+	//   disabled (Scored)"
+	//   "[check113] Ensure MFA is enabled for the root account (Scored)"
+	//   "[extra718] Check if S3 buckets have server access logging enabled"
+	//   "[check_extra718] Check if S3 buckets have server access logging
+	//   enabled"
+	//   "[iam_root_mfa_enabled] Ensure MFA is enabled for the root account"
+	m := rawControlRegexp.FindStringSubmatch(raw)
+	if m == nil {
+		return "", "", &ControlError{Raw: raw, Reason: "missing control identifier"}
+	}
+	id, description := m[1], scoredRegexp.ReplaceAllString(m[2], "")
+	switch {
+	case cisCheckRegexp.MatchString(id):
+		cm := cisCheckRegexp.FindStringSubmatch(id)
+		control = cm[1] + "." + cm[2]
+	case extraCheckRegexp.MatchString(id):
+		// Extra checks are identified by their raw prowler name without the
+		// "check_" prefix, e.g.: extra718.
+		control = extraCheckRegexp.FindStringSubmatch(id)[1]
+	case strings.HasPrefix(id, "check"), strings.HasPrefix(id, "extra"):
+		return "", "", &ControlError{Raw: raw, Reason: fmt.Sprintf("invalid check %s", id)}
+	case isV3CheckID(id):
+		// Prowler v3 checks are identified by their name, e.g.:
+		// iam_root_mfa_enabled.
+		control = id
+	default:
+		return "", "", &ControlError{Raw: raw, Reason: fmt.Sprintf("unknown check %s", id)}
+	}
+	return control, description, nil
+}
+
+// IsExtra returns true if the given control identifier, as returned by
+// [ParseControl], corresponds to a prowler extra check instead of to a CIS
+// control.
+func IsExtra(control string) bool {
+	return strings.HasPrefix(control, "extra")
+}
+
+// CompareControls compares two control identifiers, as returned by
+// [ParseControl], and returns -1, 0 or +1 if a is less than, equal to or
+// greater than b. The CIS controls are sorted numerically by section and
+// number, e.g.: 1.2 before 1.10, followed by the extra checks sorted by
+// number and by the rest of identifiers sorted lexicographically.
+func CompareControls(a, b string) int {
+	ka, na := controlSortKey(a)
+	kb, nb := controlSortKey(b)
+	if ka != kb {
+		return cmp.Compare(ka, kb)
+	}
+	if c := slices.Compare(na, nb); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// controlSortKey returns the kind of the given control identifier, used to
+// sort the CIS controls before the extra checks and these before the rest,
+// and its numeric components.
+func controlSortKey(control string) (int, []int) {
+	if m := cisControlIDRegexp.FindStringSubmatch(control); m != nil {
+		section, _ := strconv.Atoi(m[1])
+		number, _ := strconv.Atoi(m[2])
+		return 0, []int{section, number}
+	}
+	if n, ok := strings.CutPrefix(control, "extra"); ok {
+		if number, err := strconv.Atoi(n); err == nil {
+			return 1, []int{number}
+		}
+	}
+	return 2, nil
+}
+
+// Control contains the metadata of a control.
+type Control struct {
+	ID              string  `json:"id"`
+	Severity        float32 `json:"severity"`
+	SeverityLiteral string  `json:"severity_literal"`
+	// Remediation is the URL of the remediation guidance of the control.
+	Remediation string `json:"remediation"`
+	// RemediationText is a short description of the remediation of the
+	// control, shown together with the link to the remediation guidance.
+	RemediationText string `json:"remediation_text,omitempty"`
+	// TimestampPattern is a regular expression with a capturing group that
+	// extracts the timestamp embedded in the prowler messages of the
+	// control.
+	TimestampPattern string `json:"timestamp_pattern,omitempty"`
+	// ManagementAccountNote describes the different guidance that applies
+	// to the control when the account is the management account of an
+	// organization.
+	ManagementAccountNote string `json:"management_account_note,omitempty"`
+	// Service is the AWS service checked by the control, e.g.: s3.
+	Service string `json:"service,omitempty"`
+	// Level is the CIS profile level of the control: 1 or 2.
+	Level int `json:"level,omitempty"`
+}
+
+// Metadata contains the metadata of the controls keyed by control
+// identifier.
+type Metadata map[string]Control
+
+// MetadataFile is the document containing the metadata of the controls of a
+// version of the CIS benchmark.
+type MetadataFile struct {
+	// BenchmarkVersion is the version of the CIS benchmark the metadata
+	// corresponds to, e.g.: 1.2.
+	BenchmarkVersion string   `json:"benchmark_version"`
+	Controls         Metadata `json:"controls"`
+}
+
+// LoadMetadata reads the metadata of the controls from a JSON document like
+// the cis_controls.json file shipped with the vulcan-prowler check.
+func LoadMetadata(r io.Reader) (MetadataFile, error) {
+	var mf MetadataFile
+	if err := json.NewDecoder(r).Decode(&mf); err != nil {
+		return MetadataFile{}, fmt.Errorf("invalid controls metadata: %w", err)
+	}
+	if mf.BenchmarkVersion == "" {
+		return MetadataFile{}, errors.New("invalid controls metadata: missing benchmark version")
+	}
+	if mf.Controls == nil {
+		mf.Controls = Metadata{}
+	}
+	return mf, nil
+}
+
+// Warnings returns the problems of the metadata that do not make it invalid
+// but degrade the reports, e.g.: the controls without remediation text nor
+// link. They are sorted by control.
+func (mf MetadataFile) Warnings() []string {
+	var warnings []string
+	for id, c := range mf.Controls {
+		if c.Remediation == "" && c.RemediationText == "" {
+			warnings = append(warnings, fmt.Sprintf("control %s: no remediation text nor link", id))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// Lookup returns the metadata of a control. Extra checks are not part of the
+// CIS benchmark, so when there is no specific metadata for them a default
+// one is returned.
+func (md Metadata) Lookup(control string) (Control, bool) {
+	c, ok := md[control]
+	if ok {
+		return c, true
+	}
+	if IsExtra(control) {
+		return Control{
+			ID:              control,
+			Severity:        report.SeverityThresholdLow,
+			SeverityLiteral: "Low",
+		}, true
+	}
+	return Control{}, false
+}
+
+// LookupFinding returns the metadata of the control of a finding. The
+// severity and the remediation reported by prowler v3 take precedence over
+// the ones in the metadata, that is only used as a fallback for them.
+func (md Metadata) LookupFinding(fd Finding) (Control, bool) {
+	if fd.ID == "" {
+		setControl(&fd)
+	}
+	if fd.ID == "" {
+		return Control{}, false
+	}
+	c, ok := md.Lookup(fd.ID)
+	if !ok {
+		if fd.Severity == "" {
+			return Control{}, false
+		}
+		c = Control{ID: fd.ID}
+	}
+	if s, known := v3Severities[fd.Severity]; known {
+		c.Severity = s.score
+		c.SeverityLiteral = s.literal
+	} else if !ok {
+		return Control{}, false
+	}
+	if fd.Remediation != "" {
+		c.Remediation = fd.Remediation
+	}
+	if fd.RemediationText != "" {
+		c.RemediationText = fd.RemediationText
+	}
+	return c, true
+}
+
+// Enrich sets the metadata of the findings. It returns the identifiers of
+// the controls without metadata.
+func Enrich(findings []Finding, md Metadata) []string {
+	var (
+		unknown []string
+		seen    = map[string]bool{}
+	)
+	for i := range findings {
+		id := findings[i].ID
+		if id == "" {
+			continue
+		}
+		c, ok := md.LookupFinding(findings[i])
+		if !ok {
+			if !seen[id] {
+				seen[id] = true
+				unknown = append(unknown, id)
+			}
+			continue
+		}
+		findings[i].Metadata = &c
+	}
+	return unknown
+}
@@ -0,0 +1,702 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package prowlerparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseControl(t *testing.T) {
+	tests := []struct {
+		name            string
+		raw             string
+		wantControl     string
+		wantDescription string
+		wantErr         bool
+	}{
+		{
+			name:            "cis one digit",
+			raw:             "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+			wantControl:     "1.3",
+			wantDescription: "Ensure credentials unused for 90 days or greater are disabled",
+		},
+		{
+			name:            "cis two digits",
+			raw:             "[check122] Ensure IAM policies that allow full administrative privileges are not created (Scored)",
+			wantControl:     "1.22",
+			wantDescription: "Ensure IAM policies that allow full administrative privileges are not created",
+		},
+		{
+			name:            "extra",
+			raw:             "[extra718] Check if S3 buckets have server access logging enabled",
+			wantControl:     "extra718",
+			wantDescription: "Check if S3 buckets have server access logging enabled",
+		},
+		{
+			name:            "extra with check prefix",
+			raw:             "[check_extra718] Check if S3 buckets have server access logging enabled",
+			wantControl:     "extra718",
+			wantDescription: "Check if S3 buckets have server access logging enabled",
+		},
+		{
+			name:            "description with brackets",
+			raw:             "[extra7100] Ensure that no custom IAM policies exist which allow permissive role assumption (e.g. [sts:AssumeRole] on *)",
+			wantControl:     "extra7100",
+			wantDescription: "Ensure that no custom IAM policies exist which allow permissive role assumption (e.g. [sts:AssumeRole] on *)",
+		},
+		{
+			name:            "v3 check",
+			raw:             "[iam_root_mfa_enabled] Ensure MFA is enabled for the root account",
+			wantControl:     "iam_root_mfa_enabled",
+			wantDescription: "Ensure MFA is enabled for the root account",
+		},
+		{
+			name:    "empty",
+			raw:     "",
+			wantErr: true,
+		},
+		{
+			name:    "no brackets",
+			raw:     "check13 Ensure credentials unused",
+			wantErr: true,
+		},
+		{
+			name:    "unknown prefix",
+			raw:     "[foo13] Ensure credentials unused",
+			wantErr: true,
+		},
+		{
+			name:    "cis without number",
+			raw:     "[check] Ensure credentials unused",
+			wantErr: true,
+		},
+		{
+			name:    "cis non numeric",
+			raw:     "[checkabc] Ensure credentials unused",
+			wantErr: true,
+		},
+		{
+			name:    "extra without number",
+			raw:     "[check_extra] Check something",
+			wantErr: true,
+		},
+		{
+			name:            "not scored",
+			raw:             "[check115] Ensure security questions are registered in the AWS account (Not Scored)",
+			wantControl:     "1.15",
+			wantDescription: "Ensure security questions are registered in the AWS account",
+		},
+		{
+			name:            "section 4 one digit",
+			raw:             "[check41] Ensure no security groups allow ingress from 0.0.0.0/0 to port 22 (Scored)",
+			wantControl:     "4.1",
+			wantDescription: "Ensure no security groups allow ingress from 0.0.0.0/0 to port 22",
+		},
+		{
+			name:            "section 4 two digits",
+			raw:             "[check412] Some control (Scored)",
+			wantControl:     "4.12",
+			wantDescription: "Some control",
+		},
+		{
+			name:            "whitespace",
+			raw:             "  [ check310 ]   Ensure a log metric filter and alarm exist for security group changes   (Scored)  ",
+			wantControl:     "3.10",
+			wantDescription: "Ensure a log metric filter and alarm exist for security group changes",
+		},
+		{
+			name:            "no separator",
+			raw:             "[check13]Ensure credentials unused",
+			wantControl:     "1.3",
+			wantDescription: "Ensure credentials unused",
+		},
+		{
+			name:            "no description",
+			raw:             "[check13]",
+			wantControl:     "1.3",
+			wantDescription: "",
+		},
+		{
+			name:    "unknown section",
+			raw:     "[check51] Ensure something (Scored)",
+			wantErr: true,
+		},
+		{
+			name:    "section without number",
+			raw:     "[check1] Ensure something (Scored)",
+			wantErr: true,
+		},
+		{
+			name:    "number with leading zero",
+			raw:     "[check101] Ensure something (Scored)",
+			wantErr: true,
+		},
+		{
+			name:    "number too long",
+			raw:     "[check1234] Ensure something (Scored)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, description, err := ParseControl(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				var cerr *ControlError
+				if !errors.As(err, &cerr) || cerr.Raw != tt.raw {
+					t.Errorf("unexpected error type or raw control: %#v", err)
+				}
+			}
+			if control != tt.wantControl {
+				t.Errorf("unexpected control, want: %q, got: %q", tt.wantControl, control)
+			}
+			if description != tt.wantDescription {
+				t.Errorf("unexpected description, want: %q, got: %q", tt.wantDescription, description)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// goldenFinding is the representation of a finding in the golden files. It
+// includes the fields parsed from the control, which are not part of the
+// reports.
+type goldenFinding struct {
+	Finding
+	ID              string
+	Description     string
+	Severity        string `json:",omitempty"`
+	Remediation     string `json:",omitempty"`
+	RemediationText string `json:",omitempty"`
+	// MissingPermission is only set for the findings not evaluated.
+	MissingPermission string `json:",omitempty"`
+}
+
+func TestParseReportGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		format Format
+	}{
+		{name: "v2 json", report: "v2.json", format: FormatJSON},
+		{name: "v2 csv", report: "v2.csv", format: FormatCSV},
+		{name: "v3 json", report: "v3.json", format: FormatJSONV3},
+		{name: "cislevel1", report: "cislevel1.json", format: FormatJSON},
+		{name: "cislevel2", report: "cislevel2.json", format: FormatJSON},
+		{name: "extras", report: "extras.json", format: FormatJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join("testdata", "golden", tt.report)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			report, err := ParseReport(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if report.Format != tt.format {
+				t.Fatalf("unexpected format, want: %q, got: %q", tt.format, report.Format)
+			}
+			if report.Stats.Findings != len(report.Findings) {
+				t.Errorf("unexpected number of findings in stats: %d, findings: %d", report.Stats.Findings, len(report.Findings))
+			}
+			var got []goldenFinding
+			for _, fd := range report.Findings {
+				got = append(got, goldenFinding{
+					Finding:           fd,
+					ID:                fd.ID,
+					Description:       fd.Description,
+					Severity:          fd.Severity,
+					Remediation:       fd.Remediation,
+					RemediationText:   fd.RemediationText,
+					MissingPermission: fd.MissingPermission,
+				})
+			}
+			golden := path + ".golden"
+			if *update {
+				var out bytes.Buffer
+				enc := json.NewEncoder(&out)
+				enc.SetEscapeHTML(false)
+				enc.SetIndent("", "\t")
+				if err := enc.Encode(got); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			out, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var want []goldenFinding
+			if err := json.Unmarshal(out, &want); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Finding{}, "ID", "Description", "Severity", "Remediation", "RemediationText", "MissingPermission")); diff != "" {
+				t.Errorf("unexpected findings (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Format
+	}{
+		{name: "json", data: "\n{\"Control\":\"[check11] Avoid the use of the root account (Scored)\"}\n", want: FormatJSON},
+		{name: "csv", data: "PROFILE,ACCOUNT_NUM,REGION\n", want: FormatCSV},
+		{name: "empty", data: "", want: ""},
+		{name: "text", data: "1.1 [check11] Avoid the use of the root account (Scored)\n", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat([]byte(tt.data)); got != tt.want {
+				t.Errorf("unexpected format, want: %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+	}{
+		{name: "invalid header", report: "ACCOUNT_NUM,REGION\n"},
+		{name: "missing fields", report: "PROFILE,TITLE_TEXT,CHECK_RESULT\nENV,[check11] Avoid the use of the root account (Scored)\n"},
+		{name: "missing status", report: "PROFILE,TITLE_TEXT,CHECK_RESULT\nENV,[check11] Avoid the use of the root account (Scored),\n"},
+		{name: "unterminated quote", report: "PROFILE,TITLE_TEXT,CHECK_RESULT\nENV,\"[check11] Avoid,PASS\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(strings.NewReader(tt.report), FormatCSV); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("testdata/report.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	findings, stats, err := Parse(f, FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantStats := Stats{
+		Findings:          6,
+		ByStatus:          map[string]int{"PASS": 1, "FAIL": 3, "Info": 1, "WARNING": 1},
+		MalformedControls: 1,
+	}
+	if diff := cmp.Diff(wantStats, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%v", diff)
+	}
+	var ids []string
+	for _, fd := range findings {
+		ids = append(ids, fd.ID)
+	}
+	wantIDs := []string{"1.1", "1.3", "1.16", "1.20", "extra718", ""}
+	if diff := cmp.Diff(wantIDs, ids); diff != "" {
+		t.Errorf("unexpected ids (-want +got):\n%v", diff)
+	}
+	want := Finding{
+		Profile:     "default",
+		Account:     "123456789012",
+		Control:     "[check116] Ensure IAM policies are attached only to groups or roles (Scored)",
+		Message:     "User ñandú, has the policy <AdministratorAccess> attached",
+		Status:      "FAIL",
+		Scored:      "Scored",
+		Level:       "Level 1",
+		ControlID:   "1.16",
+		Region:      "eu-west-1",
+		Timestamp:   "2020-06-01T10:00:02Z",
+		Service:     "iam",
+		ID:          "1.16",
+		Description: "Ensure IAM policies are attached only to groups or roles",
+	}
+	if diff := cmp.Diff(want, findings[2]); diff != "" {
+		t.Errorf("unexpected finding (-want +got):\n%v", diff)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format Format
+	}{
+		{name: "invalid json", input: "{\"Control\": \n", format: FormatJSON},
+		{name: "unsupported format", input: "", format: Format("xml")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Parse(strings.NewReader(tt.input), tt.format); err == nil {
+				t.Error("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	mf, err := LoadMetadata(strings.NewReader(`{
+		"benchmark_version": "1.2",
+		"controls": {
+			"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "remediation": "https://example.com/1.3"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	md := mf.Controls
+	findings := []Finding{
+		{ID: "1.3"},
+		{ID: "extra718"},
+		{ID: "1.16"},
+		{ID: "1.16"},
+		{},
+	}
+	unknown := Enrich(findings, md)
+	if diff := cmp.Diff([]string{"1.16"}, unknown); diff != "" {
+		t.Errorf("unexpected unknown controls (-want +got):\n%v", diff)
+	}
+	want := []*Control{
+		{ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium", Remediation: "https://example.com/1.3"},
+		{ID: "extra718", Severity: 3.9, SeverityLiteral: "Low"},
+		nil,
+		nil,
+		nil,
+	}
+	var got []*Control
+	for _, fd := range findings {
+		got = append(got, fd.Metadata)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("unexpected metadata (-want +got):\n%v", diff)
+	}
+}
+
+func TestParseControlShipped(t *testing.T) {
+	f, err := os.Open("../cis_controls.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	mf, err := LoadMetadata(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mf.Controls) == 0 {
+		t.Fatal("no shipped controls")
+	}
+	for id := range mf.Controls {
+		for _, suffix := range []string{"(Scored)", "(Not Scored)"} {
+			raw := fmt.Sprintf("[check%s] Description of %s %s", strings.Replace(id, ".", "", 1), id, suffix)
+			t.Run(raw, func(t *testing.T) {
+				control, description, err := ParseControl(raw)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if control != id {
+					t.Errorf("unexpected control, want: %q, got: %q", id, control)
+				}
+				if want := "Description of " + id; description != want {
+					t.Errorf("unexpected description, want: %q, got: %q", want, description)
+				}
+			})
+		}
+	}
+}
+
+func TestParsePartial(t *testing.T) {
+	input := `{"Control":"[check11] Avoid the use of the root account (Scored)","Status":"PASS"}
+{"Control":"[check12] Ensure MFA is enabled (Scored)","Status":"FAIL"}
+{"Control":"[check13] Ensure credentials unu`
+	findings, stats, err := Parse(strings.NewReader(input), FormatJSON)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if len(findings) != 2 || stats.Findings != 2 {
+		t.Errorf("unexpected findings before the error: %d, stats: %+v", len(findings), stats)
+	}
+}
+
+func TestLoadMetadataMissingVersion(t *testing.T) {
+	_, err := LoadMetadata(strings.NewReader(`{"controls": {}}`))
+	if err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestMetadataFileWarnings(t *testing.T) {
+	input := `{"benchmark_version": "1.2", "controls": {
+		"1.3": {"id": "1.3", "severity": 6.9, "severity_literal": "Medium", "remediation": "https://example.com/1.3"},
+		"1.4": {"id": "1.4", "severity": 6.9, "severity_literal": "Medium", "remediation_text": "Rotate the access keys."},
+		"2.1": {"id": "2.1", "severity": 6.9, "severity_literal": "Medium"},
+		"1.10": {"id": "1.10", "severity": 6.9, "severity_literal": "Medium", "remediation": ""}
+	}}`
+	mf, err := LoadMetadata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := mf.Controls["1.4"].RemediationText; got != "Rotate the access keys." {
+		t.Errorf("unexpected remediation text: %q", got)
+	}
+	want := []string{
+		"control 1.10: no remediation text nor link",
+		"control 2.1: no remediation text nor link",
+	}
+	if diff := cmp.Diff(want, mf.Warnings()); diff != "" {
+		t.Errorf("unexpected warnings (-want +got):\n%v", diff)
+	}
+}
+
+func TestLookupFinding(t *testing.T) {
+	md := Metadata{
+		"1.1":                  {ID: "1.1", Severity: 10, SeverityLiteral: "Critical", Remediation: "https://example.com/1.1"},
+		"iam_root_mfa_enabled": {ID: "iam_root_mfa_enabled", Severity: 10, SeverityLiteral: "Critical", Remediation: "https://example.com/mfa", ManagementAccountNote: "note"},
+	}
+	tests := []struct {
+		name    string
+		finding Finding
+		want    Control
+		wantOK  bool
+	}{
+		{
+			name:    "v2 metadata",
+			finding: Finding{Control: "[check11] Avoid the use of the root account (Scored)"},
+			want:    md["1.1"],
+			wantOK:  true,
+		},
+		{
+			name:    "v3 without metadata",
+			finding: Finding{ID: "s3_bucket_default_encryption", Severity: "medium", Remediation: "https://example.com/s3"},
+			want:    Control{ID: "s3_bucket_default_encryption", Severity: 6.9, SeverityLiteral: "Medium", Remediation: "https://example.com/s3"},
+			wantOK:  true,
+		},
+		{
+			name:    "v3 overrides metadata",
+			finding: Finding{ID: "iam_root_mfa_enabled", Severity: "high"},
+			want:    Control{ID: "iam_root_mfa_enabled", Severity: 8.9, SeverityLiteral: "High", Remediation: "https://example.com/mfa", ManagementAccountNote: "note"},
+			wantOK:  true,
+		},
+		{
+			name:    "unknown severity",
+			finding: Finding{ID: "s3_bucket_default_encryption", Severity: "severe"},
+		},
+		{
+			name:    "unknown control",
+			finding: Finding{Control: "[check99] Unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := md.LookupFinding(tt.finding)
+			if ok != tt.wantOK {
+				t.Fatalf("unexpected ok, want: %v, got: %v", tt.wantOK, ok)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected control (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestAccessDenied(t *testing.T) {
+	tests := []struct {
+		name           string
+		message        string
+		wantPermission string
+		wantOK         bool
+	}{
+		{
+			name:           "action",
+			message:        "An error occurred (AccessDeniedException) when calling the DescribeConfigurationRecorders operation: User: arn:aws:sts::123456789012:assumed-role/audit/vulcan is not authorized to perform: config:DescribeConfigurationRecorders",
+			wantPermission: "config:DescribeConfigurationRecorders",
+			wantOK:         true,
+		},
+		{
+			name:           "operation",
+			message:        "An error occurred (AccessDenied) when calling the GetBucketPolicy operation: Access Denied",
+			wantPermission: "GetBucketPolicy",
+			wantOK:         true,
+		},
+		{
+			name:    "no permission",
+			message: "Access Denied Trying to Get Bucket Policy for logs-archive",
+			wantOK:  true,
+		},
+		{
+			name:    "evaluated",
+			message: "Bucket logs-archive has server access logging disabled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permission, ok := AccessDenied(tt.message)
+			if ok != tt.wantOK || permission != tt.wantPermission {
+				t.Errorf("unexpected result, want: %q %v, got: %q %v", tt.wantPermission, tt.wantOK, permission, ok)
+			}
+		})
+	}
+}
+
+func TestResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		finding Finding
+		want    string
+	}{
+		{
+			name: "reported by prowler",
+			finding: Finding{
+				Control:    "[s3_bucket_server_access_logging_enabled] Check if S3 buckets have server access logging enabled",
+				Message:    "S3 Bucket my-logs has server access logging disabled.",
+				ResourceID: "arn:aws:s3:::my-logs",
+			},
+			want: "arn:aws:s3:::my-logs",
+		},
+		{
+			name: "S3 bucket",
+			finding: Finding{
+				Control: "[extra718] Check if S3 buckets have server access logging enabled",
+				Message: "Bucket my-logs has server access logging disabled",
+			},
+			want: "my-logs",
+		},
+		{
+			name: "S3 CloudTrail bucket",
+			finding: Finding{
+				Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)",
+				Message: "Trail main S3 bucket: trail-logs.example does not have access logging enabled",
+			},
+			want: "trail-logs.example",
+		},
+		{
+			name: "IAM user",
+			finding: Finding{
+				Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)",
+				Message: "User deploy-bot has not rotated access key 1 in over 90 days",
+			},
+			want: "deploy-bot",
+		},
+		{
+			name: "IAM user with email",
+			finding: Finding{
+				Control: "[check12] Ensure multi-factor authentication (MFA) is enabled for all IAM users that have a console password (Scored)",
+				Message: "User john.doe@example.com has Password enabled but MFA disabled",
+			},
+			want: "john.doe@example.com",
+		},
+		{
+			name: "IAM root user",
+			finding: Finding{
+				Control: "[check112] Ensure no root account access key exists (Scored)",
+				Message: "Root user has access key 1 active",
+			},
+		},
+		{
+			name: "ARN",
+			finding: Finding{
+				Control: "[check116] Ensure IAM policies are attached only to groups or roles (Scored)",
+				Message: "User arn:aws:iam::123456789012:user/deploy-bot has the policy ReadOnly directly attached",
+			},
+			want: "arn:aws:iam::123456789012:user/deploy-bot",
+		},
+		{
+			name: "VPC flow logs",
+			finding: Finding{
+				Control: "[check29] Ensure VPC flow logging is enabled in all VPCs (Scored)",
+				Message: "VPC vpc-0a1b2c3d4e5f60718: No VPCFlowLog has been found",
+			},
+			want: "vpc-0a1b2c3d4e5f60718",
+		},
+		{
+			name: "VPC security group",
+			finding: Finding{
+				Control: "[check41] Ensure no security groups allow ingress from 0.0.0.0/0 to port 22 (Scored)",
+				Message: "Found Security Group: sg-0a1b2c3d (default) open to 0.0.0.0/0 for SSH port 22 in vpc-0a1b2c3d",
+			},
+			want: "sg-0a1b2c3d",
+		},
+		{
+			name: "no resource",
+			finding: Finding{
+				Control: "[check19] Ensure IAM password policy requires minimum length of 14 or greater (Scored)",
+				Message: "Password Policy missing minimum length",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Resource(tt.finding); got != tt.want {
+				t.Errorf("unexpected resource, want: %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompareControls(t *testing.T) {
+	want := []string{
+		"1.1",
+		"1.2",
+		"1.10",
+		"1.22",
+		"2.1",
+		"3.9",
+		"3.14",
+		"4.1",
+		"extra72",
+		"extra718",
+		"extra7100",
+		"cloudtrail_multi_region_enabled",
+		"iam_root_mfa_enabled",
+	}
+	got := []string{
+		"extra7100",
+		"1.10",
+		"iam_root_mfa_enabled",
+		"3.14",
+		"1.2",
+		"extra72",
+		"4.1",
+		"cloudtrail_multi_region_enabled",
+		"1.22",
+		"extra718",
+		"2.1",
+		"1.1",
+		"3.9",
+	}
+	sort.Slice(got, func(i, j int) bool { return CompareControls(got[i], got[j]) < 0 })
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected order (-want +got):\n%v", diff)
+	}
+	if c := CompareControls("1.10", "1.10"); c != 0 {
+		t.Errorf("unexpected comparison of equal controls: %d", c)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package prowlerparse
+
+import (
+	"regexp"
+)
+
+var (
+	// arnRegexp matches the ARNs embedded in the messages, e.g.:
+	// "arn:aws:iam::123456789012:user/deploy-bot".
+	arnRegexp = regexp.MustCompile(`arn:aws[a-z-]*:[a-z0-9-]+:[a-z0-9-]*:[0-9]{0,12}:[^\s,;()"']+`)
+	// userRegexp matches the IAM users at the beginning of the messages of
+	// the IAM controls, e.g.: "User deploy-bot has access key 1 older than
+	// 90 days".
+	userRegexp = regexp.MustCompile(`(?i)^user:?\s+([\w+=,.@-]+)`)
+	// vpcRegexp matches the identifiers of the VPCs, e.g.: vpc-0a1b2c3d.
+	vpcRegexp = regexp.MustCompile(`\b(vpc-[0-9a-f]{8,17})\b`)
+	// messageResourceRegexps match the resources in the messages of any
+	// control, in order of preference.
+	messageResourceRegexps = []*regexp.Regexp{
+		// Security groups, e.g.: "Found Security Group: sg-0a1b2c3d (default)
+		// open to 0.0.0.0/0".
+		regexp.MustCompile(`\b(sg-[0-9a-f]{8,17})\b`),
+		// Buckets, e.g.: "Bucket my-logs has server access logging
+		// disabled".
+		regexp.MustCompile(`(?i)bucket:?\s+([a-z0-9][a-z0-9.-]{1,61}[a-z0-9])`),
+		vpcRegexp,
+	}
+)
+
+// controlResourceRegexps contains the patterns that match the resource in
+// the messages of specific controls. They take precedence over the generic
+// ones.
+var controlResourceRegexps = map[string]*regexp.Regexp{
+	// IAM users.
+	"1.2":  userRegexp,
+	"1.3":  userRegexp,
+	"1.4":  userRegexp,
+	"1.16": userRegexp,
+	// VPC flow logs.
+	"2.9": vpcRegexp,
+}
+
+// Resource returns the identifier of the resource affected by the given
+// finding. It returns the resource reported by prowler, if any. Otherwise it
+// extracts the resource from the message of the finding: an ARN when
+// present, or the resource matched by the patterns of the control, e.g.: the
+// name of a bucket, an IAM user or the ID of a security group. It returns an
+// empty string if the message does not refer to any resource.
+func Resource(fd Finding) string {
+	if fd.ResourceID != "" {
+		return fd.ResourceID
+	}
+	if arn := arnRegexp.FindString(fd.Message); arn != "" {
+		return arn
+	}
+	id := fd.ID
+	if id == "" {
+		id, _, _ = ParseControl(fd.Control)
+	}
+	if re, ok := controlResourceRegexps[id]; ok {
+		if m := re.FindStringSubmatch(fd.Message); m != nil {
+			return m[1]
+		}
+	}
+	for _, re := range messageResourceRegexps {
+		if m := re.FindStringSubmatch(fd.Message); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
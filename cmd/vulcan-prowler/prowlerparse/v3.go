@@ -0,0 +1,156 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package prowlerparse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+// FormatJSONV3 is the format generated by prowler v3 when it is executed with
+// the flag "-M json": a JSON array with one document per finding.
+const FormatJSONV3 Format = "json-v3"
+
+// v3CheckIDRegexp matches the identifiers of the prowler v3 checks, e.g.:
+// iam_root_mfa_enabled.
+var v3CheckIDRegexp = regexp.MustCompile(`^[a-z0-9]+(_[a-z0-9]+)+$`)
+
+// v3Severities maps the severities of the prowler v3 checks to the CIS
+// severity literals and their scores.
+var v3Severities = map[string]struct {
+	literal string
+	score   float32
+}{
+	"informational": {"Informational", report.SeverityThresholdNone},
+	"low":           {"Low", report.SeverityThresholdLow},
+	"medium":        {"Medium", report.SeverityThresholdMedium},
+	"high":          {"High", report.SeverityThresholdHigh},
+	"critical":      {"Critical", report.SeverityThresholdCritical},
+}
+
+// v3Statuses maps the statuses of the prowler v3 findings to the ones used by
+// prowler v2. The checks that can not be evaluated automatically are
+// reported by prowler v2 as informational.
+var v3Statuses = map[string]string{
+	"PASS":   "PASS",
+	"FAIL":   "FAIL",
+	"INFO":   "Info",
+	"MANUAL": "Info",
+}
+
+// v3Finding is an entry of a prowler v3 report.
+type v3Finding struct {
+	AssessmentStartTime string
+	Profile             string
+	AccountID           string `json:"AccountId"`
+	Region              string
+	CheckID             string
+	CheckTitle          string
+	ServiceName         string
+	Status              string
+	StatusExtended      string
+	Severity            string
+	ResourceID          string `json:"ResourceId"`
+	ResourceArn         string
+	Remediation         struct {
+		Recommendation struct {
+			Text string
+			URL  string `json:"Url"`
+		}
+	}
+	Compliance map[string][]string
+}
+
+// finding converts the entry into a [Finding].
+func (v v3Finding) finding() (Finding, error) {
+	if v.CheckID == "" {
+		return Finding{}, errors.New("missing check ID")
+	}
+	status, ok := v3Statuses[v.Status]
+	if !ok {
+		return Finding{}, fmt.Errorf("unknown status %q", v.Status)
+	}
+	fd := Finding{
+		Profile:     v.Profile,
+		Account:     v.AccountID,
+		Control:     fmt.Sprintf("[%s] %s", v.CheckID, v.CheckTitle),
+		Message:     v.StatusExtended,
+		Status:      status,
+		ControlID:   v.CheckID,
+		Region:      v.Region,
+		Timestamp:   v.AssessmentStartTime,
+		Compliance:  v3Compliance(v.Compliance),
+		Service:     v.ServiceName,
+		ResourceID:  v.ResourceID,
+		Severity:    strings.ToLower(v.Severity),
+		Remediation: v.Remediation.Recommendation.URL,
+	}
+	fd.RemediationText = v.Remediation.Recommendation.Text
+	if fd.ResourceID == "" {
+		fd.ResourceID = v.ResourceArn
+	}
+	setControl(&fd)
+	setNotEvaluated(&fd)
+	return fd, nil
+}
+
+// v3Compliance returns the requirements of the compliance frameworks
+// implemented by a check in the format used by prowler v2, e.g.:
+// "CIS-1.5:1.4 ENS-RD2022:op.acc.4.aws.iam.1".
+func v3Compliance(compliance map[string][]string) string {
+	var reqs []string
+	for framework, ids := range compliance {
+		for _, id := range ids {
+			reqs = append(reqs, framework+":"+id)
+		}
+	}
+	sort.Strings(reqs)
+	return strings.Join(reqs, " ")
+}
+
+// parseJSONV3 reads a prowler v3 report in the JSON format.
+func parseJSONV3(r io.Reader, stats Stats) ([]Finding, Stats, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if errors.Is(err, io.EOF) {
+		return nil, stats, nil
+	}
+	if err != nil {
+		return nil, stats, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, stats, fmt.Errorf("unexpected token %v, expected an array", tok)
+	}
+	var findings []Finding
+	for n := 1; dec.More(); n++ {
+		var v v3Finding
+		if err := dec.Decode(&v); err != nil {
+			return findings, stats, fmt.Errorf("invalid finding %d: %w", n, err)
+		}
+		fd, err := v.finding()
+		if err != nil {
+			return findings, stats, fmt.Errorf("invalid finding %d: %w", n, err)
+		}
+		stats.add(fd)
+		findings = append(findings, fd)
+	}
+	if _, err := dec.Token(); err != nil {
+		return findings, stats, err
+	}
+	return findings, stats, nil
+}
+
+// isV3CheckID returns true if the given identifier is the identifier of a
+// prowler v3 check.
+func isV3CheckID(id string) bool {
+	return v3CheckIDRegexp.MatchString(id)
+}
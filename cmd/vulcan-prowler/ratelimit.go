@@ -0,0 +1,48 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// defaultAPIRateLimit is the default maximum number of AWS API requests per
+// second made by the check.
+const defaultAPIRateLimit = 10
+
+// rateLimiter limits the rate of the AWS API requests made by the check.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter returns a rate limiter that allows rps requests per second.
+// If rps is not greater than 0 the requests are not limited.
+func newRateLimiter(rps int) *rateLimiter {
+	if rps <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(rps))}
+}
+
+// Wait blocks until a new request is allowed or the context is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop releases the resources of the rate limiter.
+func (l *rateLimiter) Stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanUnit is an independent execution of prowler: one group of controls, or
+// one control when check is set, in one region. An empty region means all
+// the regions.
+type scanUnit struct {
+	region string
+	group  string
+	// check is the identifier of the control executed, e.g.: 1.13. It is
+	// only set when the controls are executed individually.
+	check string
+}
+
+// name returns the name of the unit, that is used to name its report.
+func (u scanUnit) name() string {
+	var parts []string
+	for _, p := range []string{u.region, u.group, u.check} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// String returns a human readable representation of the unit.
+func (u scanUnit) String() string {
+	what := u.group
+	if u.check != "" {
+		what = "control " + u.check
+	}
+	return fmt.Sprintf("%s (%s)", regionLabel(u.region), what)
+}
+
+// unitFailure is a unit in which prowler failed.
+type unitFailure struct {
+	unit scanUnit
+	err  error
+}
+
+// emptyUnitsError returns an error joining the errors of the given failed
+// units in which prowler did not report any entry, so the check fails
+// instead of reporting the rest of the units as a clean scan. It returns nil
+// if there is no such unit.
+func emptyUnitsError(failures []unitFailure) error {
+	var errs []error
+	for _, f := range failures {
+		if errors.Is(f.err, errProwlerNoEntries) {
+			errs = append(errs, fmt.Errorf("%s: %w", f.unit, f.err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// regionLabel returns the label used in the reports for a region.
+func regionLabel(region string) string {
+	if region == "" {
+		return "all regions"
+	}
+	return region
+}
+
+// scanUnits returns the units needed to execute the given groups in the
+// given regions.
+func scanUnits(regions, groups []string) []scanUnit {
+	var units []scanUnit
+	for _, region := range regions {
+		for _, group := range groups {
+			units = append(units, scanUnit{region: region, group: group})
+		}
+	}
+	return units
+}
+
+// checkUnits returns the units needed to execute the given controls
+// individually in the given regions.
+func checkUnits(regions, checks []string) []scanUnit {
+	var units []scanUnit
+	for _, region := range regions {
+		for _, check := range checks {
+			units = append(units, scanUnit{region: region, check: check})
+		}
+	}
+	return units
+}
+
+// unitRunner runs prowler for one unit.
+type unitRunner func(ctx context.Context, u scanUnit) (*prowlerReport, error)
+
+// runUnits runs prowler once per unit using a pool of maxParallel workers and
+// merges the reports of all the units. A failure in one unit does not stop
+// the others, the regions with failed units are recorded in the returned
+// report. It only returns an error if prowler failed in all the units. The
+// regionDone function, if not nil, is called every time all the units of a
+// region finish.
+func runUnits(ctx context.Context, units []scanUnit, maxParallel int, run unitRunner, regionDone func(region string)) (*prowlerReport, error) {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	if maxParallel > len(units) {
+		maxParallel = len(units)
+	}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		merged    = &prowlerReport{regionDurations: map[string]time.Duration{}}
+		errs      []error
+		failed    = map[string]bool{}
+		remaining = map[string]int{}
+		queue     = make(chan scanUnit)
+	)
+	for _, u := range units {
+		remaining[u.region]++
+	}
+	// finish records the result of a unit. It must be called with mu held.
+	finish := func(u scanUnit, r *prowlerReport, err error, elapsed time.Duration) {
+		merged.regionDurations[u.region] += elapsed
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+			failed[u.region] = true
+			merged.failedUnits = append(merged.failedUnits, unitFailure{unit: u, err: err})
+		} else {
+			merged.merge(r)
+		}
+		remaining[u.region]--
+		if remaining[u.region] == 0 && regionDone != nil {
+			regionDone(u.region)
+		}
+	}
+	for i := 0; i < maxParallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				start := time.Now()
+				r, err := run(ctx, u)
+				if err != nil {
+					ctxLogger(ctx).Errorf("prowler failed in %s: %v", u, err)
+				}
+				mu.Lock()
+				finish(u, r, err, time.Since(start))
+				mu.Unlock()
+			}
+		}()
+	}
+	for i, u := range units {
+		select {
+		case queue <- u:
+			continue
+		case <-ctx.Done():
+		}
+		// The scan has been stopped, the units not started are recorded as
+		// failed.
+		mu.Lock()
+		for _, u := range units[i:] {
+			finish(u, nil, ctx.Err(), 0)
+		}
+		mu.Unlock()
+		break
+	}
+	close(queue)
+	wg.Wait()
+
+	// An aborted check must not report the results of the units finished
+	// before, unlike a scan stopped by the timeout, whose partial results
+	// are reported.
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
+	if len(errs) == len(units) {
+		return nil, fmt.Errorf("prowler failed in all the scans: %w", errors.Join(errs...))
+	}
+	// The units finish in any order, the entries are sorted by region to make
+	// the report deterministic.
+	sort.SliceStable(merged.entries, func(i, j int) bool {
+		return merged.entries[i].Region < merged.entries[j].Region
+	})
+	merged.entries = dedupEntries(merged.entries)
+	sort.Strings(merged.warnings)
+	sort.Slice(merged.failedUnits, func(i, j int) bool {
+		return merged.failedUnits[i].unit.name() < merged.failedUnits[j].unit.name()
+	})
+	for _, u := range units {
+		if failed[u.region] && !slices.Contains(merged.failedRegions, u.region) {
+			merged.failedRegions = append(merged.failedRegions, u.region)
+		}
+	}
+	if len(merged.failedRegions) > 0 {
+		ctxLogger(ctx).Warnf("prowler failed in regions: %s", strings.Join(merged.failedRegions, ", "))
+	}
+	return merged, nil
+}
+
+// dedupEntries removes the entries reported more than once because they
+// belong to several of the groups executed in the same region, e.g.:
+// cislevel1 and cislevel2.
+func dedupEntries(entries []entry) []entry {
+	type key struct {
+		control, region, status, message, resource string
+	}
+	seen := map[key]bool{}
+	deduped := entries[:0]
+	for _, e := range entries {
+		k := key{e.Control, e.Region, e.Status, e.Message, e.ResourceID}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		deduped = append(deduped, e)
+	}
+	return deduped
+}
+
+// merge adds the results of the report o to the report r.
+func (r *prowlerReport) merge(o *prowlerReport) {
+	r.entries = append(r.entries, o.entries...)
+	for _, g := range o.groups {
+		if !slices.Contains(r.groups, g) {
+			r.groups = append(r.groups, g)
+		}
+	}
+	r.truncated = r.truncated || o.truncated
+	if r.version == "" {
+		r.version = o.version
+	}
+	r.warnings = append(r.warnings, o.warnings...)
+	if o.usage.PeakRSSKB > r.usage.PeakRSSKB {
+		r.usage.PeakRSSKB = o.usage.PeakRSSKB
+	}
+	r.usage.UserCPUMs += o.usage.UserCPUMs
+	r.usage.SystemCPUMs += o.usage.SystemCPUMs
+	r.usage.OutputBytes += o.usage.OutputBytes
+}
+
+// regionDurationsDetails returns the time spent scanning each region sorted
+// by region, e.g.: "eu-west-1 2m3s, us-east-1 1m10s".
+func (r *prowlerReport) regionDurationsDetails() string {
+	regions := make([]string, 0, len(r.regionDurations))
+	for region := range r.regionDurations {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	var durations []string
+	for _, region := range regions {
+		d := r.regionDurations[region].Round(time.Second)
+		durations = append(durations, fmt.Sprintf("%s %s", regionLabel(region), d))
+	}
+	return strings.Join(durations, ", ")
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// fakeProwler is a unit runner that returns one entry per unit and records
+// the maximum number of concurrent executions.
+type fakeProwler struct {
+	running    int32
+	maxRunning int32
+	fail       map[string]bool
+}
+
+func (f *fakeProwler) run(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+	region := u.region
+	n := atomic.AddInt32(&f.running, 1)
+	defer atomic.AddInt32(&f.running, -1)
+	for {
+		max := atomic.LoadInt32(&f.maxRunning)
+		if n <= max || atomic.CompareAndSwapInt32(&f.maxRunning, max, n) {
+			break
+		}
+	}
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.fail[region] {
+		return nil, errors.New("prowler failed")
+	}
+	return &prowlerReport{
+		entries: []entry{{Control: "[check21] Ensure CloudTrail is enabled (Scored)", Status: "PASS", Region: region, Message: u.group}},
+		groups:  []string{u.group},
+		usage:   resourceUsage{PeakRSSKB: 100, UserCPUMs: 10},
+	}, nil
+}
+
+func TestRunUnits(t *testing.T) {
+	regions := []string{"eu-west-1", "eu-west-2", "us-east-1", "us-east-2", "ap-south-1"}
+	tests := []struct {
+		name        string
+		maxParallel int
+		fail        map[string]bool
+		wantRegions []string
+		wantFailed  []string
+		wantErr     bool
+	}{
+		{
+			name:        "Sequential",
+			maxParallel: 1,
+			wantRegions: []string{"ap-south-1", "eu-west-1", "eu-west-2", "us-east-1", "us-east-2"},
+		},
+		{
+			name:        "Parallel",
+			maxParallel: 3,
+			wantRegions: []string{"ap-south-1", "eu-west-1", "eu-west-2", "us-east-1", "us-east-2"},
+		},
+		{
+			name:        "OneRegionFails",
+			maxParallel: 2,
+			fail:        map[string]bool{"us-east-1": true},
+			wantRegions: []string{"ap-south-1", "eu-west-1", "eu-west-2", "us-east-2"},
+			wantFailed:  []string{"us-east-1"},
+		},
+		{
+			name:        "AllRegionsFail",
+			maxParallel: 2,
+			fail:        map[string]bool{"eu-west-1": true, "eu-west-2": true, "us-east-1": true, "us-east-2": true, "ap-south-1": true},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeProwler{fail: tt.fail}
+			var done []string
+			units := scanUnits(regions, []string{"cislevel2"})
+			r, err := runUnits(context.Background(), units, tt.maxParallel, f.run, func(region string) {
+				done = append(done, region)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := int(atomic.LoadInt32(&f.maxRunning)); got > tt.maxParallel {
+				t.Errorf("too many concurrent executions: %d", got)
+			}
+			if diff := cmp.Diff(regions, done, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("finished regions mismatch (-want +got):\n%s", diff)
+			}
+			if tt.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tt.wantRegions, r.regions()); diff != "" {
+				t.Errorf("regions mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantFailed, r.failedRegions); diff != "" {
+				t.Errorf("failed regions mismatch (-want +got):\n%s", diff)
+			}
+			if want := int64(10 * len(tt.wantRegions)); r.usage.UserCPUMs != want {
+				t.Errorf("unexpected user CPU: %d", r.usage.UserCPUMs)
+			}
+		})
+	}
+}
+
+func TestRunUnitsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f := &fakeProwler{}
+	_, err := runUnits(ctx, scanUnits([]string{"eu-west-1", "eu-west-2"}, []string{"cislevel2"}), 1, f.run, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestRunUnitsGroups(t *testing.T) {
+	regions := []string{"eu-west-1", "us-east-1"}
+	groups := []string{"cislevel1", "extras"}
+	f := &fakeProwler{fail: map[string]bool{}}
+	var (
+		mu   sync.Mutex
+		runs = map[string]int{}
+	)
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		mu.Lock()
+		runs[u.region]++
+		mu.Unlock()
+		return f.run(ctx, u)
+	}
+	var done []string
+	r, err := runUnits(context.Background(), scanUnits(regions, groups), 4, run, func(region string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if runs[region] != len(groups) {
+			t.Errorf("region %s finished after %d runs", region, runs[region])
+		}
+		done = append(done, region)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(done) != len(regions) {
+		t.Errorf("unexpected finished regions: %v", done)
+	}
+	if len(r.entries) != len(regions)*len(groups) {
+		t.Errorf("unexpected number of entries: %d", len(r.entries))
+	}
+	for i := 1; i < len(r.entries); i++ {
+		if r.entries[i-1].Region > r.entries[i].Region {
+			t.Errorf("entries not sorted by region: %v", r.entries)
+		}
+	}
+	if diff := cmp.Diff(groups, r.groups, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("groups mismatch (-want +got):\n%s", diff)
+	}
+	for _, region := range regions {
+		if _, ok := r.regionDurations[region]; !ok {
+			t.Errorf("no duration for region %s", region)
+		}
+	}
+}
+
+func TestRunUnitsCancelStopsWorkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fakeProwler{}
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		cancel()
+		return f.run(ctx, u)
+	}
+	units := scanUnits([]string{"eu-west-1", "eu-west-2", "us-east-1"}, []string{"cislevel2"})
+	_, err := runUnits(ctx, units, 1, run, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v is not %v", err, context.Canceled)
+	}
+}
+
+func TestRunUnitsCanceledAfterUnitFinished(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &fakeProwler{}
+	var finished int32
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		if atomic.AddInt32(&finished, 1) > 1 {
+			cancel()
+		}
+		return f.run(ctx, u)
+	}
+	units := scanUnits([]string{"eu-west-1", "eu-west-2", "us-east-1"}, []string{"cislevel2"})
+	r, err := runUnits(ctx, units, 1, run, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v is not %v", err, context.Canceled)
+	}
+	if r != nil {
+		t.Errorf("unexpected report of an aborted scan: %+v", r)
+	}
+}
+
+func TestRunUnitsDeadlineReportsPartialResults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	f := &fakeProwler{}
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		if u.region == "eu-west-2" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return f.run(ctx, u)
+	}
+	units := scanUnits([]string{"eu-west-1", "eu-west-2"}, []string{"cislevel2"})
+	r, err := runUnits(ctx, units, 1, run, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"eu-west-1"}, r.regions()); diff != "" {
+		t.Errorf("regions mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"eu-west-2"}, r.failedRegions); diff != "" {
+		t.Errorf("failed regions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEmptyUnitsError(t *testing.T) {
+	failures := []unitFailure{
+		{unit: scanUnit{region: "eu-west-1", group: "cislevel1"}, err: errors.New("throttled")},
+		{unit: scanUnit{region: "us-east-1", group: "cislevel1"}, err: fmt.Errorf("%w: groups cislevel1", errProwlerNoEntries)},
+	}
+	if err := emptyUnitsError(failures[:1]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := emptyUnitsError(failures)
+	if !errors.Is(err, errProwlerNoEntries) {
+		t.Fatalf("error %v is not %v", err, errProwlerNoEntries)
+	}
+	if strings.Contains(err.Error(), "throttled") {
+		t.Errorf("error %v contains the errors of other failures", err)
+	}
+}
+
+func TestDedupEntries(t *testing.T) {
+	entries := []entry{
+		{Control: "[check11] Avoid the use of the root account", Status: "FAIL", Region: "eu-west-1"},
+		{Control: "[check11] Avoid the use of the root account", Status: "FAIL", Region: "eu-west-1"},
+		{Control: "[check11] Avoid the use of the root account", Status: "FAIL", Region: "us-east-1"},
+	}
+	if got := dedupEntries(entries); len(got) != 2 {
+		t.Errorf("unexpected entries: %v", got)
+	}
+}
+
+func TestRegionDurationsDetails(t *testing.T) {
+	r := &prowlerReport{regionDurations: map[string]time.Duration{
+		"us-east-1": 70 * time.Second,
+		"eu-west-1": 123400 * time.Millisecond,
+	}}
+	want := "eu-west-1 2m3s, us-east-1 1m10s"
+	if got := r.regionDurationsDetails(); got != want {
+		t.Errorf("unexpected details, want: %q, got: %q", want, got)
+	}
+}
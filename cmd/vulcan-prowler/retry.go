@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultThrottlingRetries is the default number of times a unit is
+	// retried when prowler fails because AWS throttled its requests.
+	defaultThrottlingRetries = 3
+	// maxThrottlingRetries is the maximum number of retries allowed by the
+	// throttling_retries option.
+	maxThrottlingRetries = 10
+	// maxThrottlingBackoff is the maximum time waited between two retries.
+	maxThrottlingBackoff = 5 * time.Minute
+)
+
+// throttlingBackoff is the time waited before the first retry of a throttled
+// unit. It doubles with every retry.
+var throttlingBackoff = 30 * time.Second
+
+// errProwlerThrottled is returned when prowler fails because AWS throttled the
+// requests it made.
+var errProwlerThrottled = errors.New("prowler was throttled by AWS")
+
+// throttlingErrors are the messages written by prowler, or by the AWS CLI it
+// executes, when AWS throttles its requests.
+var throttlingErrors = []string{
+	"Throttling",
+	"Rate exceeded",
+	"TooManyRequestsException",
+	"RequestLimitExceeded",
+	"SlowDown",
+}
+
+// isThrottledOutput returns true if any of the given outputs of prowler
+// shows that AWS throttled its requests.
+func isThrottledOutput(outputs ...string) bool {
+	for _, out := range outputs {
+		for _, e := range throttlingErrors {
+			if strings.Contains(out, e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unitRetries records the retries of a unit that was throttled.
+type unitRetries struct {
+	unit    scanUnit
+	retries int
+	// err is the error of the last attempt. It is nil if the unit
+	// eventually succeeded.
+	err error
+}
+
+// String returns a human readable description of the retries, e.g.:
+// "eu-west-1 (cislevel2): 2 retries, succeeded".
+func (u unitRetries) String() string {
+	outcome := "succeeded"
+	if u.err != nil {
+		outcome = "failed"
+	}
+	return fmt.Sprintf("%s: %d retries, %s", u.unit, u.retries, outcome)
+}
+
+// retryRecorder collects the retries of the throttled units. It is safe for
+// concurrent use.
+type retryRecorder struct {
+	mu      sync.Mutex
+	records []unitRetries
+}
+
+func (r *retryRecorder) add(u unitRetries) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, u)
+}
+
+// all returns the retries recorded sorted by unit.
+func (r *retryRecorder) all() []unitRetries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	records := append([]unitRetries(nil), r.records...)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].unit.name() < records[j].unit.name()
+	})
+	return records
+}
+
+// retryThrottled returns a runner that retries the units that fail because
+// AWS throttled prowler up to maxRetries times, waiting an exponentially
+// increasing time between the attempts. The other errors are returned
+// without retrying. The units that are retried are recorded in rec.
+func retryThrottled(maxRetries int, rec *retryRecorder, run unitRunner) unitRunner {
+	return func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		backoff := throttlingBackoff
+		for retries := 0; ; retries++ {
+			r, err := run(ctx, u)
+			if !errors.Is(err, errProwlerThrottled) || retries == maxRetries {
+				if retries > 0 {
+					rec.add(unitRetries{unit: u, retries: retries, err: err})
+				}
+				return r, err
+			}
+			ctxLogger(ctx).Warnf("prowler throttled in %s, retrying in %s (%d/%d)", u, backoff, retries+1, maxRetries)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				rec.add(unitRetries{unit: u, retries: retries, err: ctx.Err()})
+				return nil, ctx.Err()
+			}
+			backoff = min(2*backoff, maxThrottlingBackoff)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetryThrottled(t *testing.T) {
+	old := throttlingBackoff
+	t.Cleanup(func() { throttlingBackoff = old })
+	throttlingBackoff = time.Millisecond
+
+	errOther := errors.New("other error")
+	tests := []struct {
+		name       string
+		errs       []error
+		maxRetries int
+		wantCalls  int
+		wantErr    error
+		wantRecord []string
+	}{
+		{
+			name:      "NoErrors",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:       "ThrottledThenSucceeds",
+			errs:       []error{errProwlerThrottled, errProwlerThrottled, nil},
+			maxRetries: 3,
+			wantCalls:  3,
+			wantRecord: []string{"eu-west-1 (cislevel2): 2 retries, succeeded"},
+		},
+		{
+			name:       "GivesUp",
+			errs:       []error{errProwlerThrottled, errProwlerThrottled, errProwlerThrottled},
+			maxRetries: 2,
+			wantCalls:  3,
+			wantErr:    errProwlerThrottled,
+			wantRecord: []string{"eu-west-1 (cislevel2): 2 retries, failed"},
+		},
+		{
+			name:       "NonRetryable",
+			errs:       []error{errOther},
+			maxRetries: 3,
+			wantCalls:  1,
+			wantErr:    errOther,
+		},
+		{
+			name:       "RetriesDisabled",
+			errs:       []error{errProwlerThrottled},
+			maxRetries: 0,
+			wantCalls:  1,
+			wantErr:    errProwlerThrottled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+				err := tt.errs[calls]
+				calls++
+				if err != nil {
+					return nil, fmt.Errorf("prowler failed: %w", err)
+				}
+				return &prowlerReport{}, nil
+			}
+			rec := &retryRecorder{}
+			_, err := retryThrottled(tt.maxRetries, rec, run)(context.Background(), scanUnit{region: "eu-west-1", group: "cislevel2"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v is not %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("unexpected number of calls, want: %d, got: %d", tt.wantCalls, calls)
+			}
+			var got []string
+			for _, r := range rec.all() {
+				got = append(got, r.String())
+			}
+			if diff := cmp.Diff(tt.wantRecord, got); diff != "" {
+				t.Errorf("unexpected retries (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestRetryThrottledCanceled(t *testing.T) {
+	old := throttlingBackoff
+	t.Cleanup(func() { throttlingBackoff = old })
+	throttlingBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := func(ctx context.Context, u scanUnit) (*prowlerReport, error) {
+		cancel()
+		return nil, errProwlerThrottled
+	}
+	rec := &retryRecorder{}
+	_, err := retryThrottled(3, rec, run)(ctx, scanUnit{group: "cislevel2"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v is not %v", err, context.Canceled)
+	}
+}
+
+func TestIsThrottledOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs []string
+		want    bool
+	}{
+		{name: "Throttling", outputs: []string{"An error occurred (Throttling) when calling the GetCredentialReport operation: Rate exceeded"}, want: true},
+		{name: "RequestLimitExceeded", outputs: []string{"", "An error occurred (RequestLimitExceeded) when calling the DescribeInstances operation"}, want: true},
+		{name: "AccessDenied", outputs: []string{"An error occurred (AccessDenied) when calling the ListUsers operation"}},
+		{name: "Empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isThrottledOutput(tt.outputs...); got != tt.want {
+				t.Errorf("unexpected result, want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildOptionsThrottlingRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		optJSON string
+		want    int
+		wantErr bool
+	}{
+		{name: "Default", optJSON: `{}`, want: defaultThrottlingRetries},
+		{name: "Disabled", optJSON: `{"throttling_retries": 0}`, want: 0},
+		{name: "Custom", optJSON: `{"throttling_retries": 5}`, want: 5},
+		{name: "Negative", optJSON: `{"throttling_retries": -1}`, wantErr: true},
+		{name: "TooMany", optJSON: `{"throttling_retries": 11}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := buildOptions(tt.optJSON, "aws")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if *opts.ThrottlingRetries != tt.want {
+				t.Errorf("unexpected throttling retries, want: %d, got: %d", tt.want, *opts.ThrottlingRetries)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+const (
+	// serviceS3 is the service of the controls that check S3 buckets.
+	serviceS3 = "s3"
+
+	// defaultEnrichS3MaxBuckets is the default maximum number of buckets
+	// whose current exposure is retrieved.
+	defaultEnrichS3MaxBuckets = 50
+
+	// exposureUnknown is the exposure reported when it can not be
+	// retrieved.
+	exposureUnknown = "unknown"
+
+	errCodeNoSuchPublicAccessBlock = "NoSuchPublicAccessBlockConfiguration"
+	errCodeNoSuchBucketPolicy      = "NoSuchBucketPolicy"
+	errCodeNoSuchEncryption        = "ServerSideEncryptionConfigurationNotFoundError"
+)
+
+// s3API contains the S3 operations used to retrieve the current exposure of
+// the buckets.
+type s3API interface {
+	GetPublicAccessBlock(context.Context, *s3.GetPublicAccessBlockInput, ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error)
+	GetBucketPolicyStatus(context.Context, *s3.GetBucketPolicyStatusInput, ...func(*s3.Options)) (*s3.GetBucketPolicyStatusOutput, error)
+	GetBucketEncryption(context.Context, *s3.GetBucketEncryptionInput, ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error)
+}
+
+// s3Exposures contains the summary of the current exposure of the buckets
+// keyed by bucket name.
+type s3Exposures map[string]string
+
+// s3Enricher retrieves the current exposure of the buckets referenced by the
+// failed S3 controls.
+type s3Enricher struct {
+	// bucketRegion returns the region of a bucket.
+	bucketRegion func(ctx context.Context, bucket string) (string, error)
+	// client returns the S3 client for a region.
+	client     func(region string) s3API
+	limiter    *rateLimiter
+	maxBuckets int
+}
+
+// newS3Enricher returns an enricher that uses the AWS S3 API. The region
+// of the config is used to look up the region of the buckets.
+func newS3Enricher(cfg aws.Config, overrides map[string]string, limiter *rateLimiter, maxBuckets int) *s3Enricher {
+	clients := map[string]*s3.Client{}
+	client := func(region string) *s3.Client {
+		if c, ok := clients[region]; ok {
+			return c
+		}
+		c := newS3Client(cfg, overrides, region)
+		clients[region] = c
+		return c
+	}
+	return &s3Enricher{
+		bucketRegion: func(ctx context.Context, bucket string) (string, error) {
+			return manager.GetBucketRegion(ctx, client(cfg.Region), bucket)
+		},
+		client: func(region string) s3API {
+			return client(region)
+		},
+		limiter:    limiter,
+		maxBuckets: maxBuckets,
+	}
+}
+
+// bucketRegexp matches the bucket names in the messages of the S3
+// controls.
+var bucketRegexp = regexp.MustCompile(`(?i)bucket:?\s+([a-z0-9][a-z0-9.-]{1,61}[a-z0-9])`)
+
+// entryBuckets returns the buckets referenced in the message of an entry.
+func entryBuckets(e entry) []string {
+	var buckets []string
+	for _, m := range bucketRegexp.FindAllStringSubmatch(e.Message, -1) {
+		buckets = append(buckets, m[1])
+	}
+	return buckets
+}
+
+// isS3Control returns true if the metadata of the control of the entry is
+// tagged with the S3 service.
+func isS3Control(e entry, controls map[string]CISControl) bool {
+	control, _, err := prowlerparse.ParseControl(e.Control)
+	if err != nil {
+		return false
+	}
+	return controls[control].Service == serviceS3
+}
+
+// enrich returns the current exposure of the buckets referenced by the
+// failed S3 controls of the report. At most maxBuckets buckets are queried.
+func (s *s3Enricher) enrich(ctx context.Context, r *prowlerReport, controls map[string]CISControl) s3Exposures {
+	exposures := s3Exposures{}
+	for _, e := range r.entries {
+		if e.Status != "FAIL" || !isS3Control(e, controls) {
+			continue
+		}
+		for _, b := range entryBuckets(e) {
+			if _, ok := exposures[b]; ok {
+				continue
+			}
+			if len(exposures) >= s.maxBuckets {
+				ctxLogger(ctx).Warnf("maximum number of buckets to enrich reached (%d), skipping bucket %s", s.maxBuckets, b)
+				continue
+			}
+			exposures[b] = s.bucketExposure(ctx, b)
+		}
+	}
+	return exposures
+}
+
+// bucketExposure returns a summary of the public access and the encryption
+// state of a bucket. The parts of the summary that can not be retrieved are
+// reported as unknown.
+func (s *s3Enricher) bucketExposure(ctx context.Context, bucket string) string {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return exposureUnknown
+	}
+	region, err := s.bucketRegion(ctx, bucket)
+	if err != nil {
+		ctxLogger(ctx).Warnf("can not get the region of bucket %s: %v", bucket, err)
+		return exposureUnknown
+	}
+	c := s.client(region)
+	return fmt.Sprintf("%s; %s", s.publicAccess(ctx, c, bucket), s.encryption(ctx, c, bucket))
+}
+
+func (s *s3Enricher) publicAccess(ctx context.Context, c s3API, bucket string) string {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return "Public: " + exposureUnknown
+	}
+	pab, err := c.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	switch {
+	case err == nil:
+		if isPublicAccessBlocked(pab.PublicAccessBlockConfiguration) {
+			return "Not public (public access blocked)"
+		}
+	case isErrCode(err, errCodeNoSuchPublicAccessBlock):
+	default:
+		ctxLogger(ctx).Warnf("can not get the public access block of bucket %s: %v", bucket, err)
+	}
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return "Public: " + exposureUnknown
+	}
+	ps, err := c.GetBucketPolicyStatus(ctx, &s3.GetBucketPolicyStatusInput{Bucket: aws.String(bucket)})
+	switch {
+	case err == nil:
+		if ps.PolicyStatus != nil && aws.ToBool(ps.PolicyStatus.IsPublic) {
+			return "Public (bucket policy)"
+		}
+		return "Not public"
+	case isErrCode(err, errCodeNoSuchBucketPolicy):
+		return "Not public"
+	default:
+		ctxLogger(ctx).Warnf("can not get the policy status of bucket %s: %v", bucket, err)
+		return "Public: " + exposureUnknown
+	}
+}
+
+func (s *s3Enricher) encryption(ctx context.Context, c s3API, bucket string) string {
+	if err := s.limiter.Wait(ctx); err != nil {
+		return "Encryption: " + exposureUnknown
+	}
+	enc, err := c.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+	switch {
+	case err == nil:
+		var algs []string
+		if enc.ServerSideEncryptionConfiguration != nil {
+			for _, rule := range enc.ServerSideEncryptionConfiguration.Rules {
+				if rule.ApplyServerSideEncryptionByDefault == nil {
+					continue
+				}
+				algs = append(algs, string(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+			}
+		}
+		if len(algs) == 0 {
+			return "Not encrypted"
+		}
+		return fmt.Sprintf("Encrypted (%s)", strings.Join(algs, ", "))
+	case isErrCode(err, errCodeNoSuchEncryption):
+		return "Not encrypted"
+	default:
+		ctxLogger(ctx).Warnf("can not get the encryption of bucket %s: %v", bucket, err)
+		return "Encryption: " + exposureUnknown
+	}
+}
+
+func isPublicAccessBlocked(cfg *s3types.PublicAccessBlockConfiguration) bool {
+	if cfg == nil {
+		return false
+	}
+	return aws.ToBool(cfg.BlockPublicAcls) &&
+		aws.ToBool(cfg.IgnorePublicAcls) &&
+		aws.ToBool(cfg.BlockPublicPolicy) &&
+		aws.ToBool(cfg.RestrictPublicBuckets)
+}
+
+// isErrCode returns true if err is an error returned by the AWS API with
+// the given code.
+func isErrCode(err error, code string) bool {
+	var aerr smithy.APIError
+	return errors.As(err, &aerr) && aerr.ErrorCode() == code
+}
+
+// entryExposure returns the current exposure of the buckets referenced by an
+// entry.
+func (exposures s3Exposures) entryExposure(e entry) string {
+	var parts []string
+	for _, b := range entryBuckets(e) {
+		exposure, ok := exposures[b]
+		if !ok {
+			exposure = exposureUnknown
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", b, exposure))
+	}
+	if len(parts) == 0 {
+		return exposureUnknown
+	}
+	return strings.Join(parts, ", ")
+}
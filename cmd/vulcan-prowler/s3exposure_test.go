@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeBucket struct {
+	pab       *s3types.PublicAccessBlockConfiguration
+	pabErr    error
+	public    bool
+	policyErr error
+	sse       string
+	sseErr    error
+}
+
+type fakeS3 map[string]fakeBucket
+
+func (f fakeS3) GetPublicAccessBlock(_ context.Context, in *s3.GetPublicAccessBlockInput, _ ...func(*s3.Options)) (*s3.GetPublicAccessBlockOutput, error) {
+	b := f[aws.ToString(in.Bucket)]
+	if b.pabErr != nil {
+		return nil, b.pabErr
+	}
+	return &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: b.pab}, nil
+}
+
+func (f fakeS3) GetBucketPolicyStatus(_ context.Context, in *s3.GetBucketPolicyStatusInput, _ ...func(*s3.Options)) (*s3.GetBucketPolicyStatusOutput, error) {
+	b := f[aws.ToString(in.Bucket)]
+	if b.policyErr != nil {
+		return nil, b.policyErr
+	}
+	return &s3.GetBucketPolicyStatusOutput{PolicyStatus: &s3types.PolicyStatus{IsPublic: aws.Bool(b.public)}}, nil
+}
+
+func (f fakeS3) GetBucketEncryption(_ context.Context, in *s3.GetBucketEncryptionInput, _ ...func(*s3.Options)) (*s3.GetBucketEncryptionOutput, error) {
+	b := f[aws.ToString(in.Bucket)]
+	if b.sseErr != nil {
+		return nil, b.sseErr
+	}
+	return &s3.GetBucketEncryptionOutput{
+		ServerSideEncryptionConfiguration: &s3types.ServerSideEncryptionConfiguration{
+			Rules: []s3types.ServerSideEncryptionRule{
+				{ApplyServerSideEncryptionByDefault: &s3types.ServerSideEncryptionByDefault{SSEAlgorithm: s3types.ServerSideEncryption(b.sse)}},
+			},
+		},
+	}, nil
+}
+
+func TestS3EnricherEnrich(t *testing.T) {
+	api := fakeS3{
+		"public-bucket": {
+			pabErr: &smithy.GenericAPIError{Code: errCodeNoSuchPublicAccessBlock},
+			public: true,
+			sseErr: &smithy.GenericAPIError{Code: errCodeNoSuchEncryption},
+		},
+		"blocked-bucket": {
+			pab: &s3types.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+			sse: "aws:kms",
+		},
+		"denied-bucket": {
+			pabErr:    &smithy.GenericAPIError{Code: "AccessDenied"},
+			policyErr: &smithy.GenericAPIError{Code: "AccessDenied"},
+			sseErr:    &smithy.GenericAPIError{Code: "AccessDenied"},
+		},
+	}
+	limiter := newRateLimiter(0)
+	defer limiter.Stop()
+	enricher := &s3Enricher{
+		bucketRegion: func(ctx context.Context, bucket string) (string, error) {
+			if bucket == "missing-bucket" {
+				return "", errors.New("not found")
+			}
+			return "eu-west-1", nil
+		},
+		client:     func(region string) s3API { return api },
+		limiter:    limiter,
+		maxBuckets: 4,
+	}
+	controls := map[string]CISControl{
+		"2.3": {ID: "2.3", Service: serviceS3},
+		"2.6": {ID: "2.6", Service: serviceS3},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check23] Ensure the S3 bucket CloudTrail logs to is not publicly accessible (Scored)", Status: "FAIL", Message: "CloudTrail bucket public-bucket is publicly accessible"},
+			{Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)", Status: "FAIL", Message: "Bucket blocked-bucket has access logging disabled"},
+			{Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)", Status: "FAIL", Message: "Bucket denied-bucket has access logging disabled"},
+			{Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)", Status: "FAIL", Message: "Bucket missing-bucket has access logging disabled"},
+			{Control: "[check26] Ensure S3 bucket access logging is enabled on the CloudTrail S3 bucket (Scored)", Status: "PASS", Message: "Bucket passed-bucket has access logging enabled"},
+			{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL", Message: "Bucket other-bucket is not an S3 control"},
+		},
+	}
+	got := enricher.enrich(context.Background(), r, controls)
+	want := s3Exposures{
+		"public-bucket":  "Public (bucket policy); Not encrypted",
+		"blocked-bucket": "Not public (public access blocked); Encrypted (aws:kms)",
+		"denied-bucket":  "Public: unknown; Encryption: unknown",
+		"missing-bucket": "unknown",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("exposures mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestS3EnricherMaxBuckets(t *testing.T) {
+	limiter := newRateLimiter(0)
+	defer limiter.Stop()
+	enricher := &s3Enricher{
+		bucketRegion: func(ctx context.Context, bucket string) (string, error) { return "eu-west-1", nil },
+		client:       func(region string) s3API { return fakeS3{} },
+		limiter:      limiter,
+		maxBuckets:   1,
+	}
+	controls := map[string]CISControl{"2.6": {ID: "2.6", Service: serviceS3}}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check26] Ensure S3 bucket access logging (Scored)", Status: "FAIL", Message: "Bucket bucket-one has access logging disabled"},
+			{Control: "[check26] Ensure S3 bucket access logging (Scored)", Status: "FAIL", Message: "Bucket bucket-two has access logging disabled"},
+		},
+	}
+	exposures := enricher.enrich(context.Background(), r, controls)
+	if len(exposures) != 1 {
+		t.Errorf("unexpected number of buckets enriched: %d", len(exposures))
+	}
+	if got := exposures.entryExposure(r.entries[1]); got != "bucket-two: unknown" {
+		t.Errorf("unexpected exposure: %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// sanitizeStats contains the number of characters modified by the
+// sanitization of the strings included in the report.
+type sanitizeStats struct {
+	// ControlChars is the number of C0 and C1 control characters removed.
+	ControlChars int
+	// FormatChars is the number of bidirectional formatting and zero-width
+	// characters removed.
+	FormatChars int
+	// InvalidUTF8 is the number of invalid UTF-8 sequences replaced.
+	InvalidUTF8 int
+}
+
+func (s sanitizeStats) total() int {
+	return s.ControlChars + s.FormatChars + s.InvalidUTF8
+}
+
+// isFormatChar returns true for the bidirectional formatting characters, that
+// can be used to visually reorder the text, and for the zero-width
+// characters, that can be used to make two different strings look equal.
+func isFormatChar(r rune) bool {
+	switch {
+	case r == '\u061c', r == '\u200e', r == '\u200f':
+		// Arabic letter mark, left-to-right and right-to-left marks.
+		return true
+	case r >= '\u202a' && r <= '\u202e':
+		// Embeddings and overrides.
+		return true
+	case r >= '\u2066' && r <= '\u2069':
+		// Isolates.
+		return true
+	case r >= '\u200b' && r <= '\u200d', r == '\u2060', r == '\ufeff':
+		// Zero-width space, non-joiner and joiner, word joiner and
+		// zero-width no-break space.
+		return true
+	}
+	return false
+}
+
+// sanitizeString returns a copy of s that is valid UTF-8 and does not
+// contain control or bidirectional formatting characters. Invalid UTF-8
+// sequences are replaced by the Unicode replacement character, tabs and line
+// breaks are replaced by a space and the rest of control and formatting
+// characters are removed.
+func sanitizeString(s string, stats *sanitizeStats) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == utf8.RuneError && size == 1:
+			stats.InvalidUTF8++
+			b.WriteRune(utf8.RuneError)
+		case r == '\t' || r == '\n' || r == '\r':
+			stats.ControlChars++
+			b.WriteByte(' ')
+		case unicode.IsControl(r):
+			stats.ControlChars++
+		case isFormatChar(r):
+			stats.FormatChars++
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// sanitizeEntries sanitizes the fields of the entries that are included in
+// the report.
+func sanitizeEntries(entries []entry) sanitizeStats {
+	var stats sanitizeStats
+	for i := range entries {
+		e := &entries[i]
+		e.Control = sanitizeString(e.Control, &stats)
+		e.Message = sanitizeString(e.Message, &stats)
+		e.Region = sanitizeString(e.Region, &stats)
+		e.Description = sanitizeString(e.Description, &stats)
+	}
+	return stats
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSanitizeString(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      string
+		wantStats sanitizeStats
+	}{
+		{
+			name:  "clean",
+			input: "Bucket my-logs has no MFA delete, ñandú 日本",
+			want:  "Bucket my-logs has no MFA delete, ñandú 日本",
+		},
+		{
+			name:      "bidi override",
+			input:     "User admin\u202egpj.exe\u202c has MFA",
+			want:      "User admingpj.exe has MFA",
+			wantStats: sanitizeStats{FormatChars: 2},
+		},
+		{
+			name:      "isolates and marks",
+			input:     "\u2067name\u2069\u200f",
+			want:      "name",
+			wantStats: sanitizeStats{FormatChars: 3},
+		},
+		{
+			name:      "zero width",
+			input:     "ad\u200bmin\ufeff",
+			want:      "admin",
+			wantStats: sanitizeStats{FormatChars: 2},
+		},
+		{
+			name:      "control characters",
+			input:     "line1\nline2\ttab\x00\x1b[31mred\u0085",
+			want:      "line1 line2 tab[31mred",
+			wantStats: sanitizeStats{ControlChars: 5},
+		},
+		{
+			name:      "invalid utf8",
+			input:     "bucket\xff\xfename",
+			want:      "bucket\ufffd\ufffdname",
+			wantStats: sanitizeStats{InvalidUTF8: 2},
+		},
+		{
+			name:      "truncated utf8",
+			input:     "name\xe6\x97",
+			want:      "name\ufffd\ufffd",
+			wantStats: sanitizeStats{InvalidUTF8: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats sanitizeStats
+			got := sanitizeString(tt.input, &stats)
+			if got != tt.want {
+				t.Errorf("unexpected output, want: %q, got: %q", tt.want, got)
+			}
+			if diff := cmp.Diff(tt.wantStats, stats); diff != "" {
+				t.Errorf("unexpected stats (-want +got):\n%v", diff)
+			}
+			// The sanitization must be stable.
+			var again sanitizeStats
+			if got2 := sanitizeString(got, &again); got2 != got || again.total() != 0 {
+				t.Errorf("unstable output: %q, %+v", got2, again)
+			}
+		})
+	}
+}
+
+func TestSanitizeEntries(t *testing.T) {
+	entries := []entry{
+		{
+			Control: "[check13] Ensure credentials\x07 unused (Scored)",
+			Message: "User \u202eadmin has \xffkeys",
+			Region:  "eu-west-1\n",
+		},
+	}
+	stats := sanitizeEntries(entries)
+	want := entry{
+		Control: "[check13] Ensure credentials unused (Scored)",
+		Message: "User admin has \ufffdkeys",
+		Region:  "eu-west-1 ",
+	}
+	if diff := cmp.Diff(want, entries[0]); diff != "" {
+		t.Errorf("unexpected entry (-want +got):\n%v", diff)
+	}
+	if diff := cmp.Diff(sanitizeStats{ControlChars: 2, FormatChars: 1, InvalidUTF8: 1}, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// Reasons why a control of the benchmark is not executed.
+const (
+	skipReasonNotInGroups = "not in selected groups"
+	skipReasonExcluded    = "excluded by option extra_args"
+)
+
+// skippedControl is a control of the benchmark that is not executed.
+type skippedControl struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// groupIncludes returns true if the given prowler group executes the
+// control.
+func groupIncludes(group string, c CISControl) bool {
+	switch group {
+	case "cislevel1":
+		return c.Level <= 1
+	case "cislevel2":
+		return true
+	case "group1", "group2", "group3", "group4":
+		// The groups 1 to 4 contain the controls of the corresponding
+		// section of the benchmark.
+		return strings.HasPrefix(c.ID, strings.TrimPrefix(group, "group")+".")
+	}
+	return false
+}
+
+// hasScoredControls returns true if the given prowler group is known to
+// contain scored CIS controls. Prowler always reports, at least, one entry
+// per executed control, so its reports for these groups are never empty.
+func hasScoredControls(group string) bool {
+	switch group {
+	case "cislevel1", "cislevel2", "group1", "group2", "group3", "group4":
+		return true
+	}
+	return false
+}
+
+// excludedChecks returns the controls excluded with the -E flag of the
+// extra_args option.
+func excludedChecks(extraArgs []string) map[string]bool {
+	excluded := map[string]bool{}
+	for i := 0; i < len(extraArgs)-1; i++ {
+		if extraArgs[i] != "-E" {
+			continue
+		}
+		for _, check := range strings.Split(extraArgs[i+1], ",") {
+			control, _, err := prowlerparse.ParseControl("[" + check + "] ")
+			if err != nil {
+				continue
+			}
+			excluded[control] = true
+		}
+	}
+	return excluded
+}
+
+// resolveScope returns the controls of the benchmark that are not executed
+// with the given groups and extra args, sorted by control, together with the
+// reason why they are skipped. When more than one reason applies to a
+// control, the first one in the order the filters are applied is reported.
+// The min_severity option does not prevent the controls from being executed,
+// so it is not a reason to skip them.
+func resolveScope(groups []string, extraArgs []string, controls map[string]CISControl) []skippedControl {
+	excluded := excludedChecks(extraArgs)
+	var skipped []skippedControl
+	for id, c := range controls {
+		included := false
+		for _, g := range groups {
+			if groupIncludes(g, c) {
+				included = true
+				break
+			}
+		}
+		switch {
+		case !included:
+			skipped = append(skipped, skippedControl{ID: id, Reason: skipReasonNotInGroups})
+		case excluded[id]:
+			skipped = append(skipped, skippedControl{ID: id, Reason: skipReasonExcluded})
+		}
+	}
+	slices.SortFunc(skipped, func(a, b skippedControl) int {
+		return prowlerparse.CompareControls(a.ID, b.ID)
+	})
+	return skipped
+}
+
+// skipReasons returns the number of skipped controls per reason and the
+// sorted list of reasons.
+func skipReasons(skipped []skippedControl) (map[string]int, []string) {
+	counts := map[string]int{}
+	var reasons []string
+	for _, s := range skipped {
+		if counts[s.Reason] == 0 {
+			reasons = append(reasons, s.Reason)
+		}
+		counts[s.Reason]++
+	}
+	sort.Strings(reasons)
+	return counts, reasons
+}
+
+// scopeControls returns the sorted identifiers of the controls of the
+// benchmark that are executed with the given groups and extra args.
+func scopeControls(groups []string, extraArgs []string, controls map[string]CISControl) []string {
+	skipped := map[string]bool{}
+	for _, s := range resolveScope(groups, extraArgs, controls) {
+		skipped[s.ID] = true
+	}
+	var ids []string
+	for id := range controls {
+		if !skipped[id] {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, prowlerparse.CompareControls)
+	return ids
+}
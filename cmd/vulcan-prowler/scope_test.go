@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+func TestResolveScope(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.2":  {ID: "1.2", Level: 2},
+		"1.3":  {ID: "1.3", Level: 1},
+		"1.14": {ID: "1.14", Level: 2},
+		"2.2":  {ID: "2.2", Level: 2},
+		"2.1":  {ID: "2.1", Level: 1},
+		"3.1":  {ID: "3.1", Level: 1},
+	}
+	tests := []struct {
+		name      string
+		groups    []string
+		extraArgs []string
+		want      []skippedControl
+	}{
+		{
+			name:   "Level2",
+			groups: []string{"cislevel2"},
+		},
+		{
+			name:   "Level1",
+			groups: []string{"cislevel1"},
+			want: []skippedControl{
+				{ID: "1.2", Reason: skipReasonNotInGroups},
+				{ID: "1.14", Reason: skipReasonNotInGroups},
+				{ID: "2.2", Reason: skipReasonNotInGroups},
+			},
+		},
+		{
+			name:      "SectionGroupAndExcluded",
+			groups:    []string{"group1"},
+			extraArgs: []string{"-q", "-E", "check13,check22,extra718"},
+			want: []skippedControl{
+				{ID: "1.3", Reason: skipReasonExcluded},
+				{ID: "2.1", Reason: skipReasonNotInGroups},
+				{ID: "2.2", Reason: skipReasonNotInGroups},
+				{ID: "3.1", Reason: skipReasonNotInGroups},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveScope(tt.groups, tt.extraArgs, controls)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("skipped controls mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolveScopeOneReason(t *testing.T) {
+	content, err := os.ReadFile(bundledControlsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mf, err := prowlerparse.LoadMetadata(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reasons := map[string]bool{
+		skipReasonNotInGroups: true,
+		skipReasonExcluded:    true,
+	}
+	skipped := resolveScope([]string{"cislevel1"}, []string{"-E", "check11,check12,check214"}, mf.Controls)
+	if len(skipped) == 0 {
+		t.Fatal("expected skipped controls")
+	}
+	seen := map[string]bool{}
+	for _, s := range skipped {
+		if seen[s.ID] {
+			t.Errorf("control %s skipped more than once", s.ID)
+		}
+		seen[s.ID] = true
+		if !reasons[s.Reason] {
+			t.Errorf("control %s skipped with unexpected reason %q", s.ID, s.Reason)
+		}
+	}
+}
+
+func TestHasScoredControls(t *testing.T) {
+	tests := map[string]bool{
+		"cislevel1": true,
+		"cislevel2": true,
+		"group1":    true,
+		"group4":    true,
+		"extras":    false,
+		"group7":    false,
+		"custom":    false,
+	}
+	for group, want := range tests {
+		if got := hasScoredControls(group); got != want {
+			t.Errorf("hasScoredControls(%q) = %v, want %v", group, got, want)
+		}
+	}
+}
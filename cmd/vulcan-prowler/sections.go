@@ -0,0 +1,105 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+// cisSections maps the leading number of the identifier of the CIS controls
+// to the name of the section of the benchmark they belong to.
+var cisSections = map[string]string{
+	"1": "IAM",
+	"2": "Logging",
+	"3": "Monitoring",
+	"4": "Networking",
+}
+
+// otherSection is the section of the controls that do not belong to any
+// section of the benchmark, e.g.: the extras.
+const otherSection = "Other"
+
+// controlSection returns the section of the given control, e.g.: "1. IAM" for
+// the control 1.3.
+func controlSection(control string) string {
+	n, _, ok := strings.Cut(control, ".")
+	if !ok {
+		return otherSection
+	}
+	name, ok := cisSections[n]
+	if !ok {
+		return otherSection
+	}
+	return fmt.Sprintf("%s. %s", n, name)
+}
+
+// sortSections sorts the sections by their number, leaving otherSection at
+// the end.
+func sortSections(sections []string) {
+	number := func(s string) int {
+		n, _, _ := strings.Cut(s, ".")
+		i, err := strconv.Atoi(n)
+		if err != nil {
+			return len(cisSections) + 1
+		}
+		return i
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return number(sections[i]) < number(sections[j])
+	})
+}
+
+// sectionGroups returns one failed controls table per section with failed
+// controls. The rows keep the order they have in the given slice.
+func sectionGroups(rows []controlRow, header []string) []report.ResourcesGroup {
+	var (
+		sections []string
+		bySec    = map[string][]map[string]string{}
+	)
+	for _, r := range rows {
+		s := controlSection(r.control)
+		if _, ok := bySec[s]; !ok {
+			sections = append(sections, s)
+		}
+		bySec[s] = append(bySec[s], r.row)
+	}
+	sortSections(sections)
+	var groups []report.ResourcesGroup
+	for _, s := range sections {
+		groups = append(groups, report.ResourcesGroup{
+			Name:   "Failed Controls — " + s,
+			Header: header,
+			Rows:   bySec[s],
+		})
+	}
+	return groups
+}
+
+// sectionDetails returns the number of failed controls in each section, e.g.:
+// "Failed Controls in 1. IAM: 3".
+func sectionDetails(rows []controlRow) string {
+	var (
+		sections []string
+		counts   = map[string]int{}
+	)
+	for _, r := range rows {
+		s := controlSection(r.control)
+		if _, ok := counts[s]; !ok {
+			sections = append(sections, s)
+		}
+		counts[s]++
+	}
+	sortSections(sections)
+	var b strings.Builder
+	for _, s := range sections {
+		fmt.Fprintf(&b, "Failed Controls in %s: %d\n", s, counts[s])
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestControlSection(t *testing.T) {
+	tests := []struct {
+		control string
+		want    string
+	}{
+		{control: "1.3", want: "1. IAM"},
+		{control: "2.9", want: "2. Logging"},
+		{control: "3.14", want: "3. Monitoring"},
+		{control: "4.1", want: "4. Networking"},
+		{control: "5.1", want: otherSection},
+		{control: "extra718", want: otherSection},
+	}
+	for _, tt := range tests {
+		t.Run(tt.control, func(t *testing.T) {
+			if got := controlSection(tt.control); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func sectionRow(control string, score float32) controlRow {
+	return controlRow{control: control, score: score, row: map[string]string{"Control": control}}
+}
+
+func TestSectionGroups(t *testing.T) {
+	rows := []controlRow{
+		sectionRow("extra718", 8.9),
+		sectionRow("4.1", 8.9),
+		sectionRow("1.3", 6.9),
+		sectionRow("4.2", 6.9),
+		sectionRow("1.14", 3.9),
+	}
+	header := []string{"Control"}
+	want := []report.ResourcesGroup{
+		{
+			Name:   "Failed Controls — 1. IAM",
+			Header: header,
+			Rows:   []map[string]string{{"Control": "1.3"}, {"Control": "1.14"}},
+		},
+		{
+			Name:   "Failed Controls — 4. Networking",
+			Header: header,
+			Rows:   []map[string]string{{"Control": "4.1"}, {"Control": "4.2"}},
+		},
+		{
+			Name:   "Failed Controls — Other",
+			Header: header,
+			Rows:   []map[string]string{{"Control": "extra718"}},
+		},
+	}
+	got := sectionGroups(rows, header)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected groups (-want +got):\n%v", diff)
+	}
+	wantDetails := "Failed Controls in 1. IAM: 2\n" +
+		"Failed Controls in 4. Networking: 2\n" +
+		"Failed Controls in Other: 1\n"
+	if diff := cmp.Diff(wantDetails, sectionDetails(rows)); diff != "" {
+		t.Errorf("unexpected details (-want +got):\n%v", diff)
+	}
+}
+
+func TestFillCISLevelVulnGroupBySection(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium"},
+		"4.1": {ID: "4.1", Severity: 8.9, SeverityLiteral: "High"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{
+				Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "User user1 has not used access key 1 since creation",
+			},
+			{
+				Control: "[check41] Ensure no security groups allow ingress from 0.0.0.0/0 or ::/0 to port 22 (Scored)",
+				Status:  "FAIL",
+				Region:  "eu-west-1",
+				Message: "Found Security Group: sg-1234 open to 0.0.0.0/0",
+			},
+		},
+	}
+	for _, group := range []bool{false, true} {
+		v, err := renderVuln(frameworkCIS, "2", "alias")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, options{GroupBySection: group}, controls)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		for _, g := range fv.Resources {
+			got = append(got, g.Name)
+		}
+		want := []string{"Failed Controls"}
+		if group {
+			want = []string{"Failed Controls — 1. IAM", "Failed Controls — 4. Networking"}
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("group_by_section %v: unexpected resources (-want +got):\n%v", group, diff)
+		}
+	}
+}
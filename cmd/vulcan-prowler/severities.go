@@ -0,0 +1,72 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// severityCounts contains the number of failed controls of each CIS
+// severity. The controls whose severity literal is not one of Critical,
+// High, Medium or Low, e.g.: the controls without metadata, are counted as
+// unclassified.
+type severityCounts struct {
+	Critical     int `json:"critical"`
+	High         int `json:"high"`
+	Medium       int `json:"medium"`
+	Low          int `json:"low"`
+	Unclassified int `json:"unclassified"`
+}
+
+// add counts a failed control with the given severity literal.
+func (c *severityCounts) add(literal string) {
+	switch strings.ToLower(literal) {
+	case "critical":
+		c.Critical++
+	case "high":
+		c.High++
+	case "medium":
+		c.Medium++
+	case "low":
+		c.Low++
+	default:
+		c.Unclassified++
+	}
+}
+
+// total returns the number of failed controls counted.
+func (c severityCounts) total() int {
+	return c.Critical + c.High + c.Medium + c.Low + c.Unclassified
+}
+
+// details returns the counts to be included in the details of a
+// vulnerability, e.g.: "Critical: 2, High: 7, Medium: 15, Low: 3". The
+// unclassified controls are only included if there are any.
+func (c severityCounts) details() string {
+	s := fmt.Sprintf("Critical: %d, High: %d, Medium: %d, Low: %d", c.Critical, c.High, c.Medium, c.Low)
+	if c.Unclassified > 0 {
+		s += fmt.Sprintf(", Unclassified: %d", c.Unclassified)
+	}
+	return s
+}
+
+// failedSeverities counts the failed controls of the given entries by their
+// CIS severity. The controls with a severity lower than minSeverity are not
+// counted, as they are not reported either.
+func failedSeverities(entries []entry, controls map[string]CISControl, minSeverity severity) severityCounts {
+	var c severityCounts
+	for _, e := range entries {
+		if e.Status != "FAIL" {
+			continue
+		}
+		cinfo, _ := lookupControl(e, controls)
+		if cinfo.Severity < float32(minSeverity) {
+			continue
+		}
+		c.add(cinfo.SeverityLiteral)
+	}
+	return c
+}
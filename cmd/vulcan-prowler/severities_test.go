@@ -0,0 +1,87 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFailedSeverities(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.1": {ID: "1.1", Severity: 9, SeverityLiteral: "Critical"},
+		"1.3": {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium"},
+		"2.1": {ID: "2.1", Severity: 3.9, SeverityLiteral: "Low"},
+		"4.1": {ID: "4.1", Severity: 8.9, SeverityLiteral: "High"},
+	}
+	entries := []entry{
+		{Control: "[check11] Avoid the use of the root account (Scored)", Status: "FAIL"},
+		{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL"},
+		{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL"},
+		{Control: "[check21] Ensure CloudTrail is enabled in all regions (Scored)", Status: "FAIL"},
+		{Control: "[check41] Ensure no security groups allow ingress from 0.0.0.0/0 or ::/0 to port 22 (Scored)", Status: "PASS"},
+		{Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)", Status: "FAIL"},
+	}
+	tests := []struct {
+		name        string
+		minSeverity severity
+		want        severityCounts
+		wantDetails string
+	}{
+		{
+			name:        "AllFailed",
+			want:        severityCounts{Critical: 1, Medium: 2, Low: 1, Unclassified: 1},
+			wantDetails: "Critical: 1, High: 0, Medium: 2, Low: 1, Unclassified: 1",
+		},
+		{
+			name:        "MinSeverity",
+			minSeverity: 7,
+			want:        severityCounts{Critical: 1},
+			wantDetails: "Critical: 1, High: 0, Medium: 0, Low: 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := failedSeverities(entries, controls, tt.minSeverity)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected counts (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(tt.wantDetails, got.details()); diff != "" {
+				t.Errorf("unexpected details (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestFillCISLevelVulnSeveritiesMatchFailed(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", Severity: 6.9, SeverityLiteral: "Medium"},
+	}
+	r := &prowlerReport{
+		entries: []entry{
+			{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL", Region: "eu-west-1"},
+			{Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)", Status: "FAIL", Region: "us-east-1"},
+			{Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)", Status: "FAIL", Region: "eu-west-1"},
+		},
+	}
+	got := failedSeverities(r.entries, controls, 0)
+	if got.total() != 3 {
+		t.Errorf("got %d failed controls, want 3", got.total())
+	}
+	v, err := renderVuln(frameworkCIS, "2", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fv, err := fillCISLevelVuln(&v, r, awsAccount{Alias: "alias"}, options{}, controls)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "Failed Controls: 3\nFailed Controls by Severity: Critical: 0, High: 0, Medium: 2, Low: 0, Unclassified: 1\n"
+	if !strings.Contains(fv.Details, want) {
+		t.Errorf("details %q do not contain %q", fv.Details, want)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxStderrSize is the maximum number of bytes of the end of the standard
+// error of prowler kept to report failures.
+const maxStderrSize = 16 * 1024
+
+// Exit statuses of prowler. Prowler exits with prowlerStatusFailedChecks,
+// by design, when any control fails, so that status is a successful scan.
+// Any other non zero status is a failure.
+const (
+	// prowlerStatusOK is the status of a scan without failed checks.
+	prowlerStatusOK = 0
+	// prowlerStatusFailedChecks is the status of a scan that finished with
+	// failed checks.
+	prowlerStatusFailedChecks = 3
+)
+
+var (
+	// errAWSCLIMissing is returned when prowler can not find the AWS CLI.
+	errAWSCLIMissing = errors.New("the AWS CLI is not available in the container")
+	// errProwlerCredentials is returned when prowler can not use the
+	// credentials of the check.
+	errProwlerCredentials = errors.New("prowler can not use the AWS credentials")
+	// errProwlerAccessDenied is returned when the role used by prowler is
+	// not allowed to perform the actions needed by the checks.
+	errProwlerAccessDenied = errors.New("the role used by prowler is not allowed to perform some actions, review its permissions")
+	// errProwlerNoReport is returned when prowler finishes without errors
+	// but does not write the report.
+	errProwlerNoReport = errors.New("prowler did not write the report")
+	// errProwlerNoEntries is returned when prowler finishes without errors
+	// but its report does not contain any entry.
+	errProwlerNoEntries = errors.New("prowler did not report any entry")
+)
+
+// stderrSignatures maps the messages written by prowler, or by the AWS CLI
+// it executes, to its standard error to the errors they are translated to.
+var stderrSignatures = []struct {
+	pattern string
+	err     error
+}{
+	{pattern: "aws: command not found", err: errAWSCLIMissing},
+	{pattern: "aws: not found", err: errAWSCLIMissing},
+	{pattern: "Unable to locate credentials", err: errProwlerCredentials},
+	{pattern: "Partial credentials found", err: errProwlerCredentials},
+	{pattern: "Unable to parse config file", err: errProwlerCredentials},
+	{pattern: "InvalidClientTokenId", err: errProwlerCredentials},
+	{pattern: "An error occurred (AccessDenied)", err: errProwlerAccessDenied},
+	{pattern: "An error occurred (AccessDeniedException)", err: errProwlerAccessDenied},
+	{pattern: "An error occurred (UnauthorizedOperation)", err: errProwlerAccessDenied},
+}
+
+// maxOutputTailSize is the maximum number of bytes of the end of the output
+// of prowler included in the errors.
+const maxOutputTailSize = 2 * 1024
+
+// emptyReportError returns the error describing an execution of prowler in
+// the given region and groups that did not report any entry. It includes
+// the end of the standard output and error of prowler.
+func emptyReportError(region string, groups []string, output []byte, stderr string) error {
+	tail := &tailBuffer{max: maxOutputTailSize}
+	tail.Write(output)
+	var stats sanitizeStats
+	return fmt.Errorf("%w: groups %s, region %s, output: %s, stderr: %s", errProwlerNoEntries,
+		strings.Join(groups, ","), regionLabel(region), sanitizeString(tail.String(), &stats), sanitizeString(stderr, &stats))
+}
+
+// tailBuffer is a writer that keeps, at most, the last max bytes written to
+// it.
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = t.buf[over:]
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the bytes kept by the buffer. If bytes written before them
+// have been discarded, the string starts with "...".
+func (t *tailBuffer) String() string {
+	s := strings.TrimSpace(string(t.buf))
+	if t.truncated {
+		s = "..." + s
+	}
+	return s
+}
+
+// prowlerFailed returns true if the exit status of prowler means that the
+// scan failed.
+func prowlerFailed(status int) bool {
+	return status != prowlerStatusOK && status != prowlerStatusFailedChecks
+}
+
+// stderrError returns the error describing a failed execution of prowler
+// given its exit status and the end of its standard error.
+func stderrError(status int, stderr string) error {
+	var stats sanitizeStats
+	stderr = sanitizeString(stderr, &stats)
+	for _, s := range stderrSignatures {
+		if strings.Contains(stderr, s.pattern) {
+			return fmt.Errorf("%w: prowler exited with status %d, stderr: %s", s.err, status, stderr)
+		}
+	}
+	return fmt.Errorf("prowler exited with status %d, stderr: %s", status, stderr)
+}
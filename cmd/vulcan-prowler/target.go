@@ -0,0 +1,63 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	// The AWS SDK v2 does not expose the identifiers of the partitions.
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+// assetTypeAWSAccount is the only asset type supported by the check.
+const assetTypeAWSAccount = "AWSAccount"
+
+var accountIDRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+
+// validateAssetType returns an error if the check does not support the given
+// asset type.
+func validateAssetType(assetType string) error {
+	if assetType != assetTypeAWSAccount {
+		return fmt.Errorf("unsupported asset type %q: the check only supports %s assets", assetType, assetTypeAWSAccount)
+	}
+	return nil
+}
+
+// awsTarget is the AWS account scanned by the check.
+type awsTarget struct {
+	AccountID string
+	Partition string
+	// ARN is the ARN of the account. When the target is an account ID it
+	// is the ARN of the root user of the account in the aws partition.
+	ARN string
+}
+
+// parseTarget parses a target that can be either the ARN of the root
+// principal of an account, e.g.: arn:aws:iam::123456789012:root, or a 12
+// digit account ID. The ARNs of other principals or resources, like roles or
+// buckets, are rejected.
+func parseTarget(target string) (awsTarget, error) {
+	if accountIDRegexp.MatchString(target) {
+		return awsTarget{
+			AccountID: target,
+			Partition: endpoints.AwsPartitionID,
+			ARN:       fmt.Sprintf("arn:%s:iam::%s:root", endpoints.AwsPartitionID, target),
+		}, nil
+	}
+	parsed, err := arn.Parse(target)
+	if err != nil || !accountIDRegexp.MatchString(parsed.AccountID) {
+		return awsTarget{}, fmt.Errorf("invalid target %q: must be an ARN, e.g.: arn:aws:iam::123456789012:root, or a 12 digit account ID", target)
+	}
+	if parsed.Service != "iam" || parsed.Resource != "root" {
+		return awsTarget{}, fmt.Errorf("invalid target %q: the ARN must refer to the root principal of an account, e.g.: arn:%s:iam::%s:root", target, parsed.Partition, parsed.AccountID)
+	}
+	return awsTarget{
+		AccountID: parsed.AccountID,
+		Partition: parsed.Partition,
+		ARN:       target,
+	}, nil
+}
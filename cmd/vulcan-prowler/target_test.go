@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    awsTarget
+		wantErr bool
+	}{
+		{
+			name:   "ARN",
+			target: "arn:aws:iam::123456789012:root",
+			want:   awsTarget{AccountID: "123456789012", Partition: "aws", ARN: "arn:aws:iam::123456789012:root"},
+		},
+		{
+			name:   "ARNOtherPartition",
+			target: "arn:aws-cn:iam::123456789012:root",
+			want:   awsTarget{AccountID: "123456789012", Partition: "aws-cn", ARN: "arn:aws-cn:iam::123456789012:root"},
+		},
+		{
+			name:   "AccountID",
+			target: "123456789012",
+			want:   awsTarget{AccountID: "123456789012", Partition: "aws", ARN: "arn:aws:iam::123456789012:root"},
+		},
+		{name: "ShortAccountID", target: "12345678901", wantErr: true},
+		{name: "NonNumericAccountID", target: "12345678901a", wantErr: true},
+		{name: "ARNWithoutAccount", target: "arn:aws:s3:::bucket", wantErr: true},
+		{name: "IAMRoleARN", target: "arn:aws:iam::123456789012:role/SecurityAudit", wantErr: true},
+		{name: "IAMUserARN", target: "arn:aws:iam::123456789012:user/alice", wantErr: true},
+		{name: "S3AccessPointARN", target: "arn:aws:s3:eu-west-1:123456789012:accesspoint/ap", wantErr: true},
+		{name: "STSAssumedRoleARN", target: "arn:aws:sts::123456789012:assumed-role/role/session", wantErr: true},
+		{name: "Malformed", target: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("target mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateAssetType(t *testing.T) {
+	tests := []struct {
+		assetType string
+		wantErr   string
+	}{
+		{assetType: "AWSAccount"},
+		{assetType: "Hostname", wantErr: `unsupported asset type "Hostname": the check only supports AWSAccount assets`},
+		{assetType: "DockerImage", wantErr: `unsupported asset type "DockerImage": the check only supports AWSAccount assets`},
+		{assetType: "", wantErr: `unsupported asset type "": the check only supports AWSAccount assets`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.assetType, func(t *testing.T) {
+			err := validateAssetType(tt.assetType)
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("got error %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
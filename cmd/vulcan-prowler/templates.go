@@ -0,0 +1,132 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	report "github.com/adevinta/vulcan-report"
+)
+
+const (
+	// frameworkCIS identifies the templates of the vulnerability generated
+	// when the account has failed CIS controls.
+	frameworkCIS = "cis"
+	// frameworkCISInfo identifies the templates of the informational
+	// vulnerability that is always generated by the check.
+	frameworkCISInfo = "cis-info"
+)
+
+// templatesJSON contains the summaries, descriptions, references and labels
+// of the vulnerabilities generated by the check, keyed by framework and
+// level.
+//
+//go:embed templates.json
+var templatesJSON []byte
+
+var vulnTemplates = mustLoadTemplates(templatesJSON)
+
+// frameworkTemplate holds the fields shared by all the levels of a framework.
+// The description is a text/template rendered with a templateParams value.
+type frameworkTemplate struct {
+	Score       float32                  `json:"score"`
+	Labels      []string                 `json:"labels"`
+	References  []string                 `json:"references"`
+	Description string                   `json:"description"`
+	Levels      map[string]levelTemplate `json:"levels"`
+
+	description *template.Template
+}
+
+// levelTemplate holds the fields specific to a level of a framework. The
+// empty level is used when the check does not receive a security level.
+type levelTemplate struct {
+	Summary    string `json:"summary"`
+	Level      int    `json:"level"`
+	Classified bool   `json:"classified"`
+}
+
+// templateParams contains the values available to the description
+// templates.
+type templateParams struct {
+	Account    string
+	Level      int
+	Classified bool
+}
+
+func mustLoadTemplates(content []byte) map[string]frameworkTemplate {
+	templates, err := loadTemplates(content)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+func loadTemplates(content []byte) (map[string]frameworkTemplate, error) {
+	templates := map[string]frameworkTemplate{}
+	if err := json.Unmarshal(content, &templates); err != nil {
+		return nil, err
+	}
+	for name, ft := range templates {
+		tmpl, err := template.New(name).Parse(ft.Description)
+		if err != nil {
+			return nil, fmt.Errorf("invalid description template for framework %s: %w", name, err)
+		}
+		ft.description = tmpl
+		templates[name] = ft
+	}
+	return templates, nil
+}
+
+// renderVuln builds the vulnerability defined by the template of the given
+// framework and level. If the framework does not define the level, the
+// default (empty) level is used.
+func renderVuln(framework, level, account string) (report.Vulnerability, error) {
+	ft, ok := vulnTemplates[framework]
+	if !ok {
+		return report.Vulnerability{}, fmt.Errorf("no template for framework %s", framework)
+	}
+	lt, ok := ft.Levels[level]
+	if !ok {
+		lt, ok = ft.Levels[""]
+		if !ok {
+			return report.Vulnerability{}, fmt.Errorf("no template for framework %s and level %q", framework, level)
+		}
+	}
+	params := templateParams{
+		Account:    account,
+		Level:      lt.Level,
+		Classified: lt.Classified,
+	}
+	var buf bytes.Buffer
+	if err := ft.description.Execute(&buf, params); err != nil {
+		return report.Vulnerability{}, fmt.Errorf("can not render description for framework %s: %w", framework, err)
+	}
+	return report.Vulnerability{
+		Summary:     lt.Summary,
+		Description: buf.String(),
+		Labels:      append([]string{}, ft.Labels...),
+		References:  append([]string{}, ft.References...),
+		Fingerprint: helpers.ComputeFingerprint(),
+		Score:       ft.Score,
+	}, nil
+}
+
+// templateLevel returns the level of the templates that corresponds to the
+// given security level.
+func templateLevel(slevel *byte) string {
+	if slevel == nil {
+		return ""
+	}
+	if *slevel == 0 || *slevel == 1 {
+		return "1"
+	}
+	return "2"
+}
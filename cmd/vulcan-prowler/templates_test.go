@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRenderVuln(t *testing.T) {
+	var frameworks []string
+	for framework := range vulnTemplates {
+		frameworks = append(frameworks, framework)
+	}
+	sort.Strings(frameworks)
+	for _, framework := range frameworks {
+		for level := range vulnTemplates[framework].Levels {
+			name := framework
+			if level != "" {
+				name += "_level" + level
+			}
+			t.Run(name, func(t *testing.T) {
+				v, err := renderVuln(framework, level, "alias")
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got := []byte(formatTemplateVuln(v))
+				golden := filepath.Join("testdata", "templates", name+".golden")
+				if *update {
+					if err := os.WriteFile(golden, got, 0o644); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if diff := cmp.Diff(string(want), string(got)); diff != "" {
+					t.Errorf("unexpected vulnerability (-want +got):\n%v", diff)
+				}
+			})
+		}
+	}
+}
+
+// formatTemplateVuln formats the fields of a vulnerability set from the
+// templates in a way that wording changes are easy to review.
+func formatTemplateVuln(v report.Vulnerability) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary: %s\n", v.Summary)
+	fmt.Fprintf(&b, "Score: %v\n", v.Score)
+	fmt.Fprintf(&b, "Labels: %s\n", strings.Join(v.Labels, ", "))
+	fmt.Fprintf(&b, "References:\n")
+	for _, r := range v.References {
+		fmt.Fprintf(&b, "- %s\n", r)
+	}
+	fmt.Fprintf(&b, "Description:\n%s\n", v.Description)
+	return b.String()
+}
+
+func TestRenderVulnUnknownLevel(t *testing.T) {
+	v, err := renderVuln(frameworkCISInfo, "2", "alias")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := vulnTemplates[frameworkCISInfo].Levels[""].Summary
+	if v.Summary != want {
+		t.Errorf("unexpected summary, want: %q, got: %q", want, v.Summary)
+	}
+}
+
+func TestRenderVulnUnknownFramework(t *testing.T) {
+	if _, err := renderVuln("unknown", "", "alias"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestTemplateLevel(t *testing.T) {
+	level := func(l byte) *byte { return &l }
+	tests := []struct {
+		name   string
+		slevel *byte
+		want   string
+	}{
+		{name: "nil", slevel: nil, want: ""},
+		{name: "zero", slevel: level(0), want: "1"},
+		{name: "one", slevel: level(1), want: "1"},
+		{name: "two", slevel: level(2), want: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := templateLevel(tt.slevel); got != tt.want {
+				t.Errorf("unexpected level, want: %q, got: %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+// timestampLayouts contains the layouts tried, in order, when parsing the
+// timestamps embedded in the prowler messages.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// thresholdStats contains the results of applying the threshold_days
+// option.
+type thresholdStats struct {
+	// Reclassified is the number of entries whose status has been changed.
+	Reclassified int
+	// Unparseable is the number of entries that kept the prowler status
+	// because the timestamp could not be extracted from the message.
+	Unparseable int
+}
+
+// validateThresholds checks that all the controls specified in the
+// threshold_days option define the pattern needed to extract the timestamp
+// from the prowler messages.
+func validateThresholds(thresholds map[string]int, controls map[string]CISControl) error {
+	var ids []string
+	for id := range thresholds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if thresholds[id] <= 0 {
+			return fmt.Errorf("invalid threshold_days for control %s: must be greater than 0", id)
+		}
+		cinfo, ok := controls[id]
+		if !ok || cinfo.TimestampPattern == "" {
+			return fmt.Errorf("invalid threshold_days for control %s: the control does not support custom thresholds", id)
+		}
+		if _, err := regexp.Compile(cinfo.TimestampPattern); err != nil {
+			return fmt.Errorf("invalid timestamp pattern for control %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// applyThresholds recomputes the status of the PASS and FAIL entries of the
+// controls in thresholds using the timestamp embedded in their messages. The
+// message of the entries whose status changes is annotated with the policy
+// applied. The entries with a message that does not contain a timestamp keep
+// the status set by prowler.
+func applyThresholds(r *prowlerReport, controls map[string]CISControl, thresholds map[string]int, now time.Time) (thresholdStats, error) {
+	var stats thresholdStats
+	if len(thresholds) == 0 {
+		return stats, nil
+	}
+	patterns := map[string]*regexp.Regexp{}
+	for id := range thresholds {
+		patterns[id] = regexp.MustCompile(controls[id].TimestampPattern)
+	}
+	for i, e := range r.entries {
+		if e.Status != "PASS" && e.Status != "FAIL" {
+			continue
+		}
+		control, _, err := prowlerparse.ParseControl(e.Control)
+		if err != nil {
+			return stats, err
+		}
+		days, ok := thresholds[control]
+		if !ok {
+			continue
+		}
+		ts, ok := parseMessageTimestamp(patterns[control], e.Message)
+		if !ok {
+			stats.Unparseable++
+			continue
+		}
+		ref := now
+		if t, ok := parseTimestamp(e.Timestamp); ok {
+			ref = t
+		}
+		status := "PASS"
+		if ref.Sub(ts) > time.Duration(days)*24*time.Hour {
+			status = "FAIL"
+		}
+		if status == e.Status {
+			continue
+		}
+		r.entries[i].Message = fmt.Sprintf("%s [policy: %d days threshold, reclassified from %s to %s]", e.Message, days, e.Status, status)
+		r.entries[i].Status = status
+		stats.Reclassified++
+	}
+	return stats, nil
+}
+
+func parseMessageTimestamp(pattern *regexp.Regexp, msg string) (time.Time, bool) {
+	m := pattern.FindStringSubmatch(msg)
+	if len(m) < 2 {
+		return time.Time{}, false
+	}
+	return parseTimestamp(m[1])
+}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/adevinta/vulcan-checks/cmd/vulcan-prowler/prowlerparse"
+)
+
+func TestApplyThresholds(t *testing.T) {
+	content, err := os.ReadFile("cis_controls.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mf, err := prowlerparse.LoadMetadata(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	controls := mf.Controls
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	r := &prowlerReport{
+		entries: []entry{
+			{
+				Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)",
+				Status:  "FAIL",
+				Message: "User user1 has not rotated access key 1 since 2020-01-01T00:00:00+00:00",
+			},
+			{
+				Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)",
+				Status:  "PASS",
+				Message: "User user2 rotated access key 1 on 2019-01-01",
+			},
+			{
+				Control:   "[check14] Ensure access keys are rotated every 90 days or less (Scored)",
+				Status:    "FAIL",
+				Message:   "User user3 has not rotated access key 1 since 2019-12-01 10:00:00",
+				Timestamp: "2020-07-01T00:00:00Z",
+			},
+			{
+				Control: "[check14] Ensure access keys are rotated every 90 days or less (Scored)",
+				Status:  "FAIL",
+				Message: "User user4 has not rotated access key 1 in over 90 days",
+			},
+			{
+				Control: "[check13] Ensure credentials unused for 90 days or greater are disabled (Scored)",
+				Status:  "FAIL",
+				Message: "User user5 has not used access key 1 since 2020-01-01",
+			},
+		},
+	}
+	thresholds := map[string]int{"1.4": 180}
+	if err := validateThresholds(thresholds, controls); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stats, err := applyThresholds(r, controls, thresholds, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantStats := thresholdStats{Reclassified: 2, Unparseable: 1}
+	if diff := cmp.Diff(wantStats, stats); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%v", diff)
+	}
+	want := []struct{ status, message string }{
+		{"PASS", "User user1 has not rotated access key 1 since 2020-01-01T00:00:00+00:00 [policy: 180 days threshold, reclassified from FAIL to PASS]"},
+		{"FAIL", "User user2 rotated access key 1 on 2019-01-01 [policy: 180 days threshold, reclassified from PASS to FAIL]"},
+		{"FAIL", "User user3 has not rotated access key 1 since 2019-12-01 10:00:00"},
+		{"FAIL", "User user4 has not rotated access key 1 in over 90 days"},
+		{"FAIL", "User user5 has not used access key 1 since 2020-01-01"},
+	}
+	for i, w := range want {
+		if r.entries[i].Status != w.status || r.entries[i].Message != w.message {
+			t.Errorf("unexpected entry %d, want: %s %q, got: %s %q", i, w.status, w.message, r.entries[i].Status, r.entries[i].Message)
+		}
+	}
+}
+
+func TestValidateThresholds(t *testing.T) {
+	controls := map[string]CISControl{
+		"1.3": {ID: "1.3", TimestampPattern: `(\d{4}-\d{2}-\d{2})`},
+		"1.5": {ID: "1.5"},
+	}
+	tests := []struct {
+		name       string
+		thresholds map[string]int
+		wantErr    bool
+	}{
+		{name: "empty", thresholds: nil},
+		{name: "valid", thresholds: map[string]int{"1.3": 30}},
+		{name: "zero days", thresholds: map[string]int{"1.3": 0}, wantErr: true},
+		{name: "control without pattern", thresholds: map[string]int{"1.5": 30}, wantErr: true},
+		{name: "unknown control", thresholds: map[string]int{"9.9": 30}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateThresholds(tt.thresholds, controls)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
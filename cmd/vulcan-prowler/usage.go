@@ -0,0 +1,142 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// resourceUsage contains the resources consumed by a process. The values
+// are taken from the rusage information reported by the kernel when the
+// process finishes, so there is no need to periodically sample the process
+// while it runs.
+type resourceUsage struct {
+	// PeakRSSKB is the maximum resident set size in kilobytes.
+	PeakRSSKB   int64 `json:"peak_rss_kb"`
+	UserCPUMs   int64 `json:"user_cpu_ms"`
+	SystemCPUMs int64 `json:"system_cpu_ms"`
+	// OutputBytes is the number of bytes written by the process to its
+	// standard output.
+	OutputBytes int64 `json:"output_bytes,omitempty"`
+}
+
+// runMetrics contains the resources consumed by the check process and by
+// the prowler subprocess.
+type runMetrics struct {
+	Check   resourceUsage `json:"check"`
+	Prowler resourceUsage `json:"prowler"`
+}
+
+// processUsage returns the resources consumed by a finished process and the
+// descendants it waited for.
+func processUsage(ps *os.ProcessState) resourceUsage {
+	var u resourceUsage
+	if ps == nil {
+		return u
+	}
+	u.UserCPUMs = ps.UserTime().Milliseconds()
+	u.SystemCPUMs = ps.SystemTime().Milliseconds()
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		u.PeakRSSKB = int64(ru.Maxrss)
+	}
+	return u
+}
+
+// selfUsage returns the resources consumed by the check process. It returns
+// an empty usage if the information is not available.
+func selfUsage(ctx context.Context) resourceUsage {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		ctxLogger(ctx).Warnf("can not get the resource usage of the check: %v", err)
+		return resourceUsage{}
+	}
+	return resourceUsage{
+		PeakRSSKB:   int64(ru.Maxrss),
+		UserCPUMs:   time.Duration(ru.Utime.Nano()).Milliseconds(),
+		SystemCPUMs: time.Duration(ru.Stime.Nano()).Milliseconds(),
+	}
+}
+
+// killGracePeriod is the time the processes of a command are given to exit
+// after receiving a SIGTERM, when the context of the command is done, before
+// being killed with a SIGKILL.
+var killGracePeriod = 5 * time.Second
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// execute runs a command, with the given env vars added to the environment of
+// the current process, and returns its standard output, its exit status and
+// the resources it consumed. Like the command helpers of the SDK, it does
+// not return an error when the command exits with a status different from
+// 0.
+func execute(ctx context.Context, env []string, exe string, params ...string) ([]byte, int, resourceUsage, error) {
+	return executeStream(ctx, env, nil, nil, exe, params...)
+}
+
+// executeStream is like execute but, if onLine is not nil, it also calls
+// onLine with each line written by the command to its standard output while
+// it runs. The standard error of the command is written to stderr, if it is
+// not nil.
+func executeStream(ctx context.Context, env []string, onLine func(line []byte), stderr io.Writer, exe string, params ...string) ([]byte, int, resourceUsage, error) {
+	cmd := exec.CommandContext(ctx, exe, params...)
+	cmd.Env = append(os.Environ(), env...)
+	// The command runs in its own process group, so all the processes it
+	// spawns, e.g.: the AWS CLI processes executed by prowler, can be
+	// signaled when the context is done.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var killTimer *time.Timer
+	cmd.Cancel = func() error {
+		pgid := cmd.Process.Pid
+		killTimer = time.AfterFunc(killGracePeriod, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		return syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killGracePeriod
+	var stdout bytes.Buffer
+	out := &countingWriter{w: &stdout}
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	if onLine != nil {
+		lw := &lineWriter{fn: onLine}
+		defer lw.flush()
+		out.w = io.MultiWriter(&stdout, lw)
+	}
+	err := cmd.Run()
+	if ctx.Err() != nil && cmd.Process != nil {
+		// Kill the processes of the group that ignored the SIGTERM or
+		// were still exiting when the command finished.
+		if killTimer != nil {
+			killTimer.Stop()
+		}
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	usage := processUsage(cmd.ProcessState)
+	usage.OutputBytes = out.n
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return stdout.Bytes(), 0, usage, err
+		}
+	}
+	return stdout.Bytes(), cmd.ProcessState.ExitCode(), usage, nil
+}
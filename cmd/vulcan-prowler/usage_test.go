@@ -0,0 +1,139 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteUsage(t *testing.T) {
+	script := `i=0; while [ $i -lt 50000 ]; do i=$((i+1)); done; echo hello; exit 3`
+	output, status, usage, err := execute(context.Background(), nil, "/bin/sh", "-c", script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(output) != "hello\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if status != 3 {
+		t.Errorf("unexpected status: %d", status)
+	}
+	if usage.OutputBytes != int64(len("hello\n")) {
+		t.Errorf("unexpected output bytes: %d", usage.OutputBytes)
+	}
+	if usage.PeakRSSKB <= 0 {
+		t.Errorf("unexpected peak RSS: %d", usage.PeakRSSKB)
+	}
+	if usage.UserCPUMs+usage.SystemCPUMs <= 0 {
+		t.Errorf("unexpected CPU time: %+v", usage)
+	}
+}
+
+func TestSelfUsage(t *testing.T) {
+	usage := selfUsage(context.Background())
+	if usage.PeakRSSKB <= 0 {
+		t.Errorf("unexpected peak RSS: %d", usage.PeakRSSKB)
+	}
+}
+
+func TestExecuteNotFound(t *testing.T) {
+	if _, _, _, err := execute(context.Background(), nil, "/nonexistent/prowler"); err == nil {
+		t.Error("expected error, got nil")
+	}
+}
+
+func TestExecuteEnv(t *testing.T) {
+	creds := awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token"}
+	output, _, _, err := execute(context.Background(), creds.env(), "/bin/sh", "-c", `echo "$AWS_ACCESS_KEY_ID"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(output) != "AKID\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+	if v, ok := os.LookupEnv(envKeyID); ok && v == "AKID" {
+		t.Error("credentials leaked to the process environment")
+	}
+}
+
+// processAlive returns true if the process with the given PID exists and is
+// not a zombie.
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, that is enclosed in parentheses.
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestExecuteCancelKillsProcessTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{
+			name:   "ExitsOnSIGTERM",
+			script: `sleep 30 & echo $$ $!; wait`,
+		},
+		{
+			name:   "IgnoresSIGTERM",
+			script: `trap '' TERM; sh -c "trap '' TERM; sleep 30" & echo $$ $!; wait; wait`,
+		},
+	}
+	defer func(d time.Duration) { killGracePeriod = d }(killGracePeriod)
+	killGracePeriod = 200 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			pids := make(chan []int, 1)
+			onLine := func(line []byte) {
+				var parent, child int
+				if _, err := fmt.Sscan(string(line), &parent, &child); err == nil {
+					pids <- []int{parent, child}
+				}
+			}
+			done := make(chan error, 1)
+			go func() {
+				_, _, _, err := executeStream(ctx, nil, onLine, nil, "/bin/sh", "-c", tt.script)
+				done <- err
+			}()
+
+			var tree []int
+			select {
+			case tree = <-pids:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the script did not start")
+			}
+			cancel()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the command was not stopped")
+			}
+			// The orphaned processes can take a moment to be reaped.
+			deadline := time.Now().Add(2 * time.Second)
+			for _, pid := range tree {
+				for processAlive(pid) && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				if processAlive(pid) {
+					t.Errorf("process %d is still running", pid)
+				}
+			}
+		})
+	}
+}
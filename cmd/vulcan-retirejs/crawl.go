@@ -0,0 +1,177 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path/filepath"
+
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Maximum number of pages crawled when the max_pages option is not set.
+const defMaxPages = 20
+
+// errNotHTML is returned when the content of a page is not HTML.
+var errNotHTML = errors.New("the content is not HTML")
+
+// page is a page of the target crawled by the check.
+type page struct {
+	url  string
+	node *html.Node
+}
+
+// crawl returns the target page and the pages of the same origin reachable
+// from it following up to maxDepth levels of links, stopping after
+// maxPages pages. The errors fetching the pages other than the target are
+// logged and the pages skipped. Every request is bound to the timeout of
+// the HTTP client.
+func crawl(ctx context.Context, target string, maxDepth, maxPages int) ([]page, error) {
+	type link struct {
+		url   string
+		depth int
+	}
+
+	visited := map[string]bool{target: true}
+	queue := []link{{url: target}}
+	var pages []page
+	for len(queue) > 0 && len(pages) < maxPages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		l := queue[0]
+		queue = queue[1:]
+
+		node, err := getTargetHTML(ctx, l.url)
+		if err != nil {
+			if l.url == target {
+				if errors.Is(err, errNotHTML) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			logger.Warnf("Skipping page %s: %v", l.url, err)
+			continue
+		}
+		pages = append(pages, page{url: l.url, node: node})
+
+		if l.depth >= maxDepth {
+			continue
+		}
+		for _, u := range findLinks(l.url, node) {
+			if visited[u] {
+				continue
+			}
+			visited[u] = true
+			queue = append(queue, link{url: u, depth: l.depth + 1})
+		}
+	}
+	return pages, nil
+}
+
+// findLinks returns the absolute URLs, without fragment, of the links of a
+// page pointing to the same origin as the page.
+func findLinks(pageURL string, node *html.Node) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	linkMatcher := func(n *html.Node) bool {
+		return n.DataAtom == atom.A && scrape.Attr(n, "href") != ""
+	}
+
+	var links []string
+	for _, a := range scrape.FindAll(node, linkMatcher) {
+		ref, err := url.Parse(scrape.Attr(a, "href"))
+		if err != nil {
+			continue
+		}
+		u := base.ResolveReference(ref)
+		if u.Scheme != base.Scheme || u.Host != base.Host {
+			continue
+		}
+		u.Fragment = ""
+		u.RawFragment = ""
+		links = append(links, u.String())
+	}
+	return links
+}
+
+// scriptSources keeps track of the scripts downloaded from the crawled
+// pages, so each script is downloaded only once and the vulnerabilities
+// can report the pages referencing the affected libraries.
+type scriptSources struct {
+	// files maps the names of the downloaded files to the URLs of the
+	// scripts. Inline scripts have no URL.
+	files map[string]string
+	// pages maps the names of the downloaded files to the pages referencing
+	// the scripts.
+	pages map[string][]string
+	// downloaded maps the URLs of the scripts to the names of the files.
+	downloaded map[string]string
+	// failed contains the URLs of the scripts that could not be
+	// downloaded, so they are not requested again for other pages.
+	failed map[string]bool
+}
+
+func newScriptSources() *scriptSources {
+	return &scriptSources{
+		files:      map[string]string{},
+		pages:      map[string][]string{},
+		downloaded: map[string]string{},
+		failed:     map[string]bool{},
+	}
+}
+
+// add records that the given page references the script downloaded to the
+// given file.
+func (s *scriptSources) add(file, script, pageURL string) {
+	s.files[file] = script
+	s.pages[file] = append(s.pages[file], pageURL)
+	if script != "" {
+		s.downloaded[script] = file
+	}
+}
+
+// addPage records that the given page references a script and returns
+// false if the script has not been downloaded yet.
+func (s *scriptSources) addPage(script, pageURL string) bool {
+	file, ok := s.downloaded[script]
+	if !ok {
+		return false
+	}
+	for _, p := range s.pages[file] {
+		if p == pageURL {
+			return true
+		}
+	}
+	s.pages[file] = append(s.pages[file], pageURL)
+	return true
+}
+
+// locations returns the rows of the resources table with the script and
+// the pages referencing the given downloaded file.
+func (s *scriptSources) locations(file string) []map[string]string {
+	if s == nil {
+		return nil
+	}
+	file = filepath.Base(file)
+	script, ok := s.files[file]
+	if !ok {
+		return nil
+	}
+	if script == "" {
+		script = "inline"
+	}
+	var rows []map[string]string
+	for _, p := range s.pages[file] {
+		rows = append(rows, map[string]string{"Script": script, "Page": p})
+	}
+	return rows
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/html"
+)
+
+// newSiteServer returns a server of a site with several pages. The
+// downloads of the script shared by the pages are counted in downloads, if
+// not nil.
+func newSiteServer(downloads *atomic.Int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<a href="/a.html#top">A</a><a href="b/">B</a><a href="https://example.com/">Ext</a><a href="/doc.pdf">PDF</a>`))
+	})
+	mux.HandleFunc("/a.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<a href="/">Home</a><a href="/c.html">C</a><script src="js/lib.js"></script>`))
+	})
+	mux.HandleFunc("/b/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<script src="/js/lib.js"></script>`))
+	})
+	mux.HandleFunc("/c.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<p>C</p>`))
+	})
+	mux.HandleFunc("/doc.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	})
+	mux.HandleFunc("/js/lib.js", func(w http.ResponseWriter, r *http.Request) {
+		if downloads != nil {
+			downloads.Add(1)
+		}
+		w.Write([]byte("var lib = 1;"))
+	})
+	return httptest.NewServer(mux)
+}
+
+func pageURLs(pages []page) []string {
+	var urls []string
+	for _, p := range pages {
+		urls = append(urls, p.url)
+	}
+	return urls
+}
+
+func TestCrawl(t *testing.T) {
+	srv := newSiteServer(nil)
+	defer srv.Close()
+	target := srv.URL + "/"
+
+	tests := []struct {
+		name     string
+		maxDepth int
+		maxPages int
+		want     []string
+	}{
+		{name: "TargetOnly", maxDepth: 0, maxPages: 10, want: []string{target}},
+		{name: "Depth1", maxDepth: 1, maxPages: 10, want: []string{target, srv.URL + "/a.html", srv.URL + "/b/"}},
+		{name: "Depth2", maxDepth: 2, maxPages: 10, want: []string{target, srv.URL + "/a.html", srv.URL + "/b/", srv.URL + "/c.html"}},
+		{name: "MaxPages", maxDepth: 2, maxPages: 2, want: []string{target, srv.URL + "/a.html"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := crawl(context.Background(), target, tt.maxDepth, tt.maxPages)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, pageURLs(pages)); diff != "" {
+				t.Errorf("unexpected pages (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestCrawlCanceled(t *testing.T) {
+	srv := newSiteServer(nil)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := crawl(ctx, srv.URL+"/", 2, 10)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGetAbsoluteUrlSubPage(t *testing.T) {
+	tests := []struct {
+		page string
+		ref  string
+		want string
+	}{
+		{"http://host.tld/a/page.html", "js/script.js", "http://host.tld/a/js/script.js"},
+		{"http://host.tld/a/page.html", "/js/script.js", "http://host.tld/js/script.js"},
+		{"http://host.tld/landing/?n=1", "./script.js", "http://host.tld/landing/script.js"},
+		{"http://host.tld", "script.js", "http://host.tld/script.js"},
+		{"http://host.tld/a/b/page.html", "../js/script.js", "http://host.tld/a/js/script.js"},
+		{"https://host.tld/a/page.html", "//cdn.tld/js/script.js", "https://cdn.tld/js/script.js"},
+		{"http://host.tld/a/page.html", "https://cdn.tld/script.js", "https://cdn.tld/script.js"},
+	}
+	for _, tt := range tests {
+		if got := getAbsoluteUrl(tt.page, tt.ref); got != tt.want {
+			t.Errorf("getAbsoluteUrl(%s, %s): got %s, want %s", tt.page, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestFindScriptFilesLocations(t *testing.T) {
+	var downloads atomic.Int32
+	srv := newSiteServer(&downloads)
+	defer srv.Close()
+
+	pages, err := crawl(context.Background(), srv.URL+"/", 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sources := newScriptSources()
+	for _, p := range pages {
+		if _, err := findScriptFiles(context.Background(), p, sources); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Fatalf("script downloaded %d times, want 1", n)
+	}
+
+	file := sources.downloaded[srv.URL+"/js/lib.js"]
+	want := []map[string]string{
+		{"Script": srv.URL + "/js/lib.js", "Page": srv.URL + "/a.html"},
+		{"Script": srv.URL + "/js/lib.js", "Page": srv.URL + "/b/"},
+	}
+	if diff := cmp.Diff(want, sources.locations(jsPath+"/"+file)); diff != "" {
+		t.Errorf("unexpected locations (-want +got):\n%v", diff)
+	}
+}
+
+func TestFindScriptFilesSkipsFailures(t *testing.T) {
+	srv := newSiteServer(nil)
+	defer srv.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	content := fmt.Sprintf(`<script src="%s/lib.js"></script><script src="/js/lib.js"></script>`, down.URL)
+	node, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sources := newScriptSources()
+	got, err := findScriptFiles(context.Background(), page{url: srv.URL + "/", node: node}, sources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != 1 {
+		t.Errorf("got %d downloaded scripts, want 1", got)
+	}
+	if !sources.failed[down.URL+"/lib.js"] {
+		t.Errorf("the failed script has not been recorded")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// nvdURL is the URL of the NVD page of a CVE.
+const nvdURL = "https://nvd.nist.gov/vuln/detail/%s"
+
+// cvssJSON contains the CVSS v3 base scores of the CVEs of the advisories
+// reported by retire.js, keyed by CVE ID. The scores are the ones published
+// by the NVD (https://nvd.nist.gov/developers/vulnerabilities). The table is
+// updated by running "go generate" in the directory of the check, which adds
+// the scores of the CVEs of the current retire.js databases missing from it,
+// and it must be regenerated when the databases are updated. The advisories
+// whose CVEs are not in the table are scored from their severity.
+//
+//go:generate go run ./gencvss -out cvss.json
+//go:embed cvss.json
+var cvssJSON []byte
+
+var cvssScores = mustLoadCVSS(cvssJSON)
+
+var cveRegexp = regexp.MustCompile(`^CVE-\d{4}-\d{4,}$`)
+
+func mustLoadCVSS(content []byte) map[string]float32 {
+	scores, err := loadCVSS(content)
+	if err != nil {
+		panic(err)
+	}
+	return scores
+}
+
+// loadCVSS parses and validates the given CVSS table. The keys must be CVE
+// IDs and the scores must be between 0 and 10.
+func loadCVSS(content []byte) (map[string]float32, error) {
+	var scores map[string]float32
+	if err := json.Unmarshal(content, &scores); err != nil {
+		return nil, fmt.Errorf("invalid CVSS table: %w", err)
+	}
+	for cve, score := range scores {
+		if !cveRegexp.MatchString(cve) {
+			return nil, fmt.Errorf("invalid CVSS table: invalid CVE %q", cve)
+		}
+		if score < 0 || score > 10 {
+			return nil, fmt.Errorf("invalid CVSS table: invalid score %v for %s", score, cve)
+		}
+	}
+	return scores, nil
+}
+
+// advisoryCVSS returns the highest CVSS score of the CVEs of an advisory.
+// It returns false if none of the CVEs is in the CVSS table.
+func advisoryCVSS(v RetireJsVulnerability) (float32, bool) {
+	var max float32
+	found := false
+	for _, cve := range v.Identifiers.Cve {
+		score, ok := cvssScores[strings.ToUpper(cve)]
+		if !ok {
+			continue
+		}
+		if !found || score > max {
+			max = score
+		}
+		found = true
+	}
+	return max, found
+}
+
+// advisoryScore returns the score of an advisory, which is its CVSS score,
+// if known, or the score corresponding to the severity reported by
+// retire.js.
+func advisoryScore(v RetireJsVulnerability) float32 {
+	if score, ok := advisoryCVSS(v); ok {
+		return score
+	}
+	return getScore(v.Severity)
+}
+
+// cveReferences returns the NVD URLs of the CVEs of the given advisories,
+// sorted and without duplicates.
+func cveReferences(vulns []RetireJsVulnerability) []string {
+	seen := map[string]bool{}
+	var refs []string
+	for _, v := range vulns {
+		for _, cve := range v.Identifiers.Cve {
+			cve = strings.ToUpper(cve)
+			if seen[cve] || !cveRegexp.MatchString(cve) {
+				continue
+			}
+			seen[cve] = true
+			refs = append(refs, fmt.Sprintf(nvdURL, cve))
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// advisoryGroup contains the advisories of a version of a library found by
+// retire.js and the files it was found in.
+type advisoryGroup struct {
+	result RetireJsResult
+	files  []string
+}
+
+// groupAdvisories groups the results of retire.js by library and version,
+// and by file if byFile is true, removing the duplicated advisories. The
+// groups are returned in the order they are first found.
+func groupAdvisories(r []RetireJsFileResult, byFile bool) []*advisoryGroup {
+	var groups []*advisoryGroup
+	index := map[string]*advisoryGroup{}
+	for _, f := range r {
+		for _, res := range f.Results {
+			key := res.Component + "@" + res.Version
+			if byFile {
+				key = f.File + "|" + key
+			}
+			g, ok := index[key]
+			if !ok {
+				g = &advisoryGroup{result: RetireJsResult{
+					Component: res.Component,
+					Version:   res.Version,
+					Detection: res.Detection,
+				}}
+				index[key] = g
+				groups = append(groups, g)
+			}
+			if len(g.files) == 0 || g.files[len(g.files)-1] != f.File {
+				g.files = append(g.files, f.File)
+			}
+			for _, v := range res.Vulnerabilities {
+				if !containsAdvisory(g.result.Vulnerabilities, v) {
+					g.result.Vulnerabilities = append(g.result.Vulnerabilities, v)
+				}
+			}
+		}
+	}
+	return groups
+}
+
+func containsAdvisory(vulns []RetireJsVulnerability, v RetireJsVulnerability) bool {
+	for _, e := range vulns {
+		if advisoryKey(e) == advisoryKey(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// advisoryKey returns the fields identifying an advisory.
+func advisoryKey(v RetireJsVulnerability) string {
+	return strings.Join([]string{
+		v.Identifiers.Summary,
+		strings.Join(v.Identifiers.Cve, ","),
+		v.Identifiers.Bug,
+		v.Identifiers.Issue,
+		v.AtOrAbove,
+		v.Below,
+		strings.ToLower(v.Severity),
+	}, "|")
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadCVSS(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "Valid", content: `{"CVE-2019-19919": 9.8}`},
+		{name: "InvalidCVE", content: `{"GHSA-1234": 9.8}`, wantErr: true},
+		{name: "InvalidScore", content: `{"CVE-2019-19919": 11}`, wantErr: true},
+		{name: "InvalidJSON", content: `[]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCVSS([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdvisoryScore(t *testing.T) {
+	tests := []struct {
+		name string
+		v    RetireJsVulnerability
+		want float32
+	}{
+		{
+			name: "CVSS",
+			v:    RetireJsVulnerability{Severity: "high", Identifiers: RetireJsResultId{Cve: []string{"CVE-2019-19919"}}},
+			want: 9.8,
+		},
+		{
+			name: "HighestCVSS",
+			v:    RetireJsVulnerability{Severity: "medium", Identifiers: RetireJsResultId{Cve: []string{"CVE-2018-3721", "CVE-2019-10744"}}},
+			want: 9.1,
+		},
+		{
+			name: "UnknownCVE",
+			v:    RetireJsVulnerability{Severity: "medium", Identifiers: RetireJsResultId{Cve: []string{"CVE-1999-0001"}}},
+			want: report.SeverityThresholdMedium,
+		},
+		{
+			name: "NoCVE",
+			v:    RetireJsVulnerability{Severity: "low"},
+			want: report.SeverityThresholdLow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := advisoryScore(tt.v); got != tt.want {
+				t.Errorf("got score %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddVulnsToStateGrouped(t *testing.T) {
+	content, err := os.ReadFile("testdata/retire-handlebars.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var retireJsReport RetireJsReport
+	if err := json.Unmarshal(content, &retireJsReport); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := report.ResultData{}
+	s := state.State{ProgressReporter: stateMock{}, ResultData: &r}
+	addVulnsToState(s, retireJsReport.Data, nil, retireDB{})
+
+	if len(s.Vulnerabilities) != 1 {
+		t.Fatalf("got %d vulnerabilities, want 1", len(s.Vulnerabilities))
+	}
+	v := s.Vulnerabilities[0]
+	if v.AffectedResource != "handlebars-4.0.0" {
+		t.Errorf("got affected resource %s, want handlebars-4.0.0", v.AffectedResource)
+	}
+	// The score is the CVSS score of CVE-2019-19919, which is critical
+	// although retire.js reports it as high.
+	if v.Score != 9.8 {
+		t.Errorf("got score %v, want 9.8", v.Score)
+	}
+
+	wantRefs := []string{
+		"https://portswigger.net/kb/issues/00500080_vulnerable-javascript-dependency",
+		"https://nvd.nist.gov/vuln/detail/CVE-2019-19919",
+	}
+	if diff := cmp.Diff(wantRefs, v.References); diff != "" {
+		t.Errorf("unexpected references (-want +got):\n%v", diff)
+	}
+
+	wantRows := []map[string]string{
+		{
+			"Summary":           "Quoteless attributes in templates can lead to XSS",
+			"CVEs":              "",
+			"CVSS":              "",
+			"Affected Versions": "<4.0.0",
+			"Severity":          "low",
+			"References":        "[0](https://github.com/wycats/handlebars.js/pull/1083)",
+		},
+		{
+			"Summary":           "Prototype pollution leading to remote code execution",
+			"CVEs":              "CVE-2019-19919",
+			"CVSS":              "9.8",
+			"Affected Versions": "<4.3.0",
+			"Severity":          "high",
+			"References":        "[0](https://nvd.nist.gov/vuln/detail/CVE-2019-19919)",
+		},
+	}
+	if diff := cmp.Diff(wantRows, v.Resources[0].Rows); diff != "" {
+		t.Errorf("unexpected issues (-want +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Environment variables used when the db_url and db_sha256 options are
+	// not set.
+	dbURLEnv    = "RETIREJS_DB_URL"
+	dbSHA256Env = "RETIREJS_DB_SHA256"
+
+	// Timeout of the download of the database.
+	dbFetchTimeout = 60 * time.Second
+
+	// Maximum age of the database used when the db_max_age_days option is
+	// not set.
+	defDBMaxAgeDays = 30
+
+	// Names of the databases in the details and notes of the check.
+	jsDBName  = "retire.js database"
+	npmDBName = "retire.js npm database"
+)
+
+var (
+	// bundledDBPath is the path of the retire.js database bundled in the
+	// image of the check.
+	bundledDBPath = "jsrepository.json"
+
+	// dbMaxSize is the maximum size in bytes of the downloaded database.
+	dbMaxSize = 50 << 20
+
+	// dbClient is the HTTP client used to download the database. Unlike
+	// the client used to scan the targets, it verifies the TLS
+	// certificates.
+	dbClient = &http.Client{}
+)
+
+// retireDB is the retire.js database used by the check.
+type retireDB struct {
+	// name is the name of the database in the details and notes.
+	name string
+	// path is the path of the database file.
+	path string
+	// source is the URL the database was downloaded from, or empty for
+	// the bundled database.
+	source string
+	// sha256 is the hex encoded SHA-256 checksum of the database.
+	sha256 string
+	// updated is the last modification time of the database, or zero if
+	// it is unknown.
+	updated time.Time
+	// fetchErr is the error downloading the database, if the bundled
+	// database was used because of it.
+	fetchErr error
+}
+
+// dbURL returns the URL to download the database from. The db_url option
+// takes precedence over the RETIREJS_DB_URL environment variable.
+func (opt options) dbURL() string {
+	if opt.DBURL != "" {
+		return opt.DBURL
+	}
+	return os.Getenv(dbURLEnv)
+}
+
+// dbSHA256 returns the expected checksum of the downloaded database. The
+// db_sha256 option takes precedence over the RETIREJS_DB_SHA256
+// environment variable.
+func (opt options) dbSHA256() string {
+	if opt.DBSHA256 != "" {
+		return opt.DBSHA256
+	}
+	return os.Getenv(dbSHA256Env)
+}
+
+// dbMaxAge returns the age of the database above which a warning is added
+// to the notes of the check.
+func (opt options) dbMaxAge() time.Duration {
+	days := opt.DBMaxAgeDays
+	if days == 0 {
+		days = defDBMaxAgeDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// loadDB returns the database downloaded from the given URL, or the
+// bundled database if the URL is empty or the download fails.
+func loadDB(ctx context.Context, dbURL, checksum string) (retireDB, error) {
+	var fetchErr error
+	if dbURL != "" {
+		db, err := fetchDB(ctx, dbURL, checksum)
+		if err == nil {
+			return db, nil
+		}
+		logger.Warnf("Using the bundled retire.js database: %v", err)
+		fetchErr = err
+	}
+
+	db, err := loadBundledDB(jsDBName, bundledDBPath)
+	if err != nil {
+		return retireDB{}, err
+	}
+	db.fetchErr = fetchErr
+	return db, nil
+}
+
+// loadBundledDB returns the database bundled in the image of the check in
+// the given path.
+func loadBundledDB(name, path string) (retireDB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error reading the bundled %s: %w", name, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error reading the bundled %s: %w", name, err)
+	}
+	sum := sha256.Sum256(content)
+	return retireDB{
+		name:    name,
+		path:    path,
+		sha256:  hex.EncodeToString(sum[:]),
+		updated: info.ModTime(),
+	}, nil
+}
+
+// fetchDB downloads the database from the given URL to a temporary file.
+// The download fails if it takes more than dbFetchTimeout, the database
+// is bigger than dbMaxSize, it is not valid JSON or, when a checksum is
+// given, its SHA-256 checksum does not match.
+func fetchDB(ctx context.Context, dbURL, checksum string) (retireDB, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dbURL, nil)
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error downloading the retire.js database: %w", err)
+	}
+	resp, err := dbClient.Do(req)
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error downloading the retire.js database: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return retireDB{}, fmt.Errorf("error downloading the retire.js database: unexpected status %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, int64(dbMaxSize)+1))
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error downloading the retire.js database: %w", err)
+	}
+	if len(content) > dbMaxSize {
+		return retireDB{}, fmt.Errorf("invalid retire.js database: bigger than %d bytes", dbMaxSize)
+	}
+	sum := sha256.Sum256(content)
+	hexSum := hex.EncodeToString(sum[:])
+	if checksum != "" && !strings.EqualFold(checksum, hexSum) {
+		return retireDB{}, fmt.Errorf("invalid retire.js database: SHA-256 checksum %s does not match %s", hexSum, checksum)
+	}
+	if !json.Valid(content) {
+		return retireDB{}, fmt.Errorf("invalid retire.js database: not valid JSON")
+	}
+
+	f, err := os.CreateTemp("", "jsrepository-*.json")
+	if err != nil {
+		return retireDB{}, fmt.Errorf("error writing the retire.js database: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close() // nolint
+		os.Remove(f.Name())
+		return retireDB{}, fmt.Errorf("error writing the retire.js database: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return retireDB{}, fmt.Errorf("error writing the retire.js database: %w", err)
+	}
+
+	// The database has no dates, so its age is only known from the
+	// Last-Modified header.
+	updated, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		logger.Warnf("Unknown update date of the retire.js database downloaded from %s: no valid Last-Modified header", dbURL)
+		updated = time.Time{}
+	}
+	return retireDB{
+		name:    jsDBName,
+		path:    f.Name(),
+		source:  dbURL,
+		sha256:  hexSum,
+		updated: updated,
+	}, nil
+}
+
+// remove removes the database file if it was downloaded.
+func (db retireDB) remove() {
+	if db.source != "" {
+		os.Remove(db.path)
+	}
+}
+
+// details returns the text added to the details of the vulnerabilities
+// with the database used.
+func (db retireDB) details() string {
+	if db.sha256 == "" {
+		return ""
+	}
+	source := "bundled copy"
+	if db.source != "" {
+		source = db.source
+	}
+	updated := "update date unknown"
+	if !db.updated.IsZero() {
+		updated = "updated on " + db.updated.UTC().Format("2006-01-02")
+	}
+	name := strings.ToUpper(db.name[:1]) + db.name[1:]
+	return fmt.Sprintf("%s: %s (SHA-256 %s, %s)", name, source, db.sha256[:12], updated)
+}
+
+// notes returns the warnings added to the notes of the check about the
+// database used.
+func (db retireDB) notes(maxAge time.Duration, now time.Time) []string {
+	var notes []string
+	if db.fetchErr != nil {
+		notes = append(notes, fmt.Sprintf("The bundled %s was used because the configured one could not be used: %v.", db.name, db.fetchErr))
+	}
+	switch {
+	case db.updated.IsZero():
+		notes = append(notes, fmt.Sprintf("The update date of the %s used is unknown. Recent advisories may be missing.", db.name))
+	case now.Sub(db.updated) > maxAge:
+		notes = append(notes, fmt.Sprintf("The %s used was last updated on %s, more than %d days ago. Recent advisories may be missing.", db.name, db.updated.UTC().Format("2006-01-02"), int(maxAge.Hours()/24)))
+	}
+	return notes
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDB = `{"jquery":{"vulnerabilities":[]}}`
+
+func testDBSHA256() string {
+	sum := sha256.Sum256([]byte(testDB))
+	return hex.EncodeToString(sum[:])
+}
+
+func newDBServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jsrepository.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Tue, 01 Oct 2024 10:00:00 GMT")
+		w.Write([]byte(testDB))
+	})
+	mux.HandleFunc("/undated.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDB))
+	})
+	mux.HandleFunc("/invalid.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchDB(t *testing.T) {
+	srv := newDBServer(t)
+
+	tests := []struct {
+		name     string
+		url      string
+		checksum string
+		maxSize  int
+		wantErr  bool
+	}{
+		{name: "Valid", url: srv.URL + "/jsrepository.json", maxSize: dbMaxSize},
+		{name: "Checksum", url: srv.URL + "/jsrepository.json", checksum: strings.ToUpper(testDBSHA256()), maxSize: dbMaxSize},
+		{name: "WrongChecksum", url: srv.URL + "/jsrepository.json", checksum: strings.Repeat("0", 64), maxSize: dbMaxSize, wantErr: true},
+		{name: "TooBig", url: srv.URL + "/jsrepository.json", maxSize: len(testDB) - 1, wantErr: true},
+		{name: "NotFound", url: srv.URL + "/missing.json", maxSize: dbMaxSize, wantErr: true},
+		{name: "InvalidJSON", url: srv.URL + "/invalid.json", maxSize: dbMaxSize, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(size int) { dbMaxSize = size }(dbMaxSize)
+			dbMaxSize = tt.maxSize
+
+			db, err := fetchDB(context.Background(), tt.url, tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer db.remove()
+
+			content, err := os.ReadFile(db.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(content) != testDB {
+				t.Errorf("got database %q, want %q", content, testDB)
+			}
+			if db.sha256 != testDBSHA256() {
+				t.Errorf("got checksum %s, want %s", db.sha256, testDBSHA256())
+			}
+			want := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+			if !db.updated.Equal(want) {
+				t.Errorf("got update time %v, want %v", db.updated, want)
+			}
+		})
+	}
+}
+
+func TestLoadDBFallback(t *testing.T) {
+	srv := newDBServer(t)
+	defer func(path string) { bundledDBPath = path }(bundledDBPath)
+	bundledDBPath = filepath.Join(t.TempDir(), "jsrepository.json")
+	if err := os.WriteFile(bundledDBPath, []byte(testDB), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	db, err := loadDB(context.Background(), srv.URL+"/invalid.json", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if db.path != bundledDBPath || db.source != "" {
+		t.Errorf("got database %s from %q, want the bundled one", db.path, db.source)
+	}
+	if db.fetchErr == nil {
+		t.Error("the download error is not recorded")
+	}
+
+	db, err = loadDB(context.Background(), srv.URL+"/jsrepository.json", testDBSHA256())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.remove()
+	if db.source != srv.URL+"/jsrepository.json" {
+		t.Errorf("got database from %q, want the downloaded one", db.source)
+	}
+}
+
+func TestDBNotes(t *testing.T) {
+	now := time.Date(2024, 11, 15, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	maxAge := options{}.dbMaxAge()
+
+	fresh := retireDB{name: jsDBName, sha256: testDBSHA256(), updated: now.Add(-24 * time.Hour)}
+	if notes := fresh.notes(maxAge, now); len(notes) != 0 {
+		t.Errorf("unexpected notes for a fresh database: %v", notes)
+	}
+
+	stale := retireDB{name: jsDBName, sha256: testDBSHA256(), updated: updated, fetchErr: errors.New("timeout")}
+	notes := stale.notes(maxAge, now)
+	if len(notes) != 2 {
+		t.Fatalf("got %d notes, want 2: %v", len(notes), notes)
+	}
+	if !strings.Contains(notes[1], "2024-10-01") || !strings.Contains(notes[1], "30 days") {
+		t.Errorf("unexpected stale database note: %s", notes[1])
+	}
+
+	want := "Retire.js database: bundled copy (SHA-256 " + testDBSHA256()[:12] + ", updated on 2024-10-01)"
+	if got := stale.details(); got != want {
+		t.Errorf("got details %q, want %q", got, want)
+	}
+}
+
+func TestFetchDBUnknownDate(t *testing.T) {
+	srv := newDBServer(t)
+
+	db, err := fetchDB(context.Background(), srv.URL+"/undated.json", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer db.remove()
+	if !db.updated.IsZero() {
+		t.Errorf("got update time %v, want unknown", db.updated)
+	}
+
+	notes := db.notes(options{}.dbMaxAge(), time.Now())
+	if len(notes) != 1 || !strings.Contains(notes[0], "unknown") {
+		t.Errorf("unexpected notes for a database of unknown date: %v", notes)
+	}
+	want := "Retire.js database: " + srv.URL + "/undated.json (SHA-256 " + testDBSHA256()[:12] + ", update date unknown)"
+	if got := db.details(); got != want {
+		t.Errorf("got details %q, want %q", got, want)
+	}
+}
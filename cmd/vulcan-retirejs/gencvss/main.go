@@ -0,0 +1,223 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+// Command gencvss generates the CVSS table embedded in the retire.js check
+// from the CVSS v3 base scores published by the NVD for the CVEs of the
+// retire.js databases.
+//
+// Usage:
+//
+//	go run ./gencvss [-out cvss.json] [-all] [database ...]
+//
+// The databases are paths or URLs of retire.js databases and default to
+// the ones downloaded in the image of the check. Only the CVEs missing from
+// the output file are requested to the NVD unless -all is set. Without an
+// API key in the NVD_API_KEY environment variable, the NVD allows one
+// request every six seconds.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var defaultDatabases = []string{
+	"https://raw.githubusercontent.com/RetireJS/retire.js/master/repository/jsrepository.json",
+	"https://raw.githubusercontent.com/RetireJS/retire.js/master/repository/npmrepository.json",
+}
+
+var (
+	nvdAPI    = "https://services.nvd.nist.gov/rest/json/cves/2.0"
+	client    = &http.Client{Timeout: 30 * time.Second}
+	cveRegexp = regexp.MustCompile(`^CVE-\d{4}-\d{4,}$`)
+
+	// Time between the requests to the NVD API required by its rate
+	// limits without and with an API key.
+	nvdDelay    = 6 * time.Second
+	nvdKeyDelay = 600 * time.Millisecond
+)
+
+func main() {
+	out := flag.String("out", "cvss.json", "path of the generated CVSS table")
+	all := flag.Bool("all", false, "request the scores of all the CVEs, not only the missing ones")
+	flag.Parse()
+
+	databases := flag.Args()
+	if len(databases) == 0 {
+		databases = defaultDatabases
+	}
+	if err := run(*out, *all, databases); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(out string, all bool, databases []string) error {
+	scores := map[string]float32{}
+	if content, err := os.ReadFile(out); err == nil {
+		if err := json.Unmarshal(content, &scores); err != nil {
+			return fmt.Errorf("invalid CVSS table %s: %w", out, err)
+		}
+	}
+
+	cves := map[string]bool{}
+	for _, db := range databases {
+		if err := readCVEs(db, cves); err != nil {
+			return err
+		}
+	}
+	var missing []string
+	for cve := range cves {
+		if _, ok := scores[cve]; all || !ok {
+			missing = append(missing, cve)
+		}
+	}
+	sort.Strings(missing)
+	log.Printf("Requesting the scores of %d of %d CVEs", len(missing), len(cves))
+
+	apiKey := os.Getenv("NVD_API_KEY")
+	delay := nvdDelay
+	if apiKey != "" {
+		delay = nvdKeyDelay
+	}
+	for i, cve := range missing {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		score, ok, err := nvdScore(cve, apiKey)
+		if err != nil {
+			// Keep the previous score, if any, and continue with the
+			// rest of the CVEs.
+			log.Printf("Skipping %s: %v", cve, err)
+			continue
+		}
+		if ok {
+			scores[cve] = score
+		}
+	}
+
+	content, err := json.MarshalIndent(scores, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, append(content, '\n'), 0o644)
+}
+
+// readCVEs adds to cves the CVEs of the advisories of the retire.js
+// database in the given path or URL.
+func readCVEs(db string, cves map[string]bool) error {
+	var content []byte
+	if u, err := url.Parse(db); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		resp, err := client.Get(db)
+		if err != nil {
+			return fmt.Errorf("error downloading %s: %w", db, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error downloading %s: unexpected status %s", db, resp.Status)
+		}
+		if content, err = io.ReadAll(resp.Body); err != nil {
+			return fmt.Errorf("error downloading %s: %w", db, err)
+		}
+	} else if content, err = os.ReadFile(db); err != nil {
+		return err
+	}
+
+	var repo map[string]struct {
+		Vulnerabilities []struct {
+			Identifiers struct {
+				CVE []string `json:"CVE"`
+			} `json:"identifiers"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal(content, &repo); err != nil {
+		return fmt.Errorf("invalid retire.js database %s: %w", db, err)
+	}
+	for _, lib := range repo {
+		for _, v := range lib.Vulnerabilities {
+			for _, cve := range v.Identifiers.CVE {
+				cve = strings.ToUpper(strings.TrimSpace(cve))
+				if cveRegexp.MatchString(cve) {
+					cves[cve] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type cvssMetric struct {
+	Type     string `json:"type"`
+	CVSSData struct {
+		BaseScore float32 `json:"baseScore"`
+	} `json:"cvssData"`
+}
+
+// nvdScore returns the CVSS v3 base score of a CVE published by the NVD,
+// preferring the v3.1 metrics and the primary source. It returns false if
+// the CVE has no CVSS v3 score.
+func nvdScore(cve, apiKey string) (float32, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, nvdAPI+"?cveId="+url.QueryEscape(cve), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if apiKey != "" {
+		req.Header.Set("apiKey", apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var result struct {
+		Vulnerabilities []struct {
+			CVE struct {
+				Metrics struct {
+					V31 []cvssMetric `json:"cvssMetricV31"`
+					V30 []cvssMetric `json:"cvssMetricV30"`
+				} `json:"metrics"`
+			} `json:"cve"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, false, fmt.Errorf("invalid NVD response: %w", err)
+	}
+	if len(result.Vulnerabilities) == 0 {
+		return 0, false, nil
+	}
+	metrics := result.Vulnerabilities[0].CVE.Metrics
+	for _, m := range [][]cvssMetric{metrics.V31, metrics.V30} {
+		if score, ok := primaryScore(m); ok {
+			return score, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// primaryScore returns the base score of the primary metric, or of the
+// first one if there is no primary metric.
+func primaryScore(metrics []cvssMetric) (float32, bool) {
+	if len(metrics) == 0 {
+		return 0, false
+	}
+	for _, m := range metrics {
+		if m.Type == "Primary" {
+			return m.CVSSData.BaseScore, true
+		}
+	}
+	return metrics[0].CVSSData.BaseScore, true
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDB = `{
+  "lodash": {"vulnerabilities": [
+    {"identifiers": {"CVE": ["CVE-2019-10744"]}},
+    {"identifiers": {"CVE": ["CVE-2018-3721", "GHSA-fvqr-27wr-82fm"]}},
+    {"identifiers": {"summary": "No CVE"}}
+  ]},
+  "jquery": {"vulnerabilities": [{"identifiers": {"CVE": ["cve-2020-11022"]}}]}
+}`
+
+var testResponses = map[string]string{
+	"CVE-2019-10744": `{"vulnerabilities": [{"cve": {"metrics": {
+		"cvssMetricV31": [
+			{"type": "Secondary", "cvssData": {"baseScore": 9.8}},
+			{"type": "Primary", "cvssData": {"baseScore": 9.1}}
+		]}}}]}`,
+	"CVE-2018-3721": `{"vulnerabilities": [{"cve": {"metrics": {
+		"cvssMetricV30": [{"type": "Primary", "cvssData": {"baseScore": 6.5}}]
+		}}}]}`,
+	"CVE-2020-11022": `{"vulnerabilities": [{"cve": {"metrics": {}}}]}`,
+}
+
+func TestRun(t *testing.T) {
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cve := r.URL.Query().Get("cveId")
+		requested = append(requested, cve)
+		w.Write([]byte(testResponses[cve]))
+	}))
+	defer srv.Close()
+	defer func(api string) { nvdAPI = api }(nvdAPI)
+	nvdAPI = srv.URL
+	defer func(delay time.Duration) { nvdDelay = delay }(nvdDelay)
+	nvdDelay = 0
+
+	dir := t.TempDir()
+	db := filepath.Join(dir, "jsrepository.json")
+	if err := os.WriteFile(db, []byte(testDB), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The score of CVE-2019-10744 is already known, so it is only
+	// requested again with all set.
+	out := filepath.Join(dir, "cvss.json")
+	if err := os.WriteFile(out, []byte(`{"CVE-2019-10744": 9.0}`), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		all           bool
+		wantRequested []string
+		want          map[string]float32
+	}{
+		{
+			name:          "Missing",
+			wantRequested: []string{"CVE-2018-3721", "CVE-2020-11022"},
+			want:          map[string]float32{"CVE-2018-3721": 6.5, "CVE-2019-10744": 9.0},
+		},
+		{
+			name:          "All",
+			all:           true,
+			wantRequested: []string{"CVE-2018-3721", "CVE-2019-10744", "CVE-2020-11022"},
+			want:          map[string]float32{"CVE-2018-3721": 6.5, "CVE-2019-10744": 9.1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = nil
+			if err := run(out, tt.all, []string{db}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.wantRequested, requested); diff != "" {
+				t.Errorf("unexpected requested CVEs (-want +got):\n%v", diff)
+			}
+			content, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got map[string]float32
+			if err := json.Unmarshal(content, &got); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected scores (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
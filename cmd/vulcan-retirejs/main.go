@@ -7,6 +7,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -31,22 +32,71 @@ import (
 )
 
 const (
-	jsPath = "temp"
+	jsPath            = "temp"
+	gitRepositoryType = "GitRepository"
 )
 
 var (
-	checkName  = "vulcan-retirejs"
-	logger     = check.NewCheckLog(checkName)
-	retireArgs = []string{
-		"retire",
-		"--exitwith", "0",
-		"--outputformat", "json",
-		"--jspath", jsPath,
-		"--jsrepo", "jsrepository.json",
-	}
-	client *http.Client
+	checkName = "vulcan-retirejs"
+	logger    = check.NewCheckLog(checkName)
+	client    *http.Client
 )
 
+type options struct {
+	// MaxDepth is the number of levels of links of the same origin followed
+	// from the target to discover more pages. By default only the target
+	// page is scanned.
+	MaxDepth int `json:"max_depth"`
+	// MaxPages is the maximum number of pages scanned when crawling.
+	MaxPages int `json:"max_pages"`
+	// Branch is the branch of the GitRepository targets to scan. By
+	// default the default branch of the repository is scanned.
+	Branch string `json:"branch"`
+	// Token is used to clone private GitRepository targets.
+	Token string `json:"token"`
+	// Paths are the globs of the files of the GitRepository targets
+	// scanned, relative to the root of the repository. A "**" segment
+	// matches any number of directories.
+	Paths []string `json:"paths"`
+	// DBURL is the URL to download the retire.js database from at the
+	// start of the check. The bundled database is used if it is not set
+	// or the download fails.
+	DBURL string `json:"db_url"`
+	// DBSHA256 is the expected SHA-256 checksum of the downloaded
+	// database.
+	DBSHA256 string `json:"db_sha256"`
+	// DBMaxAgeDays is the age of the database above which a warning is
+	// added to the notes of the check.
+	DBMaxAgeDays int `json:"db_max_age_days"`
+}
+
+func (opt options) validate() error {
+	if opt.MaxDepth < 0 {
+		return fmt.Errorf("invalid max_depth %d: must be greater than or equal to 0", opt.MaxDepth)
+	}
+	if opt.MaxPages < 0 {
+		return fmt.Errorf("invalid max_pages %d: must be greater than or equal to 0", opt.MaxPages)
+	}
+	if opt.DBMaxAgeDays < 0 {
+		return fmt.Errorf("invalid db_max_age_days %d: must be greater than or equal to 0", opt.DBMaxAgeDays)
+	}
+	for _, p := range opt.Paths {
+		if err := validateGlob(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxPages returns the maximum number of pages scanned, which is
+// defMaxPages when the option is not set.
+func (opt options) maxPages() int {
+	if opt.MaxPages > 0 {
+		return opt.MaxPages
+	}
+	return defMaxPages
+}
+
 func init() {
 	timeout := 5 * time.Second
 	tr := &http.Transport{
@@ -63,7 +113,32 @@ func main() {
 		if target == "" {
 			return fmt.Errorf("check target missing")
 		}
+		var opt options
+		if optJSON != "" {
+			if err := json.Unmarshal([]byte(optJSON), &opt); err != nil {
+				return err
+			}
+		}
+		if opt.Token != "" {
+			optJSON = strings.ReplaceAll(optJSON, opt.Token, "REDACTED")
+		}
 		logger = logger.WithFields(logrus.Fields{"target": target, "assetType": assetType, "options": optJSON})
+		if err := opt.validate(); err != nil {
+			return err
+		}
+
+		db, err := loadDB(ctx, opt.dbURL(), opt.dbSHA256())
+		if err != nil {
+			return err
+		}
+		defer db.remove()
+		if notes := db.notes(opt.dbMaxAge(), time.Now()); len(notes) > 0 {
+			state.Notes = strings.Join(notes, "\n")
+		}
+
+		if assetType == gitRepositoryType {
+			return scanRepository(ctx, target, state, opt, db, nil)
+		}
 
 		isReachable, err := helpers.IsReachable(target, assetType, nil)
 		if err != nil {
@@ -73,14 +148,14 @@ func main() {
 			return checkstate.ErrAssetUnreachable
 		}
 
-		return scanTarget(ctx, target, assetType, logger, state, nil)
+		return scanTarget(ctx, target, assetType, logger, state, opt, db, nil)
 	}
 	c := check.NewCheckFromHandler(checkName, run)
 	c.RunAndServe()
 
 }
 
-func scanTarget(ctx context.Context, target, assetType string, logger *logrus.Entry, state checkstate.State, args []string) error {
+func scanTarget(ctx context.Context, target, assetType string, logger *logrus.Entry, state checkstate.State, opt options, db retireDB, args []string) error {
 	target, err := resolveTarget(target, assetType)
 	if err != nil {
 		// Don't fail the check if the target can not be accessed.
@@ -90,34 +165,55 @@ func scanTarget(ctx context.Context, target, assetType string, logger *logrus.En
 		return err
 	}
 
-	logger.Infof("Downloading javascript sources from %s", target)
+	logger.Infof("Crawling %s", target)
+	pages, err := crawl(ctx, target, opt.MaxDepth, opt.maxPages())
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Downloading javascript sources from %d pages", len(pages))
 
 	os.RemoveAll(jsPath)
 	os.MkdirAll(jsPath, os.ModePerm)
 	defer os.RemoveAll(jsPath)
 
-	_, err = findScriptFiles(target)
-	if err != nil {
-		return err
+	sources := newScriptSources()
+	for _, p := range pages {
+		_, err = findScriptFiles(ctx, p, sources)
+		if err != nil {
+			return err
+		}
+		_, err = findInlineScripts(p, sources)
+		if err != nil {
+			return err
+		}
 	}
-	_, err = findInlineScripts(target)
-	if err != nil {
-		return err
+	if len(args) == 0 {
+		args = retireArgs(db.path)
 	}
 	retireJsReport, err := runRetireJs(ctx, args)
 	if err != nil {
 		return err
 	}
 
-	addVulnsToState(state, retireJsReport)
+	addVulnsToState(state, retireJsReport, sources, db)
 
 	return nil
 }
 
-func runRetireJs(ctx context.Context, args []string) ([]RetireJsFileResult, error) {
-	if len(args) == 0 {
-		args = retireArgs
+// retireArgs returns the arguments to run retire.js over the downloaded
+// scripts using the given database.
+func retireArgs(jsRepo string) []string {
+	return []string{
+		"retire",
+		"--exitwith", "0",
+		"--outputformat", "json",
+		"--jspath", jsPath,
+		"--jsrepo", jsRepo,
 	}
+}
+
+func runRetireJs(ctx context.Context, args []string) ([]RetireJsFileResult, error) {
 	var report RetireJsReport
 	_, err := command.ExecuteAndParseJSON(ctx, logger, &report, args[0], args[1:]...)
 	if perr, ok := (err).(*command.ParseError); ok {
@@ -126,79 +222,107 @@ func runRetireJs(ctx context.Context, args []string) ([]RetireJsFileResult, erro
 	return report.Data, err
 }
 
-func addVulnsToState(state checkstate.State, r []RetireJsFileResult) {
-	for _, f := range r {
-		for _, v := range f.Results {
-			fingerprint := []string{}
-			vulnerability := report.Vulnerability{
-				Summary: "Vulnerabilities in JavaScript Dependencies",
-				CWEID:   1104,
-				Labels:  []string{"potential", "web", "retirejs"},
-				Description: "Vulnerabilities in dependencies may impact in the security of your program. For that reason " +
-					"it's important to check for issues not only in your code but in the 3rd party code you are using as a dependency.",
-				Recommendations: []string{
-					"Check if there is an update available for the affected resource.",
-					"Additional vulnerability information can be found in the links in the resources table.",
-				},
-				References:       []string{"https://portswigger.net/kb/issues/00500080_vulnerable-javascript-dependency"},
-				AffectedResource: fmt.Sprintf("%s-%s", v.Component, v.Version), // Example: jquery-1.9.0.
-				Score:            0.0,
-				Resources: []report.ResourcesGroup{
-					{
-						Name: "Vulnerabilities",
-						Header: []string{
-							"CVEs",
-							"Affected Versions",
-							"Severity",
-							"References",
-						},
-					},
+func addVulnsToState(state checkstate.State, r []RetireJsFileResult, sources *scriptSources, db retireDB) {
+	for _, g := range groupAdvisories(r, false) {
+		vulnerability := newVulnerability(g.result)
+		if details := db.details(); details != "" {
+			vulnerability.Details += "\n\n" + details
+		}
+		var rows []map[string]string
+		for _, f := range g.files {
+			rows = append(rows, sources.locations(f)...)
+		}
+		if len(rows) > 0 {
+			vulnerability.Resources = append(vulnerability.Resources, report.ResourcesGroup{
+				Name:   "Locations",
+				Header: []string{"Script", "Page"},
+				Rows:   rows,
+			})
+		}
+		state.AddVulnerabilities(vulnerability)
+	}
+}
+
+// newVulnerability returns the vulnerability reporting the issues found by
+// retire.js in a version of a library. The score is the highest score of
+// the advisories.
+func newVulnerability(v RetireJsResult) report.Vulnerability {
+	fingerprint := []string{}
+	vulnerability := report.Vulnerability{
+		Summary: "Vulnerabilities in JavaScript Dependencies",
+		CWEID:   1104,
+		Labels:  []string{"potential", "web", "retirejs"},
+		Description: "Vulnerabilities in dependencies may impact in the security of your program. For that reason " +
+			"it's important to check for issues not only in your code but in the 3rd party code you are using as a dependency.",
+		Recommendations: []string{
+			"Check if there is an update available for the affected resource.",
+			"Additional vulnerability information can be found in the links in the resources table.",
+		},
+		References:       append([]string{"https://portswigger.net/kb/issues/00500080_vulnerable-javascript-dependency"}, cveReferences(v.Vulnerabilities)...),
+		AffectedResource: fmt.Sprintf("%s-%s", v.Component, v.Version), // Example: jquery-1.9.0.
+		Score:            0.0,
+		Resources: []report.ResourcesGroup{
+			{
+				Name: "Vulnerabilities",
+				Header: []string{
+					"Summary",
+					"CVEs",
+					"CVSS",
+					"Affected Versions",
+					"Severity",
+					"References",
 				},
+			},
+		},
+	}
+	details := []string{fmt.Sprintf("The following vulnerabilities were found in %s version %s JavaScript dependency:", v.Component, v.Version)}
+	fingerprint = append(fingerprint, fmt.Sprintf("vulnerabilities#%d", len(v.Vulnerabilities)))
+	for _, i := range v.Vulnerabilities {
+		if score := advisoryScore(i); vulnerability.Score < score {
+			vulnerability.Score = score
+		}
+		fingerprint = append(fingerprint, strings.ToLower(i.Severity))
+		if i.Identifiers.Bug != "" {
+			fingerprint = append(fingerprint, i.Identifiers.Bug)
+		}
+		if i.Identifiers.Issue != "" {
+			fingerprint = append(fingerprint, i.Identifiers.Issue)
+		}
+		details = append(details, fmt.Sprintf("- [%s] %s", strings.Join(i.Identifiers.Cve, ","), i.Identifiers.Summary))
+		references := ""
+		for i, reference := range i.Info {
+			if i > 0 {
+				references += ", "
 			}
-			details := []string{fmt.Sprintf("The following vulnerabilities were found in %s version %s JavaScript dependency:", v.Component, v.Version)}
-			fingerprint = append(fingerprint, fmt.Sprintf("vulnerabilities#%d", len(v.Vulnerabilities)))
-			for _, i := range v.Vulnerabilities {
-				if score := getScore(i.Severity); vulnerability.Score < score {
-					vulnerability.Score = score
-				}
-				fingerprint = append(fingerprint, strings.ToLower(i.Severity))
-				if i.Identifiers.Bug != "" {
-					fingerprint = append(fingerprint, i.Identifiers.Bug)
-				}
-				if i.Identifiers.Issue != "" {
-					fingerprint = append(fingerprint, i.Identifiers.Issue)
-				}
-				details = append(details, fmt.Sprintf("- [%s] %s", strings.Join(i.Identifiers.Cve, ","), i.Identifiers.Summary))
-				references := ""
-				for i, reference := range i.Info {
-					if i > 0 {
-						references += ", "
-					}
-					references += fmt.Sprintf("[%d](%s)", i, reference)
-				}
-				gr := vulnerability.Resources[0]
-				r := map[string]string{
-					"CVEs":              strings.Join(i.Identifiers.Cve, ", "),
-					"Affected Versions": getAffectedVersion(i.AtOrAbove, i.Below),
-					"Severity":          strings.ToLower(i.Severity),
-					"References":        references,
-				}
-				gr.Rows = append(gr.Rows, r)
-				vulnerability.Resources[0] = gr
-			}
-			vulnerability.Details = strings.Join(details, "\n")
-
-			// The fingerprint is computed in the following way:
-			// - Store the number of vulnerabilities for the affected resource
-			// - Store the severity for each of the vulnerabilities
-			// - Store the CVEs, IssueID and BugID for each of the vulnerabilities
-			// - Sort the slice and join the elements with a field separator
-			// A change on any of these values may generate a new fingerprint.
-			sort.Strings(fingerprint)
-			vulnerability.Fingerprint = helpers.ComputeFingerprint(strings.Join(fingerprint, "|"))
-			state.AddVulnerabilities(vulnerability)
+			references += fmt.Sprintf("[%d](%s)", i, reference)
 		}
-	}
+		cvss := ""
+		if score, ok := advisoryCVSS(i); ok {
+			cvss = fmt.Sprintf("%.1f", score)
+		}
+		gr := vulnerability.Resources[0]
+		r := map[string]string{
+			"Summary":           i.Identifiers.Summary,
+			"CVEs":              strings.Join(i.Identifiers.Cve, ", "),
+			"CVSS":              cvss,
+			"Affected Versions": getAffectedVersion(i.AtOrAbove, i.Below),
+			"Severity":          strings.ToLower(i.Severity),
+			"References":        references,
+		}
+		gr.Rows = append(gr.Rows, r)
+		vulnerability.Resources[0] = gr
+	}
+	vulnerability.Details = strings.Join(details, "\n")
+
+	// The fingerprint is computed in the following way:
+	// - Store the number of vulnerabilities for the affected resource
+	// - Store the severity for each of the vulnerabilities
+	// - Store the CVEs, IssueID and BugID for each of the vulnerabilities
+	// - Sort the slice and join the elements with a field separator
+	// A change on any of these values may generate a new fingerprint.
+	sort.Strings(fingerprint)
+	vulnerability.Fingerprint = helpers.ComputeFingerprint(strings.Join(fingerprint, "|"))
+	return vulnerability
 }
 
 func getAffectedVersion(atOrAbove, below string) string {
@@ -267,14 +391,12 @@ type RetireJsVulnerability struct {
 	Severity    string           `json:"severity"`
 }
 
-func findScriptFiles(target string) (int, error) {
-	htmlNode, err := getTargetHTML(target)
-	if err != nil {
-		return 0, err
-	}
-
+// findScriptFiles downloads the scripts referenced by a given page that
+// have not been downloaded yet. The scripts that can not be downloaded are
+// logged and skipped. It returns the number of downloaded files.
+func findScriptFiles(ctx context.Context, p page, sources *scriptSources) (int, error) {
 	count := 0
-	for _, tag := range scrape.FindAll(htmlNode, scriptMatcher) {
+	for _, tag := range scrape.FindAll(p.node, scriptMatcher) {
 		url := ""
 		if tag.DataAtom == atom.Script {
 			url = scrape.Attr(tag, "src")
@@ -284,21 +406,44 @@ func findScriptFiles(target string) (int, error) {
 
 		}
 		if isRelativeUrl(url) {
-			url = getAbsoluteUrl(target, url)
+			url = getAbsoluteUrl(p.url, url)
 		}
-		if err := downloadFromUrl(url); err != nil {
-			return 0, err
+		if sources.addPage(url, p.url) || sources.failed[url] {
+			continue
 		}
+		file, err := downloadFromUrl(ctx, url)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return 0, ctxErr
+			}
+			// Scripts that can not be downloaded, e.g. because a third
+			// party CDN is down, do not make the check fail.
+			logger.Warnf("Skipping script %s: %v", url, err)
+			sources.failed[url] = true
+			continue
+		}
+		sources.add(file, url, p.url)
 		count++
 	}
 	return count, nil
 }
 
-func getTargetHTML(target string) (*html.Node, error) {
-	resp, err := client.Get(target)
+// getTargetHTML returns the parsed HTML of a given page. It returns
+// errNotHTML if the page declares a content type other than HTML.
+func getTargetHTML(ctx context.Context, target string) (*html.Node, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return nil, errNotHTML
+	}
 
 	root, err := html.Parse(resp.Body)
 	if err != nil {
@@ -317,26 +462,27 @@ func scriptMatcher(n *html.Node) bool {
 	return false
 }
 
-func getAbsoluteUrl(targetUrl string, url string) string {
-	if strings.HasPrefix(url, "./") {
-		url = strings.TrimLeft(url, "./")
-	}
-	if strings.HasPrefix(url, "//") {
-		url = strings.TrimLeft(url, "//")
+// getAbsoluteUrl returns the absolute URL of a URL referenced by a given
+// page, resolved against the URL of the page.
+func getAbsoluteUrl(targetUrl string, ref string) string {
+	base, err := url.Parse(targetUrl)
+	if err != nil {
+		return ref
 	}
-	if strings.HasPrefix(url, "/") {
-		url = strings.TrimLeft(url, "/")
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
 	}
-	return targetUrl + url
+	return base.ResolveReference(r).String()
 }
 
 func isRelativeUrl(url string) bool {
 	return !strings.Contains(url, "://")
 }
 
-// findInlineScripts downloads all inline scripts inside a given target HTML
+// findInlineScripts downloads all inline scripts inside a given page HTML
 // it returns the number of dowloaded files
-func findInlineScripts(target string) (int, error) {
+func findInlineScripts(p page, sources *scriptSources) (int, error) {
 	inlineMather := func(n *html.Node) bool {
 		if n.DataAtom == atom.Script {
 			return len(scrape.Attr(n, "src")) <= 0
@@ -344,19 +490,18 @@ func findInlineScripts(target string) (int, error) {
 		return false
 	}
 
-	htmlNode, err := getTargetHTML(target)
-	if err != nil {
-		return 0, err
-	}
-
 	count := 0
-	for i, inlineScript := range scrape.FindAll(htmlNode, inlineMather) {
+	for i, inlineScript := range scrape.FindAll(p.node, inlineMather) {
 		inlineSrc := scrape.Text(inlineScript)
-		fileName := fmt.Sprint(jsPath, "/", strconv.Itoa(i), ".js")
+		// NOTE: a random UUID is appended to avoid file path clashing with
+		// the inline scripts of other pages.
+		name := fmt.Sprintf("%s_%s.js", strconv.Itoa(i), uuid.NewV4().String())
+		fileName := fmt.Sprint(jsPath, "/", name)
 		logger.Infof("Writing inline script to file %s", fileName)
 		if err := writeFile(fileName, inlineSrc); err != nil {
 			return 0, fmt.Errorf("error writing inline script to file: %v", err)
 		}
+		sources.add(name, "", p.url)
 		count++
 	}
 	return count, nil
@@ -375,10 +520,12 @@ func writeFile(fileName string, contents string) error {
 	return nil
 }
 
-func downloadFromUrl(URL string) error {
+// downloadFromUrl downloads the script in the given URL and returns the
+// name of the file it is written to.
+func downloadFromUrl(ctx context.Context, URL string) (string, error) {
 	u, err := url.ParseRequestURI(URL)
 	if err != nil {
-		return fmt.Errorf("error invalid url %s: %w", URL, err)
+		return "", fmt.Errorf("error invalid url %s: %w", URL, err)
 	}
 	tokens := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
 	filename := tokens[len(tokens)-1]
@@ -386,16 +533,21 @@ func downloadFromUrl(URL string) error {
 	// NOTE: a random UUID is appended to avoid file path clashing with other
 	// previously downloaded scripts (e.g. '/a/jquery.min.js' and
 	// '/b/jquery.min.js').
-	filePath := fmt.Sprintf("%s/%s_%s.js", jsPath, filename, uuid.NewV4().String())
+	name := fmt.Sprintf("%s_%s.js", filename, uuid.NewV4().String())
+	filePath := fmt.Sprint(jsPath, "/", name)
 
 	logger.Infof("Downloading %s to %s", URL, filePath)
-	response, err := client.Get(URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("error downloading from url %s: %v", URL, err)
+	}
+	response, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("error downloading from url %s: %v", URL, err)
+		return "", fmt.Errorf("error downloading from url %s: %v", URL, err)
 	}
 	defer response.Body.Close()
 	bodyBytes, _ := io.ReadAll(response.Body)
-	return writeFile(filePath, string(bodyBytes))
+	return name, writeFile(filePath, string(bodyBytes))
 }
 
 // Follow redirects and return final URL.
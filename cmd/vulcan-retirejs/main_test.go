@@ -46,9 +46,9 @@ func TestRelativePathSlash(t *testing.T) {
 
 func TestRelativePathDoubleSlash(t *testing.T) {
 	baseUrl := "http://host.tld/"
-	path := "//my/script.js"
+	path := "//cdn.tld/my/script.js"
 	absoluteUrl := getAbsoluteUrl(baseUrl, path)
-	if absoluteUrl != baseUrl+"my/script.js" {
+	if absoluteUrl != "http://cdn.tld/my/script.js" {
 		t.Fatalf("Not the correct url: %s", absoluteUrl)
 	}
 }
@@ -66,7 +66,7 @@ func TestDownloadFromUrl(t *testing.T) {
 		w.Write([]byte("ABCDE"))
 	}))
 	defer ts.Close()
-	downloadFromUrl(ts.URL)
+	downloadFromUrl(context.Background(), ts.URL)
 	os.Remove("temp")
 }
 
@@ -149,7 +149,11 @@ func TestFindScriptFiles(t *testing.T) {
 	localAddr = ts.URL
 
 	expected := 2
-	got, err := findScriptFiles(localAddr)
+	node, err := getTargetHTML(context.Background(), localAddr)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	got, err := findScriptFiles(context.Background(), page{url: localAddr, node: node}, newScriptSources())
 	if err != nil {
 		t.Fatalf("expected no error but got: %v", err)
 	}
@@ -166,7 +170,11 @@ func TestInlineScripts(t *testing.T) {
 	defer ts.Close()
 	localAddr = ts.URL
 	expected := 1
-	got, err := findInlineScripts(localAddr)
+	node, err := getTargetHTML(context.Background(), localAddr)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	got, err := findInlineScripts(page{url: localAddr, node: node}, newScriptSources())
 	if err != nil {
 		t.Fatalf("expected no error but got: %v", err)
 	}
@@ -182,7 +190,7 @@ func TestFindTargetHTML(t *testing.T) {
 	}))
 
 	defer ts.Close()
-	htmlNode, err := getTargetHTML(ts.URL)
+	htmlNode, err := getTargetHTML(context.Background(), ts.URL)
 	if err != nil {
 		t.Fatalf("exepected no error but got: %v", err)
 	}
@@ -263,7 +271,7 @@ func TestAddVulnsToState(t *testing.T) {
 		Vulnerabilities: nil,
 	}
 	state := state.State{ProgressReporter: p, ResultData: &r}
-	addVulnsToState(state, []RetireJsFileResult{retireJsFileResult})
+	addVulnsToState(state, []RetireJsFileResult{retireJsFileResult}, nil, retireDB{})
 	if len(state.Vulnerabilities) != 1 {
 		t.Fatalf("We should have exactly one vulnerability")
 	}
@@ -300,7 +308,7 @@ func TestScanTarget(t *testing.T) {
 
 	l := check.NewCheckLog(checkName)
 	var state state.State
-	err = scanTarget(ctx, target, assetType, l, state, args)
+	err = scanTarget(ctx, target, assetType, l, state, options{}, retireDB{}, args)
 	if err != nil {
 		t.Fatalf("Error when running scanTarget: %v", err)
 	}
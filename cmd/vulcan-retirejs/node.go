@@ -0,0 +1,291 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// npmRepoPath is the path of the retire.js database of npm packages
+// bundled in the image of the check.
+var npmRepoPath = "npmrepository.json"
+
+// Detection reported for the packages found in the lockfiles.
+const lockfileDetection = "lockfile"
+
+// npmRepository is the retire.js database of npm packages, keyed by
+// package name.
+type npmRepository map[string]struct {
+	Vulnerabilities []RetireJsVulnerability `json:"vulnerabilities"`
+}
+
+// npmPackage is a package installed by a lockfile.
+type npmPackage struct {
+	name    string
+	version string
+}
+
+// versionRegexp matches the versions of the packages that can be compared
+// with the advisories, excluding those of Git, file or URL dependencies.
+var versionRegexp = regexp.MustCompile(`^\d+(\.\d+)*([-+.].*)?$`)
+
+func loadNPMRepository(file string) (npmRepository, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the retire.js npm database: %w", err)
+	}
+	var repo npmRepository
+	if err := json.Unmarshal(content, &repo); err != nil {
+		return nil, fmt.Errorf("invalid retire.js npm database: %w", err)
+	}
+	return repo, nil
+}
+
+// scanLockfiles matches the packages of the npm and yarn lockfiles found in
+// the given directory against the retire.js npm database. The results have
+// the same format as those reported by retire.js.
+//
+// retire.js does not scan the node dependencies since its version 4, so the
+// lockfiles are matched by the check itself.
+func scanLockfiles(dir string, repo npmRepository) ([]RetireJsFileResult, error) {
+	var results []RetireJsFileResult
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		var parse func([]byte) ([]npmPackage, error)
+		switch d.Name() {
+		case "package-lock.json", "npm-shrinkwrap.json":
+			parse = parsePackageLock
+		case "yarn.lock":
+			parse = parseYarnLock
+		default:
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		pkgs, err := parse(content)
+		if err != nil {
+			logger.Warnf("Skipping lockfile %s: %v", p, err)
+			return nil
+		}
+		if r := matchPackages(pkgs, repo); len(r) > 0 {
+			results = append(results, RetireJsFileResult{File: p, Results: r})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning the lockfiles: %w", err)
+	}
+	return results, nil
+}
+
+// matchPackages returns the packages affected by any of the advisories of
+// the npm database, in the order they are given.
+func matchPackages(pkgs []npmPackage, repo npmRepository) []RetireJsResult {
+	var results []RetireJsResult
+	seen := map[npmPackage]bool{}
+	for _, pkg := range pkgs {
+		if seen[pkg] || !versionRegexp.MatchString(pkg.version) {
+			continue
+		}
+		seen[pkg] = true
+		var vulns []RetireJsVulnerability
+		for _, v := range repo[pkg.name].Vulnerabilities {
+			if isVulnerable(pkg.version, v) {
+				vulns = append(vulns, v)
+			}
+		}
+		if len(vulns) == 0 {
+			continue
+		}
+		results = append(results, RetireJsResult{
+			Component:       pkg.name,
+			Version:         pkg.version,
+			Detection:       lockfileDetection,
+			Vulnerabilities: vulns,
+		})
+	}
+	return results
+}
+
+// isVulnerable reports whether the given version is in the range of
+// versions affected by the advisory.
+func isVulnerable(version string, v RetireJsVulnerability) bool {
+	if v.AtOrAbove != "" && !isAtOrAbove(version, v.AtOrAbove) {
+		return false
+	}
+	return v.Below == "" || !isAtOrAbove(version, v.Below)
+}
+
+// isAtOrAbove reports whether version1 is greater than or equal to
+// version2, comparing them the same way retire.js does: the versions are
+// split in dots and dashes, numeric components are compared as numbers and
+// are greater than the non numeric ones, e.g. "1.9.0" > "1.9.0b1".
+func isAtOrAbove(version1, version2 string) bool {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' })
+	}
+	v1, v2 := split(version1), split(version2)
+	for i := 0; i < max(len(v1), len(v2)); i++ {
+		c1, n1 := versionComponent(v1, i)
+		c2, n2 := versionComponent(v2, i)
+		if n1 != n2 {
+			return n1
+		}
+		if n1 {
+			i1, _ := strconv.Atoi(c1)
+			i2, _ := strconv.Atoi(c2)
+			if i1 != i2 {
+				return i1 > i2
+			}
+			continue
+		}
+		if c1 != c2 {
+			return c1 > c2
+		}
+	}
+	return true
+}
+
+// versionComponent returns the i-th component of a version and whether it
+// is numeric. Missing components are 0.
+func versionComponent(components []string, i int) (string, bool) {
+	if i >= len(components) {
+		return "0", true
+	}
+	c := components[i]
+	if _, err := strconv.Atoi(c); err == nil {
+		return c, true
+	}
+	return c, false
+}
+
+// parsePackageLock returns the packages of a package-lock.json or
+// npm-shrinkwrap.json file. The "packages" section is used when present
+// (lockfileVersion 2 and 3), otherwise the nested "dependencies" section
+// (lockfileVersion 1).
+func parsePackageLock(content []byte) ([]npmPackage, error) {
+	type dependency struct {
+		Version      string                     `json:"version"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("invalid package-lock.json: %w", err)
+	}
+
+	var pkgs []npmPackage
+	if len(lock.Packages) > 0 {
+		for key, p := range lock.Packages {
+			// The key is the path of the package, e.g.
+			// "node_modules/a/node_modules/@scope/b". The empty key is
+			// the root project.
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || p.Link || p.Version == "" {
+				continue
+			}
+			pkgs = append(pkgs, npmPackage{name: key[i+len("node_modules/"):], version: p.Version})
+		}
+		sortPackages(pkgs)
+		return pkgs, nil
+	}
+
+	var walk func(deps map[string]json.RawMessage) error
+	walk = func(deps map[string]json.RawMessage) error {
+		for name, raw := range deps {
+			var d dependency
+			if err := json.Unmarshal(raw, &d); err != nil {
+				return fmt.Errorf("invalid package-lock.json: %w", err)
+			}
+			pkgs = append(pkgs, npmPackage{name: name, version: d.Version})
+			if err := walk(d.Dependencies); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(lock.Dependencies); err != nil {
+		return nil, err
+	}
+	sortPackages(pkgs)
+	return pkgs, nil
+}
+
+// parseYarnLock returns the packages of a yarn.lock file, in both the
+// classic format and the YAML format of yarn 2 and later.
+func parseYarnLock(content []byte) ([]npmPackage, error) {
+	var pkgs []npmPackage
+	name := ""
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			// Entry header, e.g.: 'lodash@^4.17.4, lodash@^4.17.0:' or
+			// '"@babel/core@npm:^7.0.0":'.
+			spec, _, _ := strings.Cut(strings.TrimSuffix(trimmed, ":"), ",")
+			name = yarnPackageName(strings.Trim(strings.TrimSpace(spec), `"`))
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		// Version, e.g.: 'version "4.17.4"' or 'version: 4.17.4'.
+		if v, ok := strings.CutPrefix(trimmed, "version"); ok && (strings.HasPrefix(v, " ") || strings.HasPrefix(v, ":")) {
+			v = strings.Trim(strings.TrimSpace(strings.TrimPrefix(v, ":")), `"`)
+			pkgs = append(pkgs, npmPackage{name: name, version: v})
+			name = ""
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("invalid yarn.lock: %w", err)
+	}
+	return pkgs, nil
+}
+
+// yarnPackageName returns the name of the package of a yarn.lock
+// descriptor, e.g.: "lodash" for "lodash@^4.17.4" and "@babel/core" for
+// "@babel/core@npm:^7.0.0". It returns an empty string for the entries
+// that are not packages, like "__metadata".
+func yarnPackageName(spec string) string {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 {
+		return ""
+	}
+	return spec[:i]
+}
+
+// sortPackages sorts the packages by name and version, so the results do not depend on
+// the order of the keys of the lockfile.
+func sortPackages(pkgs []npmPackage) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].name != pkgs[j].name {
+			return pkgs[i].name < pkgs[j].name
+		}
+		return pkgs[i].version < pkgs[j].version
+	})
+}
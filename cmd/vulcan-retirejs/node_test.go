@@ -0,0 +1,148 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsAtOrAbove(t *testing.T) {
+	tests := []struct {
+		v1, v2 string
+		want   bool
+	}{
+		{"4.17.4", "4.17.5", false},
+		{"4.17.12", "4.17.5", true},
+		{"4.17.5", "4.17.5", true},
+		{"1.9", "1.9.0", true},
+		{"1.9.0", "1.9.0b1", true},
+		{"1.9.0b1", "1.9.0", false},
+		{"2.0.0-beta.1", "2.0.0-alpha.2", true},
+		{"10.0.0", "9.9.9", true},
+	}
+	for _, tt := range tests {
+		if got := isAtOrAbove(tt.v1, tt.v2); got != tt.want {
+			t.Errorf("isAtOrAbove(%q, %q): got %v, want %v", tt.v1, tt.v2, got, tt.want)
+		}
+	}
+}
+
+func TestParsePackageLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []npmPackage
+	}{
+		{
+			name: "Packages",
+			content: `{"lockfileVersion": 3, "packages": {
+				"": {"name": "web", "version": "1.0.0"},
+				"node_modules/lodash": {"version": "4.17.4"},
+				"node_modules/a/node_modules/@scope/b": {"version": "1.0.0"},
+				"node_modules/local": {"resolved": "../local", "link": true}
+			}}`,
+			want: []npmPackage{{"@scope/b", "1.0.0"}, {"lodash", "4.17.4"}},
+		},
+		{
+			name: "Dependencies",
+			content: `{"lockfileVersion": 1, "dependencies": {
+				"mkdirp": {"version": "0.5.1", "dependencies": {"minimist": {"version": "1.2.0"}}},
+				"minimist": {"version": "0.0.8"}
+			}}`,
+			want: []npmPackage{{"minimist", "0.0.8"}, {"minimist", "1.2.0"}, {"mkdirp", "0.5.1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePackageLock([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(npmPackage{})); diff != "" {
+				t.Errorf("unexpected packages (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestParseYarnLock(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []npmPackage
+	}{
+		{
+			name: "Classic",
+			content: `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/code-frame@^7.0.0":
+  version "7.12.13"
+  resolved "https://registry.yarnpkg.com/@babel/code-frame/-/code-frame-7.12.13.tgz"
+
+lodash@^4.17.4, lodash@^4.17.0:
+  version "4.17.4"
+  resolved "https://registry.yarnpkg.com/lodash/-/lodash-4.17.4.tgz"
+`,
+			want: []npmPackage{{"@babel/code-frame", "7.12.13"}, {"lodash", "4.17.4"}},
+		},
+		{
+			name: "Berry",
+			content: `__metadata:
+  version: 6
+  cacheKey: 8
+
+"lodash@npm:^4.17.4":
+  version: 4.17.4
+  resolution: "lodash@npm:4.17.4"
+  dependencies:
+    version: 1.0.0
+`,
+			want: []npmPackage{{"lodash", "4.17.4"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYarnLock([]byte(tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(npmPackage{})); diff != "" {
+				t.Errorf("unexpected packages (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestMatchPackages(t *testing.T) {
+	repo, err := loadNPMRepository("testdata/npmrepository.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pkgs := []npmPackage{
+		{"lodash", "4.17.11"},
+		{"lodash", "4.17.11"},
+		{"lodash", "4.17.21"},
+		{"minimist", "0.0.8"},
+		{"minimist", "1.2.0"},
+		{"minimist", "github:substack/minimist"},
+	}
+	var got []string
+	for _, r := range matchPackages(pkgs, repo) {
+		for _, v := range r.Vulnerabilities {
+			got = append(got, r.Component+"-"+r.Version+":"+v.Identifiers.Cve[0])
+		}
+	}
+	want := []string{
+		"lodash-4.17.11:CVE-2019-10744",
+		"minimist-1.2.0:CVE-2021-44906",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected matches (-want +got):\n%v", diff)
+	}
+}
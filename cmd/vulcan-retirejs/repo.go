@@ -0,0 +1,278 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// Default globs of the files of a repository scanned by the check: the npm
+// and yarn lockfiles and the bundled JavaScript files.
+var defaultRepoPaths = []string{
+	"**/package-lock.json",
+	"**/npm-shrinkwrap.json",
+	"**/yarn.lock",
+	"**/*.min.js",
+	"**/vendor/**/*.js",
+}
+
+// repoPaths returns the globs of the files of a repository scanned by the
+// check, which are defaultRepoPaths when the option is not set.
+func (opt options) repoPaths() []string {
+	if len(opt.Paths) > 0 {
+		return opt.Paths
+	}
+	return defaultRepoPaths
+}
+
+// validateGlob returns an error if the given glob is malformed.
+func validateGlob(glob string) error {
+	for _, segment := range strings.Split(glob, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid path %q: %w", glob, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the given slash separated path matches the
+// glob. Besides the syntax of path.Match, a "**" segment matches zero or
+// more directories.
+func matchGlob(glob, name string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// cloneRepository makes a shallow clone of the target repository and
+// returns the path of the clone. The repositories are cloned using the
+// token, if given, or the credentials configured for the check.
+func cloneRepository(ctx context.Context, target, branch, token string) (string, error) {
+	if token == "" {
+		repoPath, _, err := helpers.CloneGitRepository(target, branch, 1)
+		return repoPath, err
+	}
+
+	repoPath, err := os.MkdirTemp("", "vulcan-retirejs-repo-")
+	if err != nil {
+		return "", fmt.Errorf("error creating directory for repository: %w", err)
+	}
+	cloneOptions := &git.CloneOptions{
+		URL:          target,
+		Depth:        1,
+		SingleBranch: true,
+		// The username is ignored by the Git hosting services when
+		// authenticating with a token, but it must not be empty.
+		Auth: &githttp.BasicAuth{Username: "vulcan", Password: token},
+	}
+	if branch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+	if _, err := git.PlainCloneContext(ctx, repoPath, false, cloneOptions); err != nil {
+		os.RemoveAll(repoPath)
+		return "", fmt.Errorf("error cloning the repository: %w", err)
+	}
+	return repoPath, nil
+}
+
+// stageRepoFiles copies the regular files of the repository matching any
+// of the globs to the staging directory, keeping their paths relative to
+// the root of the repository. It returns the number of copied files.
+func stageRepoFiles(repoPath, staging string, globs []string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(repoPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(repoPath, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, glob := range globs {
+			if matchGlob(glob, rel) {
+				count++
+				return copyFile(p, filepath.Join(staging, filepath.FromSlash(rel)))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error looking for files in the repository: %w", err)
+	}
+	return count, nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close() // nolint
+		return err
+	}
+	return out.Close()
+}
+
+// repoRetireArgs returns the arguments to run retire.js over the
+// JavaScript files of a repository copied to the staging directory using
+// the given database. The lockfiles are scanned by scanLockfiles.
+func repoRetireArgs(staging, jsRepo string) []string {
+	return []string{
+		"retire",
+		"--exitwith", "0",
+		"--outputformat", "json",
+		"--path", staging,
+		"--jsrepo", jsRepo,
+	}
+}
+
+// scanRepository scans the JavaScript dependencies of the target
+// repository.
+func scanRepository(ctx context.Context, target string, state checkstate.State, opt options, db retireDB, args []string) error {
+	repoPath, err := cloneRepository(ctx, target, opt.Branch, opt.Token)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(repoPath)
+
+	return scanRepositoryPath(ctx, repoPath, state, opt, db, args)
+}
+
+// scanRepositoryPath scans the JavaScript dependencies of the repository
+// cloned in the given path.
+func scanRepositoryPath(ctx context.Context, repoPath string, state checkstate.State, opt options, db retireDB, args []string) error {
+	staging, err := os.MkdirTemp("", "vulcan-retirejs-files-")
+	if err != nil {
+		return fmt.Errorf("error creating staging directory: %w", err)
+	}
+	defer os.RemoveAll(staging)
+
+	n, err := stageRepoFiles(repoPath, staging, opt.repoPaths())
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		logger.Infof("No files matching %v found in the repository", opt.repoPaths())
+		return nil
+	}
+	logger.Infof("Scanning %d files of the repository", n)
+
+	// The npm database is always the bundled one, as db_url only replaces
+	// the database of JavaScript files.
+	npmDB, err := loadBundledDB(npmDBName, npmRepoPath)
+	if err != nil {
+		return err
+	}
+	npmRepo, err := loadNPMRepository(npmDB.path)
+	if err != nil {
+		return err
+	}
+	if notes := npmDB.notes(opt.dbMaxAge(), time.Now()); len(notes) > 0 {
+		if state.Notes != "" {
+			notes = append([]string{state.Notes}, notes...)
+		}
+		state.Notes = strings.Join(notes, "\n")
+	}
+	lockfileResults, err := scanLockfiles(staging, npmRepo)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		args = repoRetireArgs(staging, db.path)
+	}
+	retireJsReport, err := runRetireJs(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	addRepoVulnsToState(state, append(lockfileResults, retireJsReport...), staging, db, npmDB)
+	return nil
+}
+
+// addRepoVulnsToState adds to the state the vulnerabilities found by
+// retire.js in the files of a repository copied to the staging directory.
+// The affected resources are the paths of the files in the repository.
+// The details name the npm database for the packages of the lockfiles and
+// the given database for the rest.
+func addRepoVulnsToState(state checkstate.State, r []RetireJsFileResult, staging string, db, npmDB retireDB) {
+	for _, g := range groupAdvisories(r, true) {
+		source := db
+		if g.result.Detection == lockfileDetection {
+			source = npmDB
+		}
+		file := repoFile(staging, g.files[0])
+		vulnerability := newVulnerability(g.result)
+		// Example: web/package-lock.json:lodash-4.17.4.
+		vulnerability.AffectedResource = fmt.Sprintf("%s:%s", file, vulnerability.AffectedResource)
+		vulnerability.Details += fmt.Sprintf("\n\nFile: %s", file)
+		if details := source.details(); details != "" {
+			vulnerability.Details += "\n" + details
+		}
+		state.AddVulnerabilities(vulnerability)
+	}
+}
+
+// repoFile returns the path, relative to the root of the repository, of a
+// file reported by retire.js.
+func repoFile(staging, file string) string {
+	rel, err := filepath.Rel(staging, file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
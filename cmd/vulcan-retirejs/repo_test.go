@@ -0,0 +1,187 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		name string
+		want bool
+	}{
+		{"**/package.json", "package.json", true},
+		{"**/package.json", "web/app/package.json", true},
+		{"**/package.json", "web/package.json.bak", false},
+		{"**/vendor/**/*.js", "static/vendor/jquery.js", true},
+		{"**/vendor/**/*.js", "static/vendor/lib/jquery.js", true},
+		{"**/vendor/**/*.js", "static/jquery.js", false},
+		{"web/*.js", "web/app.js", true},
+		{"web/*.js", "web/lib/app.js", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.glob, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q): got %v, want %v", tt.glob, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePaths(t *testing.T) {
+	if err := (options{Paths: []string{"**/*.js"}}).validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (options{Paths: []string{"**/[.js"}}).validate(); err == nil {
+		t.Error("expected error for malformed glob")
+	}
+}
+
+func TestStageRepoFiles(t *testing.T) {
+	staging := t.TempDir()
+	n, err := stageRepoFiles("testdata/repo", staging, options{}.repoPaths())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	err = filepath.WalkDir(staging, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(staging, p)
+		got = append(got, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{
+		"static/vendor/jquery-1.8.1.min.js",
+		"web/package-lock.json",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected staged files (-want +got):\n%v", diff)
+	}
+	if n != len(want) {
+		t.Errorf("got %d staged files, want %d", n, len(want))
+	}
+}
+
+func TestScanRepositoryPath(t *testing.T) {
+	defer func(path string) { npmRepoPath = path }(npmRepoPath)
+	npmRepoPath = "testdata/npmrepository.json"
+
+	output, err := os.ReadFile("testdata/retire-repo.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := report.ResultData{}
+	s := state.State{ProgressReporter: stateMock{}, ResultData: &r}
+	args := []string{"echo", string(output)}
+	if err := scanRepositoryPath(context.Background(), "testdata/repo", s, options{}, retireDB{}, args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, v := range s.Vulnerabilities {
+		got = append(got, v.AffectedResource)
+	}
+	want := []string{
+		"web/package-lock.json:lodash-4.17.4",
+		"static/vendor/jquery-1.8.1.min.js:jquery-1.8.1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected affected resources (-want +got):\n%v", diff)
+	}
+	// The lockfile is matched against the npm database and the score is
+	// the CVSS score of CVE-2019-10744.
+	if got, want := s.Vulnerabilities[0].Score, float32(9.1); got != want {
+		t.Errorf("got score %v, want %v", got, want)
+	}
+}
+
+func TestScanRepositoryPathDatabases(t *testing.T) {
+	defer func(path string) { npmRepoPath = path }(npmRepoPath)
+	npmRepoPath = filepath.Join(t.TempDir(), "npmrepository.json")
+	content, err := os.ReadFile("testdata/npmrepository.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(npmRepoPath, content, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(npmRepoPath, updated, updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output, err := os.ReadFile("testdata/retire-repo.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := report.ResultData{Notes: "JS database note"}
+	s := state.State{ProgressReporter: stateMock{}, ResultData: &r}
+	db := retireDB{name: jsDBName, source: "https://example.com/jsrepository.json", sha256: strings.Repeat("a", 64), updated: time.Now()}
+	args := []string{"echo", string(output)}
+	if err := scanRepositoryPath(context.Background(), "testdata/repo", s, options{}, db, args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(s.Vulnerabilities) != 2 {
+		t.Fatalf("got %d vulnerabilities, want 2", len(s.Vulnerabilities))
+	}
+	if !strings.Contains(s.Vulnerabilities[0].Details, "Retire.js npm database: bundled copy") {
+		t.Errorf("the details of the lockfile finding do not name the npm database: %s", s.Vulnerabilities[0].Details)
+	}
+	if !strings.Contains(s.Vulnerabilities[1].Details, "Retire.js database: https://example.com/jsrepository.json") {
+		t.Errorf("the details of the file finding do not name the database: %s", s.Vulnerabilities[1].Details)
+	}
+	if !strings.HasPrefix(s.Notes, "JS database note\n") || !strings.Contains(s.Notes, "npm database used was last updated on 2024-10-01") {
+		t.Errorf("unexpected notes: %q", s.Notes)
+	}
+}
+
+func TestScanRepositoryPathNoFiles(t *testing.T) {
+	r := report.ResultData{}
+	s := state.State{ProgressReporter: stateMock{}, ResultData: &r}
+	opt := options{Paths: []string{"**/bower.json"}}
+	// The command fails if it is run.
+	args := []string{"false"}
+	if err := scanRepositoryPath(context.Background(), "testdata/repo", s, opt, retireDB{}, args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.Vulnerabilities) != 0 {
+		t.Errorf("got %d vulnerabilities, want 0", len(s.Vulnerabilities))
+	}
+}
+
+func TestRepoFile(t *testing.T) {
+	staging := filepath.Join(os.TempDir(), "staging")
+	tests := []struct {
+		file string
+		want string
+	}{
+		{filepath.Join(staging, "web", "package-lock.json"), "web/package-lock.json"},
+		{"web/yarn.lock", "web/yarn.lock"},
+	}
+	for _, tt := range tests {
+		if got := repoFile(staging, tt.file); got != tt.want {
+			t.Errorf("repoFile(%q): got %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adevinta/vulcan-check-sdk/helpers"
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// eolDateLayout is the layout of the end-of-life dates of the EOL table.
+const eolDateLayout = "2006-01-02"
+
+// eolJSON contains the end-of-life dates of the operating systems detected by
+// trivy, keyed by OS family and version.
+//
+//go:embed eol.json
+var eolJSON []byte
+
+var osEOL = mustLoadEOL(eolJSON)
+
+// now returns the current time. It is a variable so it can be replaced in the
+// tests.
+var now = time.Now
+
+// eolTable contains the end-of-life date of each version of each OS family.
+type eolTable map[string]map[string]time.Time
+
+func mustLoadEOL(content []byte) eolTable {
+	table, err := loadEOL(content)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+// loadEOL parses and validates the given EOL table. The families and the
+// versions must not be empty and the dates must follow the YYYY-MM-DD format.
+func loadEOL(content []byte) (eolTable, error) {
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("invalid EOL table: %w", err)
+	}
+	table := eolTable{}
+	for family, versions := range raw {
+		if family == "" || family != strings.ToLower(family) {
+			return nil, fmt.Errorf("invalid EOL table: invalid OS family %q", family)
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("invalid EOL table: no versions for OS family %s", family)
+		}
+		table[family] = map[string]time.Time{}
+		for version, date := range versions {
+			if version == "" {
+				return nil, fmt.Errorf("invalid EOL table: empty version for OS family %s", family)
+			}
+			t, err := time.Parse(eolDateLayout, date)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EOL table: invalid date %q for %s %s", date, family, version)
+			}
+			table[family][version] = t
+		}
+	}
+	return table, nil
+}
+
+// osVersion returns the version number in the OS name reported by trivy,
+// e.g.: "2" for "2 (Karoo)" or "2018.03" for "AMI release 2018.03".
+func osVersion(name string) string {
+	for _, f := range strings.Fields(name) {
+		if f[0] >= '0' && f[0] <= '9' {
+			return f
+		}
+	}
+	return ""
+}
+
+// lookup returns the end-of-life date of the given version of an OS family.
+// The version reported by trivy can be more specific than the versions in
+// the table, e.g.: "3.10.9" for alpine or "9.13" for debian, so the most
+// specific version of the table the given version belongs to is used.
+func (t eolTable) lookup(family, version string) (string, time.Time, bool) {
+	versions, ok := t[strings.ToLower(family)]
+	if !ok {
+		return "", time.Time{}, false
+	}
+	for v := osVersion(version); v != ""; {
+		if date, ok := versions[v]; ok {
+			return v, date, true
+		}
+		i := strings.LastIndexAny(v, ".-")
+		if i < 0 {
+			break
+		}
+		v = v[:i]
+	}
+	return "", time.Time{}, false
+}
+
+// processEOL reports the operating system of the image if it has reached its
+// end-of-life. Images on supported or unknown operating systems do not
+// generate any vulnerability.
+func processEOL(m osMetadata, state checkstate.State) {
+	if m.Family == "" || m.Name == "" {
+		return
+	}
+	version, date, ok := osEOL.lookup(m.Family, m.Name)
+	if !ok || now().Before(date) {
+		return
+	}
+	eol := date.Format(eolDateLayout)
+	distro := fmt.Sprintf("%s %s", m.Family, m.Name)
+	state.AddVulnerabilities(report.Vulnerability{
+		Summary:          "Base Image Operating System Is End-of-Life",
+		Description:      "The Docker image is built on a version of the operating system that has reached its end-of-life. The packages of the operating system will not receive security fixes anymore, so new vulnerabilities will never be fixed.",
+		Details:          fmt.Sprintf("Operating System: %s\nEnd-of-Life Date: %s", distro, eol),
+		Score:            report.SeverityThresholdMedium,
+		CWEID:            1104,
+		Labels:           []string{"issue", "docker", "eol"},
+		AffectedResource: fmt.Sprintf("%s:%s", m.Family, m.Name),
+		Fingerprint:      helpers.ComputeFingerprint(m.Family, version),
+		Recommendations: []string{
+			fmt.Sprintf("Rebase the image on a supported version of %s.", m.Family),
+		},
+		References: []string{
+			"https://endoflife.date",
+		},
+		Resources: []report.ResourcesGroup{{
+			Name:   "Operating System",
+			Header: []string{"Family", "Version", "End-of-Life Date"},
+			Rows: []map[string]string{{
+				"Family":           m.Family,
+				"Version":          m.Name,
+				"End-of-Life Date": eol,
+			}},
+		}},
+	})
+}
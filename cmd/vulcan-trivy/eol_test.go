@@ -0,0 +1,182 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadEOL(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    eolTable
+		wantErr string
+	}{
+		{
+			name:    "Valid",
+			content: `{"debian":{"9":"2022-06-30"},"alpine":{"3.10":"2021-05-01"}}`,
+			want: eolTable{
+				"debian": {"9": time.Date(2022, 6, 30, 0, 0, 0, 0, time.UTC)},
+				"alpine": {"3.10": time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name:    "InvalidJSON",
+			content: `{"debian":`,
+			wantErr: "invalid EOL table: unexpected end of JSON input",
+		},
+		{
+			name:    "InvalidDate",
+			content: `{"debian":{"9":"30/06/2022"}}`,
+			wantErr: `invalid EOL table: invalid date "30/06/2022" for debian 9`,
+		},
+		{
+			name:    "EmptyVersion",
+			content: `{"debian":{"":"2022-06-30"}}`,
+			wantErr: "invalid EOL table: empty version for OS family debian",
+		},
+		{
+			name:    "NoVersions",
+			content: `{"debian":{}}`,
+			wantErr: "invalid EOL table: no versions for OS family debian",
+		},
+		{
+			name:    "UppercaseFamily",
+			content: `{"Debian":{"9":"2022-06-30"}}`,
+			wantErr: `invalid EOL table: invalid OS family "Debian"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadEOL([]byte(tt.content))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected table (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestEOLLookup(t *testing.T) {
+	tests := []struct {
+		family      string
+		version     string
+		wantVersion string
+		wantDate    string
+	}{
+		{family: "debian", version: "9.13", wantVersion: "9", wantDate: "2022-06-30"},
+		{family: "debian", version: "9", wantVersion: "9", wantDate: "2022-06-30"},
+		{family: "alpine", version: "3.10.9", wantVersion: "3.10", wantDate: "2021-05-01"},
+		{family: "alpine", version: "3.1.4"},
+		{family: "ubuntu", version: "20.04", wantVersion: "20.04", wantDate: "2025-05-31"},
+		{family: "Amazon", version: "2 (Karoo)", wantVersion: "2", wantDate: "2026-06-30"},
+		{family: "amazon", version: "AMI release 2018.03", wantVersion: "2018.03", wantDate: "2023-12-31"},
+		{family: "photon", version: "4.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family+" "+tt.version, func(t *testing.T) {
+			version, date, ok := osEOL.lookup(tt.family, tt.version)
+			if ok != (tt.wantVersion != "") {
+				t.Fatalf("got ok %v for %s %s", ok, tt.family, tt.version)
+			}
+			if !ok {
+				return
+			}
+			if version != tt.wantVersion || date.Format(eolDateLayout) != tt.wantDate {
+				t.Errorf("got %s %s, want %s %s", version, date.Format(eolDateLayout), tt.wantVersion, tt.wantDate)
+			}
+		})
+	}
+}
+
+func TestRunEOL(t *testing.T) {
+	oldNow := now
+	t.Cleanup(func() { now = oldNow })
+	now = func() time.Time { return time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name    string
+		family  string
+		version string
+		want    []map[string]string
+	}{
+		{
+			name:    "Debian9",
+			family:  "debian",
+			version: "9.13",
+			want: []map[string]string{{
+				"Family":           "debian",
+				"Version":          "9.13",
+				"End-of-Life Date": "2022-06-30",
+			}},
+		},
+		{
+			name:    "Alpine310",
+			family:  "alpine",
+			version: "3.10.9",
+			want: []map[string]string{{
+				"Family":           "alpine",
+				"Version":          "3.10.9",
+				"End-of-Life Date": "2021-05-01",
+			}},
+		},
+		{
+			name:    "SupportedAlpine",
+			family:  "alpine",
+			version: "3.21.2",
+		},
+		{
+			name:    "SupportedDebian",
+			family:  "debian",
+			version: "12.8",
+		},
+		{
+			name:    "UnknownFamily",
+			family:  "photon",
+			version: "4.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trivyReport := fmt.Sprintf(`{"Metadata":{"OS":{"Family":%q,"Name":%q}},"Results":[]}`, tt.family, tt.version)
+			setupFakeTrivy(t, trivyReport)
+			var rd report.ResultData
+			state := checkstate.State{
+				ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+				ResultData:       &rd,
+			}
+			if err := run(context.Background(), "registry.example.com/app:1", "DockerImage", "", state); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got []map[string]string
+			for _, v := range rd.Vulnerabilities {
+				if v.Summary != "Base Image Operating System Is End-of-Life" {
+					t.Errorf("unexpected vulnerability %q", v.Summary)
+					continue
+				}
+				got = append(got, v.Resources[0].Rows...)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected EOL vulnerabilities (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
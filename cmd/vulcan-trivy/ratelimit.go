@@ -0,0 +1,111 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+)
+
+const (
+	// defaultRateLimitRetries is the default number of times trivy is
+	// executed again when the registry rate limits the requests.
+	defaultRateLimitRetries = 3
+
+	// defaultRateLimitMaxDelay is the default maximum number of seconds to
+	// wait between retries.
+	defaultRateLimitMaxDelay = 120
+)
+
+var (
+	// errRateLimited is returned when the registry keeps rate limiting the
+	// requests after all the retries. It wraps
+	// [checkstate.ErrAssetUnreachable], so the check finishes as
+	// inconclusive instead of failed and it can be run again later.
+	errRateLimited = fmt.Errorf("registry rate limit exceeded: %w", checkstate.ErrAssetUnreachable)
+
+	// rateLimitOutput matches the output of trivy when the registry rate
+	// limits the requests, e.g.: "toomanyrequests: You have reached your
+	// pull rate limit".
+	rateLimitOutput = regexp.MustCompile(`(?i)toomanyrequests|429 too many requests|pull rate limit`)
+
+	// rateLimitDelay is the delay before the first retry. It is doubled
+	// after each retry.
+	rateLimitDelay = 10 * time.Second
+)
+
+// rateLimitRetrier executes trivy again, with an exponential backoff, when
+// the registry rate limits the requests.
+type rateLimitRetrier struct {
+	maxRetries int
+	maxDelay   time.Duration
+	// retries is the number of retries performed by all the executions.
+	retries int
+}
+
+// rateLimitRetrier validates the rate limit options and returns the
+// corresponding retrier.
+func (o options) rateLimitRetrier() (*rateLimitRetrier, error) {
+	r := &rateLimitRetrier{
+		maxRetries: defaultRateLimitRetries,
+		maxDelay:   defaultRateLimitMaxDelay * time.Second,
+	}
+	if o.RateLimitRetries != nil {
+		if *o.RateLimitRetries < 0 {
+			return nil, fmt.Errorf("invalid rate_limit_retries %d: must be greater than or equal to 0", *o.RateLimitRetries)
+		}
+		r.maxRetries = *o.RateLimitRetries
+	}
+	if o.RateLimitMaxDelay < 0 {
+		return nil, fmt.Errorf("invalid rate_limit_max_delay %d: must be greater than or equal to 0", o.RateLimitMaxDelay)
+	}
+	if o.RateLimitMaxDelay > 0 {
+		r.maxDelay = time.Duration(o.RateLimitMaxDelay) * time.Second
+	}
+	return r, nil
+}
+
+// isRateLimited returns true if the given error of a trivy execution was
+// caused by the registry rate limiting the requests.
+func isRateLimited(err error) bool {
+	return err != nil && rateLimitOutput.MatchString(err.Error())
+}
+
+// do calls f until it succeeds, it fails because of something other than the
+// rate limit or the maximum number of retries is reached. The number of
+// retries performed is recorded in the notes of the report.
+func (r *rateLimitRetrier) do(ctx context.Context, state checkstate.State, f func() error) error {
+	delay := rateLimitDelay
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if !isRateLimited(err) {
+			if attempt > 0 {
+				logger.Infof("registry rate limit retries performed: %d", attempt)
+			}
+			return err
+		}
+		if attempt >= r.maxRetries {
+			logger.Warnf("registry rate limit exceeded after %d retries", attempt)
+			return fmt.Errorf("%w after %d retries: %w", errRateLimited, attempt, err)
+		}
+		if delay > r.maxDelay {
+			delay = r.maxDelay
+		}
+		logger.Warnf("registry rate limit exceeded, retrying in %s (%d/%d)", delay, attempt+1, r.maxRetries)
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+		r.retries++
+		state.Notes = fmt.Sprintf("Registry rate limit retries: %d", r.retries)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+)
+
+func TestRateLimitRetrierOptions(t *testing.T) {
+	zero, negative := 0, -1
+	tests := []struct {
+		name        string
+		opt         options
+		wantRetries int
+		wantDelay   time.Duration
+		wantErr     string
+	}{
+		{
+			name:        "Defaults",
+			wantRetries: defaultRateLimitRetries,
+			wantDelay:   defaultRateLimitMaxDelay * time.Second,
+		},
+		{
+			name:        "NoRetries",
+			opt:         options{RateLimitRetries: &zero, RateLimitMaxDelay: 30},
+			wantRetries: 0,
+			wantDelay:   30 * time.Second,
+		},
+		{
+			name:    "NegativeRetries",
+			opt:     options{RateLimitRetries: &negative},
+			wantErr: "invalid rate_limit_retries -1: must be greater than or equal to 0",
+		},
+		{
+			name:    "NegativeDelay",
+			opt:     options{RateLimitMaxDelay: -1},
+			wantErr: "invalid rate_limit_max_delay -1: must be greater than or equal to 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := tt.opt.rateLimitRetrier()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if r.maxRetries != tt.wantRetries || r.maxDelay != tt.wantDelay {
+				t.Errorf("got retries %d and delay %s, want %d and %s", r.maxRetries, r.maxDelay, tt.wantRetries, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: errors.New("trivy command execution failed: exit status 1. Command output: TOOMANYREQUESTS: You have reached your pull rate limit"), want: true},
+		{err: errors.New("GET https://registry.example.com/v2/: 429 Too Many Requests"), want: true},
+		{err: errors.New("trivy command execution failed: exit status 1. Command output: MANIFEST_UNKNOWN"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isRateLimited(tt.err); got != tt.want {
+			t.Errorf("isRateLimited(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunRateLimited(t *testing.T) {
+	oldDelay := rateLimitDelay
+	t.Cleanup(func() { rateLimitDelay = oldDelay })
+	rateLimitDelay = time.Millisecond
+
+	tests := []struct {
+		name        string
+		rateLimited string
+		options     string
+		wantErr     bool
+		wantRuns    string
+		wantNotes   string
+	}{
+		{
+			name:        "Recovered",
+			rateLimited: "2",
+			options:     `{"image_checks":{"vuln":true}}`,
+			wantRuns:    "3",
+			wantNotes:   "Registry rate limit retries: 2",
+		},
+		{
+			name:        "Persistent",
+			rateLimited: "10",
+			options:     `{"rate_limit_retries":2,"image_checks":{"vuln":true}}`,
+			wantErr:     true,
+			wantRuns:    "3",
+			wantNotes:   "Registry rate limit retries: 2",
+		},
+		{
+			name:        "NoRetries",
+			rateLimited: "1",
+			options:     `{"rate_limit_retries":0,"image_checks":{"vuln":true}}`,
+			wantErr:     true,
+			wantRuns:    "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argsFile, _ := setupFakeTrivy(t, imageReport)
+			t.Setenv("FAKE_RATE_LIMITED", tt.rateLimited)
+			var rd report.ResultData
+			state := checkstate.State{
+				ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+				ResultData:       &rd,
+			}
+			err := run(context.Background(), "docker.io/library/alpine:3.18", "DockerImage", tt.options, state)
+			if tt.wantErr {
+				if !errors.Is(err, errRateLimited) {
+					t.Errorf("got error %v, want %v", err, errRateLimited)
+				}
+				if !errors.Is(err, checkstate.ErrAssetUnreachable) {
+					t.Errorf("error %v does not wrap %v", err, checkstate.ErrAssetUnreachable)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(rd.Vulnerabilities) != 1 {
+					t.Errorf("got %d vulnerabilities, want 1", len(rd.Vulnerabilities))
+				}
+			}
+			runs, err := os.ReadFile(argsFile + ".count")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.TrimSpace(string(runs)); got != tt.wantRuns {
+				t.Errorf("trivy executed %s times, want %s", got, tt.wantRuns)
+			}
+			if rd.Notes != tt.wantNotes {
+				t.Errorf("got notes %q, want %q", rd.Notes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetrierCanceled(t *testing.T) {
+	oldDelay := rateLimitDelay
+	t.Cleanup(func() { rateLimitDelay = oldDelay })
+	rateLimitDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &rateLimitRetrier{maxRetries: 3, maxDelay: time.Hour}
+	var rd report.ResultData
+	state := checkstate.State{ResultData: &rd}
+	err := r.do(ctx, state, func() error {
+		cancel()
+		return errors.New("toomanyrequests")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
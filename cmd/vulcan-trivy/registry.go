@@ -0,0 +1,174 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+
+	"github.com/adevinta/vulcan-checks/internal/awsauth"
+)
+
+const (
+	envEndpoint = `VULCAN_ASSUME_ROLE_ENDPOINT`
+	envRole     = `ROLE_NAME`
+	// envExternalID is the env var that defines the external ID sent to the
+	// assume role endpoint.
+	envExternalID = `VULCAN_ASSUME_ROLE_EXTERNAL_ID`
+)
+
+var (
+	// ecrRegistry matches the domains of the private ECR registries and
+	// captures the account and the region of the registry.
+	ecrRegistry = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+	// ecrEndpoint overrides the URL of the ECR API. It is only set in the
+	// tests.
+	ecrEndpoint = ""
+)
+
+// registryCredentials are the credentials used by trivy to pull an image.
+// Either the username and the password or the token are set.
+type registryCredentials struct {
+	Username string
+	Password string
+	Token    string
+	// Source describes where the credentials were obtained from, e.g.:
+	// "options". It is the only field that can be logged.
+	Source string
+}
+
+// env returns the env vars that make trivy use the credentials. The
+// credentials are only passed to trivy through its environment, so they are
+// not exposed in the command line nor in the logs.
+func (c registryCredentials) env() []string {
+	if c.Token != "" {
+		return []string{"TRIVY_REGISTRY_TOKEN=" + c.Token}
+	}
+	if c.Username == "" && c.Password == "" {
+		return nil
+	}
+	return []string{
+		"TRIVY_USERNAME=" + c.Username,
+		"TRIVY_PASSWORD=" + c.Password,
+	}
+}
+
+// password returns the secret of the credentials, which is the token if it
+// is set.
+func (c registryCredentials) password() string {
+	if c.Token != "" {
+		return c.Token
+	}
+	return c.Password
+}
+
+// registryDomain returns the domain of the registry of the given image.
+func registryDomain(image string) string {
+	domain, _, ok := strings.Cut(image, "/")
+	if !ok {
+		return ""
+	}
+	return domain
+}
+
+// imageRegistryCredentials returns the credentials to pull the given image.
+// The credentials are taken, in order, from:
+//
+//   - The registry_username and registry_password, or registry_token,
+//     options.
+//   - The REGISTRY_USERNAME and REGISTRY_PASSWORD env vars, if the registry
+//     of the image is the one in REGISTRY_DOMAIN.
+//   - For private ECR registries, an ECR authorization token obtained with
+//     the credentials returned by the assume role endpoint for the account of
+//     the registry.
+//
+// It returns empty credentials if none of them applies.
+func imageRegistryCredentials(ctx context.Context, image string, opt options) (registryCredentials, error) {
+	if opt.RegistryToken != "" || opt.RegistryUsername != "" || opt.RegistryPassword != "" {
+		return registryCredentials{
+			Username: opt.RegistryUsername,
+			Password: opt.RegistryPassword,
+			Token:    opt.RegistryToken,
+			Source:   "options",
+		}, nil
+	}
+	domain := registryDomain(image)
+	if domain != "" && domain == os.Getenv("REGISTRY_DOMAIN") {
+		return registryCredentials{
+			Username: os.Getenv("REGISTRY_USERNAME"),
+			Password: os.Getenv("REGISTRY_PASSWORD"),
+			Source:   "env",
+		}, nil
+	}
+	m := ecrRegistry.FindStringSubmatch(domain)
+	if m == nil {
+		return registryCredentials{}, nil
+	}
+	return ecrCredentials(ctx, m[1], m[2])
+}
+
+// ecrCredentials requests credentials for the given account to the assume
+// role endpoint and exchanges them for an ECR authorization token of the
+// registry of the account in the given region.
+func ecrCredentials(ctx context.Context, accountID, region string) (registryCredentials, error) {
+	endpoint := os.Getenv(envEndpoint)
+	if endpoint == "" {
+		return registryCredentials{}, fmt.Errorf("%s is required to pull images from ECR", envEndpoint)
+	}
+	opts := awsauth.AssumeRoleOptions{
+		ExternalID: os.Getenv(envExternalID),
+		Logger:     logger,
+	}
+	creds, err := awsauth.AssumeRole(ctx, endpoint, accountID, os.Getenv(envRole), opts)
+	if err != nil {
+		return registryCredentials{}, fmt.Errorf("can not assume role in account %s: %w", accountID, err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)),
+	)
+	if err != nil {
+		return registryCredentials{}, fmt.Errorf("can not load AWS config: %w", err)
+	}
+	svc := ecr.NewFromConfig(cfg, func(o *ecr.Options) {
+		if ecrEndpoint != "" {
+			o.BaseEndpoint = aws.String(ecrEndpoint)
+		}
+	})
+	out, err := svc.GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{
+		RegistryIds: []string{accountID},
+	})
+	if err != nil {
+		return registryCredentials{}, fmt.Errorf("can not get ECR authorization token: %w", err)
+	}
+	if len(out.AuthorizationData) == 0 {
+		return registryCredentials{}, errors.New("no ECR authorization data returned")
+	}
+	token, err := base64.StdEncoding.DecodeString(aws.ToString(out.AuthorizationData[0].AuthorizationToken))
+	if err != nil {
+		return registryCredentials{}, errors.New("invalid ECR authorization token")
+	}
+	username, password, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return registryCredentials{}, errors.New("invalid ECR authorization token")
+	}
+	return registryCredentials{
+		Username: username,
+		Password: password,
+		Source:   "ecr",
+	}, nil
+}
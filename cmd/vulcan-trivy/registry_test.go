@@ -0,0 +1,260 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/adevinta/vulcan-checks/internal/awsauth"
+)
+
+const ecrImage = "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1"
+
+// fakeAWS serves the assume role endpoint and the ECR API. It records the
+// account requested to each of them.
+type fakeAWS struct {
+	assumeRoleStatus int
+
+	assumedAccount string
+	registryIDs    []string
+}
+
+func (f *fakeAWS) assumeRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		AccountID string `json:"account_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.assumedAccount = req.AccountID
+	if f.assumeRoleStatus != 0 {
+		w.WriteHeader(f.assumeRoleStatus)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_key":        "AKIDASSUMED",
+		"secret_access_key": "assumed-secret",
+		"session_token":     "assumed-token",
+		"version":           awsauth.PayloadVersionV2,
+	})
+}
+
+func (f *fakeAWS) ecr(w http.ResponseWriter, r *http.Request) {
+	if target := r.Header.Get("X-Amz-Target"); target != "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken" {
+		http.Error(w, "unexpected operation "+target, http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential=AKIDASSUMED/") {
+		http.Error(w, "unexpected credentials", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		RegistryIDs []string `json:"registryIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.registryIDs = req.RegistryIDs
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(map[string]any{
+		"authorizationData": []map[string]any{{
+			"authorizationToken": base64.StdEncoding.EncodeToString([]byte("AWS:ecr-password")),
+			"proxyEndpoint":      "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+		}},
+	})
+}
+
+// setupFakeAWS starts the fake assume role endpoint and ECR API and points
+// the check to them.
+func setupFakeAWS(t *testing.T) *fakeAWS {
+	f := &fakeAWS{}
+	assumeRole := httptest.NewServer(http.HandlerFunc(f.assumeRole))
+	t.Cleanup(assumeRole.Close)
+	ecrAPI := httptest.NewServer(http.HandlerFunc(f.ecr))
+	t.Cleanup(ecrAPI.Close)
+
+	t.Setenv(envEndpoint, assumeRole.URL)
+	t.Setenv(envRole, "vulcan")
+	oldEndpoint := ecrEndpoint
+	t.Cleanup(func() { ecrEndpoint = oldEndpoint })
+	ecrEndpoint = ecrAPI.URL
+	return f
+}
+
+func TestImageRegistryCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		opt     options
+		env     map[string]string
+		want    registryCredentials
+		wantEnv []string
+	}{
+		{
+			name:  "Options",
+			image: "registry.example.com/app:1",
+			opt:   options{RegistryUsername: "user", RegistryPassword: "pass"},
+			env: map[string]string{
+				"REGISTRY_DOMAIN":   "registry.example.com",
+				"REGISTRY_USERNAME": "env-user",
+				"REGISTRY_PASSWORD": "env-pass",
+			},
+			want:    registryCredentials{Username: "user", Password: "pass", Source: "options"},
+			wantEnv: []string{"TRIVY_USERNAME=user", "TRIVY_PASSWORD=pass"},
+		},
+		{
+			name:    "OptionsToken",
+			image:   "registry.example.com/app:1",
+			opt:     options{RegistryToken: "token"},
+			want:    registryCredentials{Token: "token", Source: "options"},
+			wantEnv: []string{"TRIVY_REGISTRY_TOKEN=token"},
+		},
+		{
+			name:  "Env",
+			image: "registry.example.com/app:1",
+			env: map[string]string{
+				"REGISTRY_DOMAIN":   "registry.example.com",
+				"REGISTRY_USERNAME": "env-user",
+				"REGISTRY_PASSWORD": "env-pass",
+			},
+			want:    registryCredentials{Username: "env-user", Password: "env-pass", Source: "env"},
+			wantEnv: []string{"TRIVY_USERNAME=env-user", "TRIVY_PASSWORD=env-pass"},
+		},
+		{
+			name:  "OtherRegistry",
+			image: "docker.io/library/alpine:3.18",
+			env: map[string]string{
+				"REGISTRY_DOMAIN":   "registry.example.com",
+				"REGISTRY_USERNAME": "env-user",
+				"REGISTRY_PASSWORD": "env-pass",
+			},
+		},
+		{
+			name:  "NoRegistry",
+			image: "alpine:3.18",
+			env: map[string]string{
+				"REGISTRY_DOMAIN": "",
+			},
+		},
+		{
+			name:    "ECR",
+			image:   ecrImage,
+			want:    registryCredentials{Username: "AWS", Password: "ecr-password", Source: "ecr"},
+			wantEnv: []string{"TRIVY_USERNAME=AWS", "TRIVY_PASSWORD=ecr-password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := setupFakeAWS(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := imageRegistryCredentials(context.Background(), tt.image, tt.opt)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected credentials (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(tt.wantEnv, got.env()); diff != "" {
+				t.Errorf("unexpected env (-want +got):\n%v", diff)
+			}
+			if tt.want.Source == "ecr" {
+				if f.assumedAccount != "123456789012" {
+					t.Errorf("got assumed account %q, want %q", f.assumedAccount, "123456789012")
+				}
+				if diff := cmp.Diff([]string{"123456789012"}, f.registryIDs); diff != "" {
+					t.Errorf("unexpected registry IDs (-want +got):\n%v", diff)
+				}
+			} else if f.assumedAccount != "" {
+				t.Errorf("unexpected assume role request for account %q", f.assumedAccount)
+			}
+		})
+	}
+}
+
+func TestImageRegistryCredentialsECRErrors(t *testing.T) {
+	t.Run("NoEndpoint", func(t *testing.T) {
+		setupFakeAWS(t)
+		t.Setenv(envEndpoint, "")
+		_, err := imageRegistryCredentials(context.Background(), ecrImage, options{})
+		if err == nil || !strings.Contains(err.Error(), envEndpoint) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	t.Run("NotAuthorized", func(t *testing.T) {
+		f := setupFakeAWS(t)
+		f.assumeRoleStatus = http.StatusForbidden
+		_, err := imageRegistryCredentials(context.Background(), ecrImage, options{})
+		if !errors.Is(err, awsauth.ErrRoleNotAuthorized) {
+			t.Errorf("got error %v, want %v", err, awsauth.ErrRoleNotAuthorized)
+		}
+	})
+}
+
+func TestRunRegistryCredentials(t *testing.T) {
+	argsFile, _ := setupFakeTrivy(t, imageReport)
+	setupFakeAWS(t)
+	hook := logtest.NewLocal(logger.Logger)
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	opts := `{"generate_sbom":true,"image_checks":{"vuln":true}}`
+	if err := run(context.Background(), ecrImage, "DockerImage", opts, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The credentials are passed to every execution of trivy through its
+	// environment.
+	env, err := os.ReadFile(argsFile + ".env")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Repeat("TRIVY_PASSWORD=ecr-password\nTRIVY_USERNAME=AWS\n", 2)
+	if diff := cmp.Diff(want, string(env)); diff != "" {
+		t.Errorf("unexpected trivy env (-want +got):\n%v", diff)
+	}
+
+	// The credentials are never in the command line nor in the logs.
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secrets := []string{"ecr-password", "assumed-secret", "assumed-token"}
+	for _, s := range secrets {
+		if strings.Contains(string(args), s) {
+			t.Errorf("the trivy arguments contain the secret %q", s)
+		}
+		for _, e := range hook.AllEntries() {
+			line, err := e.String()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Contains(line, s) {
+				t.Errorf("the log entry %q contains the secret %q", line, s)
+			}
+		}
+	}
+	if len(hook.AllEntries()) == 0 {
+		t.Error("no log entries")
+	}
+}
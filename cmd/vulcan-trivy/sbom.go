@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+const (
+	// sbomAttachmentName is the name of the attachment that contains the
+	// CycloneDX SBOM of the image.
+	sbomAttachmentName = "sbom.cdx.json"
+
+	// sbomContentType is the media type of the CycloneDX JSON documents.
+	sbomContentType = "application/vnd.cyclonedx+json"
+)
+
+var (
+	sbomOutputFile = "sbom.json"
+
+	// sbomMaxSize is the maximum size in bytes of the SBOM attached to the
+	// report. Bigger documents are gzipped, and they are not attached if
+	// they still exceed it.
+	sbomMaxSize = 5 * 1024 * 1024
+)
+
+// cyclonedxBOM contains the fields of a CycloneDX document used by the check.
+type cyclonedxBOM struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Components  []struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		PURL    string `json:"purl"`
+	} `json:"components"`
+}
+
+// parseSBOM parses the given CycloneDX JSON document.
+func parseSBOM(data []byte) (cyclonedxBOM, error) {
+	var bom cyclonedxBOM
+	if err := json.Unmarshal(data, &bom); err != nil {
+		return cyclonedxBOM{}, fmt.Errorf("invalid CycloneDX document: %w", err)
+	}
+	if bom.BOMFormat != "CycloneDX" {
+		return cyclonedxBOM{}, fmt.Errorf("invalid CycloneDX document: unexpected bomFormat %q", bom.BOMFormat)
+	}
+	return bom, nil
+}
+
+// packageCounts returns the number of packages of the SBOM by type, e.g.:
+// "Packages: 12\nPackages by Type: apk: 10, npm: 2". The type of a package is
+// taken from its package URL.
+func (bom cyclonedxBOM) packageCounts() string {
+	var (
+		total  int
+		types  []string
+		byType = map[string]int{}
+	)
+	for _, c := range bom.Components {
+		if c.PURL == "" {
+			continue
+		}
+		typ, _, _ := strings.Cut(strings.TrimPrefix(c.PURL, "pkg:"), "/")
+		if _, ok := byType[typ]; !ok {
+			types = append(types, typ)
+		}
+		byType[typ]++
+		total++
+	}
+	sort.Strings(types)
+	var counts []string
+	for _, t := range types {
+		counts = append(counts, fmt.Sprintf("%s: %d", t, byType[t]))
+	}
+	s := fmt.Sprintf("Packages: %d", total)
+	if len(counts) > 0 {
+		s += "\nPackages by Type: " + strings.Join(counts, ", ")
+	}
+	return s
+}
+
+// sbomAttachment returns the attachment with the given SBOM. The document is
+// gzipped if it exceeds sbomMaxSize. The returned bool is false when the
+// gzipped document still exceeds the limit and it can not be attached.
+func sbomAttachment(data []byte) (report.Attachment, bool, error) {
+	if len(data) <= sbomMaxSize {
+		return report.Attachment{
+			Name:        sbomAttachmentName,
+			ContentType: sbomContentType,
+			Data:        data,
+		}, true, nil
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(data); err != nil {
+		return report.Attachment{}, false, err
+	}
+	if err := zw.Close(); err != nil {
+		return report.Attachment{}, false, err
+	}
+	if b.Len() > sbomMaxSize {
+		return report.Attachment{}, false, nil
+	}
+	return report.Attachment{
+		Name:        sbomAttachmentName + ".gz",
+		ContentType: "application/gzip",
+		Data:        b.Bytes(),
+	}, true, nil
+}
+
+// sbomVuln returns the informational vulnerability with the SBOM of the
+// image attached.
+func sbomVuln(target string, data []byte) (report.Vulnerability, error) {
+	bom, err := parseSBOM(data)
+	if err != nil {
+		return report.Vulnerability{}, err
+	}
+	vuln := report.Vulnerability{
+		Summary:          "Software Bill of Materials",
+		Description:      "The Software Bill of Materials (SBOM) of the Docker image in CycloneDX format is attached.",
+		Score:            report.SeverityThresholdNone,
+		Labels:           []string{"informational", "docker", "sbom"},
+		AffectedResource: target,
+		Details:          bom.packageCounts(),
+	}
+	a, ok, err := sbomAttachment(data)
+	if err != nil {
+		return report.Vulnerability{}, err
+	}
+	if !ok {
+		vuln.Details += fmt.Sprintf("\n\nThe SBOM has not been attached because it exceeds the size limit of %d bytes.", sbomMaxSize)
+		return vuln, nil
+	}
+	vuln.Attachments = []report.Attachment{a}
+	return vuln, nil
+}
+
+// execTrivySBOM generates the CycloneDX SBOM of the given image. The given
+// env vars are added to the environment of trivy.
+func execTrivySBOM(target string, env []string) ([]byte, error) {
+	args := []string{
+		"image",
+		"--format", "cyclonedx",
+		"-o", sbomOutputFile,
+		"--quiet",
+		"--timeout", trivyTimeout,
+		target,
+	}
+	logger.Infof("running command: %s %s\n", trivyCmd, args)
+	cmd := exec.Command(trivyCmd, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmdOutput, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("trivy sbom generation failed: %w. Command output: %s", err, string(cmdOutput))
+	}
+	data, err := os.ReadFile(sbomOutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("trivy sbom output file read failed with error: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/google/go-cmp/cmp"
+)
+
+func readSBOM(t *testing.T) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", "sbom.cdx.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
+
+// attachedSBOM returns the SBOM attached to the given vulnerability,
+// decompressing it if needed.
+func attachedSBOM(t *testing.T, v report.Vulnerability) []byte {
+	if len(v.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want 1", len(v.Attachments))
+	}
+	a := v.Attachments[0]
+	switch a.Name {
+	case sbomAttachmentName:
+		if a.ContentType != sbomContentType {
+			t.Errorf("got content type %q, want %q", a.ContentType, sbomContentType)
+		}
+		return a.Data
+	case sbomAttachmentName + ".gz":
+		zr, err := gzip.NewReader(bytes.NewReader(a.Data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return data
+	}
+	t.Fatalf("unexpected attachment %q", a.Name)
+	return nil
+}
+
+func TestSBOMVuln(t *testing.T) {
+	data := readSBOM(t)
+	tests := []struct {
+		name         string
+		maxSize      int
+		wantAttached bool
+		wantName     string
+	}{
+		{
+			name:         "Plain",
+			maxSize:      len(data),
+			wantAttached: true,
+			wantName:     sbomAttachmentName,
+		},
+		{
+			name:         "Gzipped",
+			maxSize:      len(data) - 1,
+			wantAttached: true,
+			wantName:     sbomAttachmentName + ".gz",
+		},
+		{
+			name:    "TooLarge",
+			maxSize: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldMax := sbomMaxSize
+			t.Cleanup(func() { sbomMaxSize = oldMax })
+			sbomMaxSize = tt.maxSize
+
+			v, err := sbomVuln("registry.example.com/app:1", data)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			wantDetails := "Packages: 3\nPackages by Type: apk: 2, npm: 1"
+			if !tt.wantAttached {
+				wantDetails += "\n\nThe SBOM has not been attached because it exceeds the size limit of 10 bytes."
+				if len(v.Attachments) > 0 {
+					t.Errorf("unexpected attachments: %v", v.Attachments)
+				}
+			}
+			if diff := cmp.Diff(wantDetails, v.Details); diff != "" {
+				t.Errorf("unexpected details (-want +got):\n%v", diff)
+			}
+			if !tt.wantAttached {
+				return
+			}
+			if v.Attachments[0].Name != tt.wantName {
+				t.Errorf("got attachment %q, want %q", v.Attachments[0].Name, tt.wantName)
+			}
+			bom, err := parseSBOM(attachedSBOM(t, v))
+			if err != nil {
+				t.Fatalf("the attached SBOM is not a CycloneDX document: %v", err)
+			}
+			if bom.SpecVersion != "1.5" || len(bom.Components) != 4 {
+				t.Errorf("unexpected SBOM: %+v", bom)
+			}
+		})
+	}
+}
+
+func TestParseSBOMInvalid(t *testing.T) {
+	for _, data := range []string{`not json`, `{"bomFormat":"SPDX"}`} {
+		if _, err := parseSBOM([]byte(data)); err == nil {
+			t.Errorf("no error parsing %q", data)
+		}
+	}
+}
+
+func TestRunGenerateSBOM(t *testing.T) {
+	argsFile, _ := setupFakeTrivy(t, imageReport)
+	t.Setenv("FAKE_SBOM", string(readSBOM(t)))
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	opts := `{"generate_sbom":true,"image_checks":{"vuln":true}}`
+	if err := run(context.Background(), "registry.example.com/app:1", "DockerImage", opts, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(args), "image --format cyclonedx") {
+		t.Errorf("trivy not executed to generate the sbom: %q", args)
+	}
+
+	var sbom *report.Vulnerability
+	for i, v := range rd.Vulnerabilities {
+		if v.Summary == "Software Bill of Materials" {
+			sbom = &rd.Vulnerabilities[i]
+		}
+	}
+	if sbom == nil {
+		t.Fatalf("no SBOM vulnerability in %+v", rd.Vulnerabilities)
+	}
+	if _, err := parseSBOM(attachedSBOM(t, *sbom)); err != nil {
+		t.Errorf("the attached SBOM is not a CycloneDX document: %v", err)
+	}
+	if len(rd.Vulnerabilities) != 2 {
+		t.Errorf("got %d vulnerabilities, want 2", len(rd.Vulnerabilities))
+	}
+}
+
+func TestRunGenerateSBOMError(t *testing.T) {
+	setupFakeTrivy(t, imageReport)
+	t.Setenv("FAKE_SBOM", "not json")
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	opts := `{"generate_sbom":true,"image_checks":{"vuln":true}}`
+	if err := run(context.Background(), "registry.example.com/app:1", "DockerImage", opts, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rd.Vulnerabilities) != 1 {
+		t.Errorf("got %d vulnerabilities, want 1", len(rd.Vulnerabilities))
+	}
+}
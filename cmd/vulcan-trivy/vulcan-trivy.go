@@ -9,11 +9,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -40,6 +42,7 @@ var (
 	checkName        = "vulcan-trivy"
 	logger           = check.NewCheckLog(checkName)
 	reportOutputFile = "report.json"
+	trivyCmd         = "trivy"
 	localTargets     = regexp.MustCompile(`https?://(localhost|host\.docker\.internal|172\.17\.0\.1)`)
 
 	FilePatterns = []string{
@@ -47,8 +50,28 @@ var (
 		`pip:/requirements/[^/]+\.txt`,    // All the .txt files in a requirements directory.
 		`pip:[^/]*requirements[^/]*\.txt`, // All the files .txt that contains requirements
 	}
+
+	// cloneGitRepository and isReachable are variables so they can be
+	// replaced in the tests.
+	cloneGitRepository = helpers.CloneGitRepository
+	isReachable        = helpers.IsReachable
 )
 
+// trivySeverities contains the severities used by trivy sorted from the lowest
+// to the highest.
+var trivySeverities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// severityIndex returns the position of the given severity in
+// trivySeverities or -1 if it is not a trivy severity.
+func severityIndex(severity string) int {
+	for i, s := range trivySeverities {
+		if s == strings.ToUpper(strings.TrimSpace(severity)) {
+			return i
+		}
+	}
+	return -1
+}
+
 type checks struct {
 	Vuln   bool `json:"vuln"`
 	Secret bool `json:"secret"`
@@ -65,6 +88,33 @@ type options struct {
 	GitChecks     checks `json:"git_checks"`
 	ImageChecks   checks `json:"image_checks"`
 
+	// MinSeverity reports only the vulnerabilities with the given severity
+	// or higher. It can not be used together with Severities.
+	MinSeverity string `json:"min_severity"`
+
+	// ScanSecrets enables the secret scanner for both Docker images and Git
+	// repositories and reports all the secrets found in a single "Exposed
+	// Secrets" vulnerability.
+	ScanSecrets bool `json:"scan_secrets"`
+
+	// RegistryUsername and RegistryPassword, or RegistryToken, are the
+	// credentials used to pull the Docker images. They take precedence
+	// over the credentials of the REGISTRY_* env vars and ECR.
+	RegistryUsername string `json:"registry_username"`
+	RegistryPassword string `json:"registry_password"`
+	RegistryToken    string `json:"registry_token"`
+
+	// RateLimitRetries is the number of times trivy is executed again when
+	// the registry rate limits the requests. It defaults to 3.
+	RateLimitRetries *int `json:"rate_limit_retries"`
+	// RateLimitMaxDelay is the maximum number of seconds to wait between
+	// retries. It defaults to 120.
+	RateLimitMaxDelay int `json:"rate_limit_max_delay"`
+
+	// GenerateSBOM attaches the CycloneDX SBOM of the Docker images to the
+	// report.
+	GenerateSBOM bool `json:"generate_sbom"`
+
 	DisableCustomSecretConfig bool `json:"disable_custom_secret_config"`
 
 	// ScanImageMetadata enables scanning for secrets in the container image
@@ -73,27 +123,64 @@ type options struct {
 	ScanImageMetadata bool `json:"scan_image_metadata"`
 }
 
+// severityFlag validates the severity options and returns the value of the
+// trivy '--severity' flag, e.g.: "HIGH,CRITICAL" for a min_severity of HIGH.
+func (o options) severityFlag() (string, error) {
+	if o.MinSeverity != "" && o.Severities != "" {
+		return "", errors.New("the severities and min_severity options can not be used together")
+	}
+	if o.MinSeverity != "" {
+		i := severityIndex(o.MinSeverity)
+		if i < 0 {
+			return "", fmt.Errorf("invalid min_severity %q: must be one of %s", o.MinSeverity, strings.Join(trivySeverities, ", "))
+		}
+		return strings.Join(trivySeverities[i:], ","), nil
+	}
+	if o.Severities == "" {
+		return "", nil
+	}
+	var severities []string
+	for _, s := range strings.Split(o.Severities, ",") {
+		if severityIndex(s) < 0 {
+			return "", fmt.Errorf("invalid severity %q in severities: must be one of %s", s, strings.Join(trivySeverities, ", "))
+		}
+		severities = append(severities, strings.ToUpper(strings.TrimSpace(s)))
+	}
+	return strings.Join(severities, ","), nil
+}
+
 // TODO: Replace with "github.com/aquasecurity/trivy/pkg/types"
 type results struct {
+	Metadata struct {
+		OS osMetadata `json:"OS"`
+	} `json:"Metadata"`
 	Results scanResponse `json:"Results"`
 }
 
+// osMetadata is the operating system detected by trivy in an image.
+type osMetadata struct {
+	Family string `json:"Family"`
+	Name   string `json:"Name"`
+}
+
 type scanResponse []struct {
 	Target          string `json:"Target"`
 	Class           string `json:"Class"`
 	Type            string `json:"Type"`
 	Vulnerabilities []struct {
-		VulnerabilityID  string   `json:"VulnerabilityID"`
-		PkgName          string   `json:"PkgName"`
-		PkgPath          string   `json:"PkgPath"`
-		InstalledVersion string   `json:"InstalledVersion"`
-		FixedVersion     string   `json:"FixedVersion"`
-		Title            string   `json:"Title,omitempty"`
-		Description      string   `json:"Description,omitempty"`
-		Severity         string   `json:"Severity"`
-		References       []string `json:"References,omitempty"`
-		PrimaryURL       string   `json:"PrimaryURL,omitempty"`
-		CweIDs           []string `json:"CweIDs,omitempty"`
+		VulnerabilityID  string          `json:"VulnerabilityID"`
+		PkgName          string          `json:"PkgName"`
+		PkgPath          string          `json:"PkgPath"`
+		InstalledVersion string          `json:"InstalledVersion"`
+		FixedVersion     string          `json:"FixedVersion"`
+		Title            string          `json:"Title,omitempty"`
+		Description      string          `json:"Description,omitempty"`
+		Severity         string          `json:"Severity"`
+		SeveritySource   string          `json:"SeveritySource,omitempty"`
+		CVSS             map[string]cvss `json:"CVSS,omitempty"`
+		References       []string        `json:"References,omitempty"`
+		PrimaryURL       string          `json:"PrimaryURL,omitempty"`
+		CweIDs           []string        `json:"CweIDs,omitempty"`
 	} `json:"Vulnerabilities"`
 	Misconfigurations []struct {
 		ID            string   `json:"ID"`
@@ -123,6 +210,7 @@ type scanResponse []struct {
 		Title     string `json:"Title"`
 		StartLine int    `json:"StartLine"`
 		EndLine   int    `json:"EndLine"`
+		Match     string `json:"Match"`
 		Code      struct {
 			Lines []struct {
 				Number  int    `json:"Number"`
@@ -136,6 +224,13 @@ type scanResponse []struct {
 	} `json:"Secrets"`
 }
 
+// cvss contains the CVSS v3 data reported by a vulnerability source, e.g.:
+// nvd, redhat or ghsa.
+type cvss struct {
+	V3Vector string  `json:"V3Vector,omitempty"`
+	V3Score  float32 `json:"V3Score,omitempty"`
+}
+
 type vulnKey struct {
 	name    string
 	version string
@@ -149,6 +244,8 @@ type vulnData struct {
 
 type outdatedPackage struct {
 	severity string
+	score    float32
+	cvss     cvss
 	fixedBy  string
 	cve      string
 	link     string
@@ -184,6 +281,15 @@ func run(ctx context.Context, target, assetType, optJSON string, state checkstat
 			return err
 		}
 	}
+	severities, err := opt.severityFlag()
+	if err != nil {
+		return err
+	}
+	opt.Severities = severities
+	if opt.ScanSecrets {
+		opt.ImageChecks.Secret = true
+		opt.GitChecks.Secret = true
+	}
 
 	trivyArgs := []string{}
 
@@ -206,186 +312,238 @@ func run(ctx context.Context, target, assetType, optJSON string, state checkstat
 		trivyArgs = append(trivyArgs, []string{"--file-patterns", fmt.Sprintf(`"%s"`, p)}...)
 	}
 
-	if strings.Contains(assetType, "DockerImage") {
-		sc := checksToParam(opt.ImageChecks)
-		if sc == "" {
-			logger.Warnf("No checks enabled for DockerImage, falling to scan only vuln")
-			sc = "vuln"
+	switch {
+	case strings.Contains(assetType, "DockerImage"):
+		retrier, err := opt.rateLimitRetrier()
+		if err != nil {
+			return err
 		}
-		trivyArgs = append(trivyArgs, []string{"--scanners", sc}...)
+		return scanDockerImage(ctx, target, assetType, opt, trivyArgs, retrier, state)
+	case assetType == "GitRepository":
+		return scanGitRepository(target, opt, trivyArgs, state)
+	}
 
-		if opt.ImageChecks.Secret {
-			if !opt.DisableCustomSecretConfig {
-				trivyArgs = append(trivyArgs, []string{"--secret-config", "secret.yaml"}...)
-			}
-			if opt.ScanImageMetadata {
-				trivyArgs = append(trivyArgs, []string{"--image-config-scanners", "secret"}...)
-			}
-		}
+	return fmt.Errorf("unknown assetType %s", assetType)
+}
 
-		// Load required env vars for docker registry authentication.
-		registryEnvDomain := os.Getenv("REGISTRY_DOMAIN")
-		registryEnvUsername := os.Getenv("REGISTRY_USERNAME")
-		registryEnvPassword := os.Getenv("REGISTRY_PASSWORD")
+// scanDockerImage scans the target Docker image with "trivy image".
+func scanDockerImage(ctx context.Context, target, assetType string, opt options, trivyArgs []string, retrier *rateLimitRetrier, state checkstate.State) error {
+	sc := checksToParam(opt.ImageChecks)
+	if sc == "" {
+		logger.Warnf("No checks enabled for DockerImage, falling to scan only vuln")
+		sc = "vuln"
+	}
+	trivyArgs = append(trivyArgs, []string{"--scanners", sc}...)
 
-		slashSplit := strings.SplitAfterN(target, "/", 2)
-		if len(slashSplit) <= 1 {
-			logger.Warnf("%s does not have a path", target)
+	if opt.ImageChecks.Secret {
+		if !opt.DisableCustomSecretConfig {
+			trivyArgs = append(trivyArgs, []string{"--secret-config", "secret.yaml"}...)
 		}
-		targetSplit := strings.Split(slashSplit[len(slashSplit)-1], ":")
-		if len(targetSplit) != 2 {
-			logger.Warnf("%s does not have a tag", target)
+		if opt.ScanImageMetadata {
+			trivyArgs = append(trivyArgs, []string{"--image-config-scanners", "secret"}...)
 		}
+	}
 
-		registryDomain := strings.Trim(slashSplit[0], "/")
-		// If docker registry equals registryDomain, export trivy credential env vars.
-		if registryDomain == registryEnvDomain {
-			os.Setenv("TRIVY_AUTH_URL", registryEnvDomain)
-			os.Setenv("TRIVY_USERNAME", registryEnvUsername)
-			os.Setenv("TRIVY_PASSWORD", registryEnvPassword)
-		}
+	slashSplit := strings.SplitAfterN(target, "/", 2)
+	if len(slashSplit) <= 1 {
+		logger.Warnf("%s does not have a path", target)
+	}
+	targetSplit := strings.Split(slashSplit[len(slashSplit)-1], ":")
+	if len(targetSplit) != 2 {
+		logger.Warnf("%s does not have a tag", target)
+	}
 
-		isReachable, err := helpers.IsReachable(target, assetType,
-			helpers.NewDockerCreds(os.Getenv("TRIVY_USERNAME"), os.Getenv("TRIVY_PASSWORD")))
-		if err != nil {
-			logger.Warnf("Can not check asset reachability: %v", err)
-		}
-		if !isReachable {
-			return checkstate.ErrAssetUnreachable
-		}
+	creds, err := imageRegistryCredentials(ctx, target, opt)
+	if err != nil {
+		return fmt.Errorf("can not get the registry credentials: %w", err)
+	}
+	if creds.Source != "" {
+		logger.Infof("using registry credentials from %s", creds.Source)
+	}
 
-		results, err := execTrivy(opt, "image", append(trivyArgs, target))
-		if err != nil {
-			return err
-		}
+	reachable, err := isReachable(target, assetType,
+		helpers.NewDockerCreds(creds.Username, creds.password()))
+	if err != nil {
+		logger.Warnf("Can not check asset reachability: %v", err)
+	}
+	if !reachable {
+		return checkstate.ErrAssetUnreachable
+	}
 
-		vuln := report.Vulnerability{
-			// Issue attributes.
-			Summary:     "Outdated Packages in Docker Image",
-			Description: "Vulnerabilities have been found in outdated packages installed in the Docker image.",
-			Recommendations: []string{
-				"Update affected packages to the versions specified in the resources table or newer.",
-			},
-			Details: strings.Join([]string{
-				"Run the following command to obtain the full report in your computer.",
-				"If using a public docker registry:",
-				fmt.Sprintf(`docker run -it --rm aquasec/trivy image %s`, target),
-				"\n",
-				"If using a private docker registry:",
-				fmt.Sprintf(`docker run -it --rm \
-				-e TRIVY_AUTH_URL=https://%s \
-				-e TRIVY_USERNAME=$REGISTRY_USERNAME \
-				-e TRIVY_PASSWORD=$REGISTRY_PASSWORD \
-				aquasec/trivy image %s`, registryEnvDomain, target),
-			}, "\n"),
-			CWEID:  937,
-			Labels: []string{"potential", "docker"},
-			// Finding attributes.
-		}
-		if err = processVulns(results.Results, vuln, "", state); err != nil {
-			logger.Errorf("processing image vuln results: %+v", err)
-		}
+	var res *results
+	err = retrier.do(ctx, state, func() error {
+		var err error
+		res, err = execTrivy(opt, "image", append(trivyArgs, target), creds.env())
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-		vuln = report.Vulnerability{
-			Summary:       "Secret Leaked in DockerImage",
-			Description:   "A secret has been found stored in the DockerImage. This secret could be retrieved by anyone with access to the image. Test data and false positives can be marked as such.",
-			CWEID:         540,
-			Score:         8.9,
-			ImpactDetails: "Anyone with access to the image could retrieve the leaked secret and use it in the future with malicious intent.",
-			Labels:        []string{"issue"},
-			Recommendations: []string{
-				"Completely remove the secrets from the repository as explained in the references.",
-				"Encrypt the secrets using a tool like AWS Secrets Manager or Vault.",
-			},
-			References: []string{
-				"https://help.github.com/en/articles/removing-sensitive-data-from-a-repository",
-			},
-		}
-		if err := processSecrets(results.Results, vuln, target, "", state); err != nil {
-			logger.Errorf("processing image secret results: %+v", err)
-		}
+	vuln := report.Vulnerability{
+		// Issue attributes.
+		Summary:     "Outdated Packages in Docker Image",
+		Description: "Vulnerabilities have been found in outdated packages installed in the Docker image.",
+		Recommendations: []string{
+			"Update affected packages to the versions specified in the resources table or newer.",
+		},
+		Details: strings.Join([]string{
+			"Run the following command to obtain the full report in your computer.",
+			"If using a public docker registry:",
+			fmt.Sprintf(`docker run -it --rm aquasec/trivy image %s`, target),
+			"\n",
+			"If using a private docker registry:",
+			fmt.Sprintf(`docker run -it --rm \
+			-e TRIVY_AUTH_URL=https://%s \
+			-e TRIVY_USERNAME=$REGISTRY_USERNAME \
+			-e TRIVY_PASSWORD=$REGISTRY_PASSWORD \
+			aquasec/trivy image %s`, registryDomain(target), target),
+		}, "\n"),
+		CWEID:  937,
+		Labels: []string{"potential", "docker"},
+		// Finding attributes.
+	}
+	vuln.Details += filterDetails(opt)
+	if err = processVulns(res.Results, vuln, target, "", state); err != nil {
+		logger.Errorf("processing image vuln results: %+v", err)
+	}
 
-		return processMisconfigs(results.Results, target, "", state)
+	vuln = report.Vulnerability{
+		Summary:       "Secret Leaked in DockerImage",
+		Description:   "A secret has been found stored in the DockerImage. This secret could be retrieved by anyone with access to the image. Test data and false positives can be marked as such.",
+		CWEID:         540,
+		Score:         8.9,
+		ImpactDetails: "Anyone with access to the image could retrieve the leaked secret and use it in the future with malicious intent.",
+		Labels:        []string{"issue"},
+		Recommendations: []string{
+			"Completely remove the secrets from the repository as explained in the references.",
+			"Encrypt the secrets using a tool like AWS Secrets Manager or Vault.",
+		},
+		References: []string{
+			"https://help.github.com/en/articles/removing-sensitive-data-from-a-repository",
+		},
+	}
+	if opt.ScanSecrets {
+		err = processExposedSecrets(res.Results, target, "", state)
+	} else {
+		err = processSecrets(res.Results, vuln, target, "", state)
+	}
+	if err != nil {
+		logger.Errorf("processing image secret results: %+v", err)
 	}
 
-	if assetType == "GitRepository" {
-		sc := checksToParam(opt.GitChecks)
-		if sc == "" {
-			logger.Warnf("No checks enabled for GitRepository")
-			return nil
+	if opt.GenerateSBOM {
+		// A failure generating the SBOM does not invalidate the results of
+		// the scan.
+		var data []byte
+		err := retrier.do(ctx, state, func() error {
+			var err error
+			data, err = execTrivySBOM(target, creds.env())
+			return err
+		})
+		if err != nil {
+			logger.Errorf("generating sbom: %+v", err)
+		} else if v, err := sbomVuln(target, data); err != nil {
+			logger.Errorf("processing sbom: %+v", err)
+		} else {
+			state.AddVulnerabilities(v)
 		}
-		trivyArgs = append(trivyArgs, []string{"--scanners", sc}...)
+	}
 
-		// Define custom secret config when scanning secrets.
-		if opt.GitChecks.Secret && !opt.DisableCustomSecretConfig {
-			trivyArgs = append(trivyArgs, []string{"--secret-config", "secret.yaml"}...)
-		}
+	processEOL(res.Metadata.OS, state)
 
-		if opt.Depth == 0 {
-			opt.Depth = DefaultDepth
-		}
-		repoPath, branchName, err := helpers.CloneGitRepository(target, opt.Branch, opt.Depth)
-		if err != nil {
-			logger.Errorf("unable to clone repo: %+v", err)
-			return checkstate.ErrAssetUnreachable
-		}
+	return processMisconfigs(res.Results, target, "", state)
+}
 
-		results, err := execTrivy(opt, "fs", append(trivyArgs, repoPath))
-		if err != nil {
-			logger.Errorf("Can not execute trivy: %+v", err)
-		} else {
-			vuln := report.Vulnerability{
-				// Issue attributes.
-				Summary:     "Outdated Packages in Git repository",
-				Description: "Vulnerabilities have been found in outdated packages referenced in Git repository.",
-				Recommendations: []string{
-					"Update affected packages to the versions specified in the resources table or newer.",
-				},
-				Details: strings.Join([]string{
-					"Run the following command to obtain the full report in your computer.",
-					"If using a public git repository:",
-					fmt.Sprintf("\tdocker run -it --rm aquasec/trivy repository %s", target),
-					"If using a private repository clone first:",
-					fmt.Sprintf("\tgit clone %s repo", target),
-					"\tdocker run -it -v $PWD/repo:/repo --rm aquasec/trivy fs /repo",
-				}, "\n"),
-				CWEID:  937,
-				Labels: []string{"potential", "git"},
-				// Finding attributes.
-			}
-			if err := processVulns(results.Results, vuln, branchName, state); err != nil {
-				logger.Errorf("processing fs results: %+v", err)
-			}
+// scanGitRepository clones the target Git repository and scans it with
+// "trivy fs". The repository is cloned with the credentials configured for
+// the check, i.e.: GITHUB_ENTERPRISE_ENDPOINT and GITHUB_ENTERPRISE_TOKEN, so
+// private repositories can be scanned.
+func scanGitRepository(target string, opt options, trivyArgs []string, state checkstate.State) error {
+	sc := checksToParam(opt.GitChecks)
+	if sc == "" {
+		logger.Warnf("No checks enabled for GitRepository")
+		return nil
+	}
+	if opt.GenerateSBOM {
+		logger.Warnf("generate_sbom is only supported for DockerImage assets")
+	}
+	trivyArgs = append(trivyArgs, []string{"--scanners", sc}...)
 
-			vuln = report.Vulnerability{
-				Summary:       "Secret Leaked In Git Repository",
-				Description:   "A secret has been found stored in the Git repository. This secret may be in any historical commit and could be retrieved by anyone with read access to the repository. Test data and false positives can be marked as such.",
-				CWEID:         540,
-				Score:         8.9,
-				ImpactDetails: "Anyone with access to the repository could retrieve the leaked secret and use it in the future with malicious intent.",
-				Labels:        []string{"issue", "secret"},
-				Recommendations: []string{
-					"Completely remove the secrets from the repository as explained in the references.",
-					"It is recommended to utilize a tool such as AWS Secrets Manager or Vault, or follow the guidance provided by your CI/CD provider, to securely store confidential information.",
-				},
-				References: []string{
-					"https://help.github.com/en/articles/removing-sensitive-data-from-a-repository",
-				},
-			}
-			if err := processSecrets(results.Results, vuln, target, branchName, state); err != nil {
-				logger.Errorf("processing fs results: %+v", err)
-			}
-		}
+	// Define custom secret config when scanning secrets.
+	if opt.GitChecks.Secret && !opt.DisableCustomSecretConfig {
+		trivyArgs = append(trivyArgs, []string{"--secret-config", "secret.yaml"}...)
+	}
 
-		return processMisconfigs(results.Results, target, branchName, state)
+	if opt.Depth == 0 {
+		opt.Depth = DefaultDepth
+	}
+	repoPath, branchName, err := cloneGitRepository(target, opt.Branch, opt.Depth)
+	if err != nil {
+		logger.Errorf("unable to clone repo: %+v", err)
+		return checkstate.ErrAssetUnreachable
 	}
 
-	return fmt.Errorf("unknown assetType %s", assetType)
+	results, err := execTrivy(opt, "fs", append(trivyArgs, repoPath), nil)
+	if err != nil {
+		return err
+	}
+
+	vuln := report.Vulnerability{
+		// Issue attributes.
+		Summary:     "Outdated Packages in Git repository",
+		Description: "Vulnerabilities have been found in outdated packages referenced in Git repository.",
+		Recommendations: []string{
+			"Update affected packages to the versions specified in the resources table or newer.",
+		},
+		Details: strings.Join([]string{
+			"Run the following command to obtain the full report in your computer.",
+			"If using a public git repository:",
+			fmt.Sprintf("\tdocker run -it --rm aquasec/trivy repository %s", target),
+			"If using a private repository clone first:",
+			fmt.Sprintf("\tgit clone %s repo", target),
+			"\tdocker run -it -v $PWD/repo:/repo --rm aquasec/trivy fs /repo",
+		}, "\n"),
+		CWEID:  937,
+		Labels: []string{"potential", "git"},
+		// Finding attributes.
+	}
+	vuln.Details += filterDetails(opt)
+	if err := processVulns(results.Results, vuln, target, branchName, state); err != nil {
+		logger.Errorf("processing fs results: %+v", err)
+	}
+
+	vuln = report.Vulnerability{
+		Summary:       "Secret Leaked In Git Repository",
+		Description:   "A secret has been found stored in the Git repository. This secret may be in any historical commit and could be retrieved by anyone with read access to the repository. Test data and false positives can be marked as such.",
+		CWEID:         540,
+		Score:         8.9,
+		ImpactDetails: "Anyone with access to the repository could retrieve the leaked secret and use it in the future with malicious intent.",
+		Labels:        []string{"issue", "secret"},
+		Recommendations: []string{
+			"Completely remove the secrets from the repository as explained in the references.",
+			"It is recommended to utilize a tool such as AWS Secrets Manager or Vault, or follow the guidance provided by your CI/CD provider, to securely store confidential information.",
+		},
+		References: []string{
+			"https://help.github.com/en/articles/removing-sensitive-data-from-a-repository",
+		},
+	}
+	if opt.ScanSecrets {
+		err = processExposedSecrets(results.Results, target, branchName, state)
+	} else {
+		err = processSecrets(results.Results, vuln, target, branchName, state)
+	}
+	if err != nil {
+		logger.Errorf("processing fs results: %+v", err)
+	}
+
+	return processMisconfigs(results.Results, target, branchName, state)
 }
 
-func execTrivy(opt options, action string, actionArgs []string) (*results, error) {
+// execTrivy runs trivy with the given action and arguments. The given env
+// vars are added to the environment of trivy.
+func execTrivy(opt options, action string, actionArgs []string, env []string) (*results, error) {
 	// Build trivy command with arguments.
-	trivyCmd := "trivy"
 	trivyArgs := []string{
 		action,
 		"-f", "json",
@@ -405,6 +563,7 @@ func execTrivy(opt options, action string, actionArgs []string) (*results, error
 	logger.Infof("running command: %s %s\n", trivyCmd, trivyArgs)
 
 	cmd := exec.Command(trivyCmd, trivyArgs...)
+	cmd.Env = append(os.Environ(), env...)
 	cmdOutput, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("trivy command execution failed: %w. Command output: %s", err, string(cmdOutput))
@@ -535,7 +694,48 @@ func computeAffectedResource(target, branch string, file string, l int) string {
 	return s + fmt.Sprintf("#L%d", l)
 }
 
-func processVulns(results scanResponse, vuln report.Vulnerability, branch string, state checkstate.State) error {
+// filterDetails returns the description of the severity and fix filters
+// applied to the scan, to be appended to the details of the outdated packages
+// vulnerabilities.
+func filterDetails(opt options) string {
+	var lines []string
+	if opt.Severities != "" {
+		lines = append(lines, fmt.Sprintf("Only vulnerabilities with severity %s are reported.", opt.Severities))
+	}
+	if opt.IgnoreUnfixed {
+		lines = append(lines, "Vulnerabilities without a fixed version are not reported.")
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\n" + strings.Join(lines, "\n")
+}
+
+// severityCounts returns the number of vulnerabilities of each severity found
+// in a package, e.g.: "Vulnerabilities Found: 3 (CRITICAL: 1, HIGH: 2)".
+func severityCounts(packages []outdatedPackage) string {
+	counts := map[string]int{}
+	for _, p := range packages {
+		i := severityIndex(p.severity)
+		if i < 0 {
+			i = 0
+		}
+		counts[trivySeverities[i]]++
+	}
+	var parts []string
+	for i := len(trivySeverities) - 1; i >= 0; i-- {
+		if n := counts[trivySeverities[i]]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", trivySeverities[i], n))
+		}
+	}
+	return fmt.Sprintf("Vulnerabilities Found: %d (%s)", len(packages), strings.Join(parts, ", "))
+}
+
+// processVulns reports a vulnerability for each outdated package found in the
+// results. When scanning a Git repository, the location of the package links
+// to the file of the repository where the package is referenced.
+func processVulns(results scanResponse, vuln report.Vulnerability, target, branch string, state checkstate.State) error {
+	details := vuln.Details
 	outdatedPackageVulns := make(map[vulnKey]*vulnData)
 	for _, tt := range results {
 		for _, tv := range tt.Vulnerabilities {
@@ -556,8 +756,16 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 				path:    path,
 			}
 
+			score := getScore(tv.Severity)
+			c, ok := cvssSource(tv.CVSS, tv.SeveritySource)
+			if ok {
+				score = c.V3Score
+			}
+
 			pkg := outdatedPackage{
 				severity: tv.Severity,
+				score:    score,
+				cvss:     c,
 				fixedBy:  tv.FixedVersion,
 				cve:      tv.VulnerabilityID,
 				link:     tv.PrimaryURL,
@@ -585,6 +793,7 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 			"Fixed Version",
 			"Vulnerabilities",
 			"Severity",
+			"CVSS Score",
 			"CWEs",
 			"Title",
 		},
@@ -593,12 +802,12 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 	for key, det := range outdatedPackageVulns {
 		l := det.packages
 
-		// Sort CVEs by severity desc, cve desc
+		// Sort CVEs by score desc, cve desc
 		sort.Slice(l, func(i, j int) bool {
-			if l[i].severity == l[j].severity {
+			if l[i].score == l[j].score {
 				return cve2num(l[i].cve) > cve2num(l[j].cve)
 			}
-			return getScore(l[i].severity) > getScore(l[j].severity)
+			return l[i].score > l[j].score
 		})
 
 		vp.Rows = []map[string]string{}
@@ -612,9 +821,8 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 				continue
 			}
 
-			newScore := getScore(p.severity)
-			if newScore > maxScore {
-				maxScore = newScore
+			if p.score > maxScore {
+				maxScore = p.score
 			}
 			row := map[string]string{}
 			row["Fixed Version"] = p.fixedBy
@@ -631,6 +839,12 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 				row["Vulnerabilities"] = fmt.Sprintf("[%s](%s)", p.cve, p.link)
 			}
 			row["Severity"] = p.severity
+			if p.cvss.V3Score > 0 {
+				row["CVSS Score"] = fmt.Sprintf("%.1f", p.cvss.V3Score)
+				if p.cvss.V3Vector != "" {
+					row["CVSS Score"] += fmt.Sprintf(" (%s)", p.cvss.V3Vector)
+				}
+			}
 			row["Title"] = p.title
 			vp.Rows = append(vp.Rows, row)
 		}
@@ -639,8 +853,14 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 		sort.Strings(fingerprint)
 
 		vuln.AffectedResource = strings.TrimSpace(fmt.Sprintf("%s:%s", key.name, key.version))
+		location := key.path
+		if branch != "" {
+			location = computeAffectedResource(target, branch, key.path, 0)
+			vuln.AffectedResourceString = location
+		}
 		vuln.Fingerprint = helpers.ComputeFingerprint(key.path, fingerprint)
 		vuln.Score = maxScore
+		vuln.Details = details + "\n\n" + severityCounts(l)
 		vuln.Resources = []report.ResourcesGroup{
 			{
 				Name: "Location",
@@ -651,7 +871,7 @@ func processVulns(results scanResponse, vuln report.Vulnerability, branch string
 				},
 				Rows: []map[string]string{{
 					"Package":                  key.name,
-					"Location":                 key.path,
+					"Location":                 location,
 					"Min. Recommended Version": det.fixedBy,
 				}},
 			},
@@ -710,6 +930,101 @@ func processSecrets(results scanResponse, vuln report.Vulnerability, target, bra
 	return nil
 }
 
+// cvssSource returns the CVSS v3 data to score a vulnerability with. NVD is
+// preferred, falling back to the source of the severity reported by trivy,
+// usually the vendor, and then to the rest of the sources in alphabetical
+// order. The sources without a CVSS v3 score are ignored.
+func cvssSource(scores map[string]cvss, severitySource string) (cvss, bool) {
+	sources := []string{"nvd", severitySource}
+	var others []string
+	for s := range scores {
+		others = append(others, s)
+	}
+	sort.Strings(others)
+	sources = append(sources, others...)
+	for _, s := range sources {
+		if c, ok := scores[s]; ok && c.V3Score > 0 {
+			return c, true
+		}
+	}
+	return cvss{}, false
+}
+
+// maskedSecret matches the secrets already masked by trivy.
+var maskedSecret = regexp.MustCompile(`\*{4,}`)
+
+// redactMatch returns the match of a secret that can be included in a report.
+// Trivy masks the secret in the match with asterisks, but when it is not
+// masked everything except the first characters is replaced.
+func redactMatch(match string) string {
+	const (
+		visible = 4
+		maxLen  = 80
+	)
+	match = strings.TrimSpace(match)
+	if !maskedSecret.MatchString(match) {
+		r := []rune(match)
+		if len(r) > visible {
+			match = string(r[:visible]) + strings.Repeat("*", len(r)-visible)
+		}
+	}
+	if r := []rune(match); len(r) > maxLen {
+		match = string(r[:maxLen]) + "..."
+	}
+	return match
+}
+
+// processExposedSecrets reports all the secrets found in a single "Exposed
+// Secrets" vulnerability. The fingerprint only depends on the rules and the
+// files where the secrets were found, so the vulnerability is not reopened
+// when a leaked secret is rotated, and it is fixed once the secrets are
+// removed.
+func processExposedSecrets(results scanResponse, target, branch string, state checkstate.State) error {
+	rg := report.ResourcesGroup{
+		Name:   "Exposed Secrets",
+		Header: []string{"Rule ID", "Path", "Line", "Match"},
+	}
+	var locations []string
+	for _, tt := range results {
+		for _, ts := range tt.Secrets {
+			path := computeAffectedResource(target, branch, tt.Target, 0)
+			locations = append(locations, ts.RuleID+"|"+path)
+			rg.Rows = append(rg.Rows, map[string]string{
+				"Rule ID": ts.RuleID,
+				"Path":    path,
+				"Line":    fmt.Sprint(ts.StartLine),
+				"Match":   redactMatch(ts.Match),
+			})
+		}
+	}
+	if len(rg.Rows) == 0 {
+		return nil
+	}
+	sort.Strings(locations)
+
+	state.AddVulnerabilities(report.Vulnerability{
+		Summary:          "Exposed Secrets",
+		Description:      "Secrets have been found stored in the asset. They could be retrieved by anyone with access to it. Test data and false positives can be marked as such.",
+		Details:          fmt.Sprintf("Secrets Found: %d", len(rg.Rows)),
+		CWEID:            540,
+		Score:            report.SeverityThresholdHigh,
+		ImpactDetails:    "Anyone with access to the asset could retrieve the exposed secrets and use them with malicious intent.",
+		Labels:           []string{"issue", "secret"},
+		AffectedResource: target,
+		Fingerprint:      helpers.ComputeFingerprint(slices.Compact(locations)),
+		Recommendations: []string{
+			"Revoke and rotate the exposed secrets.",
+			"Completely remove the secrets from the asset as explained in the references.",
+			"Store the secrets using a tool like AWS Secrets Manager or Vault.",
+		},
+		References: []string{
+			"https://help.github.com/en/articles/removing-sensitive-data-from-a-repository",
+		},
+		Resources: []report.ResourcesGroup{rg},
+	})
+	return nil
+}
+
 func getScore(severity string) float32 {
 	if severity == "CRITICAL" {
 		return report.SeverityThresholdCritical
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+const (
+	authNone   = ""
+	authHTTP   = "http"
+	authHeader = "header"
+	authForm   = "form"
+
+	// Name of the ZAP replacer rule used to inject the authentication
+	// header.
+	authHeaderRule = "vulcan-auth-header"
+
+	// Default names of the fields of the login form.
+	defUsernameField = "username"
+	defPasswordField = "password"
+
+	// Maximum number of bytes of the response read to look for the logged
+	// in indicator.
+	maxIndicatorBodySize = 5 * 1024 * 1024
+
+	redactedSecret = "[REDACTED]"
+)
+
+// errAuthFailed is returned when the logged in indicator is not found in the
+// response of the target once the authentication has been configured.
+var errAuthFailed = errors.New("authentication failed: the logged in indicator was not found in the response of the target")
+
+// authMethod returns the authentication method defined by the options. It
+// returns an error if the options of more than one method are specified or
+// if the options required by the method are missing.
+func (opt options) authMethod() (string, error) {
+	header := opt.AuthHeader != "" || opt.AuthHeaderValue != ""
+	form := opt.LoginURL != ""
+	switch {
+	case header && form:
+		return "", errors.New("auth_header and login_url options are mutually exclusive")
+	case header:
+		if opt.AuthHeader == "" || opt.AuthHeaderValue == "" {
+			return "", errors.New("auth_header and auth_header_value options must be specified together")
+		}
+		return authHeader, nil
+	case form:
+		if opt.Username == "" || opt.Password == "" {
+			return "", errors.New("username and password options are required by the form based authentication")
+		}
+		if opt.LoggedInIndicator == "" {
+			return "", errors.New("logged_in_indicator option is required by the form based authentication")
+		}
+		return authForm, nil
+	case opt.Username != "":
+		return authHTTP, nil
+	}
+	return authNone, nil
+}
+
+// loggedInIndicator returns the compiled logged in indicator, or nil if it
+// is not specified.
+func (opt options) loggedInIndicator() (*regexp.Regexp, error) {
+	if opt.LoggedInIndicator == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(opt.LoggedInIndicator)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logged_in_indicator: %w", err)
+	}
+	return re, nil
+}
+
+// secrets returns the values of the options that must not appear in the
+// logs or in the report.
+func (opt options) secrets() []string {
+	var secrets []string
+	for _, s := range []string{opt.Password, opt.AuthHeaderValue, opt.ZAPAPIKey} {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets
+}
+
+// setupFormAuth configures the form based authentication in the given ZAP
+// context and forces ZAP to send every request as the authenticated user.
+func setupFormAuth(opt options, contextID string) error {
+	usernameField := opt.UsernameField
+	if usernameField == "" {
+		usernameField = defUsernameField
+	}
+	passwordField := opt.PasswordField
+	if passwordField == "" {
+		passwordField = defPasswordField
+	}
+	loginData := fmt.Sprintf("%s={%%username%%}&%s={%%password%%}", url.QueryEscape(usernameField), url.QueryEscape(passwordField))
+	params := url.Values{
+		"loginUrl":         {opt.LoginURL},
+		"loginRequestData": {loginData},
+	}
+	auth := client.Authentication()
+	if _, err := auth.SetAuthenticationMethod(contextID, "formBasedAuthentication", params.Encode()); err != nil {
+		return fmt.Errorf("error setting the authentication method: %w", err)
+	}
+	if _, err := auth.SetLoggedInIndicator(contextID, opt.LoggedInIndicator); err != nil {
+		return fmt.Errorf("error setting the logged in indicator: %w", err)
+	}
+
+	users := client.Users()
+	resp, err := users.NewUser(contextID, opt.Username)
+	if err != nil {
+		return fmt.Errorf("error creating the user: %w", err)
+	}
+	userID, err := getStringAttribute(resp, "userId")
+	if err != nil {
+		return err
+	}
+	creds := url.Values{
+		"username": {opt.Username},
+		"password": {opt.Password},
+	}
+	if _, err := users.SetAuthenticationCredentials(contextID, userID, creds.Encode()); err != nil {
+		return fmt.Errorf("error setting the user credentials: %w", err)
+	}
+	if _, err := users.SetUserEnabled(contextID, userID, "True"); err != nil {
+		return fmt.Errorf("error enabling the user: %w", err)
+	}
+
+	forced := client.ForcedUser()
+	if _, err := forced.SetForcedUser(contextID, userID); err != nil {
+		return fmt.Errorf("error setting the forced user: %w", err)
+	}
+	if _, err := forced.SetForcedUserModeEnabled(true); err != nil {
+		return fmt.Errorf("error enabling the forced user mode: %w", err)
+	}
+	if _, err := users.AuthenticateAsUser(contextID, userID); err != nil {
+		return fmt.Errorf("error authenticating as the user: %w", err)
+	}
+	return nil
+}
+
+// setupHeaderAuth adds a ZAP replacer rule that injects the authentication
+// header in every request.
+func setupHeaderAuth(opt options) error {
+	_, err := client.Replacer().AddRule(authHeaderRule, "true", "REQ_HEADER", "false", opt.AuthHeader, opt.AuthHeaderValue, "")
+	if err != nil {
+		return fmt.Errorf("error adding the authentication header rule: %w", err)
+	}
+	return nil
+}
+
+// checkLoggedIn requests the target through the given client and returns
+// errAuthFailed if the response does not match the logged in indicator.
+func checkLoggedIn(ctx context.Context, c *http.Client, target string, indicator *regexp.Regexp) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return fmt.Errorf("error creating the authentication check request: %w", err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting the target to check the authentication: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxIndicatorBodySize))
+	if err != nil {
+		return fmt.Errorf("error reading the authentication check response: %w", err)
+	}
+	// ZAP matches the logged in indicator against the headers and the body
+	// of the responses.
+	var headers strings.Builder
+	resp.Header.Write(&headers)
+	if !indicator.MatchString(headers.String()) && !indicator.Match(body) {
+		return errAuthFailed
+	}
+	return nil
+}
+
+// redact replaces the given secrets in s, both raw and URL encoded.
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redactedSecret)
+		s = strings.ReplaceAll(s, url.QueryEscape(secret), redactedSecret)
+		s = strings.ReplaceAll(s, url.PathEscape(secret), redactedSecret)
+	}
+	return s
+}
+
+// redactVulnerability removes the given secrets from the details and the
+// resources of the vulnerability, as the alerts may contain the requests
+// sent by ZAP, e.g. the login request.
+func redactVulnerability(v *report.Vulnerability, secrets []string) {
+	if len(secrets) == 0 {
+		return
+	}
+	v.Details = redact(v.Details, secrets)
+	for _, group := range v.Resources {
+		for _, row := range group.Rows {
+			for k, val := range row {
+				row[k] = redact(val, secrets)
+			}
+		}
+	}
+}
+
+// redactError returns an error with the given secrets redacted from the
+// message of err. The requests to the ZAP API contain the parameters in the
+// URL, which is included in the errors of the HTTP client.
+func redactError(err error, secrets []string) error {
+	if err == nil || len(secrets) == 0 {
+		return err
+	}
+	msg := redact(err.Error(), secrets)
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
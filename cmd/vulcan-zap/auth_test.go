@@ -0,0 +1,111 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	report "github.com/adevinta/vulcan-report"
+)
+
+func TestAuthMethod(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     options
+		want    string
+		wantErr bool
+	}{
+		{name: "None", want: authNone},
+		{name: "HTTP", opt: options{Username: "user", Password: "pass"}, want: authHTTP},
+		{name: "Header", opt: options{AuthHeader: "Authorization", AuthHeaderValue: "Bearer token"}, want: authHeader},
+		{name: "HeaderWithoutValue", opt: options{AuthHeader: "Authorization"}, wantErr: true},
+		{
+			name: "Form",
+			opt:  options{LoginURL: "https://example.com/login", Username: "user", Password: "pass", LoggedInIndicator: "Logout"},
+			want: authForm,
+		},
+		{name: "FormWithoutIndicator", opt: options{LoginURL: "https://example.com/login", Username: "user", Password: "pass"}, wantErr: true},
+		{name: "FormWithoutPassword", opt: options{LoginURL: "https://example.com/login", Username: "user", LoggedInIndicator: "Logout"}, wantErr: true},
+		{
+			name:    "HeaderAndForm",
+			opt:     options{AuthHeader: "Authorization", AuthHeaderValue: "Bearer token", LoginURL: "https://example.com/login"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opt.authMethod()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got method %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLoggedIn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.Write([]byte("<a href=/login>Login</a>"))
+			return
+		}
+		w.Write([]byte("<a href=/logout>Logout</a>"))
+	}))
+	defer srv.Close()
+
+	indicator := regexp.MustCompile(`Logout`)
+	authClient := &http.Client{Transport: headerTransport{"Authorization", "Bearer token"}}
+	if err := checkLoggedIn(context.Background(), authClient, srv.URL, indicator); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := checkLoggedIn(context.Background(), srv.Client(), srv.URL, indicator)
+	if !errors.Is(err, errAuthFailed) {
+		t.Errorf("got error %v, want %v", err, errAuthFailed)
+	}
+}
+
+// headerTransport adds a header to the requests.
+type headerTransport struct {
+	name, value string
+}
+
+func (h headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(h.name, h.value)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRedact(t *testing.T) {
+	secrets := options{Password: "p@ss word", AuthHeaderValue: "Bearer token"}.secrets()
+	v := report.Vulnerability{
+		Details: "Authorization: Bearer token",
+		Resources: []report.ResourcesGroup{{
+			Rows: []map[string]string{{
+				"URL":      "https://example.com/login?password=p%40ss+word",
+				"Evidence": "password=p@ss word",
+			}},
+		}},
+	}
+	redactVulnerability(&v, secrets)
+	got := v.Details + v.Resources[0].Rows[0]["URL"] + v.Resources[0].Rows[0]["Evidence"]
+	for _, s := range []string{"p@ss", "p%40ss", "token"} {
+		if strings.Contains(got, s) {
+			t.Errorf("secret %q not redacted: %s", s, got)
+		}
+	}
+
+	err := redactError(errors.New(`Get "http://zap/?password=p%40ss+word": EOF`), secrets)
+	if strings.Contains(err.Error(), "p%40ss") {
+		t.Errorf("secret not redacted from error: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+//go:build integration
+
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+)
+
+// recordedRequest is a request received by the test server.
+type recordedRequest struct {
+	method string
+	uri    string
+	body   int64
+}
+
+// TestPassiveModeSendsNoPayloads runs the check in passive mode against a
+// local server and verifies that only the pages of the site are requested.
+// It requires ZAP, which is looked for in ZAP_PATH, e.g.:
+//
+//	ZAP_PATH=/zap/zap.sh go test -tags integration -run PassiveMode .
+func TestPassiveModeSendsNoPayloads(t *testing.T) {
+	if path := os.Getenv("ZAP_PATH"); path != "" {
+		zapPath = path
+	}
+	if _, err := os.Stat(zapPath); err != nil {
+		t.Skipf("ZAP not found: %v", err)
+	}
+
+	pages := map[string]string{
+		"/":        `<html><body><a href="/about">About</a> <a href="/contact">Contact</a></body></html>`,
+		"/about":   `<html><body><a href="/">Home</a></body></html>`,
+		"/contact": `<html><body><a href="/">Home</a></body></html>`,
+	}
+	var (
+		mu       sync.Mutex
+		requests []recordedRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, recordedRequest{method: r.Method, uri: r.RequestURI, body: r.ContentLength})
+		mu.Unlock()
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	var rd report.ResultData
+	state := checkstate.State{
+		ProgressReporter: checkstate.ProgressReporterHandler(func(float32) {}),
+		ResultData:       &rd,
+	}
+	opts := `{"mode":"passive","depth":2}`
+	if err := run(context.Background(), srv.URL+"/", "WebAddress", opts, state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) == 0 {
+		t.Fatal("no requests received")
+	}
+	// Besides the pages of the site, the spider requests the files that
+	// list the URLs of the site.
+	allowed := map[string]bool{"/robots.txt": true, "/sitemap.xml": true}
+	for p := range pages {
+		allowed[p] = true
+	}
+	for _, req := range requests {
+		if req.method != http.MethodGet || req.body > 0 || !allowed[req.uri] {
+			t.Errorf("unexpected request in passive mode: %s %s (body %d bytes)", req.method, req.uri, req.body)
+		}
+	}
+	for _, v := range rd.Vulnerabilities {
+		if !hasLabel(v.Labels, modeLabel(modePassive)) {
+			t.Errorf("vulnerability %q has no passive mode label: %v", v.Summary, v.Labels)
+		}
+	}
+}
+
+func hasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -6,12 +6,15 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"os/exec"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -31,7 +34,24 @@ var (
 	client    zap.Interface
 )
 
-const contextName = "target"
+// contextName is the name of the ZAP context of the scan. A random name is
+// used with an external ZAP daemon.
+var contextName = "target"
+
+// activeScanPollInterval is the interval between the requests to get the
+// progress of the active scan.
+var activeScanPollInterval = 60 * time.Second
+
+// ajaxSpiderPollInterval is the interval between the requests to get the
+// status of the AJAX spider, and ajaxSpiderResultsDelay the time waited for
+// its results once it is stopped.
+var (
+	ajaxSpiderPollInterval = 10 * time.Second
+	ajaxSpiderResultsDelay = 5 * time.Second
+)
+
+// zapPath is the path of the ZAP launcher script.
+var zapPath = "/zap/zap.sh"
 
 type options struct {
 	Depth    int     `json:"depth"`
@@ -40,6 +60,29 @@ type options struct {
 	Username string  `json:"username"`
 	Password string  `json:"password"`
 	MinScore float32 `json:"min_score"`
+	// Mode selects the ZAP jobs run by the check: "passive", "active" or
+	// "full". It takes precedence over Active, which is equivalent to the
+	// "active" mode when true and to the "passive" mode otherwise.
+	Mode string `json:"mode"`
+	// ScanPolicy is the name of a scan policy installed in ZAP used by the
+	// active scan. RuleOverrides overrides the threshold and the strength
+	// of the active scan rules by rule ID.
+	ScanPolicy    string                  `json:"scan_policy"`
+	RuleOverrides map[string]ruleOverride `json:"rule_overrides"`
+	// AuthHeader and AuthHeaderValue define a header injected in every
+	// request sent by ZAP, e.g. "Authorization: Bearer <token>".
+	AuthHeader      string `json:"auth_header"`
+	AuthHeaderValue string `json:"auth_header_value"`
+	// LoginURL enables the form based authentication using Username and
+	// Password. UsernameField and PasswordField are the names of the
+	// fields of the login form, "username" and "password" by default.
+	LoginURL      string `json:"login_url"`
+	UsernameField string `json:"username_field"`
+	PasswordField string `json:"password_field"`
+	// LoggedInIndicator is a regular expression matching the responses of
+	// the target when the user is authenticated. It is used to verify the
+	// authentication before scanning.
+	LoggedInIndicator string `json:"logged_in_indicator"`
 	// List of active/passive scanners to disable by their identifiers:
 	// https://www.zaproxy.org/docs/alerts/
 	DisabledScanners           []string `json:"disabled_scanners"`
@@ -49,345 +92,446 @@ type options struct {
 	MaxRuleDuration            int      `json:"max_rule_duration"` // In minutes
 	OpenapiUrl                 string   `json:"openapi_url"`
 	OpenapiHost                string   `json:"openapi_host"`
+	// MaxSpiderDepth is the maximum depth of the spiders. It replaces
+	// Depth. MaxChildren is the maximum number of children crawled per
+	// node, 0 means no limit.
+	MaxSpiderDepth int `json:"max_spider_depth"`
+	MaxChildren    int `json:"max_children"`
+	// UseAjaxSpider defines whether the AJAX spider is run. By default,
+	// it is run in active mode and not in passive mode. It is always run
+	// in full mode.
+	UseAjaxSpider *bool `json:"use_ajax_spider"`
+	// IncludePaths and ExcludePaths are regular expressions matching the
+	// paths of the target included in and excluded from the scan. By
+	// default, all the paths are included.
+	IncludePaths []string `json:"include_paths"`
+	ExcludePaths []string `json:"exclude_paths"`
+	// MaxScanMinutes is the maximum duration of the active scan enforced
+	// by the check. When it is exceeded, the active scan is stopped and
+	// the alerts raised until then are reported.
+	MaxScanMinutes int `json:"max_scan_minutes"`
+	// ZAPAPIURL is the URL of an external ZAP daemon used instead of
+	// launching the bundled one, e.g. "https://zap.example.com:8080".
+	// ZAPAPIKey is the API key of the daemon. The global settings of an
+	// external daemon are not changed, so the options that require it
+	// are rejected.
+	ZAPAPIURL string `json:"zap_api_url"`
+	ZAPAPIKey string `json:"zap_api_key"`
 }
 
 func main() {
-	run := func(_ context.Context, target, assetType, optJSON string, state checkstate.State) (err error) {
-		var opt options
-		if optJSON != "" {
-			if err = json.Unmarshal([]byte(optJSON), &opt); err != nil {
-				return err
-			}
-		}
-
-		disabledScanners := strings.Join(opt.DisabledScanners, ",")
+	c := check.NewCheckFromHandler(checkName, run)
+	c.RunAndServe()
+}
 
-		isReachable, err := helpers.IsReachable(target, assetType, nil)
-		if err != nil {
-			logger.Warnf("Can not check asset reachability: %v", err)
-		}
-		if !isReachable {
-			return checkstate.ErrAssetUnreachable
+func run(_ context.Context, target, assetType, optJSON string, state checkstate.State) (err error) {
+	var opt options
+	if optJSON != "" {
+		if err = json.Unmarshal([]byte(optJSON), &opt); err != nil {
+			return err
 		}
+	}
 
-		ctx, ctxCancel := context.WithCancel(context.Background())
-
-		// Execute ZAP daemon.
-		go func() {
-			logger.Print("Executing for ZAP daemon...")
-			out, err := exec.Command(
-				"/zap/zap.sh",
-				"-daemon", "-host", "127.0.0.1", "-port", "8080",
-				"-config", "api.disablekey=true",
-				"-config", "database.recoverylog=false", // Reduce disk usage
-				"-notel",  // Disables telemetry
-				"-silent", // Prevents from checking for addon updates
-			).Output()
-
-			logger.Debugf("Error executing ZAP daemon: %v", err)
-			logger.Debugf("Output of the ZAP daemon: %s", string(out))
+	disabledScanners := strings.Join(opt.DisabledScanners, ",")
 
-			ctxCancel()
-		}()
+	mode, err := opt.scanMode()
+	if err != nil {
+		return err
+	}
+	jobs := opt.scanJobs(mode)
+	active := jobs.activeScan
+	if err = validateRuleOverrides(opt.RuleOverrides); err != nil {
+		return err
+	}
+	if !active && (opt.ScanPolicy != "" || len(opt.RuleOverrides) > 0) {
+		logger.Warnf("The scan policy and the rule overrides are ignored in %s mode", mode)
+	}
 
-		// Wait for ZAP to be available.
-		logger.Print("Waiting for ZAP proxy...")
-		ticker := time.NewTicker(time.Second)
-	proxyLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				return errors.New("ZAP exited while waiting for proxy")
-			case <-ticker.C:
-				conn, _ := net.DialTimeout("tcp", "127.0.0.1:8080", time.Second)
-				if conn != nil {
-					conn.Close()
-					break proxyLoop
-				}
-			}
+	authMethod, err := opt.authMethod()
+	if err != nil {
+		return err
+	}
+	indicator, err := opt.loggedInIndicator()
+	if err != nil {
+		return err
+	}
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("error parsing target URL: %w", err)
+	}
+	includeRegexes, excludeRegexes, err := scopeRegexes(targetURL, opt.IncludePaths, opt.ExcludePaths)
+	if err != nil {
+		return err
+	}
+	if opt.ZAPAPIURL != "" {
+		if err := opt.validateRemote(authMethod); err != nil {
+			return err
 		}
+	}
+	if opt.MaxScanMinutes < 0 {
+		return fmt.Errorf("invalid max_scan_minutes %d: must be greater than or equal to 0", opt.MaxScanMinutes)
+	}
+	if opt.MaxChildren < 0 {
+		return fmt.Errorf("invalid max_children %d: must be greater than or equal to 0", opt.MaxChildren)
+	}
 
-		logger.Print("Initiating ZAP client...")
+	// The errors of the requests to the ZAP API may contain the
+	// secrets, as the parameters are sent in the URL.
+	secrets := opt.secrets()
+	defer func() {
+		err = redactError(err, secrets)
+	}()
 
-		cfg := &zap.Config{
-			Proxy:     "http://127.0.0.1:8080",
-			Base:      "http://127.0.0.1:8080/JSON/",
-			BaseOther: "http://127.0.0.1:8080/OTHER/",
-		}
-		client, err = zap.NewClient(cfg)
-		if err != nil {
-			return fmt.Errorf("error configuring the ZAP proxy client: %w", err)
-		}
+	isReachable, err := helpers.IsReachable(target, assetType, nil)
+	if err != nil {
+		logger.Warnf("Can not check asset reachability: %v", err)
+	}
+	if !isReachable {
+		return checkstate.ErrAssetUnreachable
+	}
 
-		client.Core().SetOptionDefaultUserAgent("Vulcan - Security Scanner - vulcan@adevinta.com")
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
 
-		targetURL, err := url.Parse(target)
+	var cfg *zap.Config
+	if opt.ZAPAPIURL != "" {
+		logger.Print("Using external ZAP daemon...")
+		cfg, err = remoteConfig(opt.ZAPAPIURL, opt.ZAPAPIKey)
 		if err != nil {
-			return fmt.Errorf("error parsing target URL: %w", err)
+			return err
 		}
-
-		cx, err := client.Context().NewContext(contextName)
+		contextName, err = newContextName()
 		if err != nil {
-			return fmt.Errorf("error creating scope context: %w", err)
+			return err
 		}
-		contextID, err := getStringAttribute(cx, "contextId")
+	} else {
+		cfg, err = startZAP(ctx, ctxCancel)
 		if err != nil {
 			return err
 		}
+	}
+
+	logger.Print("Initiating ZAP client...")
 
-		// Add base URL to the scope.
-		targetPort := ""
-		if targetURL.Port() != "" {
-			targetPort = fmt.Sprintf(":%s", targetURL.Port())
+	client, err = zap.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("error configuring the ZAP proxy client: %w", err)
+	}
+	if err := checkZAPReachable(); err != nil {
+		return err
+	}
+
+	// The alerts of the target are filtered and removed from an external
+	// ZAP daemon, as it is shared with other checks. Its global settings
+	// are not changed.
+	alertsBaseURL := ""
+	scanPolicy, policyCopy := opt.ScanPolicy, ""
+	if opt.ZAPAPIURL != "" {
+		alertsBaseURL = fmt.Sprintf("%s://%s", targetURL.Scheme, targetURL.Host)
+		defer func() {
+			cleanUpRemote(contextName, policyCopy, alertsBaseURL)
+		}()
+	} else {
+		if err := setGlobalOptions(opt, disabledScanners); err != nil {
+			return err
 		}
-		hostnameRegExQuote := strings.Replace(targetURL.Hostname(), `.`, `\.`, -1)
-		includeInContextRegEx := fmt.Sprintf(`http(s)?:\/\/%s%s\/.*`, hostnameRegExQuote, targetPort)
-		logger.Printf("include in context regexp: %s", includeInContextRegEx)
-		_, err = client.Context().IncludeInContext(contextName, includeInContextRegEx)
+	}
+
+	cx, err := client.Context().NewContext(contextName)
+	if err != nil {
+		return fmt.Errorf("error creating scope context: %w", err)
+	}
+	contextID, err := getStringAttribute(cx, "contextId")
+	if err != nil {
+		return err
+	}
+
+	// Add the target to the scope.
+	for _, re := range includeRegexes {
+		logger.Printf("include in context regexp: %s", re)
+		_, err = client.Context().IncludeInContext(contextName, re)
 		if err != nil {
 			return fmt.Errorf("error including target URL to context: %w", err)
 		}
-
-		_, err = client.Context().SetContextInScope(contextName, "True")
+	}
+	for _, re := range excludeRegexes {
+		logger.Printf("exclude from context regexp: %s", re)
+		_, err = client.Context().ExcludeFromContext(contextName, re)
 		if err != nil {
-			return fmt.Errorf("error setting context in scope: %w", err)
+			return fmt.Errorf("error excluding URL from context: %w", err)
 		}
+	}
 
-		if opt.Username != "" {
-			auth := client.Authentication()
-			auth.SetAuthenticationMethod("1", "httpAuthentication", fmt.Sprintf("hostname=%v&port=%v", targetURL.Hostname(), targetURL.Port()))
-
-			users := client.Users()
-			users.NewUser("1", opt.Username)
-			users.SetAuthenticationCredentials("1", "0", fmt.Sprintf("username=%v&password=%v", opt.Username, opt.Password))
-			users.SetUserEnabled("1", "0", "True")
-		}
+	_, err = client.Context().SetContextInScope(contextName, "True")
+	if err != nil {
+		return fmt.Errorf("error setting context in scope: %w", err)
+	}
 
-		if opt.OpenapiUrl != "" {
-			_, err = client.Openapi().ImportUrl(opt.OpenapiUrl, opt.OpenapiHost, contextID)
-			if err != nil {
-				return fmt.Errorf("error importing openapi url: %w", err)
-			}
-		}
+	switch authMethod {
+	case authHTTP:
+		auth := client.Authentication()
+		auth.SetAuthenticationMethod(contextID, "httpAuthentication", fmt.Sprintf("hostname=%v&port=%v", targetURL.Hostname(), targetURL.Port()))
 
-		_, err = client.Pscan().DisableScanners(disabledScanners)
+		users := client.Users()
+		resp, err := users.NewUser(contextID, opt.Username)
 		if err != nil {
-			return fmt.Errorf("error disabling scanners for passive scan: %w", err)
+			return fmt.Errorf("error creating the user: %w", err)
 		}
-
-		_, err = client.Spider().SetOptionMaxDepth(opt.Depth)
+		userID, err := getStringAttribute(resp, "userId")
 		if err != nil {
-			return fmt.Errorf("error setting spider max depth: %w", err)
+			return err
 		}
-		_, err = client.Spider().SetOptionMaxDuration(opt.MaxSpiderDuration)
-		if err != nil {
-			return fmt.Errorf("error setting spider max duration: %w", err)
+		users.SetAuthenticationCredentials(contextID, userID, fmt.Sprintf("username=%v&password=%v", opt.Username, opt.Password))
+		users.SetUserEnabled(contextID, userID, "True")
+	case authHeader:
+		logger.Printf("Using header authentication with header %s", opt.AuthHeader)
+		if err := setupHeaderAuth(opt); err != nil {
+			return err
 		}
-
-		_, err = client.Pscan().DisableAllTags()
-		if err != nil {
-			return fmt.Errorf("error disabling all tags: %w", err)
+	case authForm:
+		logger.Printf("Using form based authentication with login URL %s", opt.LoginURL)
+		if err := setupFormAuth(opt, contextID); err != nil {
+			return err
 		}
+	}
 
-		logger.Printf("Running spider %v levels deep, max duration %v, ...", opt.Depth, opt.MaxSpiderDuration)
-
-		resp, err := client.Spider().Scan(targetURL.String(), "", contextName, "", "")
-		if err != nil {
-			return fmt.Errorf("error executing the spider: %w", err)
+	if indicator != nil {
+		// The target is requested through ZAP, so the request is
+		// authenticated the same way the requests of the scan are.
+		proxyURL, _ := url.Parse(cfg.Proxy)
+		proxyClient := &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyURL(proxyURL),
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			Timeout: 30 * time.Second,
+		}
+		if err := checkLoggedIn(ctx, proxyClient, targetURL.String(), indicator); err != nil {
+			return err
 		}
+		logger.Print("Authentication verified")
+	}
 
-		v, ok := resp["scan"]
-		if !ok {
-			// Scan has not been executed. Due to the ZAP proxy behaviour
-			// (the request to the ZAP API does not return the status codes)
-			// we can not be sure whether it was because a non existant target
-			// or because an error accessing the ZAP API. Therefore, we will
-			// terminate the check without errors.
-			logger.WithFields(logrus.Fields{"resp": resp}).Warn("Scan not present in response body when calling Spider().Scan()")
-			return nil
+	if opt.OpenapiUrl != "" {
+		_, err = client.Openapi().ImportUrl(opt.OpenapiUrl, opt.OpenapiHost, contextID)
+		if err != nil {
+			return fmt.Errorf("error importing openapi url: %w", err)
 		}
+	}
 
-		scanid, ok := v.(string)
-		if !ok {
-			return errors.New("scan is present in response body when calling Spider().Scan() but it is not a string")
-		}
-
-		ticker = time.NewTicker(10 * time.Second)
-	spiderLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				return errors.New("ZAP exited while waiting for spider")
-			case <-ticker.C:
-				resp, err := client.Spider().Status(scanid)
-				if err != nil {
-					return fmt.Errorf("error getting the status of the spider: %w", err)
-				}
-				v, ok := resp["status"]
-				if !ok {
-					// In this case if we can not get the status let's fail.
-					return fmt.Errorf("cannot retrieve the status of the spider: %v", resp)
-				}
-				status, ok := v.(string)
-				if !ok {
-					return errors.New("status is present in response body when calling Spider().Scatus() but it is not a string")
-				}
-
-				progress, err := strconv.Atoi(status)
-				if err != nil {
-					return fmt.Errorf("can not convert status value %s into an int", status)
-				}
-
-				logger.Debugf("Spider at %v progress.", progress)
-
-				if opt.Active {
-					state.SetProgress(float32(progress) / 200)
-				} else {
-					state.SetProgress(float32(progress) / 100)
-				}
-
-				if progress >= 100 {
-					break spiderLoop
-				}
+	if active {
+		if opt.ZAPAPIURL != "" {
+			// The scan policies are shared by the checks using the
+			// daemon, so the rule overrides and the disabled scanners
+			// are applied to a copy of the policy.
+			policyCopy = contextName
+			if err := copyScanPolicy(opt.ScanPolicy, policyCopy); err != nil {
+				return err
 			}
+			scanPolicy = policyCopy
+		}
+		if err := setupScanPolicy(scanPolicy, opt.RuleOverrides); err != nil {
+			return err
 		}
+	}
 
-		logger.Print("Waiting for spider results...")
-		time.Sleep(5 * time.Second)
+	logger.Printf("Running spider %v levels deep, max %v children, max duration %v, ...", opt.spiderDepth(), opt.MaxChildren, opt.MaxSpiderDuration)
 
-		resp, err = client.Spider().AllUrls()
-		if err != nil {
-			return fmt.Errorf("error getting the list of URLs from spider: %w", err)
-		}
-		logger.Printf("Spider found the following URLs: %+v", resp)
+	resp, err := client.Spider().Scan(targetURL.String(), strconv.Itoa(opt.MaxChildren), "", contextName, "")
+	if err != nil {
+		return fmt.Errorf("error executing the spider: %w", err)
+	}
 
-		_, err = client.AjaxSpider().SetOptionMaxDuration(opt.MaxSpiderDuration)
-		if err != nil {
-			return fmt.Errorf("error setting ajax spider max duration: %w", err)
-		}
+	v, ok := resp["scan"]
+	if !ok {
+		// Scan has not been executed. Due to the ZAP proxy behaviour
+		// (the request to the ZAP API does not return the status codes)
+		// we can not be sure whether it was because a non existant target
+		// or because an error accessing the ZAP API. Therefore, we will
+		// terminate the check without errors.
+		logger.WithFields(logrus.Fields{"resp": resp}).Warn("Scan not present in response body when calling Spider().Scan()")
+		return nil
+	}
 
-		logger.Printf("Running AJAX spider %v levels deep, max duration %v...", opt.Depth, opt.MaxSpiderDuration)
+	scanid, ok := v.(string)
+	if !ok {
+		return errors.New("scan is present in response body when calling Spider().Scan() but it is not a string")
+	}
 
-		client.AjaxSpider().SetOptionMaxCrawlDepth(opt.Depth)
-		_, err = client.AjaxSpider().Scan(targetURL.String(), "", contextName, "")
-		if err != nil {
-			return fmt.Errorf("error executing the AJAX spider: %w", err)
-		}
-
-		ticker = time.NewTicker(10 * time.Second)
-	ajaxSpiderLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				return errors.New("ZAP exited while waiting for AJAX spider")
-			case <-ticker.C:
-				resp, err := client.AjaxSpider().Status()
-				if err != nil {
-					return fmt.Errorf("error getting the status of the AJAX spider: %w", err)
-				}
-
-				v, ok := resp["status"]
-				if !ok {
-					// In this case if we can not get the status let's fail.
-					return errors.New("can not retrieve the status of the AJAX spider")
-				}
-				status, ok := v.(string)
-				if !ok {
-					return errors.New("status is present in response body when calling AjaxSpider().Scatus() but it is not a string")
-				}
-
-				if status >= "running" {
-					break ajaxSpiderLoop
-				}
+	ticker := time.NewTicker(10 * time.Second)
+spiderLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.New("ZAP exited while waiting for spider")
+		case <-ticker.C:
+			resp, err := client.Spider().Status(scanid)
+			if err != nil {
+				return fmt.Errorf("error getting the status of the spider: %w", err)
+			}
+			v, ok := resp["status"]
+			if !ok {
+				// In this case if we can not get the status let's fail.
+				return fmt.Errorf("cannot retrieve the status of the spider: %v", resp)
+			}
+			status, ok := v.(string)
+			if !ok {
+				return errors.New("status is present in response body when calling Spider().Scatus() but it is not a string")
 			}
-		}
 
-		logger.Print("Waiting for AJAX spider results...")
-		time.Sleep(5 * time.Second)
+			progress, err := strconv.Atoi(status)
+			if err != nil {
+				return fmt.Errorf("can not convert status value %s into an int", status)
+			}
 
-		resp, err = client.AjaxSpider().FullResults()
-		if err != nil {
-			return fmt.Errorf("error getting the list of URLs from AJAX spider: %w", err)
-		}
-		logger.Printf("AJAX spider found the following URLs: %+v", resp)
+			logger.Debugf("Spider at %v progress.", progress)
 
-		// Scan actively only if explicitly indicated.
-		if opt.Active {
-			logger.Print("Running active scan...")
-			err := activeScan(ctx, targetURL, state, disabledScanners, opt.MaxScanDuration, opt.MaxRuleDuration, contextID)
-			if err != nil {
-				return err
+			if active {
+				state.SetProgress(float32(progress) / 200)
+			} else {
+				state.SetProgress(float32(progress) / 100)
+			}
+
+			if progress >= 100 {
+				break spiderLoop
 			}
-			logger.Print("Waiting for active scan results...")
-			time.Sleep(5 * time.Second)
 		}
+	}
 
-		// Retrieve alerts.
-		alerts, err := client.Core().Alerts("", "", "", "")
+	logger.Print("Waiting for spider results...")
+	time.Sleep(5 * time.Second)
+
+	resp, err = client.Spider().AllUrls()
+	if err != nil {
+		return fmt.Errorf("error getting the list of URLs from spider: %w", err)
+	}
+	logger.Printf("Spider found the following URLs: %+v", resp)
+
+	spiderResults, err := client.Spider().Results(scanid)
+	if err != nil {
+		return fmt.Errorf("error getting the results of the spider: %w", err)
+	}
+	spiderURLs, err := getStringList(spiderResults, "results")
+	if err != nil {
+		return fmt.Errorf("error reading the results of the spider: %w", err)
+	}
+	discovered := len(spiderURLs)
+
+	if jobs.ajaxSpider {
+		n, err := ajaxSpider(ctx, targetURL)
 		if err != nil {
-			return fmt.Errorf("error retrieving alerts: %v", alerts)
+			return err
 		}
+		discovered += n
+	}
 
-		alertsSlice, ok := alerts["alerts"].([]interface{})
-		if !ok {
-			return errors.New("alerts does not exist or it is not an array of interface{}")
+	// Scan actively only if explicitly indicated.
+	var truncated bool
+	if active {
+		logger.Print("Running active scan...")
+		maxScanTime := time.Duration(opt.MaxScanMinutes) * time.Minute
+		truncated, err = activeScan(ctx, targetURL, state, disabledScanners, scanPolicy, maxScanTime, contextID)
+		if err != nil {
+			return err
 		}
+		logger.Print("Waiting for active scan results...")
+		time.Sleep(5 * time.Second)
+	}
 
-		vulnerabilities := make(map[string]*report.Vulnerability)
-		vulnSummary2PluginID := make(map[string]string)
-		for _, alert := range alertsSlice {
-			a, ok := alert.(map[string]interface{})
-			if !ok {
-				return errors.New("alert it is not a map[string]interface{}")
-			}
+	// Retrieve alerts.
+	alerts, err := client.Core().Alerts(alertsBaseURL, "", "", "")
+	if err != nil {
+		return fmt.Errorf("error retrieving alerts: %v", alerts)
+	}
 
-			v, err := processAlert(a)
-			if err != nil {
-				logger.WithError(err).Warn("can not process alert")
-				continue
-			}
-			pluginID, err := parsePluginID(a)
-			if err != nil {
-				logger.WithError(err).Warn("can not parse plugin ID")
-				continue
-			}
-			vulnSummary2PluginID[v.Summary] = pluginID
+	alertsSlice, ok := alerts["alerts"].([]interface{})
+	if !ok {
+		return errors.New("alerts does not exist or it is not an array of interface{}")
+	}
 
-			if _, ok := vulnerabilities[v.Summary]; ok {
-				vulnerabilities[v.Summary].Resources[0].Rows = append(
-					vulnerabilities[v.Summary].Resources[0].Rows,
-					v.Resources[0].Rows...,
-				)
-			} else {
-				vulnerabilities[v.Summary] = &v
-			}
+	// The URLs of the context are the URLs of the scope accessed by
+	// ZAP, which are the ones scanned.
+	scannedURLs, err := contextURLs(contextName)
+	if err != nil {
+		return err
+	}
+	// Other checks may be scanning the same target with an external
+	// daemon, so only the alerts of the URLs of the context are reported.
+	if opt.ZAPAPIURL != "" {
+		alertsSlice = contextAlerts(alertsSlice, scannedURLs)
+	}
+	details := crawlDetails(discovered, len(scannedURLs))
+	logger.Print(strings.ReplaceAll(details, "\n", ", "))
+	if truncated {
+		details = truncatedDetails(opt.MaxScanMinutes) + "\n" + details
+	}
+	state.Notes = details
+	if active {
+		details += "\n" + policyDetails(opt.ScanPolicy, opt.RuleOverrides)
+	}
+
+	vulnerabilities := make(map[string]*report.Vulnerability)
+	vulnSummary2PluginID := make(map[string]string)
+	for _, alert := range alertsSlice {
+		a, ok := alert.(map[string]interface{})
+		if !ok {
+			return errors.New("alert it is not a map[string]interface{}")
 		}
 
-		for _, v := range vulnerabilities {
-			// NOTE: Due to a signifcant number of false positive findings
-			// reported for low severity issues by ZAP, the MinScore option
-			// allows the check to skip reporting vulnerabilities with
-			// score below a minimum threshold.
-			if opt.MinScore > 0 && v.Score < opt.MinScore {
-				logger.Debugf("Skipping vulnerability with low score: %+v", v)
-				continue
-			}
+		v, err := processAlert(a)
+		if err != nil {
+			logger.WithError(err).Warn("can not process alert")
+			continue
+		}
+		pluginID, err := parsePluginID(a)
+		if err != nil {
+			logger.WithError(err).Warn("can not parse plugin ID")
+			continue
+		}
+		// The passive scanners can not be disabled only for the check in
+		// an external daemon, so their alerts are discarded instead.
+		if opt.ZAPAPIURL != "" && slices.Contains(opt.DisabledScanners, pluginID) {
+			continue
+		}
+		vulnSummary2PluginID[v.Summary] = pluginID
 
-			resourcesFingerprint := ""
-			pluginID := vulnSummary2PluginID[v.Summary]
-			if len(v.Resources) > 0 && !isPluginIgnoredForFingerprint(opt, pluginID) {
-				resourcesFingerprint = fingerprintFromResources(v.Resources[0].Rows)
-			}
-			v.Fingerprint = helpers.ComputeFingerprint(v.Score, resourcesFingerprint)
+		if _, ok := vulnerabilities[v.Summary]; ok {
+			vulnerabilities[v.Summary].Resources[0].Rows = append(
+				vulnerabilities[v.Summary].Resources[0].Rows,
+				v.Resources[0].Rows...,
+			)
+		} else {
+			vulnerabilities[v.Summary] = &v
+		}
+	}
 
-			state.AddVulnerabilities(*v)
+	for _, v := range vulnerabilities {
+		// NOTE: Due to a signifcant number of false positive findings
+		// reported for low severity issues by ZAP, the MinScore option
+		// allows the check to skip reporting vulnerabilities with
+		// score below a minimum threshold.
+		if opt.MinScore > 0 && v.Score < opt.MinScore {
+			logger.Debugf("Skipping vulnerability with low score: %+v", v)
+			continue
 		}
 
-		return nil
+		resourcesFingerprint := ""
+		pluginID := vulnSummary2PluginID[v.Summary]
+		if len(v.Resources) > 0 && !isPluginIgnoredForFingerprint(opt, pluginID) {
+			resourcesFingerprint = fingerprintFromResources(v.Resources[0].Rows)
+		}
+		v.Fingerprint = helpers.ComputeFingerprint(v.Score, resourcesFingerprint)
+		v.Labels = append(v.Labels, modeLabel(mode))
+		redactVulnerability(v, secrets)
+		if v.Details != "" {
+			v.Details += "\n\n"
+		}
+		v.Details += details
+
+		state.AddVulnerabilities(*v)
 	}
 
-	c := check.NewCheckFromHandler(checkName, run)
-	c.RunAndServe()
+	return nil
 }
 
 func fingerprintFromResources(resources []map[string]string) string {
@@ -423,74 +567,228 @@ func fingerprintFromResources(resources []map[string]string) string {
 	return strings.Join(occurrences, "#")
 }
 
-func activeScan(ctx context.Context, targetURL *url.URL, state checkstate.State, disabledScanners string, maxScanDuration, maxRuleDuration int, contextID string) error {
-	_, err := client.Ascan().DisableScanners(disabledScanners, "")
+// setGlobalOptions sets the options of the bundled ZAP daemon that apply
+// to all the scans.
+func setGlobalOptions(opt options, disabledScanners string) error {
+	_, err := client.Core().SetOptionDefaultUserAgent("Vulcan - Security Scanner - vulcan@adevinta.com")
 	if err != nil {
-		return fmt.Errorf("error disabling scanners for active scan: %w", err)
+		return fmt.Errorf("error setting the user agent: %w", err)
 	}
 
-	_, err = client.Ascan().SetOptionMaxScanDurationInMins(maxScanDuration)
+	_, err = client.Pscan().DisableScanners(disabledScanners)
 	if err != nil {
-		return fmt.Errorf("error setting max scan duration for active scan: %w", err)
+		return fmt.Errorf("error disabling scanners for passive scan: %w", err)
+	}
+
+	_, err = client.Pscan().DisableAllTags()
+	if err != nil {
+		return fmt.Errorf("error disabling all tags: %w", err)
 	}
 
-	_, err = client.Ascan().SetOptionMaxRuleDurationInMins(maxRuleDuration)
+	_, err = client.Spider().SetOptionMaxDepth(opt.spiderDepth())
+	if err != nil {
+		return fmt.Errorf("error setting spider max depth: %w", err)
+	}
+	_, err = client.Spider().SetOptionMaxDuration(opt.MaxSpiderDuration)
+	if err != nil {
+		return fmt.Errorf("error setting spider max duration: %w", err)
+	}
+
+	_, err = client.AjaxSpider().SetOptionMaxDuration(opt.MaxSpiderDuration)
+	if err != nil {
+		return fmt.Errorf("error setting ajax spider max duration: %w", err)
+	}
+	_, err = client.AjaxSpider().SetOptionMaxCrawlDepth(opt.spiderDepth())
+	if err != nil {
+		return fmt.Errorf("error setting ajax spider max crawl depth: %w", err)
+	}
+
+	_, err = client.Ascan().SetOptionMaxScanDurationInMins(opt.MaxScanDuration)
+	if err != nil {
+		return fmt.Errorf("error setting max scan duration for active scan: %w", err)
+	}
+	_, err = client.Ascan().SetOptionMaxRuleDurationInMins(opt.MaxRuleDuration)
 	if err != nil {
 		return fmt.Errorf("error setting max rule duration for active scan: %w", err)
 	}
+	return nil
+}
 
-	resp, err := client.Ascan().Scan("", "True", "", "", "", "", contextID)
+// startZAP launches the bundled ZAP daemon and waits until it is available.
+// The given cancel function is called when the daemon exits.
+func startZAP(ctx context.Context, ctxCancel context.CancelFunc) (*zap.Config, error) {
+	// Execute ZAP daemon.
+	go func() {
+		logger.Print("Executing for ZAP daemon...")
+		out, err := exec.Command(
+			zapPath,
+			"-daemon", "-host", "127.0.0.1", "-port", "8080",
+			"-config", "api.disablekey=true",
+			"-config", "database.recoverylog=false", // Reduce disk usage
+			"-notel",  // Disables telemetry
+			"-silent", // Prevents from checking for addon updates
+		).Output()
+
+		logger.Debugf("Error executing ZAP daemon: %v", err)
+		logger.Debugf("Output of the ZAP daemon: %s", string(out))
+
+		ctxCancel()
+	}()
+
+	// Wait for ZAP to be available.
+	logger.Print("Waiting for ZAP proxy...")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+proxyLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("ZAP exited while waiting for proxy")
+		case <-ticker.C:
+			conn, _ := net.DialTimeout("tcp", "127.0.0.1:8080", time.Second)
+			if conn != nil {
+				conn.Close()
+				break proxyLoop
+			}
+		}
+	}
+
+	return &zap.Config{
+		Proxy:     "http://127.0.0.1:8080",
+		Base:      "http://127.0.0.1:8080/JSON/",
+		BaseOther: "http://127.0.0.1:8080/OTHER/",
+	}, nil
+}
+
+// activeScan runs the active scan and waits until it finishes. If maxScanTime
+// is greater than 0 and the scan does not finish before, the scan is stopped
+// and the returned bool is true.
+func activeScan(ctx context.Context, targetURL *url.URL, state checkstate.State, disabledScanners, scanPolicy string, maxScanTime time.Duration, contextID string) (bool, error) {
+	_, err := client.Ascan().DisableScanners(disabledScanners, scanPolicy)
 	if err != nil {
-		return fmt.Errorf("error executing the active scan: %w", err)
+		return false, fmt.Errorf("error disabling scanners for active scan: %w", err)
+	}
+
+	resp, err := client.Ascan().Scan("", "True", "", scanPolicy, "", "", contextID)
+	if err != nil {
+		return false, fmt.Errorf("error executing the active scan: %w", err)
 	}
 
 	v, ok := resp["scan"]
 	if !ok {
-		return fmt.Errorf("scan is not present in response body when calling Ascan().Scan()")
+		return false, fmt.Errorf("scan is not present in response body when calling Ascan().Scan()")
 	}
 
 	scanid, ok := v.(string)
 	if !ok {
-		return errors.New("scan is present in response body when calling Ascan().Scan() but it is not a string")
+		return false, errors.New("scan is present in response body when calling Ascan().Scan() but it is not a string")
 	}
 
-	ticker := time.NewTicker(60 * time.Second)
+	var deadline <-chan time.Time
+	if maxScanTime > 0 {
+		timer := time.NewTimer(maxScanTime)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	ticker := time.NewTicker(activeScanPollInterval)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-deadline:
+			logger.Warnf("Maximum active scan duration of %v exceeded, stopping the active scan", maxScanTime)
+			if _, err := client.Ascan().Stop(scanid); err != nil {
+				return false, fmt.Errorf("error stopping the active scan: %w", err)
+			}
+			return true, nil
 		case <-ctx.Done():
-			return errors.New("ZAP exited while waiting for active scan")
+			return false, errors.New("ZAP exited while waiting for active scan")
 		case <-ticker.C:
 			ascan := client.Ascan()
 
 			resp, err := ascan.Status(scanid)
 			if err != nil {
-				return fmt.Errorf("error getting the status of the scan: %w", err)
+				return false, fmt.Errorf("error getting the status of the scan: %w", err)
 			}
 
 			v, ok := resp["status"]
 			if !ok {
 				// In this case if we can not get the status let's fail.
-				return errors.New("can not retrieve the status of the scan")
+				return false, errors.New("can not retrieve the status of the scan")
 			}
 			status, ok := v.(string)
 			if !ok {
-				return errors.New("status is present in response body when calling Ascan().Scatus() but it is not a string")
+				return false, errors.New("status is present in response body when calling Ascan().Scatus() but it is not a string")
 			}
 			progress, err := strconv.Atoi(status)
 			if err != nil {
-				return fmt.Errorf("can not convert status value %s into an int", status)
+				return false, fmt.Errorf("can not convert status value %s into an int", status)
 			}
 
-			state.SetProgress((1 + float32(progress)) / 200)
+			// The spiders account for the first half of the progress.
+			state.SetProgress(0.5 + float32(progress)/200)
 
 			logger.Debugf("Active scan at %v progress.", progress)
 			if progress >= 100 {
-				return nil
+				return false, nil
 			}
 		}
 	}
 }
 
+// ajaxSpider runs the AJAX spider and returns the number of URLs it found.
+func ajaxSpider(ctx context.Context, targetURL *url.URL) (int, error) {
+	logger.Print("Running AJAX spider...")
+
+	_, err := client.AjaxSpider().Scan(targetURL.String(), "", contextName, "")
+	if err != nil {
+		return 0, fmt.Errorf("error executing the AJAX spider: %w", err)
+	}
+
+	ticker := time.NewTicker(ajaxSpiderPollInterval)
+	defer ticker.Stop()
+ajaxSpiderLoop:
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, errors.New("ZAP exited while waiting for AJAX spider")
+		case <-ticker.C:
+			resp, err := client.AjaxSpider().Status()
+			if err != nil {
+				return 0, fmt.Errorf("error getting the status of the AJAX spider: %w", err)
+			}
+
+			v, ok := resp["status"]
+			if !ok {
+				// In this case if we can not get the status let's fail.
+				return 0, errors.New("can not retrieve the status of the AJAX spider")
+			}
+			status, ok := v.(string)
+			if !ok {
+				return 0, errors.New("status is present in response body when calling AjaxSpider().Scatus() but it is not a string")
+			}
+
+			if status == "stopped" {
+				break ajaxSpiderLoop
+			}
+		}
+	}
+
+	logger.Print("Waiting for AJAX spider results...")
+	time.Sleep(ajaxSpiderResultsDelay)
+
+	resp, err := client.AjaxSpider().NumberOfResults()
+	if err != nil {
+		return 0, fmt.Errorf("error getting the number of URLs found by the AJAX spider: %w", err)
+	}
+	n, err := getIntAttribute(resp, "numberOfResults")
+	if err != nil {
+		return 0, err
+	}
+	logger.Printf("AJAX spider found %d URLs", n)
+	return n, nil
+}
+
 func isPluginIgnoredForFingerprint(opt options, pluginID string) bool {
 	for _, ignoredID := range opt.IgnoredFingerprintScanners {
 		if pluginID == ignoredID {
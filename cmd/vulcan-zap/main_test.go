@@ -0,0 +1,182 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	checkstate "github.com/adevinta/vulcan-check-sdk/state"
+	report "github.com/adevinta/vulcan-report"
+	"github.com/zaproxy/zap-api-go/zap"
+)
+
+// fakeZAP implements the endpoints of the ZAP API used by the active scan,
+// the scan policies, the clean up and the version endpoint. The requests to
+// the rest of the endpoints are recorded.
+type fakeZAP struct {
+	// status is the progress of the active scan returned by the API.
+	status string
+	// ajaxStatuses are the statuses of the AJAX spider returned by the
+	// API, one per request, repeating the last one.
+	ajaxStatuses []string
+	// alerts, scanners and urls are returned by the alerts, scanners and
+	// context URLs views.
+	alerts   []any
+	scanners []any
+	urls     []any
+
+	mu        sync.Mutex
+	stops     int
+	ajaxPolls int
+	calls     []string
+}
+
+// recorded returns the path and the query of the recorded requests.
+func (f *fakeZAP) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// setupFakeZAP starts the fake ZAP API and points the ZAP client to it.
+func setupFakeZAP(t *testing.T, f *fakeZAP) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/JSON/ascan/action/scan/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"scan": "0"})
+	})
+	mux.HandleFunc("/JSON/ascan/view/status/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"status": f.status})
+	})
+	mux.HandleFunc("/JSON/ascan/action/stop/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.stops++
+		json.NewEncoder(w).Encode(map[string]any{"Result": "OK"})
+	})
+	mux.HandleFunc("/JSON/ajaxSpider/view/status/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		status := f.ajaxStatuses[min(f.ajaxPolls, len(f.ajaxStatuses)-1)]
+		f.ajaxPolls++
+		json.NewEncoder(w).Encode(map[string]any{"status": status})
+	})
+	mux.HandleFunc("/JSON/ajaxSpider/view/numberOfResults/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"numberOfResults": "7"})
+	})
+	mux.HandleFunc("/JSON/core/view/version/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"version": "2.14.0"})
+	})
+	mux.HandleFunc("/JSON/core/view/alerts/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"alerts": f.alerts})
+	})
+	mux.HandleFunc("/JSON/ascan/view/scanners/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"scanners": f.scanners})
+	})
+	mux.HandleFunc("/JSON/context/view/urls/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"urls": f.urls})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.calls = append(f.calls, r.URL.Path+"?"+r.URL.RawQuery)
+		json.NewEncoder(w).Encode(map[string]any{"Result": "OK"})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// The ZAP client sends the requests through the proxy, so the fake API
+	// is also used as the proxy.
+	cli, err := zap.NewClient(&zap.Config{
+		Proxy:     srv.URL,
+		Base:      srv.URL + "/JSON/",
+		BaseOther: srv.URL + "/OTHER/",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	oldClient, oldInterval := client, activeScanPollInterval
+	oldAjaxInterval, oldAjaxDelay := ajaxSpiderPollInterval, ajaxSpiderResultsDelay
+	t.Cleanup(func() {
+		client, activeScanPollInterval = oldClient, oldInterval
+		ajaxSpiderPollInterval, ajaxSpiderResultsDelay = oldAjaxInterval, oldAjaxDelay
+	})
+	client, activeScanPollInterval = cli, time.Millisecond
+	ajaxSpiderPollInterval, ajaxSpiderResultsDelay = time.Millisecond, 0
+}
+
+func TestAjaxSpider(t *testing.T) {
+	f := &fakeZAP{ajaxStatuses: []string{"running", "running", "stopped"}}
+	setupFakeZAP(t, f)
+	targetURL, _ := url.Parse("http://example.com/")
+	n, err := ajaxSpider(context.Background(), targetURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 7 {
+		t.Errorf("got %d URLs, want 7", n)
+	}
+	// The spider is polled until it is stopped.
+	if f.ajaxPolls != 3 {
+		t.Errorf("got %d status requests, want 3", f.ajaxPolls)
+	}
+}
+
+func TestActiveScan(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        string
+		maxScanTime   time.Duration
+		wantTruncated bool
+		wantStops     int
+		wantProgress  float32
+	}{
+		{
+			name:         "Completed",
+			status:       "100",
+			maxScanTime:  time.Minute,
+			wantProgress: 1,
+		},
+		{
+			name:          "MaxScanTimeExceeded",
+			status:        "20",
+			maxScanTime:   50 * time.Millisecond,
+			wantTruncated: true,
+			wantStops:     1,
+			wantProgress:  0.6,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeZAP{status: tt.status}
+			setupFakeZAP(t, f)
+			var progress float32
+			state := checkstate.State{
+				ProgressReporter: checkstate.ProgressReporterHandler(func(p float32) { progress = p }),
+				ResultData:       &report.ResultData{},
+			}
+			targetURL, _ := url.Parse("http://example.com/")
+			truncated, err := activeScan(context.Background(), targetURL, state, "", "", tt.maxScanTime, "1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("got truncated %v, want %v", truncated, tt.wantTruncated)
+			}
+			if f.stops != tt.wantStops {
+				t.Errorf("got %d stops, want %d", f.stops, tt.wantStops)
+			}
+			if progress != tt.wantProgress {
+				t.Errorf("got progress %v, want %v", progress, tt.wantProgress)
+			}
+		})
+	}
+}
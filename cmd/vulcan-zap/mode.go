@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import "fmt"
+
+// Scan modes of the check.
+const (
+	// modePassive runs the spider and the passive scan rules only, so no
+	// attack payloads are sent to the target.
+	modePassive = "passive"
+	// modeActive also runs the AJAX spider and the active scan.
+	modeActive = "active"
+	// modeFull runs the spider, the AJAX spider and the active scan.
+	modeFull = "full"
+)
+
+// scanMode returns the scan mode defined by the options. When the mode
+// option is not specified, the mode is defined by the active option.
+func (opt options) scanMode() (string, error) {
+	switch opt.Mode {
+	case "":
+		if opt.Active {
+			return modeActive, nil
+		}
+		return modePassive, nil
+	case modePassive, modeActive, modeFull:
+		return opt.Mode, nil
+	}
+	return "", fmt.Errorf("invalid mode %q: must be one of %s, %s or %s", opt.Mode, modePassive, modeActive, modeFull)
+}
+
+// scanJobs are the ZAP jobs run by the check besides the spider, which is
+// always run.
+type scanJobs struct {
+	ajaxSpider bool
+	activeScan bool
+}
+
+// scanJobs returns the jobs run in the given scan mode. The AJAX spider is
+// run by default in active mode only, unless the use_ajax_spider option is
+// specified, and always in full mode.
+func (opt options) scanJobs(mode string) scanJobs {
+	jobs := scanJobs{
+		ajaxSpider: mode != modePassive,
+		activeScan: mode != modePassive,
+	}
+	if mode != modeFull && opt.UseAjaxSpider != nil {
+		jobs.ajaxSpider = *opt.UseAjaxSpider
+	}
+	return jobs
+}
+
+// modeLabel returns the label added to the vulnerabilities found with the
+// given scan mode.
+func modeLabel(mode string) string {
+	return mode + "-scan"
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import "testing"
+
+func TestScanMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     options
+		want    string
+		wantErr bool
+	}{
+		{name: "DefaultPassive", want: modePassive},
+		{name: "DefaultActive", opt: options{Active: true}, want: modeActive},
+		{name: "ModeOverridesActive", opt: options{Active: true, Mode: modePassive}, want: modePassive},
+		{name: "Full", opt: options{Mode: modeFull}, want: modeFull},
+		{name: "Invalid", opt: options{Mode: "aggressive"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opt.scanMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got mode %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanJobs(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name string
+		opt  options
+		want scanJobs
+	}{
+		{name: "DefaultPassive", want: scanJobs{}},
+		{name: "DefaultActive", opt: options{Active: true}, want: scanJobs{ajaxSpider: true, activeScan: true}},
+		{name: "Passive", opt: options{Mode: modePassive}, want: scanJobs{}},
+		{name: "PassiveAjaxSpider", opt: options{Mode: modePassive, UseAjaxSpider: &enabled}, want: scanJobs{ajaxSpider: true}},
+		{name: "Active", opt: options{Mode: modeActive}, want: scanJobs{ajaxSpider: true, activeScan: true}},
+		{name: "ActiveNoAjaxSpider", opt: options{Mode: modeActive, UseAjaxSpider: &disabled}, want: scanJobs{activeScan: true}},
+		{name: "Full", opt: options{Mode: modeFull}, want: scanJobs{ajaxSpider: true, activeScan: true}},
+		{name: "FullNoAjaxSpider", opt: options{Mode: modeFull, UseAjaxSpider: &disabled}, want: scanJobs{ajaxSpider: true, activeScan: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, err := tt.opt.scanMode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := tt.opt.scanJobs(mode); got != tt.want {
+				t.Errorf("got jobs %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
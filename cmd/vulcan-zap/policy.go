@@ -0,0 +1,248 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Name used in the details when the scan policy is not specified.
+const defaultPolicyName = "default"
+
+var (
+	// Valid alert thresholds and attack strengths of the active scan rules.
+	validThresholds = []string{"OFF", "DEFAULT", "LOW", "MEDIUM", "HIGH"}
+	validStrengths  = []string{"DEFAULT", "LOW", "MEDIUM", "HIGH", "INSANE"}
+)
+
+// ruleOverride overrides the alert threshold and the attack strength of an
+// active scan rule. Empty values are not overridden.
+type ruleOverride struct {
+	Threshold string `json:"threshold"`
+	Strength  string `json:"strength"`
+}
+
+// validateRuleOverrides normalizes the values of the rule overrides to
+// upper case and returns an error if any of them is not valid.
+func validateRuleOverrides(overrides map[string]ruleOverride) error {
+	for id, o := range overrides {
+		o.Threshold = strings.ToUpper(o.Threshold)
+		o.Strength = strings.ToUpper(o.Strength)
+		if o.Threshold == "" && o.Strength == "" {
+			return fmt.Errorf("invalid override of rule %s: threshold or strength must be specified", id)
+		}
+		if o.Threshold != "" && !slices.Contains(validThresholds, o.Threshold) {
+			return fmt.Errorf("invalid threshold %q of rule %s: must be one of %s", o.Threshold, id, strings.Join(validThresholds, ", "))
+		}
+		if o.Strength != "" && !slices.Contains(validStrengths, o.Strength) {
+			return fmt.Errorf("invalid strength %q of rule %s: must be one of %s", o.Strength, id, strings.Join(validStrengths, ", "))
+		}
+		overrides[id] = o
+	}
+	return nil
+}
+
+// validatePolicy returns an error if the given policy is not one of the
+// available ones.
+func validatePolicy(policy string, available []string) error {
+	if !slices.Contains(available, policy) {
+		return fmt.Errorf("unknown scan policy %q: available policies are %s", policy, strings.Join(available, ", "))
+	}
+	return nil
+}
+
+// validateRuleIDs returns an error if any of the overridden rules is not
+// one of the available ones.
+func validateRuleIDs(overrides map[string]ruleOverride, available []string) error {
+	var unknown []string
+	for id := range overrides {
+		if !slices.Contains(available, id) {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("unknown rule IDs %s: available rules are %s", strings.Join(unknown, ", "), strings.Join(available, ", "))
+}
+
+// scanPolicyNames returns the names of the scan policies installed in ZAP.
+func scanPolicyNames() ([]string, error) {
+	resp, err := client.Ascan().ScanPolicyNames()
+	if err != nil {
+		return nil, fmt.Errorf("error getting the scan policies: %w", err)
+	}
+	return getStringList(resp, "scanPolicyNames")
+}
+
+// scanRule is an active scan rule of a scan policy.
+type scanRule struct {
+	id        string
+	threshold string
+	strength  string
+	enabled   bool
+}
+
+// scanRules returns the active scan rules of the given policy.
+func scanRules(policy string) ([]scanRule, error) {
+	resp, err := client.Ascan().Scanners(policy, "")
+	if err != nil {
+		return nil, fmt.Errorf("error getting the active scan rules: %w", err)
+	}
+	scanners, ok := resp["scanners"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("scanners value [%v] is not a list", resp["scanners"])
+	}
+	var rules []scanRule
+	for _, s := range scanners {
+		m, ok := s.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("scanner [%v] is not an object", s)
+		}
+		var r scanRule
+		if r.id, err = getStringAttribute(m, "id"); err != nil {
+			return nil, err
+		}
+		if r.threshold, err = getStringAttribute(m, "alertThreshold"); err != nil {
+			return nil, err
+		}
+		if r.strength, err = getStringAttribute(m, "attackStrength"); err != nil {
+			return nil, err
+		}
+		enabled, err := getStringAttribute(m, "enabled")
+		if err != nil {
+			return nil, err
+		}
+		r.enabled = enabled == "true"
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// scannerIDs returns the IDs of the active scan rules of the given policy.
+func scannerIDs(policy string) ([]string, error) {
+	rules, err := scanRules(policy)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, r := range rules {
+		ids = append(ids, r.id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// copyScanPolicy adds a scan policy with the given name and the same
+// thresholds, strengths and enabled rules as the base policy, so the
+// changes made by the check do not affect the base policy. The default
+// policy is copied if base is empty.
+func copyScanPolicy(base, name string) error {
+	if base != "" {
+		names, err := scanPolicyNames()
+		if err != nil {
+			return err
+		}
+		if err := validatePolicy(base, names); err != nil {
+			return err
+		}
+	}
+	rules, err := scanRules(base)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Ascan().AddScanPolicy(name, "", ""); err != nil {
+		return fmt.Errorf("error adding the scan policy: %w", err)
+	}
+	var disabled []string
+	for _, r := range rules {
+		if _, err := client.Ascan().SetScannerAlertThreshold(r.id, r.threshold, name); err != nil {
+			return fmt.Errorf("error copying the threshold of rule %s: %w", r.id, err)
+		}
+		if _, err := client.Ascan().SetScannerAttackStrength(r.id, r.strength, name); err != nil {
+			return fmt.Errorf("error copying the strength of rule %s: %w", r.id, err)
+		}
+		if !r.enabled {
+			disabled = append(disabled, r.id)
+		}
+	}
+	if len(disabled) > 0 {
+		if _, err := client.Ascan().DisableScanners(strings.Join(disabled, ","), name); err != nil {
+			return fmt.Errorf("error copying the disabled rules: %w", err)
+		}
+	}
+	return nil
+}
+
+// setupScanPolicy verifies that the scan policy and the overridden rules
+// exist in ZAP and applies the overrides to the policy.
+func setupScanPolicy(policy string, overrides map[string]ruleOverride) error {
+	if policy != "" {
+		names, err := scanPolicyNames()
+		if err != nil {
+			return err
+		}
+		if err := validatePolicy(policy, names); err != nil {
+			return err
+		}
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+	ids, err := scannerIDs(policy)
+	if err != nil {
+		return err
+	}
+	if err := validateRuleIDs(overrides, ids); err != nil {
+		return err
+	}
+	for id, o := range overrides {
+		if o.Threshold != "" {
+			if _, err := client.Ascan().SetScannerAlertThreshold(id, o.Threshold, policy); err != nil {
+				return fmt.Errorf("error setting the threshold of rule %s: %w", id, err)
+			}
+		}
+		if o.Strength != "" {
+			if _, err := client.Ascan().SetScannerAttackStrength(id, o.Strength, policy); err != nil {
+				return fmt.Errorf("error setting the strength of rule %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// policyDetails returns the text added to the details of the vulnerabilities
+// with the scan policy and the rule overrides applied to the active scan.
+func policyDetails(policy string, overrides map[string]ruleOverride) string {
+	if policy == "" {
+		policy = defaultPolicyName
+	}
+	details := fmt.Sprintf("Scan policy: %s", policy)
+	if len(overrides) == 0 {
+		return details
+	}
+	ids := make([]string, 0, len(overrides))
+	for id := range overrides {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	details += "\nRule overrides:"
+	for _, id := range ids {
+		o := overrides[id]
+		threshold, strength := o.Threshold, o.Strength
+		if threshold == "" {
+			threshold = "unchanged"
+		}
+		if strength == "" {
+			strength = "unchanged"
+		}
+		details += fmt.Sprintf("\n- %s: threshold %s, strength %s", id, threshold, strength)
+	}
+	return details
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateRuleOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]ruleOverride
+		want      map[string]ruleOverride
+		wantErr   string
+	}{
+		{
+			name:      "Normalized",
+			overrides: map[string]ruleOverride{"40018": {Threshold: "off"}, "40012": {Threshold: "High", Strength: "low"}},
+			want:      map[string]ruleOverride{"40018": {Threshold: "OFF"}, "40012": {Threshold: "HIGH", Strength: "LOW"}},
+		},
+		{
+			name:      "InvalidThreshold",
+			overrides: map[string]ruleOverride{"40018": {Threshold: "INSANE"}},
+			wantErr:   "invalid threshold",
+		},
+		{
+			name:      "InvalidStrength",
+			overrides: map[string]ruleOverride{"40018": {Strength: "OFF"}},
+			wantErr:   "invalid strength",
+		},
+		{
+			name:      "Empty",
+			overrides: map[string]ruleOverride{"40018": {}},
+			wantErr:   "threshold or strength must be specified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRuleOverrides(tt.overrides)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.want, tt.overrides); diff != "" {
+				t.Errorf("unexpected overrides (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyAndRules(t *testing.T) {
+	policies := []string{"Default Policy", "Static Site"}
+	if err := validatePolicy("Static Site", policies); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := validatePolicy("Unknown", policies)
+	if err == nil || !strings.Contains(err.Error(), "available policies are Default Policy, Static Site") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	rules := []string{"40012", "40018"}
+	if err := validateRuleIDs(map[string]ruleOverride{"40018": {Threshold: "OFF"}}, rules); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = validateRuleIDs(map[string]ruleOverride{"1": {Threshold: "OFF"}, "40018": {Threshold: "OFF"}}, rules)
+	if err == nil || err.Error() != "unknown rule IDs 1: available rules are 40012, 40018" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPolicyDetails(t *testing.T) {
+	got := policyDetails("", nil)
+	if got != "Scan policy: default" {
+		t.Errorf("got details %q", got)
+	}
+	got = policyDetails("Static Site", map[string]ruleOverride{
+		"40018": {Threshold: "OFF"},
+		"40012": {Threshold: "HIGH", Strength: "LOW"},
+	})
+	want := "Scan policy: Static Site\nRule overrides:\n- 40012: threshold HIGH, strength LOW\n- 40018: threshold OFF, strength unchanged"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected details (-want +got):\n%v", diff)
+	}
+}
+
+func TestCopyScanPolicy(t *testing.T) {
+	f := &fakeZAP{
+		scanners: []any{
+			map[string]any{"id": "40012", "alertThreshold": "LOW", "attackStrength": "HIGH", "enabled": "true"},
+			map[string]any{"id": "40018", "alertThreshold": "OFF", "attackStrength": "DEFAULT", "enabled": "false"},
+		},
+	}
+	setupFakeZAP(t, f)
+	if err := copyScanPolicy("", "vulcan-0123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"/JSON/ascan/action/addScanPolicy/?scanPolicyName=vulcan-0123",
+		"/JSON/ascan/action/setScannerAlertThreshold/?alertThreshold=LOW&id=40012&scanPolicyName=vulcan-0123",
+		"/JSON/ascan/action/setScannerAttackStrength/?attackStrength=HIGH&id=40012&scanPolicyName=vulcan-0123",
+		"/JSON/ascan/action/setScannerAlertThreshold/?alertThreshold=OFF&id=40018&scanPolicyName=vulcan-0123",
+		"/JSON/ascan/action/setScannerAttackStrength/?attackStrength=DEFAULT&id=40018&scanPolicyName=vulcan-0123",
+		"/JSON/ascan/action/disableScanners/?ids=40018&scanPolicyName=vulcan-0123",
+	}
+	if diff := cmp.Diff(want, f.recorded()); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/zaproxy/zap-api-go/zap"
+)
+
+// errZAPUnreachable is returned when the ZAP daemon can not be reached, so it
+// can be told apart from the errors of the scan.
+var errZAPUnreachable = errors.New("can not reach the ZAP daemon")
+
+// remoteConfig returns the configuration of the client of the external ZAP
+// daemon listening in the given URL. The daemon is also used as the proxy.
+func remoteConfig(apiURL, apiKey string) (*zap.Config, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zap_api_url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid zap_api_url %q: must be an http or https URL", apiURL)
+	}
+	base := strings.TrimSuffix(u.String(), "/")
+	return &zap.Config{
+		Proxy:     base,
+		Base:      base + "/JSON/",
+		BaseOther: base + "/OTHER/",
+		APIKey:    apiKey,
+	}, nil
+}
+
+// checkZAPReachable returns an error wrapping errZAPUnreachable if the ZAP
+// API can not be reached or rejects the requests, e.g. because of a wrong
+// API key.
+func checkZAPReachable() error {
+	resp, err := client.Core().Version()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errZAPUnreachable, err)
+	}
+	if _, ok := resp["version"]; !ok {
+		return fmt.Errorf("%w: unexpected response: %v", errZAPUnreachable, resp)
+	}
+	return nil
+}
+
+// validateRemote returns an error if any of the options that change the
+// global settings of ZAP is specified, as an external daemon is shared with
+// other checks. The settings of the daemon are used instead.
+func (opt options) validateRemote(authMethod string) error {
+	var unsupported []string
+	switch authMethod {
+	case authHeader:
+		// The replacer rules of ZAP apply to all the requests, so the
+		// header would be sent to the targets of other checks.
+		unsupported = append(unsupported, "auth_header")
+	case authForm:
+		// The forced user mode applies to all the contexts.
+		unsupported = append(unsupported, "login_url")
+	}
+	if opt.Depth != 0 {
+		unsupported = append(unsupported, "depth")
+	}
+	if opt.MaxSpiderDepth != 0 {
+		unsupported = append(unsupported, "max_spider_depth")
+	}
+	if opt.MaxSpiderDuration != 0 {
+		unsupported = append(unsupported, "max_spider_duration")
+	}
+	if opt.MaxScanDuration != 0 {
+		unsupported = append(unsupported, "max_scan_duration")
+	}
+	if opt.MaxRuleDuration != 0 {
+		unsupported = append(unsupported, "max_rule_duration")
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	return fmt.Errorf("options not supported with an external ZAP daemon, as they change its global settings: %s", strings.Join(unsupported, ", "))
+}
+
+// newContextName returns a random name for the context of the scan, so
+// concurrent checks using the same ZAP daemon do not share their contexts.
+func newContextName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating the context name: %w", err)
+	}
+	return "vulcan-" + hex.EncodeToString(b), nil
+}
+
+// contextURLs returns the URLs accessed by ZAP that belong to the context
+// with the given name.
+func contextURLs(name string) ([]string, error) {
+	resp, err := client.Context().Urls(name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting the URLs of the context: %w", err)
+	}
+	urls, err := getStringList(resp, "urls")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the URLs of the context: %w", err)
+	}
+	return urls, nil
+}
+
+// contextAlerts returns the alerts raised for the given URLs of the context
+// of the check, excluding the ones raised for other checks scanning the same
+// target with the external daemon.
+func contextAlerts(alerts []any, urls []string) []any {
+	inContext := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		inContext[u] = true
+	}
+	var filtered []any
+	for _, a := range alerts {
+		m, ok := a.(map[string]any)
+		if !ok {
+			continue
+		}
+		if u, _ := m["url"].(string); inContext[u] {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// cleanUpRemote removes from the external ZAP daemon the alerts of the URLs
+// of the context, the context and the copy of the scan policy, if any,
+// created by the check, so they are not reported by the next checks. The
+// sites tree of the target is kept, as it may be used by other checks
+// scanning the same target. The errors are logged but not returned.
+func cleanUpRemote(name, policy, baseURL string) {
+	l := logger.WithField("action", "CleanUp")
+	// The alerts are removed first, as they are selected using the URLs
+	// of the context.
+	urls, err := contextURLs(name)
+	if err != nil {
+		l.WithError(err).Error("error retrieving the URLs of the alerts to remove")
+	}
+	alerts, err := client.Core().Alerts(baseURL, "", "", "")
+	if err != nil {
+		l.WithError(err).Error("error retrieving the alerts to remove")
+	}
+	list, _ := alerts["alerts"].([]any)
+	for _, a := range contextAlerts(list, urls) {
+		id, err := getStringAttribute(a.(map[string]any), "id")
+		if err != nil {
+			continue
+		}
+		if _, err := client.Alert().DeleteAlert(id); err != nil {
+			l.WithError(err).Errorf("error removing the alert %s", id)
+		}
+	}
+	if _, err := client.Context().RemoveContext(name); err != nil {
+		l.WithError(err).Error("error removing the ZAP context")
+	}
+	if policy != "" {
+		if _, err := client.Ascan().RemoveScanPolicy(policy); err != nil {
+			l.WithError(err).Error("error removing the scan policy")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zaproxy/zap-api-go/zap"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	cfg, err := remoteConfig("https://zap.example.com:8443/", "key")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Base != "https://zap.example.com:8443/JSON/" || cfg.Proxy != "https://zap.example.com:8443" || cfg.APIKey != "key" {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	for _, u := range []string{"zap.example.com:8080", "ftp://zap.example.com", "http://"} {
+		if _, err := remoteConfig(u, ""); err == nil {
+			t.Errorf("no error for URL %q", u)
+		}
+	}
+}
+
+func TestCheckZAPReachable(t *testing.T) {
+	f := &fakeZAP{}
+	setupFakeZAP(t, f)
+	if err := checkZAPReachable(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	cli, err := zap.NewClient(&zap.Config{
+		Proxy: "http://127.0.0.1:1",
+		Base:  "http://127.0.0.1:1/JSON/",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client = cli
+	if err := checkZAPReachable(); !errors.Is(err, errZAPUnreachable) {
+		t.Errorf("got error %v, want %v", err, errZAPUnreachable)
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	tests := []struct {
+		name       string
+		opt        options
+		authMethod string
+		wantErr    bool
+	}{
+		{name: "Supported", opt: options{MaxChildren: 10, MaxScanMinutes: 60, DisabledScanners: []string{"10062"}}},
+		{name: "HTTPAuth", opt: options{Username: "user", Password: "pass"}, authMethod: authHTTP},
+		{name: "HeaderAuth", authMethod: authHeader, wantErr: true},
+		{name: "FormAuth", authMethod: authForm, wantErr: true},
+		{name: "Depth", opt: options{Depth: 2}, wantErr: true},
+		{name: "MaxSpiderDepth", opt: options{MaxSpiderDepth: 5}, wantErr: true},
+		{name: "MaxSpiderDuration", opt: options{MaxSpiderDuration: 10}, wantErr: true},
+		{name: "MaxScanDuration", opt: options{MaxScanDuration: 540}, wantErr: true},
+		{name: "MaxRuleDuration", opt: options{MaxRuleDuration: 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opt.validateRemote(tt.authMethod)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCleanUpRemote(t *testing.T) {
+	f := &fakeZAP{
+		alerts: []any{
+			map[string]any{"id": "1", "url": "http://example.com/"},
+			map[string]any{"id": "2", "url": "http://example.com/other"},
+			map[string]any{"id": "3", "url": "http://example.com/login"},
+		},
+		urls: []any{"http://example.com/", "http://example.com/login"},
+	}
+	setupFakeZAP(t, f)
+	cleanUpRemote("vulcan-0123", "vulcan-0123", "http://example.com")
+	// The alert 2 was raised for a URL of another check, and the sites
+	// tree is not removed.
+	want := []string{
+		"/JSON/alert/action/deleteAlert/?id=1",
+		"/JSON/alert/action/deleteAlert/?id=3",
+		"/JSON/context/action/removeContext/?contextName=vulcan-0123",
+		"/JSON/ascan/action/removeScanPolicy/?scanPolicyName=vulcan-0123",
+	}
+	if diff := cmp.Diff(want, f.recorded()); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
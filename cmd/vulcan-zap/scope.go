@@ -0,0 +1,102 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// spiderDepth returns the maximum depth of the spiders. The max_spider_depth
+// option takes precedence over the depth option.
+func (opt options) spiderDepth() int {
+	if opt.MaxSpiderDepth > 0 {
+		return opt.MaxSpiderDepth
+	}
+	return opt.Depth
+}
+
+// scopeRegexes returns the regular expressions of the URLs included in and
+// excluded from the context of the scan. The include and exclude paths are
+// regular expressions matching the path of the URLs of the target. When no
+// include path is given, the whole target is included.
+func scopeRegexes(targetURL *url.URL, includePaths, excludePaths []string) (include, exclude []string, err error) {
+	targetPort := ""
+	if targetURL.Port() != "" {
+		targetPort = fmt.Sprintf(":%s", targetURL.Port())
+	}
+	hostnameRegExQuote := strings.Replace(targetURL.Hostname(), `.`, `\.`, -1)
+	base := fmt.Sprintf(`http(s)?:\/\/%s%s`, hostnameRegExQuote, targetPort)
+
+	if len(includePaths) == 0 {
+		includePaths = []string{`\/.*`}
+	}
+	for _, p := range includePaths {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, nil, fmt.Errorf("invalid include path %q: %w", p, err)
+		}
+		include = append(include, base+p)
+	}
+	for _, p := range excludePaths {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, nil, fmt.Errorf("invalid exclude path %q: %w", p, err)
+		}
+		exclude = append(exclude, base+p)
+	}
+	return include, exclude, nil
+}
+
+// getStringList returns the strings of the list with the given name of a
+// ZAP API response.
+func getStringList(m map[string]any, name string) ([]string, error) {
+	v, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s value [%v] is not a list", name, v)
+	}
+	var strs []string
+	for _, e := range list {
+		str, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s element [%v] is not a string", name, e)
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+// getIntAttribute returns the value of the attribute with the given name of
+// a ZAP API response, which reports the numbers as strings.
+func getIntAttribute(m map[string]any, name string) (int, error) {
+	str, err := getStringAttribute(m, name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("%s value [%v] is not an integer", name, str)
+	}
+	return n, nil
+}
+
+// crawlDetails returns the text added to the details of the vulnerabilities
+// with the number of URLs discovered by the spiders and the number of URLs
+// of the scope of the scan.
+func crawlDetails(discovered, scanned int) string {
+	return fmt.Sprintf("URLs discovered by the spiders: %d\nURLs scanned: %d", discovered, scanned)
+}
+
+// truncatedDetails returns the text added to the details of the
+// vulnerabilities when the active scan is stopped after reaching the maximum
+// scan duration.
+func truncatedDetails(maxScanMinutes int) string {
+	return fmt.Sprintf("The active scan was stopped after reaching the maximum scan duration of %d minutes. Only the alerts raised until then are reported.", maxScanMinutes)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2019 Adevinta
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestScopeRegexes(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		includePaths []string
+		excludePaths []string
+		wantInclude  []string
+		wantExclude  []string
+		wantErr      bool
+	}{
+		{
+			name:        "Default",
+			target:      "https://www.example.com/",
+			wantInclude: []string{`http(s)?:\/\/www\.example\.com\/.*`},
+		},
+		{
+			name:         "Paths",
+			target:       "http://example.com:8080/app",
+			includePaths: []string{`\/app\/.*`},
+			excludePaths: []string{`\/app\/logout.*`},
+			wantInclude:  []string{`http(s)?:\/\/example\.com:8080\/app\/.*`},
+			wantExclude:  []string{`http(s)?:\/\/example\.com:8080\/app\/logout.*`},
+		},
+		{
+			name:         "InvalidPath",
+			target:       "https://example.com/",
+			excludePaths: []string{`\/(`},
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			include, exclude, err := scopeRegexes(u, tt.includePaths, tt.excludePaths)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.wantInclude, include); diff != "" {
+				t.Errorf("unexpected include regexes (-want +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(tt.wantExclude, exclude); diff != "" {
+				t.Errorf("unexpected exclude regexes (-want +got):\n%v", diff)
+			}
+			for _, re := range include {
+				if !regexp.MustCompile(re).MatchString(tt.target + "/") {
+					t.Errorf("target %s not matched by %s", tt.target, re)
+				}
+			}
+		})
+	}
+}
+
+func TestSpiderOptions(t *testing.T) {
+	var opt options
+	if err := json.Unmarshal([]byte(`{"depth":2}`), &opt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := opt.spiderDepth(); got != 2 {
+		t.Errorf("got spider depth %d, want 2", got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"depth":2,"max_spider_depth":5}`), &opt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := opt.spiderDepth(); got != 5 {
+		t.Errorf("got spider depth %d, want 5", got)
+	}
+}
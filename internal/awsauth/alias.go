@@ -0,0 +1,76 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package awsauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// IAMAPI contains the IAM operations used to retrieve the alias of an
+// account.
+type IAMAPI interface {
+	ListAccountAliases(context.Context, *iam.ListAccountAliasesInput, ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error)
+}
+
+// AliasOptions are the optional parameters of [AccountAlias].
+type AliasOptions struct {
+	// Endpoint overrides the URL of the IAM endpoint.
+	Endpoint string
+	// MaxAttempts is the maximum number of attempts of the request to IAM,
+	// that is retried when it fails because of throttling or a server
+	// error. It defaults to the default of the AWS SDK.
+	MaxAttempts int
+	// ConfigOptions are applied to the config of the AWS SDK after the
+	// rest of options, e.g.: to use a custom HTTP client.
+	ConfigOptions []func(*config.LoadOptions) error
+}
+
+// AccountAlias returns one of the current aliases of the account the given
+// credentials belong to, using the IAM endpoint of the given region. It
+// returns an empty string if the account has no aliases.
+func AccountAlias(ctx context.Context, creds Credentials, region string, opts AliasOptions) (string, error) {
+	cfgOpts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)),
+	}
+	if opts.MaxAttempts > 0 {
+		cfgOpts = append(cfgOpts, config.WithRetryMaxAttempts(opts.MaxAttempts))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, append(cfgOpts, opts.ConfigOptions...)...)
+	if err != nil {
+		return "", fmt.Errorf("can not load AWS config: %w", err)
+	}
+	svc := iam.NewFromConfig(cfg, func(o *iam.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+	return Alias(ctx, svc)
+}
+
+// Alias returns one of the current aliases of the account that the
+// credentials used by the given IAM client belong to. It returns an empty
+// string if the account has no aliases.
+func Alias(ctx context.Context, svc IAMAPI) (string, error) {
+	resp, err := svc.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.AccountAliases) == 0 {
+		return "", nil
+	}
+	a := resp.AccountAliases[0]
+	if a == "" {
+		return "", errors.New("unexpected empty alias getting aliases for aws account")
+	}
+	return a, nil
+}
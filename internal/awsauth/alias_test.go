@@ -0,0 +1,181 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package awsauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/google/go-cmp/cmp"
+)
+
+const listAccountAliasesResponse = `<ListAccountAliasesResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <ListAccountAliasesResult>
+    <IsTruncated>false</IsTruncated>
+    <AccountAliases>%s</AccountAliases>
+  </ListAccountAliasesResult>
+  <ResponseMetadata>
+    <RequestId>c5a076e9-f1b0-11df-8fbe-45274EXAMPLE</RequestId>
+  </ResponseMetadata>
+</ListAccountAliasesResponse>`
+
+var testCredentials = Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+type fakeIAM struct {
+	aliases []string
+	err     error
+}
+
+func (f fakeIAM) ListAccountAliases(context.Context, *iam.ListAccountAliasesInput, ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &iam.ListAccountAliasesOutput{AccountAliases: f.aliases}, nil
+}
+
+func TestAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     fakeIAM
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Alias",
+			api:  fakeIAM{aliases: []string{"alias", "other"}},
+			want: "alias",
+		},
+		{
+			name: "NoAlias",
+			api:  fakeIAM{},
+			want: "",
+		},
+		{
+			name:    "EmptyAlias",
+			api:     fakeIAM{aliases: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "Error",
+			api:     fakeIAM{err: errors.New("access denied")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Alias(context.Background(), tt.api)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("alias mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAccountAliasEndpointOverride(t *testing.T) {
+	var requests int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(fmt.Sprintf(listAccountAliasesResponse, "<member>stub-alias</member>")))
+	}))
+	defer srv.Close()
+
+	// The stub server uses a self-signed certificate, so the client of the
+	// server must be used by the SDK and a custom CA bundle defined in the
+	// environment must not replace its root CAs.
+	t.Setenv("AWS_CA_BUNDLE", "")
+	opts := AliasOptions{
+		Endpoint:      srv.URL,
+		ConfigOptions: []func(*config.LoadOptions) error{config.WithHTTPClient(srv.Client())},
+	}
+	alias, err := AccountAlias(context.Background(), testCredentials, "eu-west-1", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if alias != "stub-alias" {
+		t.Errorf("unexpected alias: %q", alias)
+	}
+	if requests != 1 {
+		t.Errorf("unexpected number of requests to the stub endpoint: %d", requests)
+	}
+}
+
+func TestAccountAliasRetriesThrottling(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/xml")
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<ErrorResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>Throttling</Code>
+    <Message>Rate exceeded</Message>
+  </Error>
+  <RequestId>c5a076e9-f1b0-11df-8fbe-45274EXAMPLE</RequestId>
+</ErrorResponse>`))
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(fmt.Sprintf(listAccountAliasesResponse, "")))
+		}
+	}))
+	defer srv.Close()
+
+	// Use the standard retryer of the SDK without delays between retries
+	// to keep the test fast.
+	retryer := func() aws.Retryer {
+		return retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = 3
+			o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) {
+				return 0, nil
+			})
+		})
+	}
+	opts := AliasOptions{
+		Endpoint:      srv.URL,
+		ConfigOptions: []func(*config.LoadOptions) error{config.WithRetryer(retryer)},
+	}
+	alias, err := AccountAlias(context.Background(), testCredentials, "eu-west-1", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if alias != "" {
+		t.Errorf("unexpected alias: %q", alias)
+	}
+	if requests != 3 {
+		t.Errorf("unexpected number of requests to the stub endpoint: %d", requests)
+	}
+}
+
+func TestAccountAliasCanceled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := AccountAlias(ctx, testCredentials, "eu-west-1", AliasOptions{Endpoint: srv.URL})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v is not %v", err, context.DeadlineExceeded)
+	}
+}
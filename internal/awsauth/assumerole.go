@@ -0,0 +1,286 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package awsauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// PayloadVersionV1 is the version of the original assume role
+	// payload, that does not contain a version field.
+	PayloadVersionV1 = 1
+	// PayloadVersionV2 is the version of the assume role payload that
+	// contains an explicit version field.
+	PayloadVersionV2 = 2
+
+	// DefaultTimeout is the default maximum time a request to the assume
+	// role endpoint is allowed to take.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultRetryDelay is the default delay before retrying a failed
+	// request to the assume role endpoint.
+	DefaultRetryDelay = time.Second
+
+	// maxResponseSize is the maximum size of the responses of the assume
+	// role endpoint.
+	maxResponseSize = 1024 * 1024
+
+	// MaxErrorBodySize is the maximum number of bytes of the body of an
+	// error response of the assume role endpoint included in the errors.
+	MaxErrorBodySize = 256
+)
+
+var (
+	// ErrUnsupportedPayload is returned when the assume role endpoint
+	// rejects a payload because it contains fields it does not know.
+	ErrUnsupportedPayload = errors.New("assume role endpoint does not support the payload")
+
+	// ErrRoleNotAuthorized is returned when the assume role endpoint
+	// responds with a 401 or 403 status code.
+	ErrRoleNotAuthorized = errors.New("role not authorized")
+)
+
+// AssumeRoleOptions are the optional parameters of [AssumeRole].
+type AssumeRoleOptions struct {
+	// ExternalID is the external ID required by the trust policy of the
+	// role. It is only sent if it is not empty. It is a secret, so it is
+	// never logged.
+	ExternalID string
+	// SessionDuration is the duration of the session in seconds. If it is
+	// 0, the endpoint decides the duration.
+	SessionDuration int
+	// Timeout is the maximum time each request to the endpoint is allowed
+	// to take. It defaults to DefaultTimeout. It is ignored if Client is
+	// set.
+	Timeout time.Duration
+	// Retries is the number of times a request is retried when it fails
+	// because of a network error, a server error or throttling. By
+	// default the requests are not retried.
+	Retries int
+	// RetryDelay is the delay before the first retry. It is doubled after
+	// each retry. It defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+	// Client is the HTTP client used to send the requests.
+	Client *http.Client
+	// Logger logs the retries and the fallbacks to the v1 payload.
+	Logger Logger
+}
+
+// assumeRoleRequest is the payload sent to the assume role endpoint.
+type assumeRoleRequest struct {
+	// Version is the version of the payload. It is omitted in the v1
+	// payload.
+	Version   int    `json:"version,omitempty"`
+	AccountID string `json:"account_id"`
+	Role      string `json:"role,omitempty"`
+	// Duration is the duration of the session in seconds.
+	Duration   int    `json:"duration,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+}
+
+// v1 returns the minimal payload understood by all the versions of the
+// assume role endpoint.
+func (r assumeRoleRequest) v1() assumeRoleRequest {
+	r.Version = 0
+	return r
+}
+
+// redact replaces the external ID of the request in the given response body,
+// so it is not included in errors or logs if the endpoint echoes it.
+func (r assumeRoleRequest) redact(body []byte) []byte {
+	if r.ExternalID == "" {
+		return body
+	}
+	return bytes.ReplaceAll(body, []byte(r.ExternalID), []byte("xxxxx"))
+}
+
+type assumeRoleResponse struct {
+	AccessKey       string `json:"access_key"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	// Version is the version of the payload honored by the endpoint. It is
+	// not returned by the endpoints that only support the v1 payload.
+	Version int `json:"version,omitempty"`
+}
+
+// retryableError is an error of a request to the assume role endpoint that
+// can succeed if the request is retried.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func (e *retryableError) Unwrap() error { return e.err }
+
+// AssumeRole requests credentials for the given account and role to the
+// assume role endpoint. It first sends the latest version of the payload
+// and, if the endpoint rejects it, retries once with the v1 payload. The
+// version of the payload honored by the endpoint is returned in the
+// PayloadVersion field of the credentials.
+func AssumeRole(ctx context.Context, endpoint, accountID, role string, opts AssumeRoleOptions) (Credentials, error) {
+	c := assumeRoleClient{
+		url:    endpoint,
+		client: opts.Client,
+		opts:   opts,
+		log:    opts.Logger,
+	}
+	if c.client == nil {
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		c.client = &http.Client{Timeout: timeout}
+	}
+	if c.log == nil {
+		c.log = nopLogger{}
+	}
+	req := assumeRoleRequest{
+		Version:    PayloadVersionV2,
+		AccountID:  accountID,
+		Role:       role,
+		Duration:   opts.SessionDuration,
+		ExternalID: opts.ExternalID,
+	}
+	resp, err := c.post(ctx, req)
+	if errors.Is(err, ErrUnsupportedPayload) {
+		c.log.Warnf("assume role endpoint rejected the v%d payload, retrying with the v%d payload: %v", PayloadVersionV2, PayloadVersionV1, err)
+		resp, err = c.post(ctx, req.v1())
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+	version := resp.Version
+	if version == 0 {
+		version = PayloadVersionV1
+	}
+	creds := Credentials{
+		AccessKeyID:     resp.AccessKey,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.SessionToken,
+		PayloadVersion:  version,
+	}
+	return creds, nil
+}
+
+// assumeRoleClient sends the requests to the assume role endpoint.
+type assumeRoleClient struct {
+	url    string
+	client *http.Client
+	opts   AssumeRoleOptions
+	log    Logger
+}
+
+// post sends the given request to the endpoint, retrying it as configured in
+// the options when it fails with a retryable error.
+func (c assumeRoleClient) post(ctx context.Context, req assumeRoleRequest) (assumeRoleResponse, error) {
+	delay := c.opts.RetryDelay
+	if delay == 0 {
+		delay = DefaultRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.postOnce(ctx, req)
+		var rerr *retryableError
+		if err == nil || !errors.As(err, &rerr) || attempt >= c.opts.Retries {
+			return resp, err
+		}
+		c.log.Warnf("assume role request for account %s failed, retrying in %v (%d/%d): %v", req.AccountID, delay, attempt+1, c.opts.Retries, err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return assumeRoleResponse{}, fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
+
+func (c assumeRoleClient) postOnce(ctx context.Context, req assumeRoleRequest) (assumeRoleResponse, error) {
+	jsonBody, err := json.Marshal(req)
+	if err != nil {
+		return assumeRoleResponse{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return assumeRoleResponse{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return assumeRoleResponse{}, err
+		}
+		return assumeRoleResponse{}, &retryableError{err}
+	}
+	defer httpResp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize))
+	if err != nil {
+		return assumeRoleResponse{}, err
+	}
+	switch code := httpResp.StatusCode; {
+	case code == http.StatusBadRequest && isUnknownFieldError(buf):
+		return assumeRoleResponse{}, fmt.Errorf("%w: %s", ErrUnsupportedPayload, errorBody(req.redact(buf)))
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return assumeRoleResponse{}, fmt.Errorf("%w: status code %d from assume role endpoint: %s", ErrRoleNotAuthorized, code, errorBody(req.redact(buf)))
+	case code == http.StatusTooManyRequests || code >= 500:
+		return assumeRoleResponse{}, &retryableError{fmt.Errorf("unexpected status code %d from assume role endpoint: %s", code, errorBody(req.redact(buf)))}
+	case code < 200 || code > 299:
+		return assumeRoleResponse{}, fmt.Errorf("unexpected status code %d from assume role endpoint: %s", code, errorBody(req.redact(buf)))
+	}
+
+	var r assumeRoleResponse
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return assumeRoleResponse{}, fmt.Errorf("can not decode response body (%d bytes, content type %q) %q: %w",
+			len(buf), httpResp.Header.Get("Content-Type"), errorBody(req.redact(buf)), err)
+	}
+	return r, nil
+}
+
+// credentialFieldRegexp matches the credential fields of a JSON response of
+// the assume role endpoint and their values, even if the value is not
+// terminated because the response is malformed.
+var credentialFieldRegexp = regexp.MustCompile(`("(?:access_key|secret_access_key|session_token)"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// redactCredentials replaces the values of the credential fields in the
+// given response body.
+func redactCredentials(body []byte) []byte {
+	return credentialFieldRegexp.ReplaceAll(body, []byte(`$1"xxxxx"`))
+}
+
+// errorBody returns the body of a response with the values of the credential
+// fields redacted, truncated and without control or formatting characters,
+// so it can be safely included in errors and logs.
+func errorBody(body []byte) string {
+	body = redactCredentials(body)
+	truncated := len(body) > MaxErrorBodySize
+	if truncated {
+		body = body[:MaxErrorBodySize]
+	}
+	s := strings.TrimSpace(sanitize(string(body)))
+	if truncated {
+		s += "..."
+	}
+	return s
+}
+
+// isUnknownFieldError returns true if the body of a response of the assume
+// role endpoint reports that the payload contains unknown fields, like the
+// error returned by a JSON decoder configured to disallow unknown fields.
+func isUnknownFieldError(body []byte) bool {
+	msg := strings.ToLower(string(body))
+	return strings.Contains(msg, "unknown field") || strings.Contains(msg, "unsupported version")
+}
@@ -0,0 +1,484 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package awsauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeAssumeRoleV1 implements an assume role endpoint that only supports the
+// v1 payload and rejects the payloads with unknown fields.
+func fakeAssumeRoleV1(t *testing.T, requests *[]map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var raw map[string]interface{}
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		if err := json.Unmarshal(body.Bytes(), &raw); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		*requests = append(*requests, raw)
+
+		var req struct {
+			AccountID string `json:"account_id"`
+			Role      string `json:"role"`
+			Duration  int    `json:"duration"`
+		}
+		dec := json.NewDecoder(body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_key":"AKID","secret_access_key":"secret","session_token":"token"}`))
+	}
+}
+
+// fakeAssumeRoleV2 implements an assume role endpoint that supports the v2
+// payload and echoes the version it honored.
+func fakeAssumeRoleV2(t *testing.T, requests *[]map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var raw map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+		*requests = append(*requests, raw)
+		w.Write([]byte(`{"access_key":"AKID","secret_access_key":"secret","session_token":"token","version":2}`))
+	}
+}
+
+func TestAssumeRoleNegotiation(t *testing.T) {
+	tests := []struct {
+		name         string
+		handler      func(*testing.T, *[]map[string]interface{}) http.HandlerFunc
+		wantVersion  int
+		wantRequests []map[string]interface{}
+	}{
+		{
+			name:        "V2Endpoint",
+			handler:     fakeAssumeRoleV2,
+			wantVersion: PayloadVersionV2,
+			wantRequests: []map[string]interface{}{
+				{"version": float64(2), "account_id": "123456789012", "role": "audit", "duration": float64(3600)},
+			},
+		},
+		{
+			name:        "V1Endpoint",
+			handler:     fakeAssumeRoleV1,
+			wantVersion: PayloadVersionV1,
+			wantRequests: []map[string]interface{}{
+				{"version": float64(2), "account_id": "123456789012", "role": "audit", "duration": float64(3600)},
+				{"account_id": "123456789012", "role": "audit", "duration": float64(3600)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []map[string]interface{}
+			srv := httptest.NewServer(tt.handler(t, &requests))
+			defer srv.Close()
+
+			opts := AssumeRoleOptions{SessionDuration: 3600, Client: srv.Client()}
+			creds, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if creds.PayloadVersion != tt.wantVersion {
+				t.Errorf("unexpected version: %d", creds.PayloadVersion)
+			}
+			if creds.AccessKeyID != "AKID" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" {
+				t.Errorf("unexpected credentials: %+v", creds)
+			}
+			if diff := cmp.Diff(tt.wantRequests, requests); diff != "" {
+				t.Errorf("requests mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleErrors(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "invalid account", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	// The requests rejected by the endpoint are not retried.
+	opts := AssumeRoleOptions{Retries: 2, RetryDelay: time.Millisecond}
+	_, err := AssumeRole(context.Background(), srv.URL, "123456789012", "", opts)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if requests != 1 {
+		t.Errorf("unexpected number of requests: %d", requests)
+	}
+}
+
+func TestAssumeRoleCanceled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a hung endpoint.
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err := AssumeRole(ctx, srv.URL, "123456789012", "", AssumeRoleOptions{Retries: 2})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("AssumeRole did not return promptly: %v", elapsed)
+	}
+}
+
+func TestAssumeRoleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := AssumeRole(context.Background(), srv.URL, "123456789012", "", AssumeRoleOptions{Timeout: 50 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("AssumeRole did not return promptly: %v", elapsed)
+	}
+}
+
+func TestAssumeRoleStatusCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantErr    bool
+		wantErrIs  error
+		wantErrMsg string
+	}{
+		{
+			name:   "OK",
+			status: http.StatusOK,
+			body:   `{"access_key":"AKID","secret_access_key":"secret","session_token":"token"}`,
+		},
+		{
+			name:       "Forbidden",
+			status:     http.StatusForbidden,
+			body:       "access denied\x1b[31m",
+			wantErr:    true,
+			wantErrIs:  ErrRoleNotAuthorized,
+			wantErrMsg: "status code 403 from assume role endpoint: access denied[31m",
+		},
+		{
+			name:       "InternalServerError",
+			status:     http.StatusInternalServerError,
+			body:       strings.Repeat("a", 1000),
+			wantErr:    true,
+			wantErrMsg: "unexpected status code 500 from assume role endpoint: " + strings.Repeat("a", MaxErrorBodySize) + "...",
+		},
+		{
+			name:       "MalformedJSON",
+			status:     http.StatusOK,
+			body:       `{"access_key":`,
+			wantErr:    true,
+			wantErrMsg: "can not decode response body",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			creds, err := AssumeRole(context.Background(), srv.URL, "123456789012", "", AssumeRoleOptions{Client: srv.Client()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr {
+				if creds.AccessKeyID != "AKID" {
+					t.Errorf("unexpected credentials: %+v", creds)
+				}
+				return
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("error %v is not %v", err, tt.wantErrIs)
+			}
+			if !strings.Contains(err.Error(), tt.wantErrMsg) {
+				t.Errorf("error %q does not contain %q", err, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleExternalID(t *testing.T) {
+	tests := []struct {
+		name       string
+		externalID string
+		want       map[string]interface{}
+	}{
+		{
+			name:       "ExternalID",
+			externalID: "s3cr3t-external-id",
+			want: map[string]interface{}{
+				"version":     float64(PayloadVersionV2),
+				"account_id":  "123456789012",
+				"role":        "audit",
+				"duration":    float64(3600),
+				"external_id": "s3cr3t-external-id",
+			},
+		},
+		{
+			name: "NoExternalID",
+			want: map[string]interface{}{
+				"version":    float64(PayloadVersionV2),
+				"account_id": "123456789012",
+				"role":       "audit",
+				"duration":   float64(3600),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []map[string]interface{}
+			srv := httptest.NewServer(fakeAssumeRoleV2(t, &requests))
+			defer srv.Close()
+
+			opts := AssumeRoleOptions{ExternalID: tt.externalID, SessionDuration: 3600}
+			_, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff([]map[string]interface{}{tt.want}, requests); diff != "" {
+				t.Errorf("request body mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleRedactsExternalID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		http.Error(w, "invalid request: "+body.String(), http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	opts := AssumeRoleOptions{ExternalID: "s3cr3t-external-id", SessionDuration: 3600}
+	_, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", opts)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "s3cr3t-external-id") {
+		t.Errorf("error contains the external ID: %v", err)
+	}
+	if !strings.Contains(err.Error(), `"external_id":"xxxxx"`) {
+		t.Errorf("error does not contain the redacted external ID: %v", err)
+	}
+}
+
+func TestAssumeRoleRedactsCredentials(t *testing.T) {
+	// The secrets are checked by prefix to detect partial leaks too.
+	secrets := []string{"AKIAEXAMPLE", "wJalrXUtnFEMI", "FwoGZXIvYXdzEJr"}
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{
+			name:   "Truncated",
+			status: http.StatusOK,
+			body:   `{"access_key":"AKIAEXAMPLEKEY","secret_access_key":"wJalrXUtnFEMI/K7MDENG","session_token":"FwoGZXIvYXdzEJr//tok`,
+		},
+		{
+			name:   "InvalidType",
+			status: http.StatusOK,
+			body:   `{"access_key": "AKIAEXAMPLEKEY", "secret_access_key": "wJalrXUtnFEMI/K7MDENG", "session_token": "FwoGZXIvYXdzEJr//token", "version": "2"}`,
+		},
+		{
+			name:   "EscapedQuotes",
+			status: http.StatusOK,
+			body:   `{"access_key":"AKIAEXAMPLEKEY","secret_access_key":"wJalrXUtnFEMI/K7MDENG\"","session_token":"FwoGZXIvYXdzEJr//token"`,
+		},
+		{
+			name:   "ErrorStatus",
+			status: http.StatusInternalServerError,
+			body:   `{"error":"internal","access_key":"AKIAEXAMPLEKEY","secret_access_key":"wJalrXUtnFEMI/K7MDENG","session_token":"FwoGZXIvYXdzEJr//token"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", AssumeRoleOptions{})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, s := range secrets {
+				if strings.Contains(err.Error(), s) {
+					t.Errorf("error contains the secret %q: %v", s, err)
+				}
+			}
+		})
+	}
+}
+
+// recordingLogger is a Logger that records the messages it logs.
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestAssumeRoleRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		retries      int
+		wantErr      bool
+		wantRequests int
+		wantLogs     int
+	}{
+		{
+			name:         "Recovered",
+			failures:     2,
+			status:       http.StatusServiceUnavailable,
+			retries:      2,
+			wantRequests: 3,
+			wantLogs:     2,
+		},
+		{
+			name:         "Throttled",
+			failures:     1,
+			status:       http.StatusTooManyRequests,
+			retries:      1,
+			wantRequests: 2,
+			wantLogs:     1,
+		},
+		{
+			name:         "Exhausted",
+			failures:     3,
+			status:       http.StatusInternalServerError,
+			retries:      2,
+			wantErr:      true,
+			wantRequests: 3,
+			wantLogs:     2,
+		},
+		{
+			name:         "NoRetries",
+			failures:     1,
+			status:       http.StatusBadGateway,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+		{
+			name:         "NotRetryable",
+			failures:     1,
+			status:       http.StatusNotFound,
+			retries:      2,
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tt.failures {
+					http.Error(w, `{"error":"unavailable","session_token":"FwoGZXIvYXdzEJr"}`, tt.status)
+					return
+				}
+				w.Write([]byte(`{"access_key":"AKID","secret_access_key":"secret","session_token":"token","version":2}`))
+			}))
+			defer srv.Close()
+
+			log := &recordingLogger{}
+			opts := AssumeRoleOptions{Retries: tt.retries, RetryDelay: time.Millisecond, Logger: log}
+			creds, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && creds.AccessKeyID != "AKID" {
+				t.Errorf("unexpected credentials: %+v", creds)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("unexpected number of requests: %d", requests)
+			}
+			if len(log.msgs) != tt.wantLogs {
+				t.Errorf("unexpected logs: %v", log.msgs)
+			}
+			for _, msg := range log.msgs {
+				if strings.Contains(msg, "FwoGZXIvYXdzEJr") {
+					t.Errorf("log contains the session token: %s", msg)
+				}
+			}
+		})
+	}
+}
+
+func TestAssumeRoleRetryCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	opts := AssumeRoleOptions{Retries: 3, RetryDelay: time.Minute}
+	_, err := AssumeRole(ctx, srv.URL, "123456789012", "audit", opts)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v is not %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("AssumeRole did not return promptly: %v", elapsed)
+	}
+}
+
+func TestAssumeRoleFallbackLogged(t *testing.T) {
+	var requests []map[string]interface{}
+	srv := httptest.NewServer(fakeAssumeRoleV1(t, &requests))
+	defer srv.Close()
+
+	log := &recordingLogger{}
+	opts := AssumeRoleOptions{Logger: log}
+	if _, err := AssumeRole(context.Background(), srv.URL, "123456789012", "audit", opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{`assume role endpoint rejected the v2 payload, retrying with the v1 payload: assume role endpoint does not support the payload: json: unknown field "version"`}
+	if diff := cmp.Diff(want, log.msgs); diff != "" {
+		t.Errorf("logs mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+// Package awsauth obtains the credentials and the identity of the AWS
+// accounts scanned by the checks. The credentials are requested to the
+// assume role endpoint of Vulcan, and the secrets they contain, together
+// with the external IDs sent to the endpoint, are never included in the
+// errors nor the logs.
+package awsauth
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Credentials are the AWS credentials of a session in the scanned account.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PayloadVersion is the version of the payload honored by the assume
+	// role endpoint that returned the credentials. It is 0 if the
+	// credentials were not returned by the endpoint.
+	PayloadVersion int
+}
+
+// Logger is the logger used to report the retries and the fallbacks
+// performed by the package, e.g.: a *logrus.Entry.
+type Logger interface {
+	Warnf(format string, args ...interface{})
+}
+
+// nopLogger is the logger used when no logger is provided.
+type nopLogger struct{}
+
+func (nopLogger) Warnf(string, ...interface{}) {}
+
+// sanitize returns the given string without control and formatting
+// characters, so it can be safely included in errors and logs. Invalid UTF-8
+// sequences are replaced by the Unicode replacement character and line
+// breaks and tabs by a space.
+func sanitize(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b.WriteRune(utf8.RuneError)
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteByte(' ')
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,23 @@
+/*
+Copyright 2020 Adevinta
+*/
+
+package awsauth
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := map[string]string{
+		"plain":                 "plain",
+		"line\nbreak\ttab":      "line break tab",
+		"escape\x1b[31m":        "escape[31m",
+		"bidi\u202eoverride":    "bidioverride",
+		"zero\u200bwidth":       "zerowidth",
+		"invalid \xff sequence": "invalid \ufffd sequence",
+	}
+	for in, want := range tests {
+		if got := sanitize(in); got != want {
+			t.Errorf("sanitize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}